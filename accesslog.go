@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMaxLen bounds request IDs accepted from a proxy.
+const requestIDMaxLen = 64
+
+// loadTrustedProxies parses TRUSTED_PROXIES, a comma-separated list of
+// addresses or CIDR prefixes, defaulting to loopback for a reverse proxy
+// on the same host. These are the peers whose X-Forwarded-For and
+// X-Request-ID headers are believed.
+func loadTrustedProxies(v *settingsView) ([]netip.Prefix, error) {
+	value, ok := v.lookup("TRUSTED_PROXIES")
+	if !ok {
+		value = "127.0.0.0/8, ::1"
+	}
+	var prefixes []netip.Prefix
+	for _, entry := range splitCommaList(value) {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// isTrustedProxy reports whether ip is one of the trusted proxies.
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range liveSettings().trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the address of the directly connected peer.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedIP walks X-Forwarded-For from the nearest hop while the hops
+// are trusted proxies and returns the first address that is not, i.e. the
+// client as seen by the outermost trusted proxy. A peer on the Unix socket
+// is always a trusted proxy.
+func forwardedIP(r *http.Request) string {
+	ip := peerIP(r)
+	if !isTrustedProxy(ip) && !viaUnixSocket(r) {
+		return ip
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+type requestIDKey struct{}
+
+// requestID returns the ID of the request ctx belongs to, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// incomingRequestID keeps the ID a trusted proxy assigned, so log lines
+// can be matched across both, and otherwise generates one.
+func incomingRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= requestIDMaxLen && (isTrustedProxy(peerIP(r)) || viaUnixSocket(r)) {
+		valid := true
+		for _, c := range id {
+			if c <= ' ' || c > '~' {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	return newULID(time.Now())
+}
+
+// logAccess writes the access log record for a finished request. Query
+// strings are left out since they may carry tokens.
+func logAccess(r *http.Request, route string, sw *statusWriter, d time.Duration) {
+	level := slog.LevelInfo
+	if sw.status >= 500 {
+		level = slog.LevelWarn
+	}
+	slog.Log(r.Context(), level, "HTTP request", "component", "http",
+		"request_id", requestID(r.Context()),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"route", route,
+		"status", sw.status,
+		"bytes", sw.bytes,
+		"duration", d,
+		"remote", clientIP(r))
+}
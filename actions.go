@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// actionLink is a link shown with announcements, e.g. as a Slack button.
+type actionLink struct {
+	Label string // literal label; empty to translate Key
+	Key   string // message key of a built-in label
+	URL   string
+}
+
+// label returns the link's label in loc.
+func (a actionLink) label(loc string) string {
+	if a.Label != "" {
+		return a.Label
+	}
+	return translate(loc, a.Key)
+}
+
+// defaultActionLinks are the actions offered when SLACK_ACTIONS is unset,
+// as paths under the base URL.
+var defaultActionLinks = []actionLink{
+	{Key: msgActionStatus, URL: "/status"},
+	{Key: msgActionHistory, URL: "/api/v1/events"},
+	{Key: msgActionPause, URL: "/dashboard/pause.html"},
+}
+
+// parseActionLinks parses SLACK_ACTIONS: semicolon-separated "Label=URL"
+// entries, where URLs may be paths relative to baseURL. Without entries
+// the defaults are used, and without a base URL only absolute links are
+// possible; "none" disables them.
+func parseActionLinks(value, baseURL string) ([]actionLink, error) {
+	if value == "none" {
+		return nil, nil
+	}
+	links := defaultActionLinks
+	if value != "" {
+		links = nil
+		for _, entry := range splitList(value) {
+			label, link, ok := strings.Cut(entry, "=")
+			if !ok {
+				return nil, fmt.Errorf("%q is not Label=URL", entry)
+			}
+			links = append(links, actionLink{Label: strings.TrimSpace(label), URL: strings.TrimSpace(link)})
+		}
+	} else if baseURL == "" {
+		return nil, nil
+	}
+
+	var base *url.URL
+	if baseURL != "" {
+		var err error
+		if base, err = url.Parse(baseURL); err != nil || base.Scheme == "" || base.Host == "" {
+			return nil, fmt.Errorf("BASE_URL %q is not an absolute URL", baseURL)
+		}
+	}
+	resolved := make([]actionLink, len(links))
+	for i, l := range links {
+		u, err := url.Parse(l.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", l.URL, err)
+		}
+		if !u.IsAbs() {
+			if base == nil {
+				return nil, fmt.Errorf("%q is relative but BASE_URL is not set", l.URL)
+			}
+			u = base.JoinPath(u.Path)
+		}
+		l.URL = u.String()
+		resolved[i] = l
+	}
+	return resolved, nil
+}
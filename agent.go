@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agent feed settings.
+const (
+	agentProtocolVersion = "1"
+	agentHeartbeat       = 30 * time.Second
+	agentMaxBackoff      = time.Minute
+)
+
+// agentMessage is one line of the agent feed: newline-delimited JSON
+// carrying raw switch readings, with heartbeats so a follower can tell a
+// quiet feed from a dead one.
+type agentMessage struct {
+	Type   string      `json:"type"` // "reading" or "heartbeat"
+	Open   bool        `json:"open,omitempty"`
+	Status spaceStatus `json:"status,omitempty"` // on readings; older agents only send open
+	Time   time.Time   `json:"time"`
+}
+
+// status returns the state a reading carries.
+func (m agentMessage) status() spaceStatus {
+	if m.Status != statusUnknown {
+		return m.Status
+	}
+	return statusFromOpen(m.Open)
+}
+
+// sensorFeed broadcasts switch readings to feed subscribers.
+type sensorFeed struct {
+	mu          sync.Mutex
+	last        *agentMessage
+	subscribers map[chan agentMessage]bool
+}
+
+var sensorReadings = &sensorFeed{subscribers: make(map[chan agentMessage]bool)}
+
+// publish sends a reading to every subscriber. Slow subscribers miss
+// readings rather than blocking the switch monitor.
+func (f *sensorFeed) publish(s spaceStatus, at time.Time) {
+	m := agentMessage{Type: "reading", Open: s.open(), Status: s, Time: at}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.last = &m
+	for ch := range f.subscribers {
+		select {
+		case ch <- m:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of readings, starting with the latest one,
+// and a function to unsubscribe.
+func (f *sensorFeed) subscribe() (<-chan agentMessage, func()) {
+	ch := make(chan agentMessage, 16)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last != nil {
+		ch <- *f.last
+	}
+	f.subscribers[ch] = true
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subscribers, ch)
+	}
+}
+
+// handleAgentFeed streams switch readings to another instance, e.g. one
+// running in shadow mode.
+func handleAgentFeed(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := streamingFlusher(w, r)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusNotImplemented)
+		return
+	}
+	keepStreaming(w)
+	readings, unsubscribe := sensorReadings.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Agent-Protocol", agentProtocolVersion)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	heartbeat := time.NewTicker(agentHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var m agentMessage
+		select {
+		case <-r.Context().Done():
+			return
+		case m = <-readings:
+		case now := <-heartbeat.C:
+			m = agentMessage{Type: "heartbeat", Time: now}
+		}
+		if err := enc.Encode(m); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// followSensorFeed consumes the agent feed of the instance at baseURL in
+// place of the GPIO pin, reconnecting with exponential backoff, until ctx
+// is done.
+func followSensorFeed(ctx context.Context, baseURL, token string, notifier Notifier) {
+	url := strings.TrimSuffix(baseURL, "/") + "/api/v1/agent/feed"
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := readSensorFeed(ctx, url, token, notifier)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Agent feed ended", "component", "shadow", "url", baseURL, "err", err)
+
+		if time.Since(start) > agentMaxBackoff {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, agentMaxBackoff)
+	}
+}
+
+// readSensorFeed applies readings from one feed connection until it fails
+// or goes quiet for two heartbeats.
+func readSensorFeed(parent context.Context, url, token string, notifier Notifier) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("X-Agent-Protocol"); v != agentProtocolVersion {
+		return fmt.Errorf("unsupported agent protocol %q", v)
+	}
+	slog.Info("Following agent feed", "component", "shadow", "url", url)
+
+	idle := time.AfterFunc(2*agentHeartbeat, cancel)
+	defer idle.Stop()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idle.Reset(2 * agentHeartbeat)
+		zones[0].beat()
+		var m agentMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return fmt.Errorf("invalid feed message: %w", err)
+		}
+		if m.Type == "reading" && m.status() != currentSpace().Status {
+			applySwitchState(zones[0], m.status(), sourceAgent, notifier)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("feed closed")
+}
+
+// shadowNotifier stands in for the notifier registry in shadow mode: it
+// logs what each notifier would send instead of sending it.
+type shadowNotifier struct {
+	notifiers *notifierRegistry
+}
+
+// Name implements Notifier.
+func (n *shadowNotifier) Name() string {
+	return "shadow"
+}
+
+// Notify implements Notifier.
+func (n *shadowNotifier) Notify(ctx context.Context, e Event) error {
+	if m, ok := spaceMaintenance.active(); ok {
+		slog.Info("Maintenance in progress; would not announce event", "component", "shadow", "started_by", m.By, "state", e.State(), "zone", e.Zone, "event", e.ID)
+		return nil
+	}
+	if pause, ok := notificationsPause.active(); ok {
+		slog.Info("Notifications paused; would not announce event", "component", "shadow", "paused_by", pause.By, "state", e.State(), "zone", e.Zone, "event", e.ID)
+		return nil
+	}
+	for _, notifier := range n.notifiers.Notifiers() {
+		p, ok := notifier.(Previewer)
+		if !ok {
+			slog.Info("Would notify", "component", "shadow", "notifier", notifier.Name(), "state", e.State(), "zone", e.Zone, "event", e.ID)
+			continue
+		}
+		plan, err := p.Preview(e, nil)
+		if err != nil {
+			slog.Warn("Notifier would fail", "component", "shadow", "notifier", notifier.Name(), "err", err)
+			continue
+		}
+		for _, m := range plan {
+			slog.Info("Would send", "component", "shadow", "notifier", m.Notifier, "destination", m.Destination, "text", m.Text)
+		}
+	}
+	return nil
+}
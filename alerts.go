@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// opsAlertCooldown suppresses repeats of the same alert.
+const opsAlertCooldown = 30 * time.Minute
+
+// opsAlerter posts operational alerts to the ops Slack channel. Without a
+// channel alerts are only logged.
+type opsAlerter struct {
+	api     *slack.Client
+	channel string
+
+	mu   sync.Mutex
+	sent map[string]time.Time // alert key -> last sent
+}
+
+// opsAlerts is configured in main; until then alerts are only logged.
+var opsAlerts = newOpsAlerter("", "")
+
+// newOpsAlerter returns an alerter posting to channel with the given token.
+func newOpsAlerter(token, channel string) *opsAlerter {
+	a := &opsAlerter{channel: channel, sent: make(map[string]time.Time)}
+	if token != "" && channel != "" {
+		a.api = slack.New(token)
+	}
+	return a
+}
+
+// configure switches the alerter to another token and channel, e.g. after
+// a configuration reload. The cooldowns carry over.
+func (a *opsAlerter) configure(token, channel string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.channel, a.api = channel, nil
+	if token != "" && channel != "" {
+		a.api = slack.New(token)
+	}
+}
+
+// Alert logs text and posts it to the ops channel unless an alert with the
+// same key was sent within the cooldown.
+func (a *opsAlerter) Alert(key, text string) {
+	a.mu.Lock()
+	if last, ok := a.sent[key]; ok && time.Since(last) < opsAlertCooldown {
+		a.mu.Unlock()
+		return
+	}
+	a.sent[key] = time.Now()
+	api, channel := a.api, a.channel
+	a.mu.Unlock()
+
+	slog.Warn("Ops alert", "component", "alerts", "text", text)
+	if api == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyAttemptLimit)
+		defer cancel()
+		if err := postSlackMessage(ctx, api, channel, ":rotating_light: "+text); err != nil {
+			slog.Error("Failed to send ops alert", "component", "alerts", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memberKeyLimit is how many API keys one member may hold.
+const memberKeyLimit = 10
+
+// memberKey is a long-lived API key a member minted for their own
+// projects. It carries a subset of memberKeyScopes and acts for its owner.
+// Only a hash of the token is kept.
+type memberKey struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"` // principal Member, e.g. "slack:U123"
+	Label     string    `json:"label"`
+	Scopes    []string  `json:"scopes"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// principal returns the identity the key authenticates as.
+func (k memberKey) principal() principal {
+	scopes := make(map[string]bool, len(k.Scopes))
+	for _, s := range k.Scopes {
+		scopes[s] = true
+	}
+	return principal{Name: "key:" + k.ID, Scopes: scopes, Member: k.Owner}
+}
+
+// memberKeyStore holds issued member keys. Revoked keys are deleted.
+type memberKeyStore struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]*memberKey // keyed by ID
+}
+
+var memberKeys = &memberKeyStore{
+	path: filepath.Join(dataDir, "api_keys.json"),
+	keys: make(map[string]*memberKey),
+}
+
+// load restores keys saved by a previous run.
+func (s *memberKeyStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var keys []*memberKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	return nil
+}
+
+// save persists the keys. The caller must hold s.mu.
+func (s *memberKeyStore) save() error {
+	keys := make([]*memberKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return writeJSONFile(s.path, keys)
+}
+
+// errKeyLimit is returned when a member already holds memberKeyLimit keys.
+var errKeyLimit = fmt.Errorf("at most %d API keys per member", memberKeyLimit)
+
+// mint issues a new key for owner and returns it with its plaintext token.
+func (s *memberKeyStore) mint(owner, label string, scopes []string) (memberKey, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return memberKey{}, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return memberKey{}, "", err
+	}
+	token := "mk_" + secret
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := 0
+	for _, k := range s.keys {
+		if k.Owner == owner {
+			held++
+		}
+	}
+	if held >= memberKeyLimit {
+		return memberKey{}, "", errKeyLimit
+	}
+	key := &memberKey{
+		ID:        id,
+		Owner:     owner,
+		Label:     label,
+		Scopes:    scopes,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now().UTC(),
+	}
+	s.keys[id] = key
+	return *key, token, s.save()
+}
+
+// revoke deletes owner's key with the given ID.
+func (s *memberKeyStore) revoke(owner, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok || k.Owner != owner {
+		return false, nil
+	}
+	delete(s.keys, id)
+	return true, s.save()
+}
+
+// lookup returns the key matching token.
+func (s *memberKeyStore) lookup(token string) (memberKey, bool) {
+	hash := hashToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.TokenHash == hash {
+			return *k, true
+		}
+	}
+	return memberKey{}, false
+}
+
+// list returns owner's keys, newest first.
+func (s *memberKeyStore) list(owner string) []memberKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []memberKey
+	for _, k := range s.keys {
+		if k.Owner == owner {
+			keys = append(keys, *k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys
+}
+
+// memberKeyProvider accepts member API keys.
+type memberKeyProvider struct{}
+
+// Name implements authProvider.
+func (memberKeyProvider) Name() string { return "member-key" }
+
+// Authenticate implements authProvider.
+func (memberKeyProvider) Authenticate(ctx context.Context, token string) (principal, bool) {
+	if !strings.HasPrefix(token, "mk_") {
+		return principal{}, false
+	}
+	if key, ok := memberKeys.lookup(token); ok {
+		return key.principal(), true
+	}
+	return principal{}, false
+}
+
+// memberKeyView is the API representation of a key, without its hash.
+type memberKeyView struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     string    `json:"token,omitempty"`
+}
+
+func viewMemberKey(k memberKey, token string) memberKeyView {
+	return memberKeyView{ID: k.ID, Label: k.Label, Scopes: k.Scopes, CreatedAt: k.CreatedAt, Token: token}
+}
+
+// requestMember returns the member the request acts for, responding with
+// an error for tokens that do not belong to a member, such as ADMIN_TOKEN.
+func requestMember(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	p, _ := authenticate(r)
+	if p.Member == "" {
+		http.Error(w, "This token does not belong to a member; sign in with Slack or OIDC", http.StatusForbidden)
+		return principal{}, false
+	}
+	return p, true
+}
+
+// handleListMemberKeys lists the caller's API keys.
+func handleListMemberKeys(w http.ResponseWriter, r *http.Request) {
+	p, ok := requestMember(w, r)
+	if !ok {
+		return
+	}
+	views := []memberKeyView{}
+	for _, k := range memberKeys.list(p.Member) {
+		views = append(views, viewMemberKey(k, ""))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// handleMintMemberKey issues an API key to the caller. The token is only
+// returned here.
+func handleMintMemberKey(w http.ResponseWriter, r *http.Request) {
+	p, ok := requestMember(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Label  string   `json:"label"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{scopeStatusRead}
+	}
+	for _, scope := range req.Scopes {
+		if !memberKeyScopes[scope] {
+			http.Error(w, "Scope not allowed for member API keys: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, token, err := memberKeys.mint(p.Member, strings.TrimSpace(req.Label), req.Scopes)
+	if errors.Is(err, errKeyLimit) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to mint API key", "component", "auth", "member", p.Member, "err", err)
+		http.Error(w, "Failed to mint API key", http.StatusInternalServerError)
+		return
+	}
+	audit("Minted API key", auditKeyMinted, p.Name, "member", p.Member, "key", key.ID, "label", key.Label, "scopes", key.Scopes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(viewMemberKey(key, token))
+}
+
+// handleRevokeMemberKey revokes one of the caller's API keys.
+func handleRevokeMemberKey(w http.ResponseWriter, r *http.Request) {
+	p, ok := requestMember(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	ok, err := memberKeys.revoke(p.Member, id)
+	if err != nil {
+		slog.Error("Failed to save API keys", "component", "auth", "err", err)
+	}
+	if !ok {
+		http.Error(w, "Unknown API key "+id, http.StatusNotFound)
+		return
+	}
+	audit("Revoked API key", auditKeyRevoked, p.Name, "member", p.Member, "key", id)
+	w.WriteHeader(http.StatusNoContent)
+}
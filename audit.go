@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audited actions.
+const (
+	auditStatusOverridden     = "status.overridden"
+	auditOverrideCleared      = "status.override_cleared"
+	auditSubscriberUpdated    = "subscriber.updated"
+	auditSubscriberRemoved    = "subscriber.removed"
+	auditConfigReloaded       = "config.reloaded"
+	auditNotificationsPaused  = "notifications.paused"
+	auditNotificationsResumed = "notifications.resumed"
+	auditKeyMinted            = "api_key.minted"
+	auditKeyRevoked           = "api_key.revoked"
+	auditMaintenanceStarted   = "maintenance.started"
+	auditMaintenanceEnded     = "maintenance.ended"
+	auditGuestPassMinted      = "guest_pass.minted"
+	auditGuestPassRevoked     = "guest_pass.revoked"
+	auditTemplateSaved        = "template.saved"
+)
+
+// Page sizes of /admin/audit.
+const (
+	auditDefaultPage = 100
+	auditMaxPage     = 1000
+)
+
+// auditEntry is one administrative action, as kept in the audit log and
+// served by /admin/audit.
+type auditEntry struct {
+	ID     string                 `json:"id"` // ULID
+	Time   time.Time              `json:"time"`
+	By     string                 `json:"by"` // the principal, e.g. "token:door-panel" or "slack:U012ABC"
+	Action string                 `json:"action"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+// auditFilter selects entries from the audit log.
+type auditFilter struct {
+	Since  time.Time // inclusive; zero for no bound
+	Until  time.Time // exclusive; zero for no bound
+	By     string    // empty for anyone
+	Action string    // empty for every action
+	Offset int
+	Limit  int
+}
+
+// auditLog is the append-only record of administrative actions, kept as
+// JSON lines in the data directory rather than the history database, so it
+// is kept whatever HISTORY_STORE says and retention never prunes it.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	entries []auditEntry // oldest first
+}
+
+var auditTrail = &auditLog{path: filepath.Join(dataDir, "audit.jsonl")}
+
+// load reads the entries recorded by previous runs. Damaged lines, e.g.
+// from a crash mid-write, are skipped.
+func (l *auditLog) load() error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			slog.Warn("Skipping damaged line", "component", "audit", "file", l.path, "line", line, "err", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = entries
+	return nil
+}
+
+// record appends an entry to the log.
+func (l *auditLog) record(e auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// query returns the page of entries matching f, newest first, and how many
+// match in all.
+func (l *auditLog) query(f auditFilter) ([]auditEntry, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var page []auditEntry
+	total := 0
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if !f.Since.IsZero() && e.Time.Before(f.Since) ||
+			!f.Until.IsZero() && !e.Time.Before(f.Until) ||
+			f.By != "" && e.By != f.By ||
+			f.Action != "" && e.Action != f.Action {
+			continue
+		}
+		if total >= f.Offset && len(page) < f.Limit {
+			page = append(page, e)
+		}
+		total++
+	}
+	return page, total
+}
+
+// audit logs an administrative action under msg with component audit and
+// the key-value pairs of detail, and keeps it in the audit log.
+func audit(msg, action, by string, detail ...interface{}) {
+	slog.Info(msg, append([]interface{}{"component", "audit", "action", action, "by", by}, detail...)...)
+	now := time.Now()
+	e := auditEntry{ID: newULID(now), Time: now.UTC(), By: by, Action: action}
+	for i := 0; i+1 < len(detail); i += 2 {
+		if e.Detail == nil {
+			e.Detail = make(map[string]interface{})
+		}
+		e.Detail[fmt.Sprint(detail[i])] = detail[i+1]
+	}
+	if err := auditTrail.record(e); err != nil {
+		slog.Error("Failed to record audit entry", "component", "audit", "action", action, "err", err)
+	}
+}
+
+// handleAudit serves the audit log, newest first, paged with limit and
+// offset. since and until bound the time range as for /history; by and
+// action select who acted and what they did.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := auditFilter{By: q.Get("by"), Action: q.Get("action")}
+	var err error
+	if f.Since, err = parseHistoryTime(q.Get("since"), false); err != nil {
+		http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Until, err = parseHistoryTime(q.Get("until"), true); err != nil {
+		http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Offset, err = queryInt(r, "offset", 0); err != nil || f.Offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	f.Limit, err = queryInt(r, "limit", auditDefaultPage)
+	if err != nil || f.Limit < 1 || f.Limit > auditMaxPage {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", auditMaxPage), http.StatusBadRequest)
+		return
+	}
+
+	page, total := auditTrail.query(f)
+	if page == nil {
+		page = []auditEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":  page,
+		"total":    total,
+		"offset":   f.Offset,
+		"limit":    f.Limit,
+		"has_more": f.Offset+len(page) < total,
+	})
+}
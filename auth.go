@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Scopes grantable to tokens. The admin token holds every scope.
+const (
+	scopeAdmin      = "admin"
+	scopeStatusRead = "status:read"
+	scopeCheckIn    = "checkin"
+	// scopeMember lets a member manage their own API keys and
+	// subscription; it implies the memberKeyScopes.
+	scopeMember       = "member"
+	scopeSubscription = "subscription"
+)
+
+// guestScopes are the scopes a guest pass may carry.
+var guestScopes = map[string]bool{
+	scopeStatusRead: true,
+	scopeCheckIn:    true,
+}
+
+// memberKeyScopes are the scopes a member API key may carry.
+var memberKeyScopes = map[string]bool{
+	scopeStatusRead:   true,
+	scopeSubscription: true,
+}
+
+// knownScopes are all grantable scopes.
+var knownScopes = map[string]bool{
+	scopeAdmin:        true,
+	scopeStatusRead:   true,
+	scopeCheckIn:      true,
+	scopeMember:       true,
+	scopeSubscription: true,
+}
+
+// apiKeyHeader is an alternative to "Authorization: Bearer" for clients
+// that cannot set that header, such as some webhook senders and door
+// controllers.
+const apiKeyHeader = "X-API-Key"
+
+// principal identifies the holder of a validated token.
+type principal struct {
+	Name string
+	// Display is shown to people instead of Name when set, e.g. an OIDC
+	// user's preferred username; it need not be unique.
+	Display string
+	Scopes  map[string]bool
+	// Member is the person the principal acts for, e.g. "slack:U123",
+	// for member identities and their API keys; empty for service tokens
+	// and guest passes.
+	Member string
+}
+
+// displayName returns the name to show people.
+func (p principal) displayName() string {
+	if p.Display != "" {
+		return p.Display
+	}
+	return p.Name
+}
+
+// has reports whether the principal was granted scope.
+func (p principal) has(scope string) bool {
+	return p.Scopes[scopeAdmin] || p.Scopes[scope] || p.Scopes[scopeMember] && memberKeyScopes[scope]
+}
+
+// requestToken extracts the token from an "Authorization: Bearer" header
+// or, failing that, from X-API-Key.
+func requestToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get(apiKeyHeader))
+}
+
+// authProvider resolves bearer tokens to principals. Providers decline
+// tokens they do not recognize so the next one can try.
+type authProvider interface {
+	// Name identifies the provider in logs.
+	Name() string
+	// Authenticate returns the principal for token, if the provider
+	// accepts it.
+	Authenticate(ctx context.Context, token string) (principal, bool)
+}
+
+// defaultAuthProviders are always available: static tokens, guest passes,
+// and member API keys. buildAuthProviders adds the rest from the settings;
+// the providers in use are a runtime setting.
+func defaultAuthProviders() []authProvider {
+	return []authProvider{&staticTokenProvider{}, guestPassProvider{}, memberKeyProvider{}}
+}
+
+// buildAuthProviders returns the built-in providers and those configured,
+// tried in order.
+func buildAuthProviders(v *settingsView) ([]authProvider, error) {
+	static := &staticTokenProvider{}
+	if value := v.setting("AUTH_TOKENS"); value != "" {
+		tokens, err := parseStaticTokens(value)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_TOKENS: %w", err)
+		}
+		static.tokens = tokens
+	}
+	providers := []authProvider{static, guestPassProvider{}, memberKeyProvider{}}
+
+	if issuer := v.setting("OIDC_ISSUER"); issuer != "" {
+		groups, err := parseScopeMap(v.setting("OIDC_GROUP_SCOPES"))
+		if err != nil {
+			return nil, fmt.Errorf("OIDC_GROUP_SCOPES: %w", err)
+		}
+		claim := v.setting("OIDC_GROUPS_CLAIM")
+		if claim == "" {
+			claim = "groups"
+		}
+		audience := v.setting("OIDC_AUDIENCE")
+		if audience == "" {
+			return nil, fmt.Errorf("OIDC_AUDIENCE must be set with OIDC_ISSUER")
+		}
+		providers = append(providers, newOIDCProvider(issuer, audience, claim, groups))
+	}
+	if value := v.setting("SLACK_AUTH_USERS"); value != "" {
+		users, err := parseScopeMap(value)
+		if err != nil {
+			return nil, fmt.Errorf("SLACK_AUTH_USERS: %w", err)
+		}
+		providers = append(providers, newSlackAuthProvider(users))
+	}
+	return providers, nil
+}
+
+// authenticate resolves the request's token to a principal.
+func authenticate(r *http.Request) (principal, bool) {
+	token := requestToken(r)
+	if token == "" {
+		return principal{}, false
+	}
+	for _, p := range liveSettings().authProviders {
+		if pr, ok := p.Authenticate(r.Context(), token); ok {
+			return pr, true
+		}
+	}
+	return principal{}, false
+}
+
+// staticToken is a long-lived token configured in the environment.
+type staticToken struct {
+	name   string
+	token  string
+	scopes map[string]bool
+}
+
+// staticTokenProvider accepts ADMIN_TOKEN and the tokens in AUTH_TOKENS.
+type staticTokenProvider struct {
+	tokens []staticToken
+}
+
+// Name implements authProvider.
+func (p *staticTokenProvider) Name() string { return "static" }
+
+// Authenticate implements authProvider. Every token is compared, by
+// digest so their lengths do not show either, and the time taken does
+// not tell how much of a token matched or which one.
+func (p *staticTokenProvider) Authenticate(ctx context.Context, token string) (principal, bool) {
+	digest := sha256.Sum256([]byte(token))
+	matches := func(candidate string) bool {
+		other := sha256.Sum256([]byte(candidate))
+		return candidate != "" && subtle.ConstantTimeCompare(digest[:], other[:]) == 1
+	}
+	var found *principal
+	if matches(liveSettings().adminToken) {
+		found = &principal{Name: "admin", Scopes: map[string]bool{scopeAdmin: true}}
+	}
+	for _, t := range p.tokens {
+		if matches(t.token) && found == nil {
+			found = &principal{Name: "token:" + t.name, Scopes: t.scopes}
+		}
+	}
+	if found == nil {
+		return principal{}, false
+	}
+	return *found, true
+}
+
+// parseStaticTokens parses AUTH_TOKENS: semicolon-separated entries of a
+// name, the token, and "scopes=a,b".
+func parseStaticTokens(value string) ([]staticToken, error) {
+	var tokens []staticToken
+	for _, entry := range splitList(value) {
+		fields := strings.Fields(entry)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%q: want NAME TOKEN scopes=...", fields[0])
+		}
+		list, ok := strings.CutPrefix(fields[2], "scopes=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want NAME TOKEN scopes=...", fields[0])
+		}
+		scopes, err := parseScopes(list)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", fields[0], err)
+		}
+		tokens = append(tokens, staticToken{name: fields[0], token: fields[1], scopes: scopes})
+	}
+	return tokens, nil
+}
+
+// guestPassProvider accepts unexpired, unrevoked guest passes.
+type guestPassProvider struct{}
+
+// Name implements authProvider.
+func (guestPassProvider) Name() string { return "guest-pass" }
+
+// Authenticate implements authProvider.
+func (guestPassProvider) Authenticate(ctx context.Context, token string) (principal, bool) {
+	if pass, ok := guestPasses.lookup(token); ok {
+		return pass.principal(), true
+	}
+	return principal{}, false
+}
+
+// parseScopes parses a comma-separated scope list.
+func parseScopes(list string) (map[string]bool, error) {
+	scopes := make(map[string]bool)
+	for _, scope := range splitCommaList(list) {
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		scopes[scope] = true
+	}
+	return scopes, nil
+}
+
+// parseScopeMap parses semicolon-separated "key=scope,scope" entries,
+// mapping e.g. OIDC groups or Slack user IDs to scopes.
+func parseScopeMap(value string) (map[string]map[string]bool, error) {
+	m := make(map[string]map[string]bool)
+	for _, entry := range splitList(value) {
+		key, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not key=scopes", entry)
+		}
+		scopes, err := parseScopes(list)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", strings.TrimSpace(key), err)
+		}
+		m[strings.TrimSpace(key)] = scopes
+	}
+	return m, nil
+}
+
+// requireScope wraps a handler so it only runs for tokens granted scope.
+// Clients and tokens with repeated failures are temporarily blocked.
+// Requests that may change something are logged with component audit and
+// the token's name, e.g. token:door-panel.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := authKeys(r, requestToken(r))
+		if rejectIfBlocked(w, keys) {
+			return
+		}
+		p, ok := authenticate(r)
+		if !ok {
+			if requestToken(r) != "" {
+				authFailures.fail(r.URL.Path, keys...)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="space-status"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !p.has(scope) {
+			http.Error(w, "Token lacks scope "+scope, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+		if changesState(r.Method) {
+			slog.Info("Authorized request", "component", "audit", "by", p.Name, "method", r.Method, "route", r.Pattern,
+				"request_id", requestID(r.Context()))
+		}
+	}
+}
+
+// changesState reports whether requests with method may change state or
+// configuration, so they are audited.
+func changesState(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Brute-force protection settings.
+const (
+	authFailureWindow    = 10 * time.Minute
+	authFailureLimit     = 5 // failures per key within the window before blocking
+	authBlockDuration    = 15 * time.Minute
+	authSustainedFailure = 20 // failures across all keys within the window that trigger an ops alert
+)
+
+// authGuard counts failed authentication attempts per client IP and per
+// presented token, and temporarily blocks repeat offenders.
+type authGuard struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time // key -> failure times within the window
+	blocked  map[string]time.Time   // key -> block expiry
+	recent   []time.Time            // all failures within the window
+}
+
+var authFailures = &authGuard{
+	failures: make(map[string][]time.Time),
+	blocked:  make(map[string]time.Time),
+}
+
+// clientIP returns the IP address the request came from, taken from
+// X-Forwarded-For when the peer is a trusted proxy.
+func clientIP(r *http.Request) string {
+	return forwardedIP(r)
+}
+
+// tokenKey identifies a presented token without keeping it in memory.
+func tokenKey(token string) string {
+	return "token:" + hashToken(token)[:12]
+}
+
+// blockedFor returns how much longer any of keys stays blocked.
+func (g *authGuard) blockedFor(keys ...string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var longest time.Duration
+	for _, key := range keys {
+		until, ok := g.blocked[key]
+		if !ok {
+			continue
+		}
+		if now.After(until) {
+			delete(g.blocked, key)
+			continue
+		}
+		longest = max(longest, until.Sub(now))
+	}
+	return longest
+}
+
+// fail records a failed attempt against each key, blocking keys that
+// exceed the limit and alerting ops on blocks and sustained failures.
+func (g *authGuard) fail(endpoint string, keys ...string) {
+	now := time.Now()
+	cutoff := now.Add(-authFailureWindow)
+
+	g.mu.Lock()
+	var newlyBlocked []string
+	for _, key := range keys {
+		times := append(pruneTimes(g.failures[key], cutoff), now)
+		g.failures[key] = times
+		if len(times) >= authFailureLimit {
+			if _, already := g.blocked[key]; !already {
+				newlyBlocked = append(newlyBlocked, key)
+			}
+			g.blocked[key] = now.Add(authBlockDuration)
+			delete(g.failures, key)
+		}
+	}
+	g.recent = append(pruneTimes(g.recent, cutoff), now)
+	recent := len(g.recent)
+	g.gc(cutoff)
+	g.mu.Unlock()
+
+	for _, key := range newlyBlocked {
+		opsAlerts.Alert("auth-block:"+key, fmt.Sprintf("Blocked %s for %s after %d failed auth attempts (last on %s)",
+			key, authBlockDuration, authFailureLimit, endpoint))
+	}
+	if recent >= authSustainedFailure {
+		opsAlerts.Alert("auth-sustained", fmt.Sprintf("%d failed auth attempts in the last %s (latest on %s from %v)",
+			recent, authFailureWindow, endpoint, keys))
+	}
+}
+
+// gc drops stale entries so the maps cannot grow without bound. The caller
+// must hold g.mu.
+func (g *authGuard) gc(cutoff time.Time) {
+	for key, times := range g.failures {
+		if len(pruneTimes(times, cutoff)) == 0 {
+			delete(g.failures, key)
+		}
+	}
+	now := time.Now()
+	for key, until := range g.blocked {
+		if now.After(until) {
+			delete(g.blocked, key)
+		}
+	}
+}
+
+// pruneTimes drops times before cutoff from a chronological slice.
+func pruneTimes(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// authKeys returns the keys an attempt is tracked under.
+func authKeys(r *http.Request, token string) []string {
+	keys := []string{"ip:" + clientIP(r)}
+	if token != "" {
+		keys = append(keys, tokenKey(token))
+	}
+	return keys
+}
+
+// rejectIfBlocked responds with 429 and returns true when the client or
+// token is currently blocked.
+func rejectIfBlocked(w http.ResponseWriter, keys []string) bool {
+	wait := authFailures.blockedFor(keys...)
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+	http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+	return true
+}
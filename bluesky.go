@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bluesky settings.
+const (
+	blueskyDefaultPDS = "https://bsky.social"
+	blueskyMaxLength  = 300 // post length limit, in characters
+)
+
+// blueskyNotifier posts announcements to Bluesky over the AT Protocol,
+// authenticating with an app password.
+type blueskyNotifier struct {
+	pdsURL      string
+	handle      string
+	appPassword string
+	templates   templateSet
+
+	mu        sync.Mutex
+	accessJWT string
+	did       string
+}
+
+// newBlueskyNotifier returns a notifier posting as handle via pdsURL.
+func newBlueskyNotifier(pdsURL, handle, appPassword string, templates templateSet) *blueskyNotifier {
+	return &blueskyNotifier{
+		pdsURL:      strings.TrimRight(pdsURL, "/"),
+		handle:      handle,
+		appPassword: appPassword,
+		templates:   templates,
+	}
+}
+
+// Name implements Notifier.
+func (n *blueskyNotifier) Name() string {
+	return "bluesky"
+}
+
+// Notify implements Notifier.
+func (n *blueskyNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, n.templates)
+	if err != nil {
+		return err
+	}
+
+	err = n.createPost(ctx, message, e.Time)
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusBadRequest) {
+		// Access tokens are short-lived; log in again and retry once.
+		n.resetSession()
+		err = n.createPost(ctx, message, e.Time)
+	}
+	return err
+}
+
+// Preview implements Previewer.
+func (n *blueskyNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, mergeTemplates(overrides, n.templates))
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: n.handle, Text: truncateRunes(message, blueskyMaxLength)}}, nil
+}
+
+// createPost creates an app.bsky.feed.post record.
+func (n *blueskyNotifier) createPost(ctx context.Context, text string, at time.Time) error {
+	jwt, did, err := n.session(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := newJSONRequest(ctx, n.pdsURL+"/xrpc/com.atproto.repo.createRecord", map[string]interface{}{
+		"repo":       did,
+		"collection": "app.bsky.feed.post",
+		"record": map[string]interface{}{
+			"$type":     "app.bsky.feed.post",
+			"text":      truncateRunes(text, blueskyMaxLength),
+			"createdAt": at.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	return doWebhookRequest(req)
+}
+
+// session returns the cached access token, logging in if necessary.
+func (n *blueskyNotifier) session(ctx context.Context) (string, string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.accessJWT != "" {
+		return n.accessJWT, n.did, nil
+	}
+
+	req, err := newJSONRequest(ctx, n.pdsURL+"/xrpc/com.atproto.server.createSession", map[string]string{
+		"identifier": n.handle,
+		"password":   n.appPassword,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	var resp struct {
+		AccessJWT string `json:"accessJwt"`
+		DID       string `json:"did"`
+	}
+	if err := doJSONRequest(req, &resp); err != nil {
+		return "", "", err
+	}
+	n.accessJWT, n.did = resp.AccessJWT, resp.DID
+	return n.accessJWT, n.did, nil
+}
+
+func (n *blueskyNotifier) resetSession() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.accessJWT = ""
+}
+
+// truncateRunes shortens s to at most limit characters, ending with an
+// ellipsis when cut.
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Canary settings.
+const (
+	canaryIDPrefix          = "canary-"
+	canaryDefaultInterval   = 15 * time.Minute
+	canaryDefaultMaxLatency = 30 * time.Second
+	canaryDefaultFailures   = 2
+	canaryDispatchStage     = "dispatch" // fan-out and queueing, up to Notify returning
+)
+
+// canaryTarget is implemented by notifiers that take canary events. They
+// pass them through like any other event but drop them, reporting to
+// canaryArrived, at the last step before sending.
+type canaryTarget interface {
+	// canaryStage names the notifier in canary results, or is empty when
+	// the notifier would not handle a state change at all.
+	canaryStage() string
+}
+
+// isCanary reports whether an event or delivery ID belongs to a canary
+// event, which must never be announced. The mark is part of the ID so it
+// survives the write-ahead log and webhook payloads.
+func isCanary(id string) bool {
+	return strings.HasPrefix(id, canaryIDPrefix)
+}
+
+// canaryTargets returns the notifiers that take canary events and will
+// report them.
+func canaryTargets(notifiers []Notifier) []Notifier {
+	var targets []Notifier
+	for _, n := range notifiers {
+		if t, ok := n.(canaryTarget); ok && t.canaryStage() != "" {
+			targets = append(targets, n)
+		}
+	}
+	return targets
+}
+
+// pipelineCanary periodically sends a canary event through the notifier
+// registry and checks that it reaches every notifier's delivery worker in
+// time. Stages failing failures runs in a row raise an ops alert, so a
+// stuck queue or dead worker is noticed before a real door event is lost.
+type pipelineCanary struct {
+	notifiers  *notifierRegistry
+	interval   time.Duration
+	maxLatency time.Duration
+	failures   int
+
+	mu      sync.Mutex
+	id      string                   // event of the probe in flight, if any
+	start   time.Time                // when it was sent
+	arrived map[string]time.Duration // latency by stage
+	failed  map[string]int           // consecutive failed runs by stage
+}
+
+// canary is set in main when the canary is enabled.
+var canary *pipelineCanary
+
+// loadCanarySettings reads CANARY_INTERVAL, where 0 disables the canary,
+// CANARY_MAX_LATENCY, and CANARY_FAILURES.
+func loadCanarySettings() (interval, maxLatency time.Duration, failures int) {
+	interval = getEnvDuration("CANARY_INTERVAL", canaryDefaultInterval)
+	if interval <= 0 {
+		return 0, 0, 0
+	}
+	maxLatency = getEnvDuration("CANARY_MAX_LATENCY", canaryDefaultMaxLatency)
+	if maxLatency >= interval {
+		fatal("CANARY_MAX_LATENCY must be shorter than CANARY_INTERVAL", "max_latency", maxLatency, "interval", interval)
+	}
+	return interval, maxLatency, getEnvInt("CANARY_FAILURES", canaryDefaultFailures)
+}
+
+func newPipelineCanary(notifiers *notifierRegistry, interval, maxLatency time.Duration, failures int) *pipelineCanary {
+	return &pipelineCanary{
+		notifiers:  notifiers,
+		interval:   interval,
+		maxLatency: maxLatency,
+		failures:   max(failures, 1),
+		failed:     make(map[string]int),
+	}
+}
+
+func (c *pipelineCanary) run() {
+	for {
+		time.Sleep(c.interval)
+		c.probe()
+	}
+}
+
+// canaryArrived reports whether id is a canary, which the caller must then
+// drop, and records its arrival at stage.
+func canaryArrived(id, stage string) bool {
+	if !isCanary(id) {
+		return false
+	}
+	if c := canary; c != nil {
+		c.arrive(id, stage)
+	}
+	return true
+}
+
+func (c *pipelineCanary) arrive(id, stage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id != c.id {
+		// Replayed from a previous run, or later than a finished probe.
+		slog.Debug("Ignoring stale canary", "component", "canary", "stage", stage, "event", id)
+		return
+	}
+	c.arrived[stage] = time.Since(c.start)
+}
+
+// probe sends one canary event and evaluates every stage once maxLatency
+// has passed.
+func (c *pipelineCanary) probe() {
+	start := time.Now()
+	e := Event{ID: canaryIDPrefix + newULID(start), Zone: zones[0].name, Time: start}
+	var stages []string
+	for _, n := range canaryTargets(c.notifiers.Notifiers()) {
+		stages = append(stages, n.(canaryTarget).canaryStage())
+	}
+
+	c.mu.Lock()
+	c.id, c.start, c.arrived = e.ID, start, make(map[string]time.Duration)
+	c.mu.Unlock()
+
+	err := c.notifiers.Notify(context.Background(), e)
+	dispatch := time.Since(start)
+	time.Sleep(c.maxLatency - dispatch)
+
+	c.mu.Lock()
+	arrived := c.arrived
+	c.id = ""
+	c.mu.Unlock()
+
+	problems := make(map[string]string)
+	metricCanaryLatency.set(dispatch.Seconds(), canaryDispatchStage)
+	switch {
+	case err != nil:
+		problems[canaryDispatchStage] = err.Error()
+	case dispatch > c.maxLatency:
+		problems[canaryDispatchStage] = fmt.Sprintf("took %s", dispatch.Round(time.Millisecond))
+	}
+	for _, stage := range stages {
+		d, ok := arrived[stage]
+		if !ok {
+			problems[stage] = fmt.Sprintf("did not arrive within %s", c.maxLatency)
+			continue
+		}
+		metricCanaryLatency.set(d.Seconds(), stage)
+	}
+	c.evaluate(append([]string{canaryDispatchStage}, stages...), problems, e.ID)
+}
+
+// evaluate updates the consecutive failure counts and alerts on stages
+// at or past the threshold, and on their recovery.
+func (c *pipelineCanary) evaluate(stages []string, problems map[string]string, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stage := range stages {
+		problem, failed := problems[stage]
+		if !failed {
+			if c.failed[stage] >= c.failures {
+				slog.Info("Canary stage recovered", "component", "canary", "stage", stage, "event", id)
+				opsAlerts.Alert("canary-recovered:"+stage, fmt.Sprintf("Notification pipeline canary: %s recovered", stage))
+			}
+			delete(c.failed, stage)
+			continue
+		}
+		c.failed[stage]++
+		metricCanaryFailures.inc(stage)
+		slog.Warn("Canary stage failed", "component", "canary", "stage", stage, "problem", problem,
+			"consecutive", c.failed[stage], "event", id)
+		if c.failed[stage] >= c.failures {
+			opsAlerts.Alert("canary:"+stage, fmt.Sprintf("Notification pipeline canary: %s %s (%d runs in a row)",
+				stage, problem, c.failed[stage]))
+		}
+	}
+	if len(problems) == 0 {
+		slog.Debug("Canary passed", "component", "canary", "stages", len(stages), "event", id)
+	}
+}
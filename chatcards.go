@@ -0,0 +1,116 @@
+package main
+
+import "context"
+
+// teamsNotifier posts announcements as Adaptive Cards to a Microsoft Teams
+// incoming webhook or Workflows trigger URL.
+type teamsNotifier struct {
+	webhookURL string
+}
+
+// newTeamsNotifier returns a notifier posting to the given webhook URL.
+func newTeamsNotifier(webhookURL string) *teamsNotifier {
+	return &teamsNotifier{webhookURL: webhookURL}
+}
+
+// Name implements Notifier.
+func (n *teamsNotifier) Name() string {
+	return "teams"
+}
+
+// Notify implements Notifier.
+func (n *teamsNotifier) Notify(ctx context.Context, e Event) error {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, nil)
+	if err != nil {
+		return err
+	}
+
+	var facts []map[string]string
+	for _, f := range eventFacts(lang, e) {
+		facts = append(facts, map[string]string{"title": f.Label, "value": f.Value})
+	}
+	color := "Attention"
+	if e.Open {
+		color = "Good"
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []interface{}{
+			map[string]interface{}{"type": "TextBlock", "text": message, "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+			map[string]interface{}{"type": "FactSet", "facts": facts},
+		},
+	}
+	return postJSON(ctx, n.webhookURL, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	})
+}
+
+// Preview implements Previewer.
+func (n *teamsNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
+
+// googleChatNotifier posts announcements as cards to a Google Chat space
+// incoming webhook.
+type googleChatNotifier struct {
+	webhookURL string
+}
+
+// newGoogleChatNotifier returns a notifier posting to the given webhook URL.
+func newGoogleChatNotifier(webhookURL string) *googleChatNotifier {
+	return &googleChatNotifier{webhookURL: webhookURL}
+}
+
+// Name implements Notifier.
+func (n *googleChatNotifier) Name() string {
+	return "google-chat"
+}
+
+// Notify implements Notifier.
+func (n *googleChatNotifier) Notify(ctx context.Context, e Event) error {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, nil)
+	if err != nil {
+		return err
+	}
+
+	var widgets []interface{}
+	for _, f := range eventFacts(lang, e) {
+		widgets = append(widgets, map[string]interface{}{
+			"decoratedText": map[string]string{"topLabel": f.Label, "text": f.Value},
+		})
+	}
+	return postJSON(ctx, n.webhookURL, map[string]interface{}{
+		"text": message,
+		"cardsV2": []interface{}{
+			map[string]interface{}{
+				"cardId": "space-status",
+				"card": map[string]interface{}{
+					"header":   map[string]string{"title": message},
+					"sections": []interface{}{map[string]interface{}{"widgets": widgets}},
+				},
+			},
+		},
+	})
+}
+
+// Preview implements Previewer.
+func (n *googleChatNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
+
+// previewWebhook previews the rendered message of a single-destination
+// webhook notifier.
+func previewWebhook(name string, e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: name, Destination: "webhook", Text: message}}, nil
+}
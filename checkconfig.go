@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/host/v3"
+)
+
+// checkConfigFlag reports whether the command line asks for --check-config,
+// and whether as --check-config=online, which also tries the Slack token.
+func checkConfigFlag(args []string) (online, ok bool) {
+	for _, arg := range args {
+		switch arg {
+		case "--check-config", "-check-config":
+			return false, true
+		case "--check-config=online", "-check-config=online":
+			return true, true
+		}
+		if strings.HasPrefix(strings.TrimLeft(arg, "-"), "check-config=") {
+			fatal("Invalid --check-config; use --check-config or --check-config=online", "value", arg)
+		}
+	}
+	return false, false
+}
+
+// settingsCheck is one part of the configuration validated by
+// --check-config. run calls fatal on a problem, as at startup.
+type settingsCheck struct {
+	name string
+	run  func()
+}
+
+// runConfigCheck validates the configuration as startup would, without
+// opening connections, reading the switch, or serving, and prints a line
+// per check. It returns the exit status: 0 when everything passed.
+// Unlike startup it carries on after a problem, so all of them are listed.
+func runConfigCheck(online bool) int {
+	var err error
+	if fatalErr := catchFatal(func() { err = loadConfig() }); fatalErr != nil {
+		err = fatalErr
+	}
+	if err != nil {
+		fmt.Printf("FAIL configuration file: %v\n", err)
+		return 1
+	}
+	// Notifiers are built as in shadow mode, so none connects.
+	shadowOf := setting("SHADOW_OF")
+	shadowMode = true
+
+	checks := []settingsCheck{
+		{"core settings", loadCoreSettings},
+		{"startup announcement", func() {
+			if _, err := parseStartupAnnounce(setting("STARTUP_ANNOUNCE")); err != nil {
+				fatal("Invalid STARTUP_ANNOUNCE", "err", err)
+			}
+		}},
+	}
+	for _, s := range notifierSlots {
+		checks = append(checks, settingsCheck{"notifier " + s.name, func() {
+			if _, err := s.build(loadedSettings()); err != nil {
+				fatal("Invalid notifier settings", "err", err)
+			}
+		}})
+	}
+	checks = append(checks,
+		settingsCheck{"authentication", func() {
+			if _, err := buildAuthProviders(loadedSettings()); err != nil {
+				fatal("Invalid authentication settings", "err", err)
+			}
+		}},
+		settingsCheck{"message templates", func() {
+			if _, err := prepareMessageTemplates(loadedSettings()); err != nil {
+				fatal("Invalid template", "err", err)
+			}
+		}},
+		settingsCheck{"custom endpoints", func() {
+			if dir := setting("CUSTOM_ENDPOINTS_DIR"); dir != "" {
+				if _, err := loadCustomEndpoints(dir); err != nil {
+					fatal("Failed to load custom endpoints", "err", err)
+				}
+			}
+		}},
+		settingsCheck{"spaceapi", func() {
+			if _, err := loadSpaceAPIConfig(); err != nil {
+				fatal("Invalid SpaceAPI settings", "err", err)
+			}
+		}},
+		settingsCheck{"canary", func() { loadCanarySettings() }},
+		settingsCheck{"history", func() {
+			if _, err := historyBackend(); err != nil {
+				fatal("Invalid history settings", "err", err)
+			}
+			if _, err := loadRetentionPolicy(); err != nil {
+				fatal("Invalid history retention", "err", err)
+			}
+		}},
+		settingsCheck{"listen", func() {
+			addr := setting("LISTEN_ADDR")
+			if addr == "" {
+				addr = defaultListenAddr
+			}
+			if err := checkListenAddr(addr); err != nil {
+				fatal("Invalid LISTEN_ADDR", "err", err)
+			}
+		}},
+		settingsCheck{"tls", func() {
+			s, err := loadTLSSettings()
+			if err != nil {
+				fatal("Invalid TLS settings", "err", err)
+			}
+			if s.certFile != "" {
+				if err := (&certFiles{certFile: s.certFile, keyFile: s.keyFile}).load(); err != nil {
+					fatal("Invalid TLS certificate", "err", err)
+				}
+			}
+		}},
+		settingsCheck{"influx", func() {
+			if _, err := parseInfluxTags(setting("INFLUX_TAGS")); err != nil {
+				fatal("Invalid INFLUX_TAGS", "err", err)
+			}
+			if getEnvDuration("INFLUX_SAMPLE_INTERVAL", influxDefaultSampleInterval) < 0 {
+				fatal("INFLUX_SAMPLE_INTERVAL must not be negative")
+			}
+		}},
+	)
+	if shadowOf == "" {
+		checks = append(checks, settingsCheck{"gpio pin", checkSwitchPin})
+	}
+	if online {
+		checks = append(checks, settingsCheck{"slack auth", checkSlackToken})
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if err := catchFatal(c.run); err != nil {
+			fmt.Printf("FAIL %s: %v\n", c.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	warnUnusedSettings()
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
+// checkSwitchPin checks that every zone's switch pins exist on this host,
+// without configuring it.
+func checkSwitchPin() {
+	if _, err := host.Init(); err != nil {
+		fatal("Failed to initialize GPIO", "err", err)
+	}
+	for _, z := range zones {
+		for _, name := range []string{z.pinName, z.membersPinName} {
+			if name != "" && gpioreg.ByName(name) == nil {
+				fatal("Failed to find pin", "zone", z.name, "pin", name)
+			}
+		}
+	}
+}
+
+// checkSlackToken calls auth.test with SLACK_TOKEN.
+func checkSlackToken() {
+	ctx, cancel := context.WithTimeout(context.Background(), slackAuthCheckTime)
+	defer cancel()
+	if _, err := slack.New(getEnv("SLACK_TOKEN")).AuthTestContext(ctx); err != nil {
+		fatal("Slack auth check failed", "err", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressMinSize is the smallest body worth compressing; smaller ones are
+// sent as they are.
+const compressMinSize = 1024
+
+// brotliQuality trades ratio for the Pi's CPU; 11 is the best ratio.
+const brotliQuality = 5
+
+// compressHTTP turns response compression on or off, from
+// HTTP_COMPRESSION at startup.
+var compressHTTP = true
+
+// compressibleTypes are the media types compressed: JSON and HTML as well
+// as the CSV export, plain text, calendars, and SVG images.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+	"text/html":        true,
+	"text/csv":         true,
+	"text/plain":       true,
+	"text/calendar":    true,
+	"image/svg+xml":    true,
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, brotliQuality) }}
+)
+
+// compressEncoder is a pooled gzip or brotli writer.
+type compressEncoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header,
+// preferring br when both are equally acceptable, or returns "".
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			if k, v, ok := strings.Cut(p, "="); ok && strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if coding == "*" {
+			coding = "br"
+		}
+		if (coding == "br" || coding == "gzip") && q > 0 && (q > bestQ || q == bestQ && coding == "br") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses the body with the negotiated encoding when its
+// type is compressible. It holds back the first compressMinSize bytes to
+// decide, so small documents such as /status go out uncompressed; a
+// Flush decides at once, so streams start flowing.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	head     bool // HEAD request: headers only
+	status   int
+	buf      []byte
+	decided  bool
+	enc      compressEncoder
+}
+
+// newCompressWriter wraps w for r, or returns nil when r accepts no
+// encoding we offer.
+func newCompressWriter(w http.ResponseWriter, r *http.Request) *compressWriter {
+	if !compressHTTP {
+		return nil
+	}
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+	return &compressWriter{ResponseWriter: w, encoding: encoding, head: r.Method == http.MethodHead, status: http.StatusOK}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified || w.head {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < compressMinSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap supports http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends what has been written so far, compressing streamed bodies.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide writes the header, compressing the body from here on if large
+// is set and the response is compressible, and sends the bytes held back.
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	compressible := compressibleTypes[mediaType] && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		w.status != http.StatusPartialContent
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compressible && large && w.status != http.StatusNoContent && w.status != http.StatusNotModified && !w.head {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if w.encoding == "br" {
+			w.enc = brotliWriters.Get().(*brotli.Writer)
+		} else {
+			w.enc = gzipWriters.Get().(*gzip.Writer)
+		}
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close sends anything still held back and finishes the compressed
+// stream, returning the encoder to its pool.
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	w.enc.Reset(nil)
+	if w.encoding == "br" {
+		brotliWriters.Put(w.enc)
+	} else {
+		gzipWriters.Put(w.enc)
+	}
+	w.enc = nil
+}
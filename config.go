@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultConfigFile is read when it exists and CONFIG_FILE is unset.
+const defaultConfigFile = "space-status.toml"
+
+// configFile holds the settings read from the configuration file. File
+// keys map to the environment variable names used throughout: the table
+// path and key joined with underscores and uppercased, so token under
+// [slack] is SLACK_TOKEN, and max_size_mb under [log] is LOG_MAX_SIZE_MB.
+type configFile struct {
+	path   string
+	values map[string]configValue
+	// secrets are read from Vault along with the file, see loadVaultSecrets.
+	secrets    map[string]string
+	secretPath string
+
+	// validating is set while a reload tries the file out. An unreadable
+	// secret file then fails the reload, see readErr, instead of exiting.
+	validating atomic.Bool
+	mu         sync.Mutex
+	readErr    error // the first secret file that could not be read
+}
+
+// failed records an unreadable secret file of a file being validated.
+func (c *configFile) failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readErr == nil {
+		c.readErr = err
+	}
+}
+
+// secretFileErr returns the first secret file that could not be read while
+// the file was being validated.
+func (c *configFile) secretFileErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readErr
+}
+
+// configValue is one setting from the file, with its key in the file for
+// error messages.
+type configValue struct {
+	value string
+	key   string // e.g. log.max_size_mb
+}
+
+// loadedConfig holds the loaded configuration file. A reload replaces it
+// while requests read settings.
+var loadedConfig atomic.Pointer[configFile]
+
+// currentConfig returns the loaded configuration file; it is empty until
+// loadConfig runs and when there is no file.
+func currentConfig() *configFile {
+	if c := loadedConfig.Load(); c != nil {
+		return c
+	}
+	return &configFile{}
+}
+
+// settingsRead records every setting looked up, so file keys nothing reads
+// (usually typos) can be reported.
+var settingsRead = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: make(map[string]bool)}
+
+// settingSnapshot is the value of a setting at one point in time.
+type settingSnapshot struct {
+	value string
+	ok    bool
+}
+
+// settingsView looks settings up against one configuration file. A reload
+// reads the new file through a view before loading it, so requests keep
+// seeing the running configuration until the new one has been validated.
+// A view made by capture also records its lookups; it is meant for the
+// goroutine that made it.
+type settingsView struct {
+	file     *configFile
+	captures []map[string]settingSnapshot
+}
+
+// loadedSettings returns a view of the loaded configuration file.
+func loadedSettings() *settingsView {
+	return &settingsView{file: currentConfig()}
+}
+
+// loadConfig reads the file named by CONFIG_FILE (or --config), which must
+// exist, or space-status.toml in the working directory if there is one,
+// and makes it the loaded configuration.
+func loadConfig() error {
+	next, err := readConfig()
+	if err != nil {
+		return err
+	}
+	loadedConfig.Store(next)
+	return nil
+}
+
+// readConfig reads the configuration file without loading it.
+func readConfig() (*configFile, error) {
+	path, required := setting("CONFIG_FILE"), true
+	if path == "" {
+		path, required = defaultConfigFile, false
+	}
+	next := &configFile{}
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err) && !required:
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && info.Mode().Perm()&0004 != 0 {
+			slog.Warn("Configuration file is world-readable; it may contain secrets", "component", "config", "file", path)
+		}
+		values, err := parseConfig(path, f)
+		if err != nil {
+			return nil, err
+		}
+		next = &configFile{path: path, values: values}
+	}
+	if err := loadVaultSecrets(next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// parseConfig parses the configuration file. Every key with a value, in a
+// table, inline table, or dotted, is a setting; strings, integers, floats,
+// and booleans are kept as the string the environment variable would hold.
+// Lists are given as strings, exactly as in the environment variable, so
+// arrays are rejected, and so are dates and times.
+func parseConfig(path string, r io.Reader) (map[string]configValue, error) {
+	var doc map[string]any
+	md, err := toml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return nil, fmt.Errorf("%s:%d: %s", path, perr.Position.Line, perr.Message)
+		}
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	values := make(map[string]configValue)
+	for _, k := range md.Keys() {
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%s: %s", path, k, fmt.Sprintf(format, args...))
+		}
+		if _, err := splitConfigKey(k.String()); err != nil {
+			return nil, fail("%v", err)
+		}
+		var raw any = doc
+		for _, part := range k {
+			raw = raw.(map[string]any)[part]
+		}
+		var value string
+		switch v := raw.(type) {
+		case map[string]any:
+			continue // a table; its keys follow
+		case string:
+			value = v
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		case float64:
+			value = strconv.FormatFloat(v, 'g', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		case []any, []map[string]any:
+			return nil, fail("arrays are not supported; give lists as a string, as in the environment variable")
+		default:
+			return nil, fail("dates and times are not supported; quote them, and durations such as \"15m\", as strings")
+		}
+		key := settingName(k)
+		if prev, ok := values[key]; ok {
+			return nil, fail("%s is already set by %s", key, prev.key)
+		}
+		values[key] = configValue{value: value, key: k.String()}
+	}
+	return values, nil
+}
+
+// splitConfigKey splits a bare or dotted key such as "log.max_size_mb".
+func splitConfigKey(key string) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(key, ".") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		for _, c := range part {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+				return nil, fmt.Errorf("invalid key %q; use letters, digits, _ and -", key)
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// settingName maps a key path to its environment variable name.
+func settingName(parts []string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.Join(parts, "_"), "-", "_"))
+}
+
+// lookupSetting returns a setting from the loaded configuration, see
+// settingsView.lookup.
+func lookupSetting(key string) (string, bool) {
+	return loadedSettings().lookup(key)
+}
+
+// lookup returns a setting from the command line, the environment, Vault,
+// or the view's configuration file, in that order of precedence. An
+// environment variable takes precedence even when it is empty. Except in
+// Vault, any setting can instead be read from a file named by <key>_FILE,
+// as Docker and Kubernetes mount secrets; KEY takes precedence over
+// KEY_FILE from the same source.
+func (v *settingsView) lookup(key string) (string, bool) {
+	value, ok, err := resolveSetting(v.file, key)
+	if err != nil {
+		if !v.file.validating.Load() {
+			fatal("Failed to read secret file", "key", key+secretFileSuffix, "err", err)
+		}
+		v.file.failed(fmt.Errorf("%s%s: %w", key, secretFileSuffix, err))
+	}
+	markSettingsRead(key)
+	for _, capture := range v.captures {
+		capture[key] = settingSnapshot{value, ok}
+	}
+	return value, ok
+}
+
+// secretFileSuffix marks a setting naming a file to read the value from.
+const secretFileSuffix = "_FILE"
+
+// resolveSetting looks a setting up against the given configuration file
+// without recording the lookup. A secret file that cannot be read is
+// returned as an error, with the setting counting as set.
+func resolveSetting(file *configFile, key string) (string, bool, error) {
+	if f, ok := flagSettings[key]; ok {
+		return f.value, true, nil
+	}
+	if f, ok := flagSettings[key+secretFileSuffix]; ok {
+		value, err := readSecretFile(f.value)
+		return value, true, err
+	}
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true, nil
+	}
+	if path, ok := os.LookupEnv(key + secretFileSuffix); ok {
+		value, err := readSecretFile(path)
+		return value, true, err
+	}
+	if value, ok := file.secrets[key]; ok {
+		return value, true, nil
+	}
+	if v, ok := file.values[key]; ok {
+		return v.value, true, nil
+	}
+	if v, ok := file.values[key+secretFileSuffix]; ok {
+		value, err := readSecretFile(v.value)
+		return value, true, err
+	}
+	return "", false, nil
+}
+
+// readSecretFile returns the contents of a secret file without the
+// trailing newline most editors and `echo` add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// markSettingsRead records settings read without lookupSetting, so they
+// are not reported as unused.
+func markSettingsRead(keys ...string) {
+	settingsRead.Lock()
+	defer settingsRead.Unlock()
+	for _, key := range keys {
+		settingsRead.keys[key] = true
+		settingsRead.keys[key+secretFileSuffix] = true
+	}
+}
+
+// capture runs fn with a view that also records fn's lookups, and returns
+// them with their values. Captures nest; lookups made through other views,
+// such as by concurrent requests, are not recorded.
+func (v *settingsView) capture(fn func(v *settingsView)) map[string]settingSnapshot {
+	captured := make(map[string]settingSnapshot)
+	captures := append(append([]map[string]settingSnapshot(nil), v.captures...), captured)
+	fn(&settingsView{file: v.file, captures: captures})
+	return captured
+}
+
+// changed reports whether any setting in snapshot now has a different
+// value in the view.
+func (v *settingsView) changed(snapshot map[string]settingSnapshot) bool {
+	for key, before := range snapshot {
+		// A secret file that cannot be read counts as changed, so the
+		// rebuild reports it.
+		if value, ok, err := resolveSetting(v.file, key); err != nil || value != before.value || ok != before.ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setting returns a setting from the loaded configuration, or "" when it
+// is not set.
+func setting(key string) string {
+	return loadedSettings().setting(key)
+}
+
+// setting returns a setting, or "" when it is not set.
+func (v *settingsView) setting(key string) string {
+	value, _ := v.lookup(key)
+	return value
+}
+
+// settingSource describes where a setting of the loaded configuration
+// came from, see settingsView.source.
+func settingSource(key string) string {
+	return loadedSettings().source(key)
+}
+
+// source describes where a setting came from, for error messages.
+func (v *settingsView) source(key string) string {
+	if f, ok := flagSettings[key]; ok {
+		return "flag " + f.flag
+	}
+	if f, ok := flagSettings[key+secretFileSuffix]; ok {
+		return "file " + f.value
+	}
+	if _, ok := os.LookupEnv(key); ok {
+		return "environment"
+	}
+	if path, ok := os.LookupEnv(key + secretFileSuffix); ok {
+		return "file " + path
+	}
+	config := v.file
+	if _, ok := config.secrets[key]; ok {
+		return "vault " + config.secretPath
+	}
+	if v, ok := config.values[key]; ok {
+		return config.path + ":" + v.key
+	}
+	if v, ok := config.values[key+secretFileSuffix]; ok {
+		return "file " + v.value
+	}
+	return "default"
+}
+
+// warnUnusedSettings logs flags and configuration file keys that nothing
+// read, which are usually misspelt or belong to a disabled feature.
+func warnUnusedSettings() {
+	settingsRead.Lock()
+	defer settingsRead.Unlock()
+	for key, f := range flagSettings {
+		if !settingsRead.keys[key] {
+			slog.Warn("Unused setting on the command line", "component", "config", "key", key, "flag", f.flag)
+		}
+	}
+	var unused []string
+	for key := range currentConfig().values {
+		if !settingsRead.keys[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(unused)
+	for _, key := range unused {
+		slog.Warn("Unused setting in configuration file", "component", "config", "key", key, "source", settingSource(key))
+	}
+}
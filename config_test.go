@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    map[string]string
+		wantErr string // in the error, if it should fail
+	}{
+		{
+			name: "tables and top-level keys",
+			file: "startup_announce = \"ops\"\n[slack]\ntoken = \"xoxb-1\" # comment\n[log]\nmax_size_mb = 20\n",
+			want: map[string]string{"STARTUP_ANNOUNCE": "ops", "SLACK_TOKEN": "xoxb-1", "LOG_MAX_SIZE_MB": "20"},
+		},
+		{
+			name: "dotted keys and inline tables",
+			file: "log.max_age = \"720h\"\nslack = { channel = \"#space\" }\n",
+			want: map[string]string{"LOG_MAX_AGE": "720h", "SLACK_CHANNEL": "#space"},
+		},
+		{
+			name: "nested tables",
+			file: "[zone.woodshop]\npin = \"GPIO27\"\nopen-level = \"high\"\n",
+			want: map[string]string{"ZONE_WOODSHOP_PIN": "GPIO27", "ZONE_WOODSHOP_OPEN_LEVEL": "high"},
+		},
+		{
+			name: "values as in the environment",
+			file: "a = 'C:\\path'\nb = 1_000\nc = 0.5\nd = true\ne = \"\"\"\nmulti\"\"\"\n",
+			want: map[string]string{"A": "C:\\path", "B": "1000", "C": "0.5", "D": "true", "E": "multi"},
+		},
+		{
+			name: "empty file",
+			want: map[string]string{},
+		},
+		{name: "syntax error", file: "[slack]\ntoken = xoxb\n", wantErr: "test.toml:2:"},
+		{name: "unquoted duration", file: "log_max_age = 15m\n", wantErr: "test.toml:1:"},
+		{name: "key set twice", file: "[slack]\ntoken = \"a\"\ntoken = \"b\"\n", wantErr: "test.toml:3:"},
+		{name: "same setting twice", file: "slack_token = \"a\"\n[slack]\ntoken = \"b\"\n", wantErr: "SLACK_TOKEN is already set by slack_token"},
+		{name: "array", file: "zones = [\"main\"]\n", wantErr: "arrays are not supported"},
+		{name: "array of tables", file: "[[zone]]\npin = \"GPIO17\"\n", wantErr: "arrays are not supported"},
+		{name: "date", file: "since = 2024-01-01\n", wantErr: "dates and times are not supported"},
+		{name: "invalid key", file: "\"slack token\" = \"a\"\n", wantErr: "invalid key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseConfig("test.toml", strings.NewReader(tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConfig() = %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfig() = %v", err)
+			}
+			got := make(map[string]string)
+			for key, v := range values {
+				got[key] = v.value
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExampleConfig(t *testing.T) {
+	f, err := os.Open("space-status.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := parseConfig(f.Name(), f); err != nil {
+		t.Fatalf("parseConfig() = %v", err)
+	}
+}
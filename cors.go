@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// corsMaxAge is how long browsers may cache a preflight answer, in seconds.
+const corsMaxAge = 600
+
+// corsPolicy says which other origins' scripts may read the public
+// endpoints, read from the CORS_* settings at startup and on reload.
+type corsPolicy struct {
+	origins []string // "*", an origin, or an origin with a "*." subdomain wildcard
+	methods string
+	headers string
+}
+
+// loadCORSPolicy reads CORS_ALLOWED_ORIGINS, a comma-separated list of
+// origins such as https://splatspace.org or https://*.splatspace.org, or *
+// for any, and the methods and request headers allowed in preflights.
+// Without origins no CORS headers are sent.
+func loadCORSPolicy(v *settingsView) (corsPolicy, error) {
+	p := corsPolicy{methods: v.setting("CORS_ALLOWED_METHODS"), headers: v.setting("CORS_ALLOWED_HEADERS")}
+	for _, origin := range splitCommaList(v.setting("CORS_ALLOWED_ORIGINS")) {
+		if err := checkCORSOrigin(origin); err != nil {
+			return corsPolicy{}, fmt.Errorf("CORS_ALLOWED_ORIGINS: %s: %w", origin, err)
+		}
+		p.origins = append(p.origins, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+	if p.methods == "" {
+		p.methods = "GET, HEAD, OPTIONS"
+	}
+	if p.headers == "" {
+		p.headers = "Accept, Accept-Language, If-None-Match, If-Modified-Since"
+	}
+	return p, nil
+}
+
+// checkCORSOrigin accepts * or a scheme and host without a path.
+func checkCORSOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+		return fmt.Errorf("not an origin like https://example.org")
+	}
+	return nil
+}
+
+// allows reports whether scripts from origin may read responses.
+func (p corsPolicy) allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(rest, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyOrigin reports whether every origin is allowed.
+func (p corsPolicy) anyOrigin() bool {
+	for _, allowed := range p.origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// withCORS adds CORS headers for allowed origins to a public read
+// endpoint and answers preflight requests. Responses expose the headers
+// needed for conditional requests and tracing.
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := liveSettings().cors
+		h := w.Header()
+		if len(p.origins) > 0 && !p.anyOrigin() {
+			h.Add("Vary", "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && p.allows(origin)
+		if allowed {
+			if p.anyOrigin() {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			h.Set("Access-Control-Expose-Headers", "ETag, Last-Modified, "+requestIDHeader)
+		}
+		if r.Method != http.MethodOptions {
+			next(w, r)
+			return
+		}
+		if allowed && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", p.methods)
+			h.Set("Access-Control-Allow-Headers", p.headers)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		}
+		h.Set("Allow", "GET, HEAD, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handlePublic registers a rate-limited public read endpoint for GET,
+// which includes HEAD, and for CORS preflights.
+func handlePublic(path string, h http.HandlerFunc) {
+	http.HandleFunc("GET "+path, withCORS(limitRate(h)))
+	http.HandleFunc("OPTIONS "+path, withCORS(limitRate(h)))
+}
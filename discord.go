@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Embed colors for Discord announcements.
+const (
+	discordColorOpen   = 0x2ecc71
+	discordColorClosed = 0xe74c3c
+)
+
+// discordNotifier posts announcements to a Discord channel webhook.
+type discordNotifier struct {
+	webhookURL string
+}
+
+// newDiscordNotifier returns a notifier posting to the given webhook URL.
+func newDiscordNotifier(webhookURL string) *discordNotifier {
+	return &discordNotifier{webhookURL: webhookURL}
+}
+
+// Name implements Notifier.
+func (n *discordNotifier) Name() string {
+	return "discord"
+}
+
+// Notify implements Notifier.
+func (n *discordNotifier) Notify(ctx context.Context, e Event) error {
+	payload, err := n.payload(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.webhookURL, payload)
+}
+
+// Preview implements Previewer.
+func (n *discordNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
+
+// payload builds the webhook body: the rendered message as the embed title
+// with state, timestamp, and previous-state duration fields.
+func (n *discordNotifier) payload(e Event) (map[string]interface{}, error) {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	color := discordColorClosed
+	if e.Open {
+		color = discordColorOpen
+	}
+	fields := []map[string]interface{}{
+		{"name": translate(lang, msgFieldState), "value": statusText(lang, e.Status), "inline": true},
+		{"name": translate(lang, msgFieldChanged), "value": fmt.Sprintf("<t:%d:f>", e.Time.Unix()), "inline": true},
+	}
+	if e.Duration > 0 {
+		fields = append(fields, map[string]interface{}{
+			"name": translate(lang, msgFieldDuration), "value": formatDuration(e.Duration), "inline": true,
+		})
+	}
+
+	embed := map[string]interface{}{
+		"title":     message,
+		"color":     color,
+		"timestamp": e.Time.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		"fields":    fields,
+	}
+	return map[string]interface{}{"embeds": []interface{}{embed}}, nil
+}
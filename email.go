@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTP TLS modes.
+const (
+	smtpTLSStartTLS = "starttls" // upgrade a plain connection, usually port 587
+	smtpTLSImplicit = "tls"      // TLS from the first byte, usually port 465
+	smtpTLSNone     = "none"
+)
+
+// defaultEmailSubject is used when EMAIL_SUBJECT_TEMPLATE is unset.
+const defaultEmailSubject = "[space-status] {{.Default}}"
+
+// emailNotifier sends announcements by email to a fixed recipient list and
+// to subscribers who registered an address with /optin email.
+type emailNotifier struct {
+	addr       string // host:port
+	tlsMode    string
+	username   string
+	password   string
+	from       string
+	recipients []string
+	subject    *template.Template
+	templates  templateSet
+}
+
+// newEmailNotifier returns a notifier sending through the SMTP server at addr.
+func newEmailNotifier(addr, tlsMode, username, password, from string, recipients []string, subject *template.Template, templates templateSet) (*emailNotifier, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	for _, r := range recipients {
+		if _, err := mail.ParseAddress(r); err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", r, err)
+		}
+	}
+	switch tlsMode {
+	case smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone:
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", tlsMode)
+	}
+	return &emailNotifier{
+		addr:       addr,
+		tlsMode:    tlsMode,
+		username:   username,
+		password:   password,
+		from:       from,
+		recipients: recipients,
+		subject:    subject,
+		templates:  templates,
+	}, nil
+}
+
+// Name implements Notifier.
+func (n *emailNotifier) Name() string {
+	return "email"
+}
+
+// Notify implements Notifier.
+func (n *emailNotifier) Notify(ctx context.Context, e Event) error {
+	to := n.recipientsFor(e)
+	if len(to) == 0 {
+		return nil
+	}
+	subject, body, err := n.render(e, nil)
+	if err != nil {
+		return err
+	}
+	return n.send(ctx, to, subject, body, e.ID)
+}
+
+// Preview implements Previewer.
+func (n *emailNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	subject, body, err := n.render(e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	var plan []plannedMessage
+	for _, to := range n.recipientsFor(e) {
+		plan = append(plan, plannedMessage{Notifier: n.Name(), Destination: to, Text: "Subject: " + subject + "\n\n" + body})
+	}
+	return plan, nil
+}
+
+// recipientsFor returns the configured recipients plus subscribers with an
+// email address who want this event.
+func (n *emailNotifier) recipientsFor(e Event) []string {
+	seen := make(map[string]bool)
+	var to []string
+	for _, addr := range append(append([]string{}, n.recipients...), emailSubscribersFor(e)...) {
+		key := strings.ToLower(addr)
+		if !seen[key] {
+			seen[key] = true
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// render produces the subject and body of the announcement.
+func (n *emailNotifier) render(e Event, overrides templateSet) (string, string, error) {
+	lang := liveSettings().locale
+	body, err := renderMessage(lang, e, mergeTemplates(overrides, n.templates))
+	if err != nil {
+		return "", "", err
+	}
+	var subject bytes.Buffer
+	err = n.subject.Execute(&subject, templateData{
+		Open:     e.Open,
+		State:    statusText(lang, e.Status),
+		Status:   e.Status.String(),
+		Zone:     eventZoneLabel(e),
+		Time:     e.Time,
+		Duration: formatDuration(e.Duration),
+		Default:  defaultMessage(lang, e),
+		Session:  e.Session,
+
+		SpaceOpen:  e.SpaceStatus.open(),
+		SpaceState: statusText(lang, e.SpaceStatus),
+		Zones:      e.Zones,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("render subject: %w", err)
+	}
+	return strings.Join(strings.Fields(subject.String()), " "), body, nil
+}
+
+// send delivers one message to all recipients, who are kept out of the
+// headers so subscribers do not see each other's addresses.
+func (n *emailNotifier) send(ctx context.Context, to []string, subject, body, eventID string) error {
+	host, _, err := net.SplitHostPort(n.addr)
+	if err != nil {
+		return permanent(err)
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if n.tlsMode == smtpTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", n.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", n.addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if n.tlsMode == smtpTLSStartTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if n.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.username, n.password, host)); err != nil {
+			return permanent(fmt.Errorf("auth: %w", err))
+		}
+	}
+
+	from, _ := mail.ParseAddress(n.from)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	var rcptErrs []error
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			rcptErrs = append(rcptErrs, fmt.Errorf("%s: %w", addr, err))
+		}
+	}
+	if len(rcptErrs) == len(to) {
+		return permanent(errors.Join(rcptErrs...))
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(from, subject, body, eventID)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := c.Quit(); err != nil {
+		return err
+	}
+	// Retrying would resend to the recipients that were accepted.
+	if len(rcptErrs) > 0 {
+		return permanent(errors.Join(rcptErrs...))
+	}
+	return nil
+}
+
+// message formats an RFC 5322 message with a quoted-printable UTF-8 body.
+// The Message-ID is derived from the event ID so a resent copy can be
+// recognised as a duplicate.
+func (n *emailNotifier) message(from *mail.Address, subject, body, eventID string) []byte {
+	var msg bytes.Buffer
+	id := eventID
+	if id == "" {
+		id, _ = randomHex(12)
+	}
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: undisclosed-recipients:;\r\n")
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", id, hostFromAddress(from.Address))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return msg.Bytes()
+}
+
+func hostFromAddress(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
+
+// splitCommaList splits a comma-separated list, dropping empty entries.
+func splitCommaList(value string) []string {
+	var addrs []string
+	for _, a := range strings.Split(value, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// customEndpointSuffix marks template files in CUSTOM_ENDPOINTS_DIR.
+const customEndpointSuffix = ".tmpl"
+
+// customEndpoint is an operator-defined GET endpoint rendering a template
+// against the current state, e.g. whatsapp.txt.tmpl served as
+// /whatsapp.txt.
+type customEndpoint struct {
+	path        string
+	contentType string
+	tmpl        interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+}
+
+// endpointData is what custom endpoint templates receive.
+type endpointData struct {
+	Open        bool           // open to everyone or to members only
+	State       string         // localized state name
+	Status      string         // open, members_only, or closed
+	Message     string         // localized status sentence
+	Since       time.Time      // last state change; zero if unknown
+	Duration    string         // time in the current state, e.g. "2h 15m"
+	Seq         uint64         // sequence number of the latest event
+	Session     *sessionRecord // the current session, if open
+	NextOpening *occurrence    // next scheduled opening, if interface{}
+}
+
+// endpointFuncs are available in custom endpoint templates.
+var endpointFuncs = map[string]interface{}{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// loadCustomEndpoints parses every *.tmpl file in dir. The file name minus
+// the suffix is the path and must have an extension, which sets the
+// Content-Type. .html templates are escaped with html/template.
+func loadCustomEndpoints(dir string) ([]customEndpoint, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+customEndpointSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []customEndpoint
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), customEndpointSuffix)
+		ext := filepath.Ext(name)
+		if ext == "" {
+			return nil, fmt.Errorf("%s: endpoint name needs an extension, e.g. %s.txt%s", file, name, customEndpointSuffix)
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		e := customEndpoint{path: "/" + name, contentType: mime.TypeByExtension(ext)}
+		if e.contentType == "" {
+			e.contentType = "text/plain; charset=utf-8"
+		}
+		if ext == ".html" {
+			e.tmpl, err = htmltemplate.New(name).Funcs(endpointFuncs).Option("missingkey=error").Parse(string(source))
+		} else {
+			e.tmpl, err = template.New(name).Funcs(endpointFuncs).Option("missingkey=error").Parse(string(source))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := e.tmpl.Execute(io.Discard, currentEndpointData(time.Now())); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func currentEndpointData(now time.Time) endpointData {
+	lang := liveSettings().locale
+	cur := currentSpace()
+	text := statusText(lang, cur.Status)
+	data := endpointData{
+		Open:    cur.Status.open(),
+		State:   text,
+		Status:  cur.Status.String(),
+		Message: statusMessage(lang, cur.Status),
+		Since:   cur.Since,
+		Seq:     eventSequence.current(),
+	}
+	if !cur.Since.IsZero() {
+		data.Duration = formatDuration(now.Sub(cur.Since))
+	}
+	if cur.Status.open() {
+		data.Session = sessions.snapshot(now)
+		if data.Session != nil {
+			data.Session.ClosedAt = time.Time{}
+		}
+	}
+	if next := upcomingOpenings(now, scheduleHorizon); len(next) > 0 {
+		data.NextOpening = &next[0]
+	}
+	return data
+}
+
+// registerCustomEndpoints adds the endpoints to the default mux. An
+// endpoint clashing with a built-in route stops startup.
+func registerCustomEndpoints(endpoints []customEndpoint) {
+	for _, e := range endpoints {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fatal("Custom endpoint conflicts with a built-in route", "path", e.path, "err", r)
+				}
+			}()
+			http.HandleFunc("GET "+e.path, limitRate(e.serve))
+		}()
+		slog.Info("Serving custom endpoint", "component", "http", "path", e.path)
+	}
+}
+
+func (e customEndpoint) serve(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := e.tmpl.Execute(&buf, currentEndpointData(time.Now())); err != nil {
+		slog.Error("Failed to render custom endpoint", "component", "http", "path", e.path, "err", err)
+		http.Error(w, "Failed to render endpoint", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", e.contentType)
+	w.Write(buf.Bytes())
+}
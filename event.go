@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultZone names the area covered by the door switch when no zones are
+// configured.
+const defaultZone = "main"
+
+// Event describes a change of a zone's state and, unless ZoneOnly, of the
+// space state.
+type Event struct {
+	ID       string         `json:"id"`  // ULID assigned when the event is created
+	Seq      uint64         `json:"seq"` // position in the event sequence; 0 for hypothetical events
+	Zone     string         `json:"zone"`
+	Status   spaceStatus    `json:"status"`
+	Open     bool           `json:"open"` // Status is open or members only
+	Time     time.Time      `json:"time"`
+	Duration time.Duration  `json:"-"` // time the zone spent in the previous state
+	Session  *sessionRecord `json:"-"` // the session that ended, on close events
+	// ZoneOnly is set when the zone changed but the space as a whole did not,
+	// e.g. the woodshop closing while the main space stays open.
+	ZoneOnly bool `json:"zone_only,omitempty"`
+	// SpaceStatus is the state of the space after the event.
+	SpaceStatus spaceStatus `json:"-"`
+	// SpaceDuration is the time the space spent in its previous state, on
+	// events that changed it.
+	SpaceDuration time.Duration `json:"-"`
+	// Zones is every zone's state after the event, when ZONES is set.
+	Zones []zoneStatus `json:"-"`
+	// Manual is set when a status override changed the space, with the
+	// reason given for it, if any.
+	Manual bool   `json:"manual,omitempty"`
+	Reason string `json:"-"`
+}
+
+// newEvent creates an event of the named zone with a fresh ID.
+func newEvent(zone string, s spaceStatus, at time.Time) Event {
+	return Event{ID: newULID(at), Zone: zone, Status: s, Open: s.open(), Time: at}
+}
+
+// sequenceCounter hands out persisted, strictly increasing event sequence
+// numbers, so consumers can detect missed events across restarts.
+type sequenceCounter struct {
+	mu   sync.Mutex
+	path string
+	last uint64
+}
+
+var eventSequence = &sequenceCounter{path: filepath.Join(dataDir, "sequence.json")}
+
+// load restores the last number handed out by a previous run.
+func (c *sequenceCounter) load() error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved struct {
+		Last uint64 `json:"last"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parse %s: %w", c.path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = saved.Last
+	return nil
+}
+
+// next returns the next sequence number. The number is used even if saving
+// it fails, so an error only risks reuse after a restart.
+func (c *sequenceCounter) next() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last++
+	return c.last, writeJSONFile(c.path, map[string]uint64{"last": c.last})
+}
+
+// advance makes sure numbers up to n are never handed out again, e.g. when
+// the event log is ahead of a lost or stale counter file.
+func (c *sequenceCounter) advance(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > c.last {
+		c.last = n
+	}
+}
+
+// current returns the last number handed out.
+func (c *sequenceCounter) current() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// State returns the machine-readable name of the new state.
+func (e Event) State() string {
+	if e.Open {
+		return "open"
+	}
+	return "closed"
+}
+
+// formatDuration renders a duration to minute precision, e.g. "2h 15m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours, minutes := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	switch {
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// fact is a labelled value shown in card-style announcements.
+type fact struct {
+	Label string
+	Value string
+}
+
+// eventFacts returns the localized state, change time, and previous-state
+// duration of an event for card layouts.
+func eventFacts(loc string, e Event) []fact {
+	facts := []fact{
+		{translate(loc, msgFieldState), statusText(loc, e.Status)},
+		{translate(loc, msgFieldChanged), e.Time.In(liveSettings().location).Format("2006-01-02 15:04 MST")},
+	}
+	if e.Duration > 0 {
+		facts = append(facts, fact{translate(loc, msgFieldDuration), formatDuration(e.Duration)})
+	}
+	return facts
+}
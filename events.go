@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event backfill page sizes.
+const (
+	eventsDefaultPage = 100
+	eventsMaxPage     = 1000
+)
+
+// eventRecord is the JSON form of an event shared by the event log, the
+// events API, webhooks, and MQTT.
+type eventRecord struct {
+	ID       string      `json:"id"`
+	Seq      uint64      `json:"seq"`
+	State    string      `json:"state"`
+	Status   spaceStatus `json:"status"`
+	Open     bool        `json:"open"`
+	Zone     string      `json:"zone"`
+	ZoneOnly bool        `json:"zone_only,omitempty"` // the space as a whole did not change
+	Manual   bool        `json:"manual,omitempty"`    // a status override changed the space
+	// SpaceStatus is the state of the space after the event, set with ZONES.
+	SpaceStatus     spaceStatus `json:"space_status,omitempty"`
+	Time            time.Time   `json:"time"`
+	DurationSeconds int64       `json:"duration_seconds"`
+}
+
+func newEventRecord(e Event) eventRecord {
+	r := eventRecord{
+		ID:              e.ID,
+		Seq:             e.Seq,
+		State:           e.State(),
+		Status:          e.Status,
+		Open:            e.Open,
+		Zone:            e.Zone,
+		ZoneOnly:        e.ZoneOnly,
+		Manual:          e.Manual,
+		Time:            e.Time.UTC(),
+		DurationSeconds: int64(e.Duration / time.Second),
+	}
+	if zonesConfigured {
+		r.SpaceStatus = e.SpaceStatus
+	}
+	return r
+}
+
+// spaceEventRecord returns the record of an event that changed the space,
+// with the state fields describing the space rather than the zone, for
+// consumers that follow the space alone.
+func spaceEventRecord(e Event) eventRecord {
+	r := newEventRecord(e)
+	r.Status, r.Open = e.SpaceStatus, e.SpaceStatus.open()
+	r.State = Event{Open: r.Open}.State()
+	return r
+}
+
+// status returns the zone's state after the event. Events recorded before
+// statuses only say whether it opened.
+func (r eventRecord) status() spaceStatus {
+	if r.Status != statusUnknown {
+		return r.Status
+	}
+	return statusFromOpen(r.Open)
+}
+
+// spaceStatus returns the space's state after the event.
+func (r eventRecord) spaceStatus() spaceStatus {
+	if r.SpaceStatus != statusUnknown {
+		return r.SpaceStatus
+	}
+	return r.status()
+}
+
+// eventLog is an append-only record of every state change, kept as JSON
+// lines so consumers can backfill missed events by sequence number.
+type eventLog struct {
+	mu      sync.Mutex
+	path    string
+	records []eventRecord // ordered by Seq
+}
+
+var events = &eventLog{path: filepath.Join(dataDir, "events.jsonl")}
+
+// load reads the events recorded by previous runs. Damaged lines, e.g.
+// from a crash mid-write, are skipped.
+func (l *eventLog) load() error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records []eventRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var r eventRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			slog.Warn("Skipping damaged line", "component", "events", "file", l.path, "line", line, "err", err)
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", l.path, err)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = records
+	return nil
+}
+
+// lastSeq returns the sequence number of the latest recorded event.
+func (l *eventLog) lastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == 0 {
+		return 0
+	}
+	return l.records[len(l.records)-1].Seq
+}
+
+// inZone reports whether the record is an event of the named zone. Events
+// recorded before zones existed belong to the default zone.
+func (r eventRecord) inZone(zone string) bool {
+	if r.Zone == "" {
+		return zone == defaultZone
+	}
+	return r.Zone == zone
+}
+
+// last returns the most recent event of the zone.
+func (l *eventLog) last(zone string) (eventRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.records) - 1; i >= 0; i-- {
+		if l.records[i].inZone(zone) {
+			return l.records[i], true
+		}
+	}
+	return eventRecord{}, false
+}
+
+// lastSpace returns the most recent event that changed the space state.
+func (l *eventLog) lastSpace() (eventRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.records) - 1; i >= 0; i-- {
+		if !l.records[i].ZoneOnly {
+			return l.records[i], true
+		}
+	}
+	return eventRecord{}, false
+}
+
+// latest returns the most recent event that changed the space to s.
+func (l *eventLog) latest(s spaceStatus) (eventRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.records) - 1; i >= 0; i-- {
+		if r := l.records[i]; !r.ZoneOnly && r.spaceStatus() == s {
+			return r, true
+		}
+	}
+	return eventRecord{}, false
+}
+
+// openings returns the times the space opened at or after since, oldest
+// first, and the time of the first recorded event. Going from open to
+// members only or back is not an opening.
+func (l *eventLog) openings(since time.Time) ([]time.Time, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == 0 {
+		return nil, time.Time{}
+	}
+	var opens []time.Time
+	wasOpen := false
+	for _, r := range l.records {
+		if r.ZoneOnly {
+			continue
+		}
+		open := r.spaceStatus().open()
+		if open && !wasOpen && !r.Time.Before(since) {
+			opens = append(opens, r.Time)
+		}
+		wasOpen = open
+	}
+	return opens, l.records[0].Time
+}
+
+// record appends an event to the log.
+func (l *eventLog) record(e Event) error {
+	r := newEventRecord(e)
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, r)
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// since returns up to limit events after seq, oldest first, and whether
+// more remain.
+func (l *eventLog) since(seq uint64, limit int) ([]eventRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := sort.Search(len(l.records), func(i int) bool { return l.records[i].Seq > seq })
+	rest := l.records[i:]
+	if len(rest) > limit {
+		return append([]eventRecord(nil), rest[:limit]...), true
+	}
+	return append([]eventRecord(nil), rest...), false
+}
+
+// handleListEvents serves events after since_seq so stream consumers that
+// were offline can catch up before resuming: page with the last returned
+// seq until has_more is false.
+func handleListEvents(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if v := r.URL.Query().Get("since_seq"); v != "" {
+		parsed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since_seq", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	limit, err := queryInt(r, "limit", eventsDefaultPage)
+	if err != nil || limit < 1 || limit > eventsMaxPage {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", eventsMaxPage), http.StatusBadRequest)
+		return
+	}
+
+	page, more := events.since(since, limit)
+	if page == nil {
+		page = []eventRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":     page,
+		"has_more":   more,
+		"latest_seq": eventSequence.current(),
+	})
+}
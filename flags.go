@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// flagAliases are short flag names for common settings. Every other
+// setting is available as its name in lowercase with dashes, e.g.
+// --log-max-size-mb for LOG_MAX_SIZE_MB.
+var flagAliases = map[string]string{
+	"config":  "CONFIG_FILE",
+	"listen":  "LISTEN_ADDR",
+	"pin":     "GPIO_PIN",
+	"channel": "SLACK_CHANNEL",
+}
+
+// flagValue is a setting given on the command line, with the flag as
+// written for messages.
+type flagValue struct {
+	value string
+	flag  string
+}
+
+// flagSettings are the settings given on the command line. They take
+// precedence over the environment and the configuration file.
+var flagSettings = make(map[string]flagValue)
+
+// parseFlags reads settings from command-line arguments of the form
+// --name=value or --name value; a flag without a value, such as
+// --mqtt-ha-discovery, means true. One or two dashes are accepted. The
+// --soak and --check-config mode flags are handled by soakFlag and
+// checkConfigFlag and skipped here.
+func parseFlags(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return fmt.Errorf("unexpected argument %q; settings are given as --name=value", arg)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "h", "help":
+			printUsage()
+			os.Exit(0)
+		case "soak", "check-config":
+			continue
+		}
+		if !hasValue {
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				value = args[i]
+			} else {
+				value = "true"
+			}
+		}
+		key, ok := flagAliases[name]
+		if !ok {
+			parts, err := splitConfigKey(name)
+			if err != nil || len(parts) != 1 {
+				return fmt.Errorf("invalid flag %q", arg)
+			}
+			key = settingName(parts)
+		}
+		if prev, ok := flagSettings[key]; ok {
+			return fmt.Errorf("%s is already set by %s", key, prev.flag)
+		}
+		flagSettings[key] = flagValue{value: value, flag: "--" + name}
+	}
+	return nil
+}
+
+// printUsage describes the command line on stdout.
+func printUsage() {
+	aliases := make([]string, 0, len(flagAliases))
+	for name := range flagAliases {
+		aliases = append(aliases, name)
+	}
+	sort.Strings(aliases)
+
+	fmt.Println("Usage: space-status [--name=value ...]")
+	fmt.Println("       space-status --check-config[=online] [--name=value ...]")
+	fmt.Println()
+	fmt.Println("Every setting can be given as a flag named after it in lowercase")
+	fmt.Println("with dashes, e.g. --log-max-size-mb=20 for LOG_MAX_SIZE_MB. Flags")
+	fmt.Println("override environment variables, which override the configuration")
+	fmt.Println("file, which overrides the defaults.")
+	fmt.Println()
+	fmt.Println("Shorthands:")
+	for _, name := range aliases {
+		fmt.Printf("  --%-10s %s\n", name, flagAliases[name])
+	}
+	fmt.Println()
+	fmt.Println("See README.md for the settings.")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Health check settings.
+const (
+	monitorStallAfter  = 5 * time.Second                  // no poll for this long means the monitor is stuck
+	feedStallAfter     = 2*agentHeartbeat + 5*time.Second // the same for the shadow agent feed
+	slackAuthRetry     = time.Minute
+	slackAuthCheckTime = 10 * time.Second
+)
+
+var (
+	// monitorStall is how old a zone's heartbeat may get; it depends on
+	// whether the switch is polled or followed over the agent feed.
+	monitorStall = monitorStallAfter
+	// configLoaded is set once startup has read all configuration and
+	// state.
+	configLoaded atomic.Bool
+	// shadowMode is set in main when the switch comes from an agent feed.
+	shadowMode bool
+)
+
+// slackAuth remembers the outcome of validating the Slack token.
+var slackAuth struct {
+	sync.Mutex
+	checked bool
+	err     error
+	team    string
+	teamID  string
+}
+
+// healthCheck is the result of one check in a health response.
+type healthCheck struct {
+	Status string `json:"status"` // "ok", "fail", or "skipped"
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthResponse is the body of /healthz and /readyz.
+type healthResponse struct {
+	Status string                 `json:"status"` // "ok" or "fail"
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// checkSlackAuth validates the Slack token, retrying until it succeeds
+// so a Slack outage at boot only delays readiness.
+func checkSlackAuth(token string) {
+	api := slack.New(token)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), slackAuthCheckTime)
+		resp, err := api.AuthTestContext(ctx)
+		cancel()
+
+		slackAuth.Lock()
+		slackAuth.checked, slackAuth.err = true, err
+		if err == nil {
+			slackAuth.team, slackAuth.teamID = resp.Team, resp.TeamID
+		}
+		slackAuth.Unlock()
+		if err == nil {
+			slog.Info("Slack token valid", "component", "slack", "team", resp.Team)
+			return
+		}
+		slog.Warn("Slack auth check failed", "component", "slack", "retry_in", slackAuthRetry, "err", err)
+		time.Sleep(slackAuthRetry)
+	}
+}
+
+// gpioCheck reports whether every zone's switch pins are still readable.
+func gpioCheck() healthCheck {
+	if shadowMode {
+		return healthCheck{Status: "skipped", Detail: "shadow mode reads the agent feed"}
+	}
+	var names []string
+	for _, z := range zones {
+		pins := z.pins.Load()
+		if pins == nil {
+			return healthCheck{Status: "fail", Detail: z.pinName + " not set up yet"}
+		}
+		for _, p := range pins.all() {
+			if !pinIsInput(p) {
+				return healthCheck{Status: "fail", Detail: p.Name() + " no longer reads as an input"}
+			}
+			names = append(names, p.Name())
+		}
+	}
+	return healthCheck{Status: "ok", Detail: strings.Join(names, ", ")}
+}
+
+// monitorCheck reports whether every zone's monitor loop is running. In
+// shadow mode only the first zone is followed, over the agent feed.
+func monitorCheck() healthCheck {
+	monitored := zones
+	if shadowMode {
+		monitored = zones[:1]
+	}
+	for _, z := range monitored {
+		last := z.heartbeat.Load()
+		if last == 0 {
+			return healthCheck{Status: "fail", Detail: zoneDetail(z, "not started")}
+		}
+		age := time.Since(time.Unix(0, last))
+		if age > monitorStall {
+			return healthCheck{Status: "fail", Detail: zoneDetail(z, "last poll "+age.Round(time.Second).String()+" ago")}
+		}
+	}
+	return healthCheck{Status: "ok"}
+}
+
+// zoneDetail prefixes a health check detail with the zone it is about when
+// there are several.
+func zoneDetail(z *zone, detail string) string {
+	if !zonesConfigured {
+		return detail
+	}
+	return z.name + ": " + detail
+}
+
+func slackCheck() healthCheck {
+	slackAuth.Lock()
+	defer slackAuth.Unlock()
+	switch {
+	case !slackAuth.checked:
+		return healthCheck{Status: "fail", Detail: "not checked yet"}
+	case slackAuth.err != nil:
+		return healthCheck{Status: "fail", Detail: slackAuth.err.Error()}
+	}
+	return healthCheck{Status: "ok", Detail: slackAuth.team}
+}
+
+func configCheck() healthCheck {
+	if !configLoaded.Load() {
+		return healthCheck{Status: "fail", Detail: "still starting"}
+	}
+	return healthCheck{Status: "ok"}
+}
+
+// handleHealthz reports liveness: the process serves requests and the
+// switch pin is reachable.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, map[string]healthCheck{"gpio": gpioCheck()})
+}
+
+// handleReadyz reports readiness: configuration is loaded, the Slack token
+// is valid, and the monitor loop is running.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, map[string]healthCheck{
+		"config":  configCheck(),
+		"slack":   slackCheck(),
+		"monitor": monitorCheck(),
+	})
+}
+
+// writeHealth responds 200 if no check failed and 503 otherwise.
+func writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	resp := healthResponse{Status: "ok", Checks: checks}
+	for _, c := range checks {
+		if c.Status == "fail" {
+			resp.Status = "fail"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
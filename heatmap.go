@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Weeks covered by /stats/heatmap.svg.
+const (
+	heatmapDefaultWeeks = 12
+	heatmapMaxWeeks     = 104
+)
+
+// Layout of the heatmap image, in pixels.
+const (
+	heatmapCell   = 18
+	heatmapGap    = 2
+	heatmapLeft   = 40 // weekday labels
+	heatmapTop    = 34 // title and hour labels
+	heatmapMargin = 4
+)
+
+// heatmapColors shade cells by the share of the hour the space was open:
+// none, up to a quarter, a half, three quarters, and more.
+var heatmapColors = [...]string{"#ebedf0", "#9be9a8", "#40c463", "#30a14e", "#216e39"}
+
+// heatmapNoData fills hours before the history begins.
+const heatmapNoData = "#f6f8fa"
+
+// heatmapGrid holds the share of each hour of the week, Monday first, the
+// space was open; -1 marks hours the history does not cover.
+type heatmapGrid [7][24]float64
+
+// handleHeatmap serves an SVG image of how often the space was open in
+// each hour of the week over the last weeks (default 12), for embedding on
+// a website. It is public like /status, as it shows no more than the
+// status does over time. zone draws one zone, and lang picks the language
+// of the labels.
+func handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	if _, off := history.(noHistory); off {
+		http.Error(w, "History is turned off", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	weeks := heatmapDefaultWeeks
+	if value := q.Get("weeks"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > heatmapMaxWeeks {
+			http.Error(w, fmt.Sprintf("weeks must be between 1 and %d", heatmapMaxWeeks), http.StatusBadRequest)
+			return
+		}
+		weeks = n
+	}
+	zone := q.Get("zone")
+	until := time.Now()
+	grid, err := heatmapOf(r.Context(), zone, until.AddDate(0, 0, -7*weeks), until)
+	if err != nil {
+		slog.Error("Failed to query history", "component", "history", "err", err)
+		http.Error(w, "Failed to query history", http.StatusInternalServerError)
+		return
+	}
+
+	loc := negotiateLocale(q.Get("lang"), r.Header.Get("Accept-Language"))
+	title := translate(loc, msgHeatmapTitle, weeks)
+	if zone != "" {
+		label := zoneLabel(zone)
+		if z := zoneByName(zone); z != nil {
+			label = z.label
+		}
+		title = label + " · " + title
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(renderHeatmap(grid, title, loc))
+}
+
+// heatmapOf computes the grid of the zone or, with zone empty, the space
+// between since and until. Hours before the first transition kept, or
+// before the history was pruned, are not covered.
+func heatmapOf(ctx context.Context, zone string, since, until time.Time) (heatmapGrid, error) {
+	var grid heatmapGrid
+	if prunedBefore, err := history.PrunedBefore(ctx); err != nil {
+		return grid, err
+	} else if prunedBefore.After(since) {
+		since = prunedBefore
+	}
+	first, err := history.Query(ctx, historyFilter{Limit: 1})
+	if err != nil {
+		return grid, err
+	}
+	if len(first) > 0 && first[0].Time.After(since) {
+		since = first[0].Time
+	}
+	var open, covered [7][24]time.Duration
+	if len(first) > 0 && since.Before(until) {
+		intervals, err := openIntervals(ctx, zone, since, until)
+		if err != nil {
+			return grid, err
+		}
+		for _, in := range intervals {
+			addByHour(&open, in.Start, in.End)
+		}
+		addByHour(&covered, since, until)
+	}
+	for day := range grid {
+		for hour := range grid[day] {
+			grid[day][hour] = -1
+			if covered[day][hour] > 0 {
+				grid[day][hour] = float64(open[day][hour]) / float64(covered[day][hour])
+			}
+		}
+	}
+	return grid, nil
+}
+
+// addByHour adds the time from start to end to the hours of the week in
+// TIMEZONE it falls in.
+func addByHour(cells *[7][24]time.Duration, start, end time.Time) {
+	tz := liveSettings().location
+	for start.Before(end) {
+		local := start.In(tz)
+		next := time.Date(local.Year(), local.Month(), local.Day(), local.Hour()+1, 0, 0, 0, tz)
+		if !next.After(start) {
+			// The clocks went back: the hour repeats.
+			next = start.Truncate(time.Hour).Add(time.Hour)
+		}
+		if next.After(end) {
+			next = end
+		}
+		cells[(local.Weekday()+6)%7][local.Hour()] += next.Sub(start)
+		start = next
+	}
+}
+
+// renderHeatmap draws the grid as an SVG image with weekdays as rows and
+// hours as columns. Each cell has a tooltip with its share.
+func renderHeatmap(grid heatmapGrid, title, loc string) []byte {
+	step := heatmapCell + heatmapGap
+	width := heatmapLeft + 24*step + heatmapMargin
+	height := heatmapTop + 7*step + heatmapMargin
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="10" fill="#57606a">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&b, `<text x="0" y="12" font-size="12" fill="#24292f">%s</text>`+"\n", html.EscapeString(title))
+	for hour := 0; hour < 24; hour += 3 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%02d</text>`+"\n", heatmapLeft+hour*step, heatmapTop-6, hour)
+	}
+	for day := range grid {
+		weekday := time.Weekday((day + 1) % 7)
+		name := weekdayAbbreviation(loc, weekday)
+		y := heatmapTop + day*step
+		fmt.Fprintf(&b, `<text x="0" y="%d">%s</text>`+"\n", y+heatmapCell-5, html.EscapeString(name))
+		for hour, share := range grid[day] {
+			fill, tip := heatmapNoData, translate(loc, msgHeatmapNoData, name, hour, (hour+1)%24)
+			if share >= 0 {
+				fill = heatmapColors[heatmapLevel(share)]
+				tip = translate(loc, msgHeatmapCell, name, hour, (hour+1)%24, int(share*100+0.5))
+			}
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s"><title>%s</title></rect>`+"\n",
+				heatmapLeft+hour*step, y, heatmapCell, heatmapCell, fill, html.EscapeString(tip))
+		}
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// heatmapLevel returns the index into heatmapColors for a share.
+func heatmapLevel(share float64) int {
+	switch {
+	case share <= 0:
+		return 0
+	case share <= 0.25:
+		return 1
+	case share <= 0.5:
+		return 2
+	case share <= 0.75:
+		return 3
+	}
+	return 4
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of transitions, recorded with each one.
+const (
+	sourceSwitch  = "switch"  // the switch changed while the service ran
+	sourceStartup = "startup" // the first reading differed from the last known state
+	sourceAgent   = "agent"   // a reading from the agent feed, in shadow mode
+	sourceImport  = "import"  // imported from the event log
+)
+
+// transition is one change of a zone's state, as kept in the history and
+// served by /history.
+type transition struct {
+	EventID string      `json:"event_id"`
+	Seq     uint64      `json:"seq"`
+	Time    time.Time   `json:"time"`
+	Zone    string      `json:"zone"`
+	From    spaceStatus `json:"from"` // unknown when there was no earlier state
+	To      spaceStatus `json:"to"`
+	// SpaceFrom and SpaceTo are the state of the space before and after;
+	// equal when only the zone changed.
+	SpaceFrom spaceStatus `json:"space_from"`
+	SpaceTo   spaceStatus `json:"space_to"`
+	Source    string      `json:"source"`
+}
+
+// historyFilter selects transitions from the history.
+type historyFilter struct {
+	Since time.Time   // inclusive; zero for no bound
+	Until time.Time   // exclusive; zero for no bound
+	Zone  string      // empty for every zone
+	State spaceStatus // the state changed to; unknown for any
+	// NewestFirst reverses the order, for paging back from the present.
+	NewestFirst bool
+	Offset      int
+	Limit       int // 0 for no limit
+}
+
+// historyStore keeps every state transition, as the backbone of history,
+// statistics, and reports. Implementations are safe for concurrent use.
+type historyStore interface {
+	// Record adds a transition. Recording one with the event ID of an
+	// earlier one does nothing, so imports can be repeated.
+	Record(ctx context.Context, t transition) error
+	// Query returns the transitions matching f, oldest first unless
+	// f.NewestFirst.
+	Query(ctx context.Context, f historyFilter) ([]transition, error)
+	// Each calls fn with every transition matching f, in the order of
+	// Query, without holding them all in memory. An error from fn stops it.
+	Each(ctx context.Context, f historyFilter, fn func(t transition) error) error
+	// Count returns how many transitions match f, ignoring its offset and
+	// limit.
+	Count(ctx context.Context, f historyFilter) (int, error)
+	// Empty reports whether no transition has been recorded.
+	Empty(ctx context.Context) (bool, error)
+	// Prune records totals and deletes the transitions before cutoff in
+	// one go, keeping the last one of each zone, which gives its state at
+	// the cutoff. It returns how many it deleted.
+	Prune(ctx context.Context, cutoff time.Time, totals []dailyTotal) (int, error)
+	// PrunedBefore returns the cutoff of the latest Prune, zero if none.
+	PrunedBefore(ctx context.Context) (time.Time, error)
+	// DailyTotals returns the totals of the zone, empty for the space, for
+	// the days from since until before until, oldest first. Zero times
+	// are no bound.
+	DailyTotals(ctx context.Context, zone string, since, until time.Time) ([]dailyTotal, error)
+	Close() error
+}
+
+// dailyTotal is what remains of a day's transitions once the history is
+// downsampled.
+type dailyTotal struct {
+	Day         string // e.g. "2026-10-14", in TIMEZONE
+	Zone        string // empty for the space as a whole
+	OpenSeconds int64
+	Openings    int
+}
+
+// History backends, selected with HISTORY_STORE.
+const (
+	historyStoreSQLite   = "sqlite"
+	historyStorePostgres = "postgres"
+	historyStoreNone     = "none"
+)
+
+// Page sizes of /history.
+const (
+	historyDefaultPage = 100
+	historyMaxPage     = 1000
+)
+
+// historyWriteTimeout bounds recording one transition, so a locked
+// database cannot hold up announcements.
+const historyWriteTimeout = 5 * time.Second
+
+// historyConnectTimeout bounds connecting to a history database server at
+// startup.
+const historyConnectTimeout = 10 * time.Second
+
+// history is the configured history store.
+var history historyStore = noHistory{}
+
+// historyBackend reads HISTORY_STORE, defaulting to SQLite. PostgreSQL
+// needs HISTORY_POSTGRES_DSN.
+func historyBackend() (string, error) {
+	switch backend := strings.ToLower(setting("HISTORY_STORE")); backend {
+	case "":
+		return historyStoreSQLite, nil
+	case historyStorePostgres:
+		if setting("HISTORY_POSTGRES_DSN") == "" {
+			return "", fmt.Errorf("HISTORY_STORE=postgres needs HISTORY_POSTGRES_DSN")
+		}
+		return backend, nil
+	case historyStoreSQLite, historyStoreNone:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("unknown HISTORY_STORE %q; use sqlite, postgres, or none", backend)
+	}
+}
+
+// openHistoryStore opens the store selected by HISTORY_STORE: SQLite at
+// HISTORY_SQLITE_PATH by default, PostgreSQL at HISTORY_POSTGRES_DSN, or
+// none.
+func openHistoryStore() (historyStore, error) {
+	backend, err := historyBackend()
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case historyStoreNone:
+		return noHistory{}, nil
+	case historyStorePostgres:
+		return openPostgresHistory(setting("HISTORY_POSTGRES_DSN"))
+	}
+	path := setting("HISTORY_SQLITE_PATH")
+	if path == "" {
+		path = filepath.Join(dataDir, "history.db")
+	}
+	return openSQLiteHistory(path)
+}
+
+// configureHistory opens the history store and, while it is empty, fills
+// it from the event log, so history reaches back to before it existed.
+func configureHistory() error {
+	store, err := openHistoryStore()
+	if err != nil {
+		return err
+	}
+	history = store
+	ctx := context.Background()
+	empty, err := store.Empty(ctx)
+	if err != nil {
+		return err
+	}
+	if empty {
+		n, err := importHistory(ctx, store)
+		if err != nil {
+			return fmt.Errorf("import event log: %w", err)
+		}
+		if n > 0 {
+			slog.Info("Imported event log into history", "component", "history", "transitions", n)
+		}
+	}
+	return nil
+}
+
+// importHistory records every event of the event log as a transition,
+// tracking the states before each from the events before it.
+func importHistory(ctx context.Context, store historyStore) (int, error) {
+	records, _ := events.since(0, math.MaxInt)
+	zoneStates := make(map[string]spaceStatus)
+	space := statusUnknown
+	for _, r := range records {
+		zone := r.Zone
+		if zone == "" {
+			zone = defaultZone
+		}
+		t := transition{EventID: r.ID, Seq: r.Seq, Time: r.Time, Zone: zone,
+			From: zoneStates[zone], To: r.status(), SpaceFrom: space, SpaceTo: space, Source: sourceImport}
+		if !r.ZoneOnly {
+			t.SpaceTo = r.spaceStatus()
+		}
+		if err := store.Record(ctx, t); err != nil {
+			return 0, err
+		}
+		zoneStates[zone], space = t.To, t.SpaceTo
+	}
+	return len(records), nil
+}
+
+// recordTransition adds a transition to the history, logging a failure:
+// the event log still has the change.
+func recordTransition(t transition) {
+	ctx, cancel := context.WithTimeout(context.Background(), historyWriteTimeout)
+	defer cancel()
+	if err := history.Record(ctx, t); err != nil {
+		slog.Error("Failed to record history", "component", "history", "event", t.EventID, "err", err)
+	}
+}
+
+// handleHistory serves past transitions, newest first, paged with limit
+// and offset. since and until bound the time range, as RFC 3339 times or
+// dates in TIMEZONE, until including the whole day; zone and state select
+// the zone, which may be one no longer configured, and the state changed
+// to.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if _, off := history.(noHistory); off {
+		http.Error(w, "History is turned off", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	f := historyFilter{Zone: q.Get("zone"), NewestFirst: true}
+	var err error
+	if f.Since, err = parseHistoryTime(q.Get("since"), false); err != nil {
+		http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Until, err = parseHistoryTime(q.Get("until"), true); err != nil {
+		http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("state"); v != "" {
+		if f.State, err = parseSpaceStatus(v); err != nil || f.State == statusUnknown {
+			http.Error(w, `state must be "open", "members_only", or "closed"`, http.StatusBadRequest)
+			return
+		}
+	}
+	if f.Offset, err = queryInt(r, "offset", 0); err != nil || f.Offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	f.Limit, err = queryInt(r, "limit", historyDefaultPage)
+	if err != nil || f.Limit < 1 || f.Limit > historyMaxPage {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", historyMaxPage), http.StatusBadRequest)
+		return
+	}
+
+	page, err := history.Query(r.Context(), f)
+	if err != nil {
+		slog.Error("Failed to query history", "component", "history", "err", err)
+		http.Error(w, "Failed to query history", http.StatusInternalServerError)
+		return
+	}
+	total, err := history.Count(r.Context(), f)
+	if err != nil {
+		slog.Error("Failed to count history", "component", "history", "err", err)
+		http.Error(w, "Failed to query history", http.StatusInternalServerError)
+		return
+	}
+	if page == nil {
+		page = []transition{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transitions": page,
+		"total":       total,
+		"offset":      f.Offset,
+		"limit":       f.Limit,
+		"has_more":    f.Offset+len(page) < total,
+	})
+}
+
+// historyCSVHeader is the first row of CSV exports. local_time is in
+// TIMEZONE, for spreadsheets.
+var historyCSVHeader = []string{"event_id", "seq", "time", "local_time", "zone", "from", "to", "space_from", "space_to", "source"}
+
+// handleHistoryExport streams every transition, oldest first, as CSV or, with
+// format=json, a JSON array, for analysis elsewhere. since, until, and zone
+// narrow it down as for /history.
+func handleHistoryExport(w http.ResponseWriter, r *http.Request) {
+	tz := liveSettings().location
+	if _, off := history.(noHistory); off {
+		http.Error(w, "History is turned off", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, `format must be "csv" or "json"`, http.StatusBadRequest)
+		return
+	}
+	f := historyFilter{Zone: q.Get("zone")}
+	var err error
+	if f.Since, err = parseHistoryTime(q.Get("since"), false); err != nil {
+		http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Until, err = parseHistoryTime(q.Get("until"), true); err != nil {
+		http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := "history-" + time.Now().In(tz).Format("2006-01-02") + "." + format
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	var write func(t transition) error
+	var finish func() error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(historyCSVHeader)
+		write = func(t transition) error {
+			return cw.Write([]string{t.EventID, strconv.FormatUint(t.Seq, 10), t.Time.Format(time.RFC3339),
+				t.Time.In(tz).Format("2006-01-02 15:04:05"), t.Zone, t.From.String(), t.To.String(),
+				t.SpaceFrom.String(), t.SpaceTo.String(), t.Source})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "[")
+		enc := json.NewEncoder(w)
+		first := true
+		write = func(t transition) error {
+			if !first {
+				io.WriteString(w, ",")
+			}
+			first = false
+			return enc.Encode(t)
+		}
+		finish = func() error {
+			_, err := io.WriteString(w, "]\n")
+			return err
+		}
+	}
+
+	// The status is sent with the first bytes, so a failure part way is only
+	// logged and leaves the download truncated.
+	if err := history.Each(r.Context(), f, write); err != nil {
+		slog.Error("History export failed", "component", "history", "err", err)
+		return
+	}
+	if err := finish(); err != nil {
+		slog.Error("History export failed", "component", "history", "err", err)
+	}
+}
+
+// parseHistoryTime parses a time bound of /history: an RFC 3339 time or a
+// date in the schedule's timezone, meaning its start or, with endOfDay, the
+// start of the next day. Empty is no bound.
+func parseHistoryTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, liveSettings().location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a date", value)
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// noHistory is the store with HISTORY_STORE=none: it keeps nothing.
+type noHistory struct{}
+
+// Record implements historyStore.
+func (noHistory) Record(ctx context.Context, t transition) error { return nil }
+
+// Query implements historyStore.
+func (noHistory) Query(ctx context.Context, f historyFilter) ([]transition, error) { return nil, nil }
+
+// Each implements historyStore.
+func (noHistory) Each(ctx context.Context, f historyFilter, fn func(t transition) error) error {
+	return nil
+}
+
+// Count implements historyStore.
+func (noHistory) Count(ctx context.Context, f historyFilter) (int, error) { return 0, nil }
+
+// Empty implements historyStore.
+func (noHistory) Empty(ctx context.Context) (bool, error) { return false, nil }
+
+// Prune implements historyStore.
+func (noHistory) Prune(ctx context.Context, cutoff time.Time, totals []dailyTotal) (int, error) {
+	return 0, nil
+}
+
+// PrunedBefore implements historyStore.
+func (noHistory) PrunedBefore(ctx context.Context) (time.Time, error) { return time.Time{}, nil }
+
+// DailyTotals implements historyStore.
+func (noHistory) DailyTotals(ctx context.Context, zone string, since, until time.Time) ([]dailyTotal, error) {
+	return nil, nil
+}
+
+// Close implements historyStore.
+func (noHistory) Close() error { return nil }
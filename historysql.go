@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// historySchema creates the tables: transitions, the daily totals left by
+// downsampling, and history_meta, which holds the pruned_before cutoff.
+// Times are Unix nanoseconds and states are status names. It is written to
+// work on both SQLite and PostgreSQL.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS transitions (
+	event_id   TEXT PRIMARY KEY,
+	seq        BIGINT NOT NULL,
+	time       BIGINT NOT NULL,
+	zone       TEXT NOT NULL,
+	from_state TEXT NOT NULL,
+	to_state   TEXT NOT NULL,
+	space_from TEXT NOT NULL,
+	space_to   TEXT NOT NULL,
+	source     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS transitions_time ON transitions (time);
+CREATE INDEX IF NOT EXISTS transitions_zone_time ON transitions (zone, time);
+CREATE TABLE IF NOT EXISTS daily_totals (
+	day          TEXT NOT NULL,
+	zone         TEXT NOT NULL,
+	open_seconds BIGINT NOT NULL,
+	openings     INTEGER NOT NULL,
+	PRIMARY KEY (day, zone)
+);
+CREATE TABLE IF NOT EXISTS history_meta (
+	key   TEXT PRIMARY KEY,
+	value BIGINT NOT NULL
+);
+`
+
+// sqlHistory is a history store in an SQL database: an embedded SQLite
+// file or a PostgreSQL server. Queries are written with ? placeholders and
+// rewritten for PostgreSQL.
+type sqlHistory struct {
+	db *sql.DB
+	// numbered is set for databases taking $1, $2, ... placeholders.
+	numbered bool
+}
+
+// openSQLiteHistory opens or creates the database at path. Write-ahead
+// logging lets a transition be written while a slow export is reading, and
+// writers wait for each other for up to the busy timeout.
+func openSQLiteHistory(path string) (*sqlHistory, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	return newSQLHistory(db, false, path)
+}
+
+// openPostgresHistory connects to the PostgreSQL database at dsn, e.g.
+// postgres://status@db.example.org/spacestatus?sslmode=require, and
+// creates the tables if needed.
+func openPostgresHistory(dsn string) (*sqlHistory, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), historyConnectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to PostgreSQL: %w", err)
+	}
+	return newSQLHistory(db, true, "PostgreSQL")
+}
+
+// newSQLHistory creates the tables in db, described as where in errors.
+func newSQLHistory(db *sql.DB, numbered bool, where string) (*sqlHistory, error) {
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create history schema in %s: %w", where, err)
+	}
+	return &sqlHistory{db: db, numbered: numbered}, nil
+}
+
+// bind rewrites the ? placeholders of query for the database.
+func (h *sqlHistory) bind(query string) string {
+	if !h.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Record implements historyStore.
+func (h *sqlHistory) Record(ctx context.Context, t transition) error {
+	_, err := h.db.ExecContext(ctx, h.bind(`INSERT INTO transitions
+		(event_id, seq, time, zone, from_state, to_state, space_from, space_to, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (event_id) DO NOTHING`),
+		t.EventID, int64(t.Seq), t.Time.UnixNano(), t.Zone, t.From.String(), t.To.String(),
+		t.SpaceFrom.String(), t.SpaceTo.String(), t.Source)
+	return err
+}
+
+// sqlWhere returns the WHERE clause selecting f, empty with no
+// conditions, and its arguments.
+func sqlWhere(f historyFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if !f.Since.IsZero() {
+		where, args = append(where, "time >= ?"), append(args, f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		where, args = append(where, "time < ?"), append(args, f.Until.UnixNano())
+	}
+	if f.Zone != "" {
+		where, args = append(where, "zone = ?"), append(args, f.Zone)
+	}
+	if f.State != statusUnknown {
+		where, args = append(where, "to_state = ?"), append(args, f.State.String())
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// Query implements historyStore.
+func (h *sqlHistory) Query(ctx context.Context, f historyFilter) ([]transition, error) {
+	var result []transition
+	err := h.Each(ctx, f, func(t transition) error {
+		result = append(result, t)
+		return nil
+	})
+	return result, err
+}
+
+// Each implements historyStore.
+func (h *sqlHistory) Each(ctx context.Context, f historyFilter, fn func(t transition) error) error {
+	where, args := sqlWhere(f)
+	query := `SELECT event_id, seq, time, zone, from_state, to_state, space_from, space_to, source FROM transitions` + where
+	if f.NewestFirst {
+		query += " ORDER BY time DESC, seq DESC"
+	} else {
+		query += " ORDER BY time, seq"
+	}
+	if f.Limit > 0 || f.Offset > 0 {
+		// An offset needs a limit in SQLite, where -1 is none; PostgreSQL
+		// takes NULL.
+		var limit interface{} = f.Limit
+		if f.Limit <= 0 {
+			limit = -1
+			if h.numbered {
+				limit = nil
+			}
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, f.Offset)
+	}
+
+	rows, err := h.db.QueryContext(ctx, h.bind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t transition
+		var seq, nanos int64
+		var from, to, spaceFrom, spaceTo string
+		if err := rows.Scan(&t.EventID, &seq, &nanos, &t.Zone, &from, &to, &spaceFrom, &spaceTo, &t.Source); err != nil {
+			return err
+		}
+		t.Seq, t.Time = uint64(seq), time.Unix(0, nanos).UTC()
+		for _, s := range []struct {
+			name string
+			dst  *spaceStatus
+		}{{from, &t.From}, {to, &t.To}, {spaceFrom, &t.SpaceFrom}, {spaceTo, &t.SpaceTo}} {
+			if err := s.dst.UnmarshalText([]byte(s.name)); err != nil {
+				return fmt.Errorf("transition %s: %w", t.EventID, err)
+			}
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Count implements historyStore.
+func (h *sqlHistory) Count(ctx context.Context, f historyFilter) (int, error) {
+	where, args := sqlWhere(f)
+	var n int
+	err := h.db.QueryRowContext(ctx, h.bind(`SELECT COUNT(*) FROM transitions`+where), args...).Scan(&n)
+	return n, err
+}
+
+// Empty implements historyStore.
+func (h *sqlHistory) Empty(ctx context.Context) (bool, error) {
+	var exists bool
+	err := h.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM transitions)`).Scan(&exists)
+	return !exists, err
+}
+
+// Prune implements historyStore.
+func (h *sqlHistory) Prune(ctx context.Context, cutoff time.Time, totals []dailyTotal) (int, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, t := range totals {
+		if _, err := tx.ExecContext(ctx, h.bind(`INSERT INTO daily_totals (day, zone, open_seconds, openings)
+			VALUES (?, ?, ?, ?) ON CONFLICT (day, zone)
+			DO UPDATE SET open_seconds = excluded.open_seconds, openings = excluded.openings`),
+			t.Day, t.Zone, t.OpenSeconds, t.Openings); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.ExecContext(ctx, h.bind(`DELETE FROM transitions WHERE time < ?
+		AND time < (SELECT MAX(time) FROM transitions AS latest WHERE latest.zone = transitions.zone AND latest.time < ?)`),
+		cutoff.UnixNano(), cutoff.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, h.bind(`INSERT INTO history_meta (key, value) VALUES ('pruned_before', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value WHERE history_meta.value < excluded.value`),
+		cutoff.UnixNano()); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// PrunedBefore implements historyStore.
+func (h *sqlHistory) PrunedBefore(ctx context.Context) (time.Time, error) {
+	var nanos int64
+	err := h.db.QueryRowContext(ctx, `SELECT value FROM history_meta WHERE key = 'pruned_before'`).Scan(&nanos)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
+
+// DailyTotals implements historyStore.
+func (h *sqlHistory) DailyTotals(ctx context.Context, zone string, since, until time.Time) ([]dailyTotal, error) {
+	tz := liveSettings().location
+	query := `SELECT day, zone, open_seconds, openings FROM daily_totals WHERE zone = ?`
+	args := []interface{}{zone}
+	if !since.IsZero() {
+		query, args = query+" AND day >= ?", append(args, dayPeriod(since.In(tz)))
+	}
+	if !until.IsZero() {
+		query, args = query+" AND day < ?", append(args, dayPeriod(until.In(tz)))
+	}
+	rows, err := h.db.QueryContext(ctx, h.bind(query+" ORDER BY day"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var totals []dailyTotal
+	for rows.Next() {
+		var t dailyTotal
+		if err := rows.Scan(&t.Day, &t.Zone, &t.OpenSeconds, &t.Openings); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// Close implements historyStore.
+func (h *sqlHistory) Close() error {
+	return h.db.Close()
+}
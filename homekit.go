@@ -0,0 +1,64 @@
+//go:build homekit
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+)
+
+// homekitNotifier exposes the space state as a HomeKit contact sensor:
+// "contact not detected" (door open) while the space is open.
+type homekitNotifier struct {
+	sensor *service.ContactSensor
+}
+
+// Name implements Notifier.
+func (n *homekitNotifier) Name() string {
+	return "homekit"
+}
+
+// Notify implements Notifier. The sensor stands for the space, so events
+// that only change a zone are ignored.
+func (n *homekitNotifier) Notify(ctx context.Context, e Event) error {
+	if e.ZoneOnly {
+		return nil
+	}
+	n.sensor.ContactSensorState.SetValue(homekitContactState(e.SpaceStatus.open()))
+	return nil
+}
+
+func homekitContactState(open bool) int {
+	if open {
+		return characteristic.ContactSensorStateContactNotDetected
+	}
+	return characteristic.ContactSensorStateContactDetected
+}
+
+// startHomeKit serves the accessory on addr, pairing with pin. Pairings are
+// kept under the data directory.
+func startHomeKit(name, pin, addr string) (Notifier, error) {
+	a := accessory.New(accessory.Info{Name: name, Manufacturer: "Splatspace", Model: "space-status"}, accessory.TypeSensor)
+	sensor := service.NewContactSensor()
+	sensor.ContactSensorState.SetValue(homekitContactState(currentSpace().Status.open()))
+	a.AddS(sensor.S)
+
+	server, err := hap.NewServer(hap.NewFsStore(filepath.Join(dataDir, "homekit")), a)
+	if err != nil {
+		return nil, err
+	}
+	server.Pin = pin
+	server.Addr = addr
+	go func() {
+		if err := server.ListenAndServe(context.Background()); err != nil {
+			slog.Error("HomeKit server stopped", "component", "homekit", "err", err)
+		}
+	}()
+	return &homekitNotifier{sensor: sensor}, nil
+}
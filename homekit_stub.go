@@ -0,0 +1,10 @@
+//go:build !homekit
+
+package main
+
+import "errors"
+
+// startHomeKit is unavailable unless built with -tags homekit.
+func startHomeKit(name, pin, addr string) (Notifier, error) {
+	return nil, errors.New("built without HomeKit support; rebuild with -tags homekit")
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookSignatureHeader carries the HMAC signature of a webhook body.
+const webhookSignatureHeader = "X-Space-Status-Signature"
+
+// Outgoing webhook event types.
+const (
+	hookStateChanged   = "state.changed"
+	hookSessionStarted = "session.started"
+	hookSessionUpdated = "session.updated"
+	hookSessionEnded   = "session.ended"
+)
+
+// hookEventTypes are the event types a webhook subscriber may choose.
+var hookEventTypes = map[string]bool{
+	hookStateChanged:   true,
+	hookSessionStarted: true,
+	hookSessionUpdated: true,
+	hookSessionEnded:   true,
+}
+
+// hookPayload is the JSON body POSTed to webhook subscribers.
+type hookPayload struct {
+	ID        string      `json:"id"` // event ID for state changes, a fresh ULID otherwise
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookSubscriber POSTs the event types it subscribed to to one URL. It
+// has its own queue so a slow subscriber does not delay the others.
+// Deliveries are signed when the subscriber has a secret. A configuration
+// reload can change the URL, secret, and events while the queue carries
+// on.
+type webhookSubscriber struct {
+	queue  *deliveryQueue[hookDelivery]
+	seen   *recentIDs
+	walKey string // name of the queue in notifyLog
+
+	mu     sync.Mutex
+	url    string
+	secret string
+	events map[string]bool
+}
+
+// hookDelivery is a payload waiting for delivery; ctx carries its trace.
+type hookDelivery struct {
+	ctx     context.Context
+	payload hookPayload
+}
+
+// newWebhookSubscriber returns a subscriber; start must be called before
+// it is used.
+func newWebhookSubscriber(url, secret string, events map[string]bool) *webhookSubscriber {
+	return &webhookSubscriber{url: url, secret: secret, events: events, queue: newDeliveryQueue[hookDelivery](), seen: newRecentIDs()}
+}
+
+// start registers the subscriber's queue and starts its delivery worker.
+func (w *webhookSubscriber) start() {
+	w.walKey = notifyLog.register(w.Name(), w)
+	go supervise(context.Background(), "notifier "+w.walKey, func(context.Context) { w.run() })
+}
+
+// settings returns the subscriber's current URL, secret, and events.
+func (w *webhookSubscriber) settings() (string, string, map[string]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.url, w.secret, w.events
+}
+
+// reconfigure implements reconfigurable, taking over the settings of a
+// freshly parsed subscriber.
+func (w *webhookSubscriber) reconfigure(fresh Notifier) bool {
+	f, ok := fresh.(*webhookSubscriber)
+	if !ok {
+		return false
+	}
+	url, secret, events := f.settings()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.url, w.secret, w.events = url, secret, events
+	return true
+}
+
+// registeredWebhooks returns the webhook subscribers among r's notifiers.
+func registeredWebhooks(r *notifierRegistry) []*webhookSubscriber {
+	var hooks []*webhookSubscriber
+	for _, n := range r.Notifiers() {
+		if w, ok := n.(*webhookSubscriber); ok {
+			hooks = append(hooks, w)
+		}
+	}
+	return hooks
+}
+
+// Name implements Notifier.
+func (w *webhookSubscriber) Name() string {
+	return "webhook"
+}
+
+// Notify implements Notifier for state changes.
+func (w *webhookSubscriber) Notify(ctx context.Context, e Event) error {
+	return w.publish(ctx, hookPayload{ID: e.ID, Event: hookStateChanged, Timestamp: e.Time.UTC(), Data: newEventRecord(e)})
+}
+
+// Preview implements Previewer.
+func (w *webhookSubscriber) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	url, _, events := w.settings()
+	if !events[hookStateChanged] {
+		return nil, nil
+	}
+	return []plannedMessage{{Notifier: w.Name(), Destination: url, Text: hookStateChanged + " " + e.State()}}, nil
+}
+
+// PublishSession delivers a session lifecycle event. Ended sessions include
+// the localized closing summary. Nothing is sent while notifications are
+// paused.
+func (w *webhookSubscriber) PublishSession(kind string, s *sessionRecord) {
+	if _, ok := notificationsPause.active(); ok {
+		return
+	}
+	data := map[string]interface{}{"session": viewSession(s)}
+	if kind == hookSessionEnded {
+		data["summary"] = sessionSummary(liveSettings().locale, s)
+	}
+	now := time.Now()
+	if err := w.publish(context.Background(), hookPayload{ID: newULID(now), Event: kind, Timestamp: now.UTC(), Data: data}); err != nil {
+		url, _, _ := w.settings()
+		slog.Error("Dropping webhook", "component", "webhooks", "event", kind, "url", url, "err", err)
+	}
+}
+
+// publish enqueues a payload if the subscriber wants its event type,
+// recording it in the write-ahead log first.
+func (w *webhookSubscriber) publish(ctx context.Context, p hookPayload) error {
+	if _, _, events := w.settings(); !events[p.Event] || !w.seen.add(p.ID) {
+		return nil
+	}
+	if err := notifyLog.accept(walEntry{Queue: w.walKey, ID: p.ID, Payload: &p}); err != nil {
+		slog.Error("Failed to log delivery", "component", "webhooks", "queue", w.walKey, "delivery", p.ID, "err", err)
+	}
+	if err := w.enqueue(hookDelivery{ctx: context.WithoutCancel(ctx), payload: p}); err != nil {
+		w.seen.forget(p.ID)
+		return err
+	}
+	return nil
+}
+
+func (w *webhookSubscriber) enqueue(d hookDelivery) error {
+	return enqueueDelivery(w.queue, w.walKey, d.payload.ID, d)
+}
+
+// replay implements walQueue.
+func (w *webhookSubscriber) replay(entry walEntry) {
+	if entry.Payload == nil {
+		notifyLog.done(w.walKey, entry.ID)
+		return
+	}
+	w.seen.add(entry.ID)
+	if err := w.enqueue(hookDelivery{ctx: context.Background(), payload: *entry.Payload}); err != nil {
+		w.seen.forget(entry.ID)
+		slog.Error("Dropping replayed delivery", "component", "webhooks", "queue", w.walKey, "delivery", entry.ID, "err", err)
+	}
+}
+
+// canaryStage implements canaryTarget.
+func (w *webhookSubscriber) canaryStage() string {
+	if _, _, events := w.settings(); !events[hookStateChanged] {
+		return ""
+	}
+	return w.walKey
+}
+
+func (w *webhookSubscriber) run() {
+	for d := range w.queue.items {
+		p := d.payload
+		if canaryArrived(p.ID, w.walKey) {
+			notifyLog.done(w.walKey, p.ID)
+			continue
+		}
+		if held, ok := announcementsHeld(); ok {
+			slog.Info("Notifications held back; dropping webhook", "component", "webhooks", "queue", w.walKey, "event", p.Event, "delivery", p.ID, "held_by", held)
+			notifyLog.done(w.walKey, p.ID)
+			continue
+		}
+		body, err := json.Marshal(p)
+		if err != nil {
+			slog.Error("Failed to encode webhook", "component", "webhooks", "event", p.Event, "err", err)
+			notifyLog.done(w.walKey, p.ID)
+			continue
+		}
+		url, secret, _ := w.settings()
+		err = deliverWithRetry(d.ctx, w.Name(), func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return permanent(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			// Receivers can deduplicate redeliveries by this header.
+			req.Header.Set("X-Event-ID", p.ID)
+			injectTraceparent(ctx, req.Header)
+			if secret != "" {
+				req.Header.Set(webhookSignatureHeader, signWebhook(secret, time.Now(), body))
+			}
+			return doWebhookRequest(req)
+		})
+		if err != nil {
+			slog.Error("Giving up on webhook", "component", "webhooks", "event", p.Event, "url", url, "err", err)
+		}
+		notifyLog.done(w.walKey, p.ID)
+	}
+}
+
+// signWebhook returns the signature header for body sent at t:
+// "t=UNIX,v1=HEX", where HEX is the HMAC-SHA256 of "UNIX.body". Each
+// attempt is signed afresh so receivers can reject stale timestamps.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t.Unix())
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// parseWebhookSubscribers parses OUTGOING_WEBHOOKS: semicolon-separated
+// entries of a URL optionally followed by "events=a,b" and "secret=...".
+// Entries without an events list receive state changes only, and entries
+// without a secret are signed with defaultSecret, if set.
+func parseWebhookSubscribers(value, defaultSecret string) ([]*webhookSubscriber, error) {
+	var subscribers []*webhookSubscriber
+	for _, entry := range splitList(value) {
+		fields := strings.Fields(entry)
+		u, err := url.Parse(fields[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%q: invalid URL", fields[0])
+		}
+
+		events := map[string]bool{hookStateChanged: true}
+		secret := defaultSecret
+		for _, option := range fields[1:] {
+			if v, ok := strings.CutPrefix(option, "secret="); ok {
+				secret = v
+				continue
+			}
+			list, ok := strings.CutPrefix(option, "events=")
+			if !ok {
+				return nil, fmt.Errorf("%q: unknown option %q", fields[0], option)
+			}
+			events = make(map[string]bool)
+			for _, kind := range strings.Split(list, ",") {
+				if !hookEventTypes[kind] {
+					return nil, fmt.Errorf("%q: unknown event type %q", fields[0], kind)
+				}
+				events[kind] = true
+			}
+		}
+		subscribers = append(subscribers, newWebhookSubscriber(u.String(), secret, events))
+	}
+	return subscribers, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often a certificate loaded from files is
+// checked for renewal, e.g. by certbot.
+const certCheckInterval = time.Minute
+
+// defaultACMEHTTPAddr serves HTTP-01 challenges and redirects to HTTPS.
+const defaultACMEHTTPAddr = ":80"
+
+// tlsSettings is how the server gets its certificate: from TLS_CERT_FILE
+// and TLS_KEY_FILE, or from an ACME CA such as Let's Encrypt for
+// ACME_DOMAINS. Neither means plain HTTP.
+type tlsSettings struct {
+	certFile, keyFile string
+
+	domains   []string
+	email     string
+	cacheDir  string
+	directory string // ACME directory URL; empty for Let's Encrypt
+	httpAddr  string // HTTP-01 listener; empty for TLS-ALPN-01 only
+}
+
+// loadTLSSettings reads the TLS and ACME settings.
+func loadTLSSettings() (tlsSettings, error) {
+	s := tlsSettings{
+		certFile:  setting("TLS_CERT_FILE"),
+		keyFile:   setting("TLS_KEY_FILE"),
+		domains:   splitCommaList(setting("ACME_DOMAINS")),
+		email:     setting("ACME_EMAIL"),
+		cacheDir:  setting("ACME_CACHE_DIR"),
+		directory: setting("ACME_DIRECTORY_URL"),
+		httpAddr:  defaultACMEHTTPAddr,
+	}
+	if addr, ok := lookupSetting("ACME_HTTP_ADDR"); ok {
+		s.httpAddr = addr
+	}
+	if s.cacheDir == "" {
+		s.cacheDir = filepath.Join(dataDir, "acme")
+	}
+	if (s.certFile == "") != (s.keyFile == "") {
+		return s, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if s.certFile != "" && len(s.domains) > 0 {
+		return s, fmt.Errorf("set either TLS_CERT_FILE or ACME_DOMAINS, not both")
+	}
+	return s, nil
+}
+
+// enabled reports whether the server speaks HTTPS.
+func (s tlsSettings) enabled() bool {
+	return s.certFile != "" || len(s.domains) > 0
+}
+
+// config returns the server's TLS configuration, loading the certificate
+// pair or setting up ACME, and the handler for the HTTP-01 listener if
+// one is needed.
+func (s tlsSettings) config() (*tls.Config, http.Handler, error) {
+	if s.certFile != "" {
+		c := &certFiles{certFile: s.certFile, keyFile: s.keyFile}
+		if err := c.load(); err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: c.get}, nil, nil
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(s.cacheDir),
+		HostPolicy: autocert.HostWhitelist(s.domains...),
+		Email:      s.email,
+	}
+	if s.directory != "" {
+		m.Client = &acme.Client{DirectoryURL: s.directory}
+	}
+	config := m.TLSConfig() // answers TLS-ALPN-01 challenges
+	config.MinVersion = tls.VersionTLS12
+	var challenges http.Handler
+	if s.httpAddr != "" {
+		challenges = m.HTTPHandler(nil) // redirects everything else to HTTPS
+	}
+	return config, challenges, nil
+}
+
+// certFiles serves a certificate pair from files, loading it again when
+// either file changes so renewals need no restart.
+type certFiles struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// load reads the pair.
+func (c *certFiles) load() error {
+	modTime, err := c.modified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.cert, c.modTime, c.checked = &cert, modTime, time.Now()
+	return nil
+}
+
+// modified returns the later modification time of the two files.
+func (c *certFiles) modified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// get implements tls.Config.GetCertificate. A renewed pair that fails to
+// load is logged and the previous one kept.
+func (c *certFiles) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= certCheckInterval {
+		c.checked = time.Now()
+		if modTime, err := c.modified(); err == nil && modTime.After(c.modTime) {
+			if err := c.load(); err != nil {
+				slog.Error("Failed to reload TLS certificate", "component", "http", "err", err)
+			} else {
+				slog.Info("Reloaded TLS certificate", "component", "http", "file", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Default HTTP server limits. They keep a slow or misbehaving client from
+// holding connections, memory, or goroutines on the Pi.
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultMaxHeaderBytes    = 64 << 10
+	defaultMaxBodyBytes      = 1 << 20
+)
+
+// httpLimits are the timeouts and size limits of the HTTP servers. A zero
+// timeout or size means no limit.
+type httpLimits struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	maxBodyBytes      int64
+}
+
+// serverLimits is read by loadCoreSettings.
+var serverLimits = httpLimits{
+	readHeaderTimeout: defaultReadHeaderTimeout,
+	readTimeout:       defaultReadTimeout,
+	writeTimeout:      defaultWriteTimeout,
+	idleTimeout:       defaultIdleTimeout,
+	maxHeaderBytes:    defaultMaxHeaderBytes,
+	maxBodyBytes:      defaultMaxBodyBytes,
+}
+
+// loadHTTPLimits reads the HTTP_*_TIMEOUT and HTTP_MAX_*_BYTES settings.
+func loadHTTPLimits() (httpLimits, error) {
+	l := httpLimits{
+		readHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		readTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", defaultReadTimeout),
+		writeTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", defaultWriteTimeout),
+		idleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", defaultIdleTimeout),
+		maxHeaderBytes:    getEnvInt("HTTP_MAX_HEADER_BYTES", defaultMaxHeaderBytes),
+		maxBodyBytes:      int64(getEnvInt("HTTP_MAX_BODY_BYTES", defaultMaxBodyBytes)),
+	}
+	if l.readHeaderTimeout < 0 || l.readTimeout < 0 || l.writeTimeout < 0 || l.idleTimeout < 0 {
+		return l, fmt.Errorf("HTTP timeouts must not be negative")
+	}
+	if l.maxHeaderBytes < 0 || l.maxBodyBytes < 0 {
+		return l, fmt.Errorf("HTTP_MAX_HEADER_BYTES and HTTP_MAX_BODY_BYTES must not be negative")
+	}
+	if l.maxHeaderBytes == 0 {
+		l.maxHeaderBytes = http.DefaultMaxHeaderBytes // net/http has no "unlimited"
+	}
+	return l, nil
+}
+
+// newHTTPServer returns a server for handler with the configured limits.
+// Requests run in ctx.
+func newHTTPServer(ctx context.Context, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: serverLimits.readHeaderTimeout,
+		ReadTimeout:       serverLimits.readTimeout,
+		WriteTimeout:      serverLimits.writeTimeout,
+		IdleTimeout:       serverLimits.idleTimeout,
+		MaxHeaderBytes:    serverLimits.maxHeaderBytes,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+}
+
+// limitBody rejects a request whose declared body exceeds
+// HTTP_MAX_BODY_BYTES with 413 and caps reading any other body at it, so
+// a handler reading a body that turns out longer gets an error. It
+// reports whether the request may go on.
+func limitBody(w http.ResponseWriter, r *http.Request) bool {
+	limit := serverLimits.maxBodyBytes
+	if limit == 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// streamingFlusher returns the Flusher of a response meant to stream, such
+// as the agent feed. It reports false when the response cannot stream:
+// the tunnel sends each response in one frame once the handler returns.
+func streamingFlusher(w http.ResponseWriter, r *http.Request) (http.Flusher, bool) {
+	if tunneled, _ := r.Context().Value(tunneledKey{}).(bool); tunneled {
+		return nil, false
+	}
+	flusher, ok := w.(http.Flusher)
+	return flusher, ok
+}
+
+// keepStreaming lifts the server's read and write timeouts for a response
+// that is meant to last, such as the agent feed or a CPU profile.
+// Responses that do not go through a server, e.g. over the tunnel, have
+// none to lift.
+func keepStreaming(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
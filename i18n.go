@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultLocale is used when no locale is configured or a key is missing
+// from the selected catalog.
+const defaultLocale = "en"
+
+// Message keys shared by every catalog.
+const (
+	msgStateOpen    = "state.open"
+	msgStateClosed  = "state.closed"
+	msgStateMembers = "state.members_only"
+	msgStateUnknown = "state.unknown"
+	msgStateChange  = "state.change"
+	msgZoneChange   = "zone.change"
+	msgManualChange = "manual.change"
+	msgManualReason = "manual.reason"
+	msgStatus       = "status.summary"
+	msgStatusNone   = "status.unknown"
+	msgOptInDone    = "optin.done"
+	msgOptInOpen    = "optin.open"
+	msgOptInClose   = "optin.close"
+	msgOptInUsage   = "optin.usage"
+	msgQuietSet     = "quiet.set"
+	msgQuietClear   = "quiet.cleared"
+	msgEmailSet     = "email.set"
+	msgEmailClear   = "email.cleared"
+	msgPauseSet     = "pause.set"
+	msgPauseClear   = "pause.cleared"
+	msgPauseNone    = "pause.none"
+	msgPauseUsage   = "pause.usage"
+
+	msgMaintenanceOn    = "maintenance.on"
+	msgMaintenanceOff   = "maintenance.off"
+	msgMaintenanceNone  = "maintenance.none"
+	msgMaintenanceUsage = "maintenance.usage"
+	msgAdminOnly        = "command.admin_only"
+
+	msgActionStatus  = "action.status"
+	msgActionHistory = "action.history"
+	msgActionPause   = "action.pause"
+
+	msgFieldState    = "field.state"
+	msgFieldChanged  = "field.changed"
+	msgFieldDuration = "field.duration"
+
+	msgSessionSummary = "session.summary"
+	msgSessionNote    = "session.note"
+
+	msgWeekdaysShort = "weekdays.short"
+	msgHeatmapTitle  = "heatmap.title"
+	msgHeatmapCell   = "heatmap.cell"
+	msgHeatmapNoData = "heatmap.no_data"
+)
+
+// catalogs maps a locale to its translated messages. Messages are
+// fmt-style format strings.
+var catalogs = map[string]map[string]string{
+	"en": {
+		msgStateOpen:    "open",
+		msgStateClosed:  "closed",
+		msgStateMembers: "open to members only",
+		msgStateUnknown: "unknown",
+		msgStateChange:  "The space is now %s.",
+		msgZoneChange:   "%s is now %s.",
+		msgManualChange: "The space has been set to %s manually.",
+		msgManualReason: "Reason: %s",
+		msgStatus:       "The space is %s.",
+		msgStatusNone:   "The state of the space is not known yet.",
+		msgOptInDone:    "You have opted in for notifications, <@%s>.",
+		msgOptInOpen:    "You will be notified when the space opens, <@%s>.",
+		msgOptInClose:   "You will be notified when the space closes, <@%s>.",
+		msgOptInUsage:   "Usage: /optin [open|close|both], /optin quiet HH:MM-HH:MM|off, or /optin email ADDRESS|off",
+		msgQuietSet:     "Quiet hours set to %s. Channel posts are unaffected.",
+		msgQuietClear:   "Quiet hours cleared.",
+		msgEmailSet:     "Notifications will also be emailed to %s.",
+		msgEmailClear:   "Email notifications turned off.",
+		msgPauseSet:     "All notifications are paused until %s.",
+		msgPauseClear:   "Notifications resumed.",
+		msgPauseNone:    "Notifications are not paused.",
+		msgPauseUsage:   "Usage: /pause [DURATION [reason]], e.g. /pause 2h fixing the door sensor, /pause status, or /pause off",
+
+		msgMaintenanceOn:    "Maintenance mode is on since %s: the status stays %s and nothing is announced.",
+		msgMaintenanceOff:   "Maintenance mode is off; the status follows the switch again.",
+		msgMaintenanceNone:  "Maintenance mode is off.",
+		msgMaintenanceUsage: "Usage: /maintenance on [reason], e.g. /maintenance on rewiring the switch, /maintenance status, or /maintenance off",
+		msgAdminOnly:        "Only admins can use this command.",
+
+		msgActionStatus:  "Status",
+		msgActionHistory: "History",
+		msgActionPause:   "Pause notifications",
+
+		msgFieldState:    "State",
+		msgFieldChanged:  "Changed",
+		msgFieldDuration: "Previous state lasted",
+
+		msgSessionSummary: "Open for %s · peak of ~%d people · %d check-ins.",
+		msgSessionNote:    "Note: %s",
+
+		msgWeekdaysShort: "Sun,Mon,Tue,Wed,Thu,Fri,Sat",
+		msgHeatmapTitle:  "Open by hour over the last %d weeks",
+		msgHeatmapCell:   "%s %02d:00–%02d:00: open %d%% of the time",
+		msgHeatmapNoData: "%s %02d:00–%02d:00: no data",
+	},
+	"es": {
+		msgStateOpen:    "abierto",
+		msgStateClosed:  "cerrado",
+		msgStateMembers: "abierto solo para socios",
+		msgStateUnknown: "desconocido",
+		msgStateChange:  "El espacio ahora está %s.",
+		msgZoneChange:   "%s ahora está %s.",
+		msgManualChange: "El espacio se ha marcado como %s manualmente.",
+		msgManualReason: "Motivo: %s",
+		msgStatus:       "El espacio está %s.",
+		msgStatusNone:   "Todavía no se conoce el estado del espacio.",
+		msgOptInDone:    "Te has suscrito a las notificaciones, <@%s>.",
+		msgOptInOpen:    "Se te avisará cuando el espacio abra, <@%s>.",
+		msgOptInClose:   "Se te avisará cuando el espacio cierre, <@%s>.",
+		msgOptInUsage:   "Uso: /optin [open|close|both], /optin quiet HH:MM-HH:MM|off o /optin email DIRECCIÓN|off",
+		msgQuietSet:     "Horario de silencio fijado en %s. Los mensajes del canal no cambian.",
+		msgQuietClear:   "Horario de silencio eliminado.",
+		msgEmailSet:     "Las notificaciones también se enviarán a %s.",
+		msgEmailClear:   "Notificaciones por correo desactivadas.",
+		msgPauseSet:     "Todas las notificaciones están en pausa hasta %s.",
+		msgPauseClear:   "Notificaciones reanudadas.",
+		msgPauseNone:    "Las notificaciones no están en pausa.",
+		msgPauseUsage:   "Uso: /pause [DURACIÓN [motivo]], p. ej. /pause 2h arreglando el sensor, /pause status o /pause off",
+
+		msgMaintenanceOn:    "El modo de mantenimiento está activo desde %s: el estado se mantiene en %s y no se anuncia nada.",
+		msgMaintenanceOff:   "Modo de mantenimiento desactivado; el estado vuelve a seguir al interruptor.",
+		msgMaintenanceNone:  "El modo de mantenimiento está desactivado.",
+		msgMaintenanceUsage: "Uso: /maintenance on [motivo], p. ej. /maintenance on recableando el interruptor, /maintenance status o /maintenance off",
+		msgAdminOnly:        "Solo los administradores pueden usar este comando.",
+
+		msgActionStatus:  "Estado",
+		msgActionHistory: "Historial",
+		msgActionPause:   "Pausar notificaciones",
+
+		msgFieldState:    "Estado",
+		msgFieldChanged:  "Cambio",
+		msgFieldDuration: "Duración del estado anterior",
+
+		msgSessionSummary: "Abierto durante %s · máximo de ~%d personas · %d registros de entrada.",
+		msgSessionNote:    "Nota: %s",
+
+		msgWeekdaysShort: "dom,lun,mar,mié,jue,vie,sáb",
+		msgHeatmapTitle:  "Horas de apertura en las últimas %d semanas",
+		msgHeatmapCell:   "%s %02d:00–%02d:00: abierto el %d%% del tiempo",
+		msgHeatmapNoData: "%s %02d:00–%02d:00: sin datos",
+	},
+}
+
+// loadLocale reads the LOCALE setting, falling back to the
+// default locale when it is unset or has no catalog.
+func loadLocale(v *settingsView) string {
+	value := normalizeLocale(v.setting("LOCALE"))
+	if _, ok := catalogs[value]; !ok {
+		return defaultLocale
+	}
+	return value
+}
+
+// normalizeLocale reduces a tag such as "es-MX" or "es_MX.UTF-8" to its
+// language part.
+func normalizeLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_."); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// negotiateLocale picks a supported locale from an explicit override or an
+// Accept-Language header, falling back to the configured locale.
+func negotiateLocale(override, acceptLanguage string) string {
+	if _, ok := catalogs[normalizeLocale(override)]; ok {
+		return normalizeLocale(override)
+	}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if _, ok := catalogs[normalizeLocale(tag)]; ok {
+			return normalizeLocale(tag)
+		}
+	}
+	return liveSettings().locale
+}
+
+// translate formats the message for key in the given locale.
+func translate(loc, key string, args ...interface{}) string {
+	format, ok := catalogs[loc][key]
+	if !ok {
+		format = catalogs[defaultLocale][key]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// stateText returns the localized word for an open or closed state.
+func stateText(loc string, open bool) string {
+	if open {
+		return translate(loc, msgStateOpen)
+	}
+	return translate(loc, msgStateClosed)
+}
+
+// statusText returns the localized words for a status, e.g. "open to
+// members only".
+func statusText(loc string, s spaceStatus) string {
+	switch s {
+	case statusOpen:
+		return translate(loc, msgStateOpen)
+	case statusMembersOnly:
+		return translate(loc, msgStateMembers)
+	case statusClosed:
+		return translate(loc, msgStateClosed)
+	}
+	return translate(loc, msgStateUnknown)
+}
+
+// statusMessage returns the localized sentence describing the space in
+// status s, e.g. "The space is open."
+func statusMessage(loc string, s spaceStatus) string {
+	if s == statusUnknown {
+		return translate(loc, msgStatusNone)
+	}
+	return translate(loc, msgStatus, statusText(loc, s))
+}
+
+// weekdayAbbreviation returns the localized short name of a weekday, e.g.
+// "Tue".
+func weekdayAbbreviation(loc string, d time.Weekday) string {
+	names := strings.Split(translate(loc, msgWeekdaysShort), ",")
+	if int(d) < len(names) {
+		return names[d]
+	}
+	return d.String()[:3]
+}
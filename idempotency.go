@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idempotency key settings.
+const (
+	idempotencyTTL       = 24 * time.Hour
+	idempotencyMaxKeyLen = 255
+	idempotencyMaxBody   = 1 << 20
+)
+
+// idempotentResponse is a stored response replayed for a repeated key.
+type idempotentResponse struct {
+	fingerprint [32]byte // hash of the request body
+	done        bool     // false while the first request is still running
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyCache remembers responses by principal, route, and key.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+var idempotencyKeys = &idempotencyCache{entries: make(map[string]*idempotentResponse)}
+
+// idempotent wraps a mutating handler so a retried request carrying the
+// same Idempotency-Key header gets the original response instead of being
+// applied twice. Reusing a key with a different body is rejected.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKeyLen {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxBody))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		p, _ := authenticate(r)
+		cacheKey := p.Name + " " + r.Method + " " + r.URL.Path + " " + key
+		entry, fresh := idempotencyKeys.claim(cacheKey, sha256.Sum256(body))
+		switch {
+		case entry == nil:
+			http.Error(w, "Idempotency-Key reused with a different request", http.StatusUnprocessableEntity)
+			return
+		case !fresh && !entry.done:
+			http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+			return
+		case !fresh:
+			replayResponse(w, entry)
+			return
+		}
+
+		serveAndStore(w, r, cacheKey, next)
+	}
+}
+
+// slackDeduplicated wraps a Slack request handler so retries Slack sends
+// after a slow response (marked with X-Slack-Retry-Num) are answered from
+// the first response instead of being applied again. Requests are keyed by
+// trigger_id, or by their body when there is none.
+func slackDeduplicated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxBody))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id := fmt.Sprintf("%x", sha256.Sum256(body))
+		if form, err := url.ParseQuery(string(body)); err == nil && form.Get("trigger_id") != "" {
+			id = form.Get("trigger_id")
+		}
+		cacheKey := "slack " + r.URL.Path + " " + id
+
+		retry := r.Header.Get("X-Slack-Retry-Num")
+		entry, fresh := idempotencyKeys.claim(cacheKey, sha256.Sum256([]byte(id)))
+		if !fresh {
+			slog.Info("Ignoring Slack retry", "component", "slack", "retry", retry, "reason", r.Header.Get("X-Slack-Retry-Reason"), "path", r.URL.Path)
+			w.Header().Set("X-Slack-No-Retry", "1")
+			if entry.done {
+				replayResponse(w, entry)
+			}
+			return
+		}
+		if retry != "" {
+			slog.Info("Handling Slack retry; the original was not received", "component", "slack", "retry", retry, "reason", r.Header.Get("X-Slack-Retry-Reason"), "path", r.URL.Path)
+		}
+		serveAndStore(w, r, cacheKey, next)
+	}
+}
+
+// serveAndStore runs next, stores its response under cacheKey, and writes
+// it to w.
+func serveAndStore(w http.ResponseWriter, r *http.Request, cacheKey string, next http.HandlerFunc) {
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	next(rec, r)
+	idempotencyKeys.complete(cacheKey, rec.status, rec.header, rec.body.Bytes())
+
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// responseRecorder buffers a handler's response so it can be stored.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+// claim returns the entry for key and whether this call created it. It
+// returns nil if key was used with a different body.
+func (c *idempotencyCache) claim(key string, fingerprint [32]byte) (*idempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	if e, ok := c.entries[key]; ok {
+		if e.fingerprint != fingerprint {
+			return nil, false
+		}
+		return e, false
+	}
+	e := &idempotentResponse{fingerprint: fingerprint, expires: now.Add(idempotencyTTL)}
+	c.entries[key] = e
+	return e, true
+}
+
+// complete stores the response for key. Server errors are forgotten so the
+// client can retry them.
+func (c *idempotencyCache) complete(key string, status int, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if status >= 500 {
+		delete(c.entries, key)
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		e.done, e.status, e.header, e.body = true, status, header.Clone(), body
+	}
+}
+
+func replayResponse(w http.ResponseWriter, e *idempotentResponse) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
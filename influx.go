@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InfluxDB export settings.
+const (
+	influxDefaultMeasurement    = "space_status"
+	influxDefaultSampleInterval = time.Minute
+	influxFlushInterval         = 10 * time.Second
+	influxBatchSize             = 500
+	influxMaxPending            = 10000
+	influxMaxBackoff            = 5 * time.Minute
+	influxWriteTimeout          = 10 * time.Second
+	influxQueueSize             = 1024
+)
+
+// influx exports to InfluxDB; it is nil unless configured by
+// configureInflux, in which case exporting does nothing.
+var influx *influxExporter
+
+// influxExporter writes state transitions and regular samples of the
+// current state in line protocol to an InfluxDB write endpoint, or to
+// anything accepting line protocol, such as Telegraf or VictoriaMetrics.
+// Lines that fail to write are kept and sent again with the next batch.
+type influxExporter struct {
+	url         string
+	token       string
+	measurement string
+	tags        string // extra tags, escaped and sorted, each with a leading comma
+	client      *http.Client
+	lines       chan string
+}
+
+// configureInflux sets up export when INFLUX_URL is set. A shadow instance
+// does not export, as the instance it follows does.
+func configureInflux() error {
+	url := setting("INFLUX_URL")
+	if url == "" || shadowMode {
+		return nil
+	}
+	tags, err := parseInfluxTags(setting("INFLUX_TAGS"))
+	if err != nil {
+		return fmt.Errorf("INFLUX_TAGS: %w", err)
+	}
+	interval := getEnvDuration("INFLUX_SAMPLE_INTERVAL", influxDefaultSampleInterval)
+	if interval < 0 {
+		return fmt.Errorf("INFLUX_SAMPLE_INTERVAL must not be negative")
+	}
+	measurement := setting("INFLUX_MEASUREMENT")
+	if measurement == "" {
+		measurement = influxDefaultMeasurement
+	}
+
+	influx = &influxExporter{
+		url:         url,
+		token:       setting("INFLUX_TOKEN"),
+		measurement: measurement,
+		tags:        tags,
+		client:      &http.Client{Timeout: influxWriteTimeout},
+		lines:       make(chan string, influxQueueSize),
+	}
+	go influx.run()
+	if interval > 0 {
+		go influx.sampleEvery(interval)
+	}
+	slog.Info("Exporting to InfluxDB", "component", "influx", "url", url, "sample_interval", interval)
+	return nil
+}
+
+// parseInfluxTags parses "key=value,key2=value2" into escaped line
+// protocol tags, sorted by key as InfluxDB prefers.
+func parseInfluxTags(value string) (string, error) {
+	pairs := splitCommaList(value)
+	sort.Strings(pairs)
+	var b strings.Builder
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return "", fmt.Errorf("%q is not key=value", pair)
+		}
+		b.WriteString("," + influxEscape(k, ",= ") + "=" + influxEscape(v, ",= "))
+	}
+	return b.String(), nil
+}
+
+// transition exports a transition of a zone, followed by samples of the
+// state it left the space and the zones in, so graphs step at the right
+// moment rather than at the next sample.
+func (e *influxExporter) transition(t transition) {
+	if e == nil {
+		return
+	}
+	tags := ",zone=" + influxEscape(t.Zone, ",= ") + ",source=" + influxEscape(t.Source, ",= ")
+	e.enqueue(fmt.Sprintf("%s_transition%s%s from=%s,to=%s,space_from=%s,space_to=%s,event_id=%s,seq=%di %d",
+		influxEscape(e.measurement, ", "), tags, e.tags,
+		influxString(t.From.String()), influxString(t.To.String()),
+		influxString(t.SpaceFrom.String()), influxString(t.SpaceTo.String()),
+		influxString(t.EventID), t.Seq, t.Time.UnixNano()))
+	e.sample(t.Time)
+}
+
+// sampleEvery exports samples of the current state once per interval.
+func (e *influxExporter) sampleEvery(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		e.sample(now)
+	}
+}
+
+// sample exports the state of the space and of each zone at now. States
+// not known yet are left out rather than counted as closed.
+func (e *influxExporter) sample(now time.Time) {
+	measurement := influxEscape(e.measurement, ", ")
+	if s := currentSpace().Status; s != statusUnknown {
+		e.enqueue(fmt.Sprintf("%s%s %s %d", measurement, e.tags, influxStatusFields(s), now.UnixNano()))
+	}
+	if !zonesConfigured {
+		return
+	}
+	for _, z := range zones {
+		if s, _ := z.current(); s != statusUnknown {
+			e.enqueue(fmt.Sprintf("%s_zone,zone=%s%s %s %d", measurement, influxEscape(z.name, ",= "), e.tags,
+				influxStatusFields(s), now.UnixNano()))
+		}
+	}
+}
+
+// influxStatusFields returns the fields of a sample: open is 1 while open
+// to everyone or to members only, so its mean over a day is the share of
+// the day the space was open.
+func influxStatusFields(s spaceStatus) string {
+	return fmt.Sprintf("open=%di,members_only=%di,status=%s",
+		boolInt(s.open()), boolInt(s == statusMembersOnly), influxString(s.String()))
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// enqueue hands a line to the writer without blocking the caller; when
+// the queue is full the line is dropped.
+func (e *influxExporter) enqueue(line string) {
+	select {
+	case e.lines <- line:
+	default:
+		metricInfluxLines.inc("dropped")
+		slog.Warn("InfluxDB export queue full; dropping a line", "component", "influx")
+	}
+}
+
+// run batches queued lines and writes them every influxFlushInterval, or
+// sooner once a batch is full. After a failed write it waits longer before
+// each retry, up to influxMaxBackoff, and keeps at most influxMaxPending
+// lines, dropping the oldest.
+func (e *influxExporter) run() {
+	ticker := time.NewTicker(influxFlushInterval)
+	defer ticker.Stop()
+	var pending []string
+	var backoff time.Duration
+	var retryAt time.Time
+	for {
+		select {
+		case line := <-e.lines:
+			pending = append(pending, line)
+			if len(pending) > influxMaxPending {
+				dropped := len(pending) - influxMaxPending
+				metricInfluxLines.add(float64(dropped), "dropped")
+				slog.Warn("InfluxDB unreachable for too long; dropping the oldest lines", "component", "influx", "lines", dropped)
+				pending = append([]string(nil), pending[dropped:]...)
+			}
+			if len(pending) < influxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		if len(pending) == 0 || time.Now().Before(retryAt) {
+			continue
+		}
+		for len(pending) > 0 {
+			n := min(len(pending), influxBatchSize)
+			retry, err := e.write(pending[:n])
+			if err != nil && retry {
+				backoff = min(max(2*backoff, influxFlushInterval), influxMaxBackoff)
+				retryAt = time.Now().Add(backoff)
+				slog.Warn("InfluxDB write failed; retrying", "component", "influx", "lines", len(pending), "retry_in", backoff, "err", err)
+				break
+			}
+			if err != nil {
+				metricInfluxLines.add(float64(n), "rejected")
+				slog.Error("InfluxDB rejected lines; dropping them", "component", "influx", "lines", n, "err", err)
+			} else {
+				metricInfluxLines.add(float64(n), "written")
+			}
+			backoff, retryAt = 0, time.Time{}
+			pending = pending[n:]
+		}
+	}
+}
+
+// write posts lines to the endpoint. retry reports whether a failure may
+// pass, as opposed to the server rejecting the data or the credentials.
+func (e *influxExporter) write(lines []string) (retry bool, err error) {
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewBufferString(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("status %s", resp.Status)
+	}
+}
+
+// influxEscape escapes the characters in special for measurements, tag
+// keys, and tag values.
+func influxEscape(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// influxString quotes a string field value.
+func influxString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IRC connection settings.
+const (
+	ircDialTimeout     = 30 * time.Second
+	ircReadTimeout     = 5 * time.Minute // servers ping well within this
+	ircWriteTimeout    = 10 * time.Second
+	ircMaxBackoff      = 5 * time.Minute
+	ircMaxLineLength   = 400 // leaves room for the PRIVMSG prefix within 512 bytes
+	ircRealName        = "space-status"
+	ircSASLMechanism   = "PLAIN"
+	ircRegisterTimeout = time.Minute
+)
+
+// errIRCNotConnected is returned while the client is not in the channel.
+var errIRCNotConnected = errors.New("not connected to IRC")
+
+// ircNotifier keeps a connection to an IRC server and announces state
+// changes in a channel.
+type ircNotifier struct {
+	server   string // host:port
+	useTLS   bool
+	channel  string
+	nick     string
+	saslUser string
+	saslPass string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	joined bool
+}
+
+// newIRCNotifier returns a notifier for the given server and channel. SASL
+// PLAIN is used when saslUser is set.
+func newIRCNotifier(server string, useTLS bool, channel, nick, saslUser, saslPass string) *ircNotifier {
+	return &ircNotifier{
+		server:   server,
+		useTLS:   useTLS,
+		channel:  channel,
+		nick:     nick,
+		saslUser: saslUser,
+		saslPass: saslPass,
+	}
+}
+
+// Name implements Notifier.
+func (n *ircNotifier) Name() string {
+	return "irc"
+}
+
+// Notify implements Notifier. Multi-line messages are sent line by line.
+func (n *ircNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil || !n.joined {
+		return errIRCNotConnected
+	}
+	for _, line := range strings.Split(message, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if len(line) > ircMaxLineLength {
+			line = line[:ircMaxLineLength]
+		}
+		if err := n.writeLocked("PRIVMSG %s :%s", n.channel, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Preview implements Previewer.
+func (n *ircNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: n.channel, Text: message}}, nil
+}
+
+// run keeps the client connected, reconnecting with exponential backoff.
+func (n *ircNotifier) run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := n.session()
+		slog.Warn("IRC connection ended", "component", "irc", "server", n.server, "err", err)
+
+		if time.Since(start) > ircMaxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, ircMaxBackoff)
+	}
+}
+
+// session connects, registers, joins the channel, and serves the
+// connection until it fails.
+func (n *ircNotifier) session() error {
+	dialer := &net.Dialer{Timeout: ircDialTimeout}
+	var conn net.Conn
+	var err error
+	if n.useTLS {
+		host, _, _ := net.SplitHostPort(n.server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", n.server, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", n.server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	n.mu.Lock()
+	n.conn = conn
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.conn, n.joined = nil, false
+		n.mu.Unlock()
+	}()
+
+	nick := n.nick
+	if n.saslUser != "" {
+		n.write("CAP REQ :sasl")
+	}
+	n.write("NICK %s", nick)
+	n.write("USER %s 0 * :%s", nick, ircRealName)
+
+	registered := false
+	registerDeadline := time.Now().Add(ircRegisterTimeout)
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(ircReadTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !registered && time.Now().After(registerDeadline) {
+			return errors.New("registration timed out")
+		}
+
+		msg := parseIRCLine(line)
+		switch msg.command {
+		case "PING":
+			n.write("PONG :%s", msg.trailing())
+		case "CAP":
+			if len(msg.params) >= 2 && msg.params[1] == "ACK" {
+				n.write("AUTHENTICATE %s", ircSASLMechanism)
+			} else if len(msg.params) >= 2 && msg.params[1] == "NAK" {
+				return errors.New("server does not support SASL")
+			}
+		case "AUTHENTICATE":
+			if msg.trailing() == "+" {
+				payload := n.saslUser + "\x00" + n.saslUser + "\x00" + n.saslPass
+				n.write("AUTHENTICATE %s", base64.StdEncoding.EncodeToString([]byte(payload)))
+			}
+		case "903": // RPL_SASLSUCCESS
+			n.write("CAP END")
+		case "902", "904", "905", "906": // SASL failures
+			return fmt.Errorf("SASL authentication failed: %s", msg.trailing())
+		case "001": // RPL_WELCOME
+			registered = true
+			n.write("JOIN %s", n.channel)
+		case "433": // ERR_NICKNAMEINUSE
+			nick += "_"
+			n.write("NICK %s", nick)
+		case "JOIN":
+			if strings.EqualFold(msg.nick(), nick) {
+				n.mu.Lock()
+				n.joined = true
+				n.mu.Unlock()
+				slog.Info("Joined IRC channel", "component", "irc", "channel", n.channel, "server", n.server, "nick", nick)
+			}
+		case "KICK":
+			if len(msg.params) >= 2 && strings.EqualFold(msg.params[1], nick) {
+				return fmt.Errorf("kicked from %s: %s", n.channel, msg.trailing())
+			}
+		case "ERROR":
+			return fmt.Errorf("server error: %s", msg.trailing())
+		}
+	}
+}
+
+// write sends one IRC line.
+func (n *ircNotifier) write(format string, args ...interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.writeLocked(format, args...)
+}
+
+// writeLocked sends one IRC line. The caller must hold n.mu.
+func (n *ircNotifier) writeLocked(format string, args ...interface{}) error {
+	if n.conn == nil {
+		return errIRCNotConnected
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(ircWriteTimeout))
+	_, err := fmt.Fprintf(n.conn, format+"\r\n", args...)
+	return err
+}
+
+// ircMessage is a parsed IRC protocol line.
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string // the last element is the trailing parameter, if any
+}
+
+// nick returns the nickname from the message prefix.
+func (m ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
+
+// trailing returns the last parameter.
+func (m ircMessage) trailing() string {
+	if len(m.params) == 0 {
+		return ""
+	}
+	return m.params[len(m.params)-1]
+}
+
+// parseIRCLine parses "[:prefix] COMMAND params... [:trailing]".
+func parseIRCLine(line string) ircMessage {
+	line = strings.TrimRight(line, "\r\n")
+	var msg ircMessage
+	if strings.HasPrefix(line, "@") { // IRCv3 message tags
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		msg.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	head, trailing, hasTrailing := strings.Cut(line, " :")
+	fields := strings.Fields(head)
+	if len(fields) > 0 {
+		msg.command = strings.ToUpper(fields[0])
+		msg.params = fields[1:]
+	}
+	if hasTrailing {
+		msg.params = append(msg.params, trailing)
+	}
+	return msg
+}
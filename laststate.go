@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// knownState is a status and when it began.
+type knownState struct {
+	Status spaceStatus `json:"status"`
+	Since  time.Time   `json:"since"`
+}
+
+// savedState is the last known state of the space and of each zone.
+type savedState struct {
+	Space knownState            `json:"space"`
+	Zones map[string]knownState `json:"zones"`
+}
+
+// stateStore keeps the last known state on disk, so the first reading
+// after a restart is compared with it and only announced when the switch
+// actually moved while the service was down. States still unknown are not
+// saved, so a restart before the first reading keeps the previous ones.
+type stateStore struct {
+	mu    sync.Mutex
+	path  string
+	saved savedState
+}
+
+var lastKnown = &stateStore{path: filepath.Join(dataDir, "state.json"),
+	saved: savedState{Zones: make(map[string]knownState)}}
+
+// load restores the state saved by a previous run.
+func (s *stateStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved savedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	if saved.Zones == nil {
+		saved.Zones = make(map[string]knownState)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = saved
+	return nil
+}
+
+// zone returns the last known state of the named zone. Without a saved
+// one, e.g. on the first start after an upgrade, it is taken from the
+// zone's last recorded event.
+func (s *stateStore) zone(name string) (knownState, bool) {
+	s.mu.Lock()
+	known, ok := s.saved.Zones[name]
+	s.mu.Unlock()
+	if ok {
+		return known, true
+	}
+	if r, ok := events.last(name); ok {
+		return knownState{Status: r.status(), Since: r.Time}, true
+	}
+	return knownState{}, false
+}
+
+// space returns the last known state of the space, falling back to the
+// last recorded event that changed it.
+func (s *stateStore) space() (knownState, bool) {
+	s.mu.Lock()
+	known := s.saved.Space
+	s.mu.Unlock()
+	if known.Status != statusUnknown {
+		return known, true
+	}
+	if r, ok := events.lastSpace(); ok {
+		return knownState{Status: r.spaceStatus(), Since: r.Time}, true
+	}
+	return knownState{}, false
+}
+
+// save records the current state of the space and the zones. The caller
+// holds spaceMu.
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur := currentSpace(); cur.Status != statusUnknown {
+		s.saved.Space = knownState{Status: cur.Status, Since: cur.Since}
+	}
+	for _, z := range zones {
+		if status, since := z.current(); status != statusUnknown {
+			s.saved.Zones[z.name] = knownState{Status: status, Since: since}
+		}
+	}
+	return writeJSONFile(s.path, s.saved)
+}
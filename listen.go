@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// unixSocketPrefix marks a LISTEN_ADDR that is a Unix domain socket path,
+// e.g. unix:/run/space-status/http.sock.
+const unixSocketPrefix = "unix:"
+
+// defaultSocketMode lets the owner and group, such as the reverse proxy's,
+// connect.
+const defaultSocketMode = 0660
+
+// listenFDsStart is the first file descriptor systemd passes.
+const listenFDsStart = 3
+
+// openListener returns the socket systemd passed in, if it started the
+// service through socket activation, or else listens on addr: a TCP
+// address such as :8080 or 127.0.0.1:8080, or a Unix domain socket. A
+// socket left over from a previous run is replaced; the new one gets
+// LISTEN_SOCKET_MODE (octal, default 0660) and, if set,
+// LISTEN_SOCKET_GROUP.
+func openListener(addr string) (net.Listener, error) {
+	if ln, err := activatedListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	path, ok := strings.CutPrefix(addr, unixSocketPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	mode, gid, err := socketPermissions()
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	// The socket is created with mode rather than changed to it, so no one
+	// else can connect in between. The umask is process-wide: a file
+	// created meanwhile is at most as open as the socket.
+	umask := syscall.Umask(int(0777 &^ mode))
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// activatedListener returns the socket passed by systemd, as described in
+// sd_listen_fds(3), or nil if there is none. The socket unit decides the
+// address, so systemd can bind a privileged port while the service runs
+// unprivileged. It expects a single socket; the variables are cleared so
+// they do not leak into child processes such as hooks.
+func activatedListener() (net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	switch {
+	case n == 0:
+		return nil, nil
+	case n > 1:
+		return nil, fmt.Errorf("systemd passed %d sockets; expected one", n)
+	}
+	f := os.NewFile(listenFDsStart, "systemd socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("use socket from systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// socketPermissions reads LISTEN_SOCKET_MODE and LISTEN_SOCKET_GROUP, a
+// group name or ID; gid is -1 to keep the default group.
+func socketPermissions() (mode os.FileMode, gid int, err error) {
+	mode, gid = defaultSocketMode, -1
+	if value := setting("LISTEN_SOCKET_MODE"); value != "" {
+		m, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || m > 0777 {
+			return 0, 0, fmt.Errorf("LISTEN_SOCKET_MODE must be an octal mode such as 0660")
+		}
+		mode = os.FileMode(m)
+	}
+	if group := setting("LISTEN_SOCKET_GROUP"); group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("LISTEN_SOCKET_GROUP: %w", err)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("LISTEN_SOCKET_GROUP: %w", err)
+			}
+		}
+	}
+	return mode, gid, nil
+}
+
+// viaUnixSocket reports whether r arrived on a Unix domain socket, whose
+// peers are local processes such as a reverse proxy.
+func viaUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// checkListenAddr validates LISTEN_ADDR without listening.
+func checkListenAddr(addr string) error {
+	if _, ok := strings.CutPrefix(addr, unixSocketPrefix); ok {
+		_, _, err := socketPermissions()
+		return err
+	}
+	_, err := net.ResolveTCPAddr("tcp", addr)
+	return err
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log formats selected with LOG_FORMAT.
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// setupLogging installs the default slog logger. LOG_LEVEL selects the
+// minimum level (debug, info, warn, or error; default info). LOG_FORMAT
+// selects JSON records appended to the log file, rotated by size and age
+// (LOG_MAX_SIZE_MB, LOG_MAX_AGE, LOG_KEEP), or human-readable lines on
+// stderr for interactive runs; it defaults to console when stderr is a
+// terminal and json otherwise. LOG_OUTPUT=journald or syslog sends records
+// to the local system log instead. The most recent LOG_TAIL_LINES records
+// are also kept in memory for /api/v1/logs. The returned log is nil unless
+// logging to the file.
+func setupLogging() *rotatingLog {
+	var level slog.Level
+	if v := setting("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			fatal("Invalid LOG_LEVEL", "value", v, "err", err)
+		}
+	}
+	var system slog.Handler
+	var err error
+	switch output := setting("LOG_OUTPUT"); output {
+	case "", "file":
+	case logOutputJournald:
+		system, err = newJournalHandler(level)
+	case logOutputSyslog:
+		system, err = newSyslogHandler(level)
+	default:
+		fatal("Invalid LOG_OUTPUT; use file, journald, or syslog", "value", output)
+	}
+	if err != nil {
+		fatal("Failed to set up system logging", "err", err)
+	}
+	logTail = newLogBuffer(getEnvInt("LOG_TAIL_LINES", logTailDefaultLines))
+	setDefault := func(h slog.Handler) {
+		tail := slog.NewJSONHandler(logTail, &slog.HandlerOptions{Level: level, ReplaceAttr: jsonDurations})
+		slog.SetDefault(slog.New(teeHandler{h, tail}))
+	}
+	if system != nil {
+		setDefault(system)
+		return nil
+	}
+
+	format := setting("LOG_FORMAT")
+	if format == "" {
+		format = logFormatJSON
+		if isTerminal(os.Stderr) {
+			format = logFormatConsole
+		}
+	}
+
+	switch format {
+	case logFormatConsole:
+		setDefault(newConsoleHandler(os.Stderr, level))
+		return nil
+	case logFormatJSON:
+	default:
+		fatal("Invalid LOG_FORMAT; use json or console", "value", format)
+	}
+
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		fatal("Failed to create log directory", "err", err)
+	}
+	maxSize := int64(getEnvInt("LOG_MAX_SIZE_MB", logDefaultMaxSizeMB)) << 20
+	logFile, err := openRotatingLog(filepath.Join(logDir, logFileName), maxSize,
+		getEnvDuration("LOG_MAX_AGE", logDefaultMaxAge), getEnvInt("LOG_KEEP", logDefaultKeep))
+	if err != nil {
+		fatal("Failed to open log file", "err", err)
+	}
+	setDefault(slog.NewJSONHandler(logFile, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: jsonDurations,
+	}))
+
+	return logFile
+}
+
+// fatal logs msg at error level and exits, like log.Fatal. Within
+// catchFatal it returns the problem to the caller instead.
+func fatal(msg string, args ...any) {
+	if catchingFatal.Load() {
+		panic(fatalError{msg: msg, args: args})
+	}
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// catchingFatal is set while catchFatal runs.
+var catchingFatal atomic.Bool
+
+// fatalError is what fatal panics with within catchFatal.
+type fatalError struct {
+	msg  string
+	args []any
+}
+
+func (e fatalError) Error() string {
+	r := slog.NewRecord(time.Time{}, slog.LevelError, e.msg, 0)
+	r.Add(e.args...)
+	msg := e.msg
+	r.Attrs(func(a slog.Attr) bool {
+		msg += fmt.Sprintf(" %s=%v", a.Key, a.Value)
+		return true
+	})
+	return msg
+}
+
+// catchFatal runs fn and returns the problem it called fatal with, if any,
+// so --check-config can list every problem. The flag is process-wide, so
+// it is only for --check-config, which runs nothing else; a reload gets
+// errors returned instead.
+func catchFatal(fn func()) (err error) {
+	catchingFatal.Store(true)
+	defer func() {
+		catchingFatal.Store(false)
+		if r := recover(); r != nil {
+			e, ok := r.(fatalError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	fn()
+	return nil
+}
+
+// jsonDurations writes durations as seconds, which log pipelines can
+// aggregate, instead of nanoseconds.
+func jsonDurations(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.Float64(a.Key, a.Value.Duration().Seconds())
+	}
+	return a
+}
+
+// isTerminal reports whether f is a character device such as a TTY.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// consoleHandler writes records as single lines such as
+//
+//	19:04:05.123 INFO  [mqtt] Connected to MQTT broker broker=mqtt.local:1883
+//
+// The component attribute, if any, is shown in brackets before the
+// message.
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	prefix string      // group prefix for attribute keys
+	attrs  []slog.Attr // from WithAttrs, keys already prefixed
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{mu: new(sync.Mutex), w: w, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var component string
+	var rest bytes.Buffer
+	add := func(a slog.Attr) {
+		if a.Key == "component" && component == "" {
+			component = a.Value.String()
+			return
+		}
+		appendConsoleAttr(&rest, "", a)
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		a.Key = h.prefix + a.Key
+		add(a)
+		return true
+	})
+
+	var b bytes.Buffer
+	b.WriteString(r.Time.Format("15:04:05.000"))
+	fmt.Fprintf(&b, " %-5s ", r.Level)
+	if component != "" {
+		b.WriteString("[" + component + "] ")
+	}
+	b.WriteString(r.Message)
+	b.Write(rest.Bytes())
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(b.Bytes())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// appendConsoleAttr writes " key=value", flattening groups into dotted
+// keys and quoting values that contain spaces.
+func appendConsoleAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			appendConsoleAttr(b, prefix, g)
+		}
+		return
+	}
+	var value string
+	switch a.Value.Kind() {
+	case slog.KindTime:
+		value = a.Value.Time().Format(time.RFC3339)
+	default:
+		value = a.Value.String()
+	}
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, value)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log rotation defaults.
+const (
+	logDefaultMaxSizeMB = 10
+	logDefaultMaxAge    = 24 * time.Hour
+	logDefaultKeep      = 7
+	logArchiveTime      = "20060102T150405.000000000" // fixed width, so names sort by time
+)
+
+// rotatingLog is an append-only log file that is rotated once it reaches
+// maxSize bytes or gets older than maxAge. Rotation renames the file to a
+// timestamped archive and opens a new one, so no record is rewritten or
+// lost; archives are then gzipped and all but the newest keep removed.
+type rotatingLog struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+	pending sync.WaitGroup // compressions in progress
+}
+
+// openRotatingLog opens path for appending. Archives a previous run did not
+// get to compress are compressed first, and a leftover file older than
+// maxAge is rotated straight away.
+func openRotatingLog(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	l.compressLeftovers()
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	if info, err := l.f.Stat(); err == nil && l.size > 0 && time.Since(info.ModTime()) > maxAge {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past maxSize or
+// the file is older than maxAge. Records are never split across files.
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && (l.size+int64(len(p)) > l.maxSize || time.Since(l.opened) > l.maxAge) {
+		if err := l.rotate(); err != nil {
+			// Keep logging to the current file rather than losing records.
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file after any running compression finishes.
+// It is a no-op on a nil log, as returned in console mode.
+func (l *rotatingLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	// Compressions log through l, so wait before taking the lock.
+	l.pending.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// rotate renames the current file and opens a new one. The caller must
+// hold l.mu.
+func (l *rotatingLog) rotate() error {
+	archive := l.path + "." + time.Now().UTC().Format(logArchiveTime)
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, archive); err != nil {
+		// Reopen the original so writes keep working.
+		if openErr := l.open(); openErr != nil {
+			return fmt.Errorf("%w (and reopening failed: %v)", err, openErr)
+		}
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	l.pending.Add(1)
+	go func() {
+		defer l.pending.Done()
+		supervise(context.Background(), "log maintenance", func(context.Context) {
+			if err := compressLog(archive); err != nil {
+				slog.Error("Failed to compress log archive", "component", "logging", "file", archive, "err", err)
+			}
+			l.prune()
+		})
+	}()
+	return nil
+}
+
+// compressLog gzips path to path.gz and removes the original.
+func compressLog(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes all but the newest keep compressed archives.
+func (l *rotatingLog) prune() {
+	archives, err := filepath.Glob(l.path + ".*.gz")
+	if err != nil {
+		return
+	}
+	sort.Strings(archives)
+	for len(archives) > l.keep {
+		if err := os.Remove(archives[0]); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove old log archive", "component", "logging", "file", archives[0], "err", err)
+		}
+		archives = archives[1:]
+	}
+}
+
+// compressLeftovers gzips archives a previous run rotated but did not get
+// to compress.
+func (l *rotatingLog) compressLeftovers() {
+	matches, _ := filepath.Glob(l.path + ".*")
+	for _, m := range matches {
+		if strings.HasSuffix(m, ".gz") {
+			continue
+		}
+		if strings.HasSuffix(m, ".gz.tmp") {
+			os.Remove(m)
+			continue
+		}
+		if err := compressLog(m); err != nil {
+			slog.Error("Failed to compress log archive", "component", "logging", "file", m, "err", err)
+		}
+	}
+	l.prune()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log outputs selected with LOG_OUTPUT, in place of the log file.
+const (
+	logOutputJournald = "journald"
+	logOutputSyslog   = "syslog"
+)
+
+// Local system log settings.
+const (
+	journalSocket    = "/run/systemd/journal/socket"
+	syslogIdentifier = "space-status"
+)
+
+// journalHandler sends records to systemd-journald over its native
+// protocol. Attributes become journal fields, e.g. component=mqtt becomes
+// COMPONENT=mqtt, so they can be filtered with journalctl COMPONENT=mqtt.
+type journalHandler struct {
+	conn   *net.UnixConn
+	level  slog.Leveler
+	prefix string      // group prefix for field names
+	attrs  []slog.Attr // from WithAttrs, keys already prefixed
+}
+
+func newJournalHandler(level slog.Leveler) (*journalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to journald: %w", err)
+	}
+	return &journalHandler{conn: conn, level: level}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", r.Message)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(syslogPriority(r.Level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", syslogIdentifier)
+	for _, a := range h.attrs {
+		appendJournalAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendJournalAttr(&b, h.prefix, a)
+		return true
+	})
+	if _, err := h.conn.Write(b.Bytes()); err != nil {
+		// Oversized or undeliverable: better on stderr, which systemd
+		// also collects, than lost.
+		fmt.Fprintf(os.Stderr, "%s %s\n", r.Level, r.Message)
+		return err
+	}
+	return nil
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "_"
+	return &c
+}
+
+// appendJournalAttr writes an attribute as a journal field, flattening
+// groups.
+func appendJournalAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, g := range a.Value.Group() {
+			appendJournalAttr(b, prefix, g)
+		}
+		return
+	}
+	var value string
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		value = strconv.FormatFloat(a.Value.Duration().Seconds(), 'f', -1, 64)
+	case slog.KindTime:
+		value = a.Value.Time().Format(time.RFC3339Nano)
+	default:
+		value = a.Value.String()
+	}
+	writeJournalField(b, journalFieldName(prefix+a.Key), value)
+}
+
+// journalFieldName maps a key to a valid journal field name: uppercase
+// letters, digits, and underscores, starting with a letter, and not one of
+// the fields the handler sets itself.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	if name == "" || name[0] < 'A' || name[0] > 'Z' || name == "MESSAGE" || name == "PRIORITY" || name == "SYSLOG_IDENTIFIER" {
+		name = "F_" + name
+	}
+	return name
+}
+
+// writeJournalField writes NAME=value, or the length-prefixed form the
+// protocol requires for values containing newlines.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// syslogPriority maps a level to a syslog severity.
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	}
+	return 7 // debug
+}
+
+// syslogHandler sends records to the local syslog daemon, with the
+// attributes as a JSON object in the message. The daemon adds the time and
+// severity.
+type syslogHandler struct {
+	inner slog.Handler
+	sink  *syslogSink
+}
+
+// syslogSink receives the JSON rendering of one record at a time and sends
+// it at the record's severity.
+type syslogSink struct {
+	mu    sync.Mutex
+	w     *syslog.Writer
+	level slog.Level
+}
+
+func (s *syslogSink) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimSuffix(p, []byte("\n")))
+	var err error
+	switch syslogPriority(s.level) {
+	case 3:
+		err = s.w.Err(msg)
+	case 4:
+		err = s.w.Warning(msg)
+	case 6:
+		err = s.w.Info(msg)
+	default:
+		err = s.w.Debug(msg)
+	}
+	return len(p), err
+}
+
+func newSyslogHandler(level slog.Leveler) (*syslogHandler, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, syslogIdentifier)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+	sink := &syslogSink{w: w}
+	inner := slog.NewJSONHandler(sink, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return jsonDurations(groups, a)
+		},
+	})
+	return &syslogHandler{inner: inner, sink: sink}, nil
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+	h.sink.level = r.Level
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithAttrs(attrs), sink: h.sink}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{inner: h.inner.WithGroup(name), sink: h.sink}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Log tail settings.
+const (
+	logTailDefaultLines = 1000 // records kept in memory
+	logTailDefaultShow  = 100  // records returned without ?lines
+)
+
+// logBuffer keeps the most recent log records as JSON lines, whatever
+// LOG_OUTPUT is, and passes new ones to followers. Each Write is one
+// record, as written by slog.JSONHandler.
+type logBuffer struct {
+	mu          sync.Mutex
+	lines       [][]byte // ring buffer
+	next        int      // index of the next line to write
+	full        bool
+	subscribers map[chan []byte]bool
+}
+
+var logTail = newLogBuffer(logTailDefaultLines)
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{lines: make([][]byte, max(size, 1)), subscribers: make(map[chan []byte]bool)}
+}
+
+// Write implements io.Writer. Slow followers miss records rather than
+// blocking logging.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	line := append([]byte(nil), p...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// last returns up to n of the most recent lines, oldest first.
+func (b *logBuffer) last(n int) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastLocked(n)
+}
+
+func (b *logBuffer) lastLocked(n int) [][]byte {
+	count := b.next
+	if b.full {
+		count = len(b.lines)
+	}
+	n = min(n, count)
+	out := make([][]byte, 0, n)
+	for i := b.next - n; i < b.next; i++ {
+		out = append(out, b.lines[(i+len(b.lines))%len(b.lines)])
+	}
+	return out
+}
+
+// follow returns up to n recent lines and a channel of the lines that
+// follow them, with no gap in between, and a function to unsubscribe.
+func (b *logBuffer) follow(n int) ([][]byte, <-chan []byte, func()) {
+	ch := make(chan []byte, 256)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[ch] = true
+	return b.lastLocked(n), ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+}
+
+// teeHandler sends each record to both handlers.
+type teeHandler struct {
+	a, b slog.Handler
+}
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.a.Enabled(ctx, level) || h.b.Enabled(ctx, level)
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	if h.a.Enabled(ctx, r.Level) {
+		errs = append(errs, h.a.Handle(ctx, r.Clone()))
+	}
+	if h.b.Enabled(ctx, r.Level) {
+		errs = append(errs, h.b.Handle(ctx, r))
+	}
+	return errors.Join(errs...)
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return teeHandler{h.a.WithAttrs(attrs), h.b.WithAttrs(attrs)}
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	return teeHandler{h.a.WithGroup(name), h.b.WithGroup(name)}
+}
+
+// handleLogs returns the most recent log records as JSON lines: ?lines=N
+// of them (default 100), and with ?follow=true keeps streaming new ones
+// until the client disconnects.
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	n := logTailDefaultShow
+	if v := r.URL.Query().Get("lines"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "lines must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	if !follow {
+		for _, line := range logTail.last(n) {
+			w.Write(line)
+		}
+		return
+	}
+
+	flusher, ok := streamingFlusher(w, r)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusNotImplemented)
+		return
+	}
+	keepStreaming(w)
+	recent, lines, unsubscribe := logTail.follow(n)
+	defer unsubscribe()
+	w.WriteHeader(http.StatusOK)
+	for _, line := range recent {
+		w.Write(line)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-lines:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
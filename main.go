@@ -1,190 +1,703 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/slack-go/slack"
 	"periph.io/x/conn/v3/gpio"
 	"periph.io/x/conn/v3/gpio/gpioreg"
 	"periph.io/x/host/v3"
 )
 
-var (
-	state          bool
-	optInUsers     = make(map[string]bool)
-	optInUsersLock sync.RWMutex
-)
+// spaceSnapshot is the status of the space and when it began.
+type spaceSnapshot struct {
+	// Status is unknown until every zone's switch has given a stable
+	// reading.
+	Status spaceStatus
+	Since  time.Time // zero while not known
+}
+
+// space holds the current spaceSnapshot. Changes replace it as a whole
+// under spaceMu, so readers that do not hold spaceMu, such as request
+// handlers, always see a status with its own time.
+var space atomic.Pointer[spaceSnapshot]
+
+// currentSpace returns the status of the space and when it began.
+func currentSpace() spaceSnapshot {
+	if s := space.Load(); s != nil {
+		return *s
+	}
+	return spaceSnapshot{}
+}
+
+// setSpace replaces the status of the space. The caller holds spaceMu.
+func setSpace(s spaceStatus, since time.Time) {
+	space.Store(&spaceSnapshot{Status: s, Since: since})
+}
 
 // Constants for configuration
 const (
-	slackVerificationToken = "your-slack-verification-token"
-	logDir                 = "logs"
 	logFileName            = "app.log"
-	logCleanupInterval     = time.Hour
-	logRetentionDuration   = 24 * time.Hour
-	pollingInterval        = 100 * time.Millisecond
+	dataDir                = "data"
+	defaultListenAddr      = ":8080"
+	defaultLogDir          = "logs"
+	defaultSwitchPin       = "GPIO17"
+	defaultPollingInterval = 100 * time.Millisecond
+	defaultSwitchDebounce  = 50 * time.Millisecond
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// Settings read at startup, see loadCoreSettings.
+var (
+	listenAddr = defaultListenAddr
+	logDir     = defaultLogDir
+	// switchDebounce is how long a new level must hold before it counts;
+	// 0 disables debouncing.
+	switchDebounce  = defaultSwitchDebounce
+	shutdownTimeout = defaultShutdownTimeout
+	// systemd is set when the service runs under systemd with
+	// Type=notify.
+	systemd *systemdNotifier
 )
 
 func main() {
+	if d, ok := soakFlag(os.Args[1:]); ok {
+		os.Exit(runSoak(d))
+	}
+	if err := parseFlags(os.Args[1:]); err != nil {
+		fatal("Invalid command line; see --help", "component", "config", "err", err)
+	}
+	if online, ok := checkConfigFlag(os.Args[1:]); ok {
+		os.Exit(runConfigCheck(online))
+	}
+	systemd = newSystemdNotifier()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := loadConfig(); err != nil {
+		fatal("Invalid configuration file", "component", "config", "err", err)
+	}
+	loadCoreSettings()
+
 	slackToken := getEnv("SLACK_TOKEN")
-	slackChannel := getEnv("SLACK_CHANNEL")
 
-	initializeGPIO()
-	defer startHTTPServer()
+	// In shadow mode the switch is read from another instance's agent feed
+	// and nothing is sent; connections that would compete with the primary
+	// instance (IRC, XMPP, Telegram polling, ops alerts) are not opened.
+	shadowOf := setting("SHADOW_OF")
+	if shadowOf == "" {
+		opsAlerts = newOpsAlerter(slackToken, setting("OPS_SLACK_CHANNEL"))
+	} else {
+		shadowMode, monitorStall = true, feedStallAfter
+	}
+	go checkSlackAuth(slackToken)
+
+	startupPolicy, err := parseStartupAnnounce(setting("STARTUP_ANNOUNCE"))
+	if err != nil {
+		fatal("Invalid STARTUP_ANNOUNCE", "err", err)
+	}
+	notifiers := newNotifierRegistry()
+	if err := configureNotifiers(loadedSettings(), notifiers); err != nil {
+		fatal("Invalid notifier settings", "err", err)
+	}
+	sessions.onChange = func(kind string, s *sessionRecord) {
+		hooks := registeredWebhooks(notifiers)
+		if shadowOf != "" {
+			slog.Info("Would publish session", "component", "shadow", "event", kind, "session", s.ID, "webhooks", len(hooks))
+			return
+		}
+		for _, hook := range hooks {
+			hook.PublishSession(kind, s)
+		}
+	}
 
+	if shadowOf == "" {
+		initializeGPIO()
+	}
+	var endpoints []customEndpoint
+	if dir := setting("CUSTOM_ENDPOINTS_DIR"); dir != "" {
+		if endpoints, err = loadCustomEndpoints(dir); err != nil {
+			fatal("Failed to load custom endpoints", "err", err)
+		}
+	}
+	spaceAPI, err := loadSpaceAPIConfig()
+	if err != nil {
+		fatal("Invalid SpaceAPI settings", "err", err)
+	}
 	logFile := setupLogging()
 	defer logFile.Close()
+	build := currentBuildInfo()
+	slog.Info("Starting space-status", "version", build.String(), "go", build.GoVersion)
+
+	loadMessageTemplates()
+	if err := guestPasses.load(); err != nil {
+		fatal("Failed to load guest passes", "err", err)
+	}
+	go pruneGuestPasses()
+	if err := memberKeys.load(); err != nil {
+		fatal("Failed to load API keys", "err", err)
+	}
+	if err := sessions.load(); err != nil {
+		fatal("Failed to load sessions", "err", err)
+	}
+	if err := loadSubscriptions(); err != nil {
+		fatal("Failed to load subscriptions", "err", err)
+	}
+	if err := eventSequence.load(); err != nil {
+		fatal("Failed to load event sequence", "err", err)
+	}
+	if err := events.load(); err != nil {
+		fatal("Failed to load events", "err", err)
+	}
+	if err := auditTrail.load(); err != nil {
+		fatal("Failed to load audit log", "err", err)
+	}
+	eventSequence.advance(events.lastSeq())
+	if err := lastKnown.load(); err != nil {
+		fatal("Failed to load last known state", "err", err)
+	}
+	restoreZoneStates()
+	if err := configureHistory(); err != nil {
+		fatal("Failed to open history", "err", err)
+	}
+	retention, err := loadRetentionPolicy()
+	if err != nil {
+		fatal("Invalid history retention", "err", err)
+	}
+	if retention.months > 0 {
+		go pruneHistoryPeriodically(retention)
+	}
+	if err := notificationsPause.load(); err != nil {
+		fatal("Failed to load notification pause", "err", err)
+	}
+	if err := statusOverride.load(notifiers); err != nil {
+		fatal("Failed to load status override", "err", err)
+	}
+	if err := spaceMaintenance.load(notifiers); err != nil {
+		fatal("Failed to load maintenance", "err", err)
+	}
+	if err := configureOTLP(); err != nil {
+		fatal("Invalid OpenTelemetry settings", "err", err)
+	}
+	if err := configureInflux(); err != nil {
+		fatal("Invalid InfluxDB settings", "err", err)
+	}
+	startPiMonitor(getEnvInt("PI_TEMP_ALERT", piDefaultTempAlert))
+	if shadowOf == "" {
+		if err := notifyLog.open(); err != nil {
+			fatal("Failed to open notification log", "err", err)
+		}
+		notifyLog.replay()
+
+		if interval, maxLatency, failures := loadCanarySettings(); interval > 0 {
+			canary = newPipelineCanary(notifiers, interval, maxLatency, failures)
+			go canary.run()
+		}
+	}
+
+	if relay := setting("TUNNEL_URL"); relay != "" && shadowOf == "" {
+		go newTunnelClient(relay, setting("TUNNEL_TOKEN"), instrumentHTTP(http.DefaultServeMux)).run()
+	}
+
+	warnUnusedSettings()
+	configLoaded.Store(true)
+	go watchReloadSignal(notifiers)
+	monitors := newSupervisor(ctx)
+	if shadowOf != "" {
+		slog.Info("Running in shadow mode; notifications are logged, not sent", "component", "shadow", "primary", shadowOf)
+		monitors.start("agent feed", func(ctx context.Context) {
+			followSensorFeed(ctx, shadowOf, setting("SHADOW_TOKEN"), &shadowNotifier{notifiers: notifiers})
+		})
+	} else {
+		warnUnknownZoneNotifiers(notifiers.Notifiers())
+		for _, z := range zones {
+			pins := setupZonePins(z)
+			monitors.start("monitor "+z.name, func(ctx context.Context) {
+				monitorSwitch(ctx, z, pins, notifiers, startupPolicy)
+			})
+		}
+	}
+	servers := startHTTPServer(ctx, notifiers, endpoints, spaceAPI)
 
-	pin := setupGPIOPin("GPIO17")
-	go monitorSwitch(pin, slackToken, slackChannel)
+	<-ctx.Done()
+	stop() // a second signal stops the process at once
+	shutdown(servers, monitors)
+}
+
+// loadCoreSettings reads the settings that package-level state is built
+// from, once the configuration file is loaded. Those in runtimeSettings
+// are read again on reload.
+func loadCoreSettings() {
+	if addr := setting("LISTEN_ADDR"); addr != "" {
+		listenAddr = addr
+	}
+	compressHTTP = setting("HTTP_COMPRESSION") != "false"
+	limits, err := loadHTTPLimits()
+	if err != nil {
+		fatal("Invalid HTTP limits", "err", err)
+	}
+	serverLimits = limits
+	shutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if shutdownTimeout <= 0 {
+		fatal("SHUTDOWN_TIMEOUT must be positive", "value", shutdownTimeout)
+	}
+	if dir := setting("LOG_DIR"); dir != "" {
+		logDir = dir
+	}
+	pinName := setting("GPIO_PIN")
+	if pinName == "" {
+		pinName = defaultSwitchPin
+	}
+	// The default pull-up suits a switch wired to ground, which reads low
+	// while the space is open.
+	pull, err := parsePull(setting("GPIO_PULL"))
+	if err != nil {
+		fatal("Invalid GPIO_PULL", "err", err)
+	}
+	openLevel, err := parseLevel(setting("GPIO_OPEN_LEVEL"))
+	if err != nil {
+		fatal("Invalid GPIO_OPEN_LEVEL", "err", err)
+	}
+	zones = loadZones(pinName, setting("GPIO_MEMBERS_PIN"), pull, openLevel)
+	switchDebounce = getEnvDuration("GPIO_DEBOUNCE", defaultSwitchDebounce)
+	if switchDebounce < 0 {
+		fatal("GPIO_DEBOUNCE must not be negative", "value", switchDebounce)
+	}
+	runtime, err := readRuntimeSettings(loadedSettings())
+	if err != nil {
+		fatal("Invalid settings", "err", err)
+	}
+	runtime.apply()
 }
 
-// getEnv retrieves environment variables and exits on missing variables.
+// getEnv retrieves a required setting and exits when it is missing.
 func getEnv(key string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		log.Fatalf("Environment variable %s must be set", key)
+	value, err := requiredSetting(key)
+	if err != nil {
+		fatal("Setting must be set in the environment or configuration file", "key", key)
 	}
 	return value
 }
 
-// initializeGPIO initializes the GPIO library.
-func initializeGPIO() {
-	if _, err := host.Init(); err != nil {
-		log.Fatalf("Failed to initialize GPIO: %v", err)
-	}
+// requiredSetting retrieves a setting of the loaded configuration that must
+// be set.
+func requiredSetting(key string) (string, error) {
+	return loadedSettings().requiredSetting(key)
 }
 
-// setupGPIOPin configures a GPIO pin as input with a pull-up resistor.
-func setupGPIOPin(pinName string) gpio.PinIO {
-	pin := gpioreg.ByName(pinName)
-	if pin == nil {
-		log.Fatalf("Failed to find pin %s", pinName)
+// requiredSetting retrieves a setting that must be set.
+func (v *settingsView) requiredSetting(key string) (string, error) {
+	value := v.setting(key)
+	if value == "" {
+		return "", fmt.Errorf("%s must be set in the environment or configuration file", key)
 	}
-	if err := pin.In(gpio.PullUp, gpio.BothEdges); err != nil {
-		log.Fatalf("Failed to configure pin %s as input: %v", pinName, err)
+	return value, nil
+}
+
+// requiredSettings retrieves settings that must all be set, in order.
+func (v *settingsView) requiredSettings(keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		value, err := v.requiredSetting(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
 	}
-	return pin
+	return values, nil
 }
 
-// setupLogging sets up logging to a file with rotation for old logs.
-func setupLogging() *os.File {
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		log.Fatalf("Failed to create log directory: %v", err)
+// setupEmailNotifier configures the SMTP notifier from the environment.
+func setupEmailNotifier(v *settingsView, host string) (*emailNotifier, error) {
+	tlsMode := v.setting("SMTP_TLS")
+	if tlsMode == "" {
+		tlsMode = smtpTLSStartTLS
+	}
+	port := v.setting("SMTP_PORT")
+	if port == "" {
+		port = "587"
+		if tlsMode == smtpTLSImplicit {
+			port = "465"
+		}
+	}
+	subjectSource := v.setting("EMAIL_SUBJECT_TEMPLATE")
+	if subjectSource == "" {
+		subjectSource = defaultEmailSubject
+	}
+	subject, err := parseMessageTemplate("subject", subjectSource)
+	if err != nil {
+		return nil, fmt.Errorf("EMAIL_SUBJECT_TEMPLATE: %w", err)
+	}
+	from, err := v.requiredSetting("SMTP_FROM")
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := loadTemplateOverrides(v, "EMAIL")
+	if err != nil {
+		return nil, err
 	}
 
-	logFile, err := os.OpenFile(fmt.Sprintf("%s/%s", logDir, logFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	n, err := newEmailNotifier(net.JoinHostPort(host, port), tlsMode, v.setting("SMTP_USERNAME"), v.setting("SMTP_PASSWORD"),
+		from, splitCommaList(v.setting("SMTP_TO")), subject, overrides)
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		return nil, fmt.Errorf("SMTP: %w", err)
 	}
+	return n, nil
+}
 
-	log.SetOutput(logFile)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+// getEnvInt reads an optional integer setting, exiting on invalid values.
+func getEnvInt(key string, fallback int) int {
+	n, err := intSetting(key, fallback)
+	if err != nil {
+		fatal("Setting must be an integer", "key", key, "source", settingSource(key), "err", errors.Unwrap(err))
+	}
+	return n
+}
 
-	go cleanupOldLogs()
+// intSetting reads an optional integer setting of the loaded
+// configuration.
+func intSetting(key string, fallback int) (int, error) {
+	return loadedSettings().intSetting(key, fallback)
+}
 
-	return logFile
+// intSetting reads an optional integer setting.
+func (v *settingsView) intSetting(key string, fallback int) (int, error) {
+	value := v.setting(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s (%s) must be an integer: %w", key, v.source(key), err)
+	}
+	return n, nil
 }
 
-// cleanupOldLogs deletes log entries older than the retention duration.
-func cleanupOldLogs() {
-	for {
-		time.Sleep(logCleanupInterval)
+// getEnvDuration reads an optional duration setting such as "15m", exiting
+// on invalid values.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	d, err := durationSetting(key, fallback)
+	if err != nil {
+		fatal("Setting must be a duration", "key", key, "source", settingSource(key), "err", errors.Unwrap(err))
+	}
+	return d
+}
 
-		logEntries, err := os.ReadFile(fmt.Sprintf("%s/%s", logDir, logFileName))
-		if err != nil {
-			log.Printf("Failed to read log file: %v", err)
-			continue
-		}
+// durationSetting reads an optional duration setting such as "15m" of the
+// loaded configuration.
+func durationSetting(key string, fallback time.Duration) (time.Duration, error) {
+	return loadedSettings().durationSetting(key, fallback)
+}
 
-		var recentLogs []byte
-		cutoff := time.Now().Add(-logRetentionDuration)
+// durationSetting reads an optional duration setting such as "15m".
+func (v *settingsView) durationSetting(key string, fallback time.Duration) (time.Duration, error) {
+	value := v.setting(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s (%s) must be a duration: %w", key, v.source(key), err)
+	}
+	return d, nil
+}
 
-		for _, entry := range bytes.Split(logEntries, []byte("\n")) {
-			if len(entry) == 0 {
-				continue
-			}
-			if logTime, err := time.Parse(time.RFC3339, string(entry[:20])); err == nil && logTime.After(cutoff) {
-				recentLogs = append(recentLogs, entry...)
-				recentLogs = append(recentLogs, '\n')
-			}
-		}
+// initializeGPIO initializes the GPIO library.
+func initializeGPIO() {
+	if _, err := host.Init(); err != nil {
+		fatal("Failed to initialize GPIO", "err", err)
+	}
+}
 
-		if err := os.WriteFile(fmt.Sprintf("%s/%s", logDir, logFileName), recentLogs, 0644); err != nil {
-			log.Printf("Failed to write log file: %v", err)
-		}
+// setupGPIOPin configures a GPIO pin as input with the given resistor and
+// edge detection, and reports whether edge detection is available. Without
+// it, the pin has to be polled.
+func setupGPIOPin(pinName string, pull gpio.Pull) (gpio.PinIO, bool) {
+	pin := gpioreg.ByName(pinName)
+	if pin == nil {
+		fatal("Failed to find pin", "pin", pinName)
 	}
+	err := pin.In(pull, gpio.BothEdges)
+	if err == nil {
+		return pin, true
+	}
+	slog.Warn("Edge detection unavailable; polling the pin", "component", "switch", "pin", pinName,
+		"interval", liveSettings().pollingInterval, "err", err)
+	if err := pin.In(pull, gpio.NoEdge); err != nil {
+		fatal("Failed to configure pin as input", "pin", pinName, "pull", pull, "err", err)
+	}
+	return pin, false
 }
 
-// monitorSwitch monitors the GPIO pin and sends Slack messages on state change.
-func monitorSwitch(pin gpio.PinIO, slackToken, slackChannel string) {
-	var lastState gpio.Level
-	for {
-		currentState := pin.Read()
-		if currentState != lastState {
-			lastState = currentState
-			state = currentState == gpio.Low
-			message := fmt.Sprintf("Switch state changed to: %v", state)
-			log.Println(message)
-			sendSlackMessage(slackToken, slackChannel, message)
-		}
-		time.Sleep(pollingInterval)
+// setupZonePins configures the pins of a zone's switch. Unless every pin
+// detects edges, all of them are polled.
+func setupZonePins(z *zone) *zonePins {
+	pins := &zonePins{}
+	pins.main, pins.edges = setupGPIOPin(z.pinName, z.pull)
+	if z.membersPinName != "" {
+		var edges bool
+		pins.members, edges = setupGPIOPin(z.membersPinName, z.pull)
+		pins.edges = pins.edges && edges
 	}
+	return pins
 }
 
-// sendSlackMessage sends a message to the specified Slack channel.
-func sendSlackMessage(slackToken, slackChannel, message string) {
-	api := slack.New(slackToken)
-	_, _, err := api.PostMessage(slackChannel, slack.MsgOptionText(message, false))
-	if err != nil {
-		log.Printf("Failed to send Slack message: %v", err)
+// parsePull parses GPIO_PULL: up (the default), down, or none for a
+// switch with an external resistor.
+func parsePull(value string) (gpio.Pull, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "up":
+		return gpio.PullUp, nil
+	case "down":
+		return gpio.PullDown, nil
+	case "none", "float":
+		return gpio.Float, nil
 	}
+	return gpio.PullNoChange, fmt.Errorf("unknown pull %q; use up, down, or none", value)
 }
 
-// startHTTPServer initializes and starts the HTTP server.
-func startHTTPServer() {
-	http.HandleFunc("/optin", handleOptIn)
-	http.HandleFunc("/status", getStatus)
-	log.Println("HTTP server running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// parseLevel parses GPIO_OPEN_LEVEL: low (the default) or high.
+func parseLevel(value string) (gpio.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "low":
+		return gpio.Low, nil
+	case "high":
+		return gpio.High, nil
+	}
+	return gpio.Low, fmt.Errorf("unknown level %q; use low or high", value)
 }
 
-// handleOptIn handles Slack /optin command and updates user preferences.
-func handleOptIn(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid request format", http.StatusBadRequest)
+// applySwitchState records a change of a zone's switch and announces it
+// through the notifier. Changes that change the space as a whole also
+// update the status, sessions, and the agent feed. source says where the
+// change came from, for the history. While the status is overridden, the
+// override decides the space and changes of the switch are not announced;
+// during maintenance the space keeps its status and nothing is announced.
+func applySwitchState(z *zone, s spaceStatus, source string, notifier Notifier) {
+	spaceMu.Lock()
+	now := time.Now()
+	from, previous := z.setState(s, now)
+	cur := currentSpace()
+	change := transition{Time: now, Zone: z.name, From: from, To: s, SpaceFrom: cur.Status, Source: source}
+	// The first reading after a start changes from the last known state.
+	if last, ok := lastKnown.zone(z.name); ok && from == statusUnknown {
+		change.From = last.Status
+	}
+	if last, ok := lastKnown.space(); ok && cur.Status == statusUnknown {
+		change.SpaceFrom = last.Status
+	}
+	metricZoneOpen.set(boolGauge(s.open()), z.name)
+	states := zoneStates(nil, statusUnknown)
+	event := newEvent(z.name, s, now)
+	event.SpaceStatus = spaceStatusOf(states)
+	override, overridden := statusOverride.active()
+	if overridden {
+		event.SpaceStatus = override.Status
+	} else if held, ok := spaceMaintenance.held(); ok {
+		event.SpaceStatus = held
+	}
+	event.ZoneOnly = !updateSpace(event.SpaceStatus, now)
+	if zonesConfigured {
+		event.Zones = states
+	}
+	ctx, span := startSpan(context.Background(), "switch.change", spanKindInternal)
+	defer span.finish(nil)
+	span.set("state", event.Status.String())
+	span.set("event.id", event.ID)
+	recordChange(&event, now)
+	span.set("event.seq", event.Seq)
+	if !previous.IsZero() {
+		event.Duration = now.Sub(previous)
+	}
+	spaceMu.Unlock()
+	change.EventID, change.Seq = event.ID, event.Seq
+	change.SpaceTo = change.SpaceFrom
+	if !event.ZoneOnly {
+		change.SpaceTo = event.SpaceStatus
+	}
+	recordTransition(change)
+	influx.transition(change)
+	slog.Info("Switch state changed", "component", "switch", "state", event.Status, "zone", event.Zone,
+		"space_changed", !event.ZoneOnly, "duration", event.Duration, "event", event.ID, "seq", event.Seq)
+	if overridden && event.ZoneOnly {
+		// The switch no longer decides the space, e.g. while the sensor is
+		// broken, so its changes are kept out of the announcements.
+		slog.Info("Status overridden; not announcing switch change", "component", "switch", "event", event.ID)
 		return
 	}
+	notifier.Notify(ctx, event)
+}
 
-	userID := r.FormValue("user_id")
-	slackToken := r.FormValue("token")
-	if userID == "" || slackToken != slackVerificationToken {
-		http.Error(w, "Invalid user or token", http.StatusUnauthorized)
-		return
+// recordChange numbers and records an event and, unless it is zone only,
+// takes the space to its state, starting or ending the session. The caller
+// holds spaceMu.
+func recordChange(event *Event, now time.Time) {
+	if !event.ZoneOnly {
+		sensorReadings.publish(event.SpaceStatus, now)
+		recordStateMetrics(event.SpaceStatus, now)
+	}
+	seq, err := eventSequence.next()
+	if err != nil {
+		slog.Error("Failed to save event sequence", "component", "switch", "err", err)
 	}
+	event.Seq = seq
+	if err := events.record(*event); err != nil {
+		slog.Error("Failed to record event", "component", "switch", "err", err)
+	}
+	if !event.ZoneOnly {
+		if since := currentSpace().Since; !since.IsZero() {
+			event.SpaceDuration = now.Sub(since)
+		}
+		setSpace(event.SpaceStatus, now)
+		// Going from open to members only or back carries on the session.
+		if event.SpaceStatus.open() {
+			if err := sessions.start(*event); err != nil {
+				slog.Error("Failed to save session", "component", "switch", "err", err)
+			}
+		} else {
+			session, err := sessions.end(*event)
+			if err != nil {
+				slog.Error("Failed to save session", "component", "switch", "err", err)
+			}
+			event.Session = session
+		}
+	}
+	if err := lastKnown.save(); err != nil {
+		slog.Error("Failed to save state", "component", "switch", "err", err)
+	}
+}
 
-	optInUsersLock.Lock()
-	defer optInUsersLock.Unlock()
-	optInUsers[userID] = true
+// startHTTPServer registers the routes and starts serving, over TLS when
+// a certificate is configured. It returns the servers to shut down: the
+// main one and, with ACME over HTTP-01, the challenge listener. Requests
+// run in ctx, so long-lived responses such as the agent feed end with it.
+func startHTTPServer(ctx context.Context, notifiers *notifierRegistry, endpoints []customEndpoint, spaceAPI *spaceAPIConfig) []*http.Server {
+	registerRoutes(notifiers, endpoints, spaceAPI)
+
+	tlsConfig, err := loadTLSSettings()
+	if err != nil {
+		fatal("Invalid TLS settings", "err", err)
+	}
+	ln, err := openListener(listenAddr)
+	if err != nil {
+		fatal("Failed to listen", "component", "http", "addr", listenAddr, "err", err)
+	}
+	server := newHTTPServer(ctx, instrumentHTTP(http.DefaultServeMux))
+	servers := []*http.Server{server}
+	if tlsConfig.enabled() {
+		config, challenges, err := tlsConfig.config()
+		if err != nil {
+			fatal("Failed to set up TLS", "component", "http", "err", err)
+		}
+		server.TLSConfig = config
+		if challenges != nil {
+			acme := newHTTPServer(ctx, challenges)
+			acme.Addr = tlsConfig.httpAddr
+			servers = append(servers, acme)
+			slog.Info("ACME HTTP-01 listener running", "component", "http", "addr", tlsConfig.httpAddr)
+			go serve("ACME HTTP-01 listener", acme.ListenAndServe)
+		}
+		slog.Info("HTTPS server running", "component", "http", "addr", ln.Addr().String(), "acme_domains", tlsConfig.domains)
+		go serve("HTTPS server", func() error { return server.ServeTLS(ln, "", "") })
+	} else {
+		slog.Info("HTTP server running", "component", "http", "addr", ln.Addr().String())
+		go serve("HTTP server", func() error { return server.Serve(ln) })
+	}
+	if systemd != nil {
+		go systemd.run()
+	}
+	return servers
+}
 
-	response := map[string]string{
-		"response_type": "ephemeral",
-		"text":          fmt.Sprintf("You have opted in for notifications, <@%s>.", userID),
+// serve runs a server and exits if it stops other than by shutting down.
+func serve(name string, run func() error) {
+	if err := run(); !errors.Is(err, http.ErrServerClosed) {
+		fatal(name+" stopped", "component", "http", "err", err)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
 }
 
-// getStatus responds with the current switch state in JSON format.
-func getStatus(w http.ResponseWriter, r *http.Request) {
-	response := map[string]bool{"state": state}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// registerRoutes adds every endpoint to the default mux.
+func registerRoutes(notifiers *notifierRegistry, endpoints []customEndpoint, spaceAPI *spaceAPIConfig) {
+	http.HandleFunc("/optin", limitRate(slackDeduplicated(handleOptIn)))
+	http.HandleFunc("/pause", limitRate(slackDeduplicated(handlePauseCommand)))
+	http.HandleFunc("/maintenance", limitRate(slackDeduplicated(handleMaintenanceCommand)))
+	http.HandleFunc("/status", withCORS(limitRate(getStatus)))
+	http.HandleFunc("PUT /status", requireScope(scopeAdmin, idempotent(handleSetStatus)))
+	http.HandleFunc("DELETE /status", requireScope(scopeAdmin, handleClearStatus))
+	handlePublic("/api/v1/status", getStatus)
+	http.HandleFunc("PUT /api/v1/status", requireScope(scopeAdmin, idempotent(handleSetStatus)))
+	http.HandleFunc("DELETE /api/v1/status", requireScope(scopeAdmin, handleClearStatus))
+	handlePublic("/status.txt", handleStatusText)
+	http.HandleFunc("GET /version", limitRate(handleVersion))
+	http.HandleFunc("GET /healthz", handleHealthz)
+	http.HandleFunc("GET /readyz", handleReadyz)
+	http.HandleFunc("/schedule.ics", withCORS(limitRate(handleScheduleICS)))
+	handlePublic("/shields.json", handleShields)
+	http.HandleFunc("GET /api/v1/schema", limitRate(handleSchema))
+	http.HandleFunc("GET /metrics", requireScope(scopeStatusRead, handleMetrics))
+	if spaceAPI != nil {
+		http.HandleFunc("GET /spaceapi.json", limitRate(handleSpaceAPI(spaceAPI)))
+	}
+	http.HandleFunc("/api/v1/preview", requireScope(scopeAdmin, handlePreview(notifiers)))
+	http.HandleFunc("GET /api/v1/templates", requireScope(scopeAdmin, handleListTemplates))
+	http.HandleFunc("PUT /api/v1/templates/{name}", requireScope(scopeAdmin, handleSaveTemplate))
+	http.HandleFunc("POST /api/v1/checkin", requireScope(scopeCheckIn, idempotent(handleCheckIn)))
+	http.HandleFunc("POST /api/v1/session/notes", requireScope(scopeAdmin, idempotent(handleAddSessionNote)))
+	http.HandleFunc("GET /api/v1/sessions", requireScope(scopeStatusRead, handleListSessions))
+	http.HandleFunc("GET /api/v1/sessions/{id}", requireScope(scopeStatusRead, handleGetSession))
+	http.HandleFunc("PATCH /api/v1/sessions/{id}", requireScope(scopeAdmin, handleUpdateSession))
+	http.HandleFunc("POST /api/v1/sessions/{id}/notes", requireScope(scopeAdmin, idempotent(handleAddSessionNote)))
+	http.HandleFunc("GET /api/v1/passes", requireScope(scopeAdmin, handleListGuestPasses))
+	http.HandleFunc("POST /api/v1/passes", requireScope(scopeAdmin, handleMintGuestPass))
+	http.HandleFunc("DELETE /api/v1/passes/{id}", requireScope(scopeAdmin, handleRevokeGuestPass))
+	http.HandleFunc("GET /api/v1/me/keys", requireScope(scopeMember, handleListMemberKeys))
+	http.HandleFunc("POST /api/v1/me/keys", requireScope(scopeMember, handleMintMemberKey))
+	http.HandleFunc("DELETE /api/v1/me/keys/{id}", requireScope(scopeMember, handleRevokeMemberKey))
+	http.HandleFunc("GET /api/v1/me/subscription", requireScope(scopeSubscription, handleGetMySubscription))
+	http.HandleFunc("PUT /api/v1/me/subscription", requireScope(scopeSubscription, handlePutMySubscription))
+	http.HandleFunc("DELETE /api/v1/me/subscription", requireScope(scopeSubscription, handleDeleteMySubscription))
+	http.HandleFunc("GET /admin/subscribers", requireScope(scopeAdmin, handleListSubscribers))
+	http.HandleFunc("GET /api/v1/admin/subscribers", requireScope(scopeAdmin, handleListSubscribers))
+	http.HandleFunc("POST /admin/subscribers", requireScope(scopeAdmin, handleAddSubscriber))
+	http.HandleFunc("POST /api/v1/admin/subscribers", requireScope(scopeAdmin, handleAddSubscriber))
+	http.HandleFunc("DELETE /admin/subscribers/{user}", requireScope(scopeAdmin, handleRemoveSubscriber))
+	http.HandleFunc("DELETE /api/v1/admin/subscribers/{user}", requireScope(scopeAdmin, handleRemoveSubscriber))
+	http.HandleFunc("GET /admin/audit", requireScope(scopeAdmin, handleAudit))
+	http.HandleFunc("GET /api/v1/admin/audit", requireScope(scopeAdmin, handleAudit))
+	http.HandleFunc("GET /api/v1/events", requireScope(scopeStatusRead, handleListEvents))
+	http.HandleFunc("GET /history", requireScope(scopeStatusRead, handleHistory))
+	http.HandleFunc("GET /api/v1/history", requireScope(scopeStatusRead, handleHistory))
+	http.HandleFunc("GET /history/export", requireScope(scopeStatusRead, handleHistoryExport))
+	http.HandleFunc("GET /api/v1/history/export", requireScope(scopeStatusRead, handleHistoryExport))
+	http.HandleFunc("GET /stats", requireScope(scopeStatusRead, handleStats))
+	http.HandleFunc("GET /api/v1/stats", requireScope(scopeStatusRead, handleStats))
+	handlePublic("/stats/heatmap.svg", handleHeatmap)
+	handlePublic("/api/v1/stats/heatmap.svg", handleHeatmap)
+	http.HandleFunc("GET /api/v1/agent/feed", requireScope(scopeStatusRead, handleAgentFeed))
+	http.HandleFunc("GET /api/v1/pause", requireScope(scopeAdmin, handleGetPause))
+	http.HandleFunc("PUT /api/v1/pause", requireScope(scopeAdmin, handlePause))
+	http.HandleFunc("DELETE /api/v1/pause", requireScope(scopeAdmin, handleResume))
+	http.HandleFunc("GET /api/v1/maintenance", requireScope(scopeAdmin, handleGetMaintenance))
+	http.HandleFunc("PUT /api/v1/maintenance", requireScope(scopeAdmin, handleStartMaintenance))
+	http.HandleFunc("DELETE /api/v1/maintenance", requireScope(scopeAdmin, handleEndMaintenance))
+	http.HandleFunc("GET /api/v1/system", requireScope(scopeAdmin, handleSystemHealth))
+	http.HandleFunc("GET /api/v1/logs", requireScope(scopeAdmin, handleLogs))
+	http.HandleFunc("POST /admin/reload", requireScope(scopeAdmin, handleReload(notifiers)))
+	http.HandleFunc("POST /api/v1/reload", requireScope(scopeAdmin, handleReload(notifiers)))
+	http.HandleFunc("GET /debug/pprof/{$}", requireScope(scopeAdmin, handlePprofIndex))
+	http.HandleFunc("GET /debug/pprof/profile", requireScope(scopeAdmin, handlePprofCPU))
+	http.HandleFunc("GET /debug/pprof/trace", requireScope(scopeAdmin, handlePprofTrace))
+	http.HandleFunc("GET /debug/pprof/{name}", requireScope(scopeAdmin, handlePprofProfile))
+	http.HandleFunc("GET /dashboard/", limitRate(dashboardHandler().ServeHTTP))
+	registerCustomEndpoints(endpoints)
 }
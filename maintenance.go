@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusSourceMaintenance marks the status held by maintenance mode.
+const statusSourceMaintenance = "maintenance"
+
+// maintenanceWindow records who put the space into maintenance, and the
+// status it had then.
+type maintenanceWindow struct {
+	Status    spaceStatus `json:"status"` // unknown when the switch had not been read yet
+	Reason    string      `json:"reason,omitempty"`
+	By        string      `json:"by"`
+	StartedAt time.Time   `json:"started_at"`
+}
+
+// maintenanceSwitch is the maintenance mode for electrical work, when the
+// switch will be flipped many times. Until it is turned off, the space
+// keeps the status it had when maintenance began and nothing is announced
+// by any notifier or webhook; changes of the switch are still recorded in
+// the history. Unlike a pause it does not expire, and unlike an override
+// it also holds back announcements. Turning it off takes the space back to
+// the switch, announcing the change if there is one.
+type maintenanceSwitch struct {
+	mu       sync.Mutex
+	path     string
+	current  *maintenanceWindow
+	notifier Notifier // announces the space going back to the switch
+}
+
+var spaceMaintenance = &maintenanceSwitch{path: filepath.Join(dataDir, "maintenance.json")}
+
+// load restores maintenance left on by a previous run. notifier announces
+// the change when it ends.
+func (m *maintenanceSwitch) load(notifier Notifier) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifier = notifier
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var w *maintenanceWindow
+	if err := json.Unmarshal(data, &w); err != nil {
+		return fmt.Errorf("parse %s: %w", m.path, err)
+	}
+	m.current = w
+	if w != nil {
+		slog.Info("Maintenance in progress", "component", "status", "status", w.Status, "started_by", w.By, "started_at", w.StartedAt)
+	}
+	return nil
+}
+
+// active returns the current maintenance, if any.
+func (m *maintenanceSwitch) active() (maintenanceWindow, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current == nil {
+		return maintenanceWindow{}, false
+	}
+	return *m.current, true
+}
+
+// held returns the status the space keeps during maintenance. There is
+// none when maintenance began before the switch had been read; the space
+// then follows the switch, still without announcements.
+func (m *maintenanceSwitch) held() (spaceStatus, bool) {
+	w, ok := m.active()
+	if !ok || w.Status == statusUnknown {
+		return statusUnknown, false
+	}
+	return w.Status, true
+}
+
+// start puts the space into maintenance, holding its current status. It
+// reports false, leaving it as it is, when maintenance is already on.
+func (m *maintenanceSwitch) start(reason, by string) (maintenanceWindow, bool, error) {
+	spaceMu.Lock()
+	m.mu.Lock()
+	if m.current != nil {
+		w := *m.current
+		m.mu.Unlock()
+		spaceMu.Unlock()
+		return w, false, nil
+	}
+	now := time.Now()
+	w := maintenanceWindow{Status: currentSpace().Status, Reason: reason, By: by, StartedAt: now}
+	if last, ok := lastKnown.space(); ok && w.Status == statusUnknown {
+		w.Status = last.Status
+	}
+	m.current = &w
+	err := writeJSONFile(m.path, m.current)
+	m.mu.Unlock()
+	spaceMu.Unlock()
+
+	audit("Started maintenance", auditMaintenanceStarted, by, "status", w.Status, "reason", reason)
+	text := fmt.Sprintf("Maintenance started by %s; the status stays %s and nothing is announced until it ends", by, w.Status)
+	if reason != "" {
+		text += ": " + reason
+	}
+	opsAlerts.Alert("maintenance:"+now.String(), text)
+	return w, true, err
+}
+
+// end turns maintenance off and hands the space back to the switch. It
+// reports whether maintenance was on.
+func (m *maintenanceSwitch) end(by string) (bool, error) {
+	m.mu.Lock()
+	active := m.current != nil
+	m.current = nil
+	err := os.Remove(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	m.mu.Unlock()
+
+	if active {
+		audit("Ended maintenance", auditMaintenanceEnded, by)
+		opsAlerts.Alert("maintenance:"+time.Now().String(), "Maintenance ended by "+by+"; the status follows the switch again")
+		applyOverride(m.notifier)
+	}
+	return active, err
+}
+
+// maintenanceStatusView is the maintenance as shown by /status, without
+// who started it.
+type maintenanceStatusView struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// maintenanceView is the API representation of the maintenance state.
+type maintenanceView struct {
+	Maintenance bool `json:"maintenance"`
+	*maintenanceWindow
+}
+
+func currentMaintenanceView() maintenanceView {
+	w, ok := spaceMaintenance.active()
+	if !ok {
+		return maintenanceView{}
+	}
+	return maintenanceView{Maintenance: true, maintenanceWindow: &w}
+}
+
+// handleGetMaintenance reports whether maintenance is on.
+func handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentMaintenanceView())
+}
+
+// handleStartMaintenance turns maintenance on.
+func handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	if shadowMode {
+		http.Error(w, "A shadow instance follows its primary; start maintenance there", http.StatusConflict)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, _ := authenticate(r)
+	if _, _, err := spaceMaintenance.start(strings.TrimSpace(req.Reason), p.Name); err != nil {
+		slog.Error("Failed to save maintenance", "component", "status", "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentMaintenanceView())
+}
+
+// handleEndMaintenance turns maintenance off.
+func handleEndMaintenance(w http.ResponseWriter, r *http.Request) {
+	p, _ := authenticate(r)
+	if _, err := spaceMaintenance.end(p.Name); err != nil {
+		slog.Error("Failed to save maintenance", "component", "status", "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMaintenanceCommand implements the /maintenance Slack command:
+// `/maintenance on [reason]`, `/maintenance off`, or
+// `/maintenance status`. Only users SLACK_AUTH_USERS makes admins may
+// use it.
+func handleMaintenanceCommand(w http.ResponseWriter, r *http.Request) {
+	lang := liveSettings().locale
+	tz := liveSettings().location
+	userID, ok := verifySlackCommand(w, r)
+	if !ok {
+		return
+	}
+	if !slackUserHas(userID, scopeAdmin) {
+		respondEphemeral(w, translate(lang, msgAdminOnly))
+		return
+	}
+	by := "slack:" + userID
+
+	fields := strings.Fields(r.FormValue("text"))
+	if len(fields) == 0 {
+		respondEphemeral(w, translate(lang, msgMaintenanceUsage))
+		return
+	}
+	switch strings.ToLower(fields[0]) {
+	case "on":
+		m, _, err := spaceMaintenance.start(strings.Join(fields[1:], " "), by)
+		if err != nil {
+			slog.Error("Failed to save maintenance", "component", "status", "err", err)
+		}
+		respondEphemeral(w, translate(lang, msgMaintenanceOn, m.StartedAt.In(tz).Format("Mon 15:04"), statusText(lang, m.Status)))
+	case "off":
+		if _, err := spaceMaintenance.end(by); err != nil {
+			slog.Error("Failed to save maintenance", "component", "status", "err", err)
+		}
+		respondEphemeral(w, translate(lang, msgMaintenanceOff))
+	case "status":
+		if m, ok := spaceMaintenance.active(); ok {
+			respondEphemeral(w, translate(lang, msgMaintenanceOn, m.StartedAt.In(tz).Format("Mon 15:04"), statusText(lang, m.Status)))
+			return
+		}
+		respondEphemeral(w, translate(lang, msgMaintenanceNone))
+	default:
+		respondEphemeral(w, translate(lang, msgMaintenanceUsage))
+	}
+}
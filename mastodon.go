@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mastodonDefaultDailyCap is used when MASTODON_DAILY_CAP is unset.
+const mastodonDefaultDailyCap = 4
+
+// mastodonNotifier toots announcements, at most dailyCap per day in the
+// schedule timezone so a flapping switch cannot flood followers.
+type mastodonNotifier struct {
+	instanceURL string
+	token       string
+	visibility  string
+	dailyCap    int
+	templates   templateSet // Mastodon-specific templates, if any
+
+	mu     sync.Mutex
+	day    string
+	posted int
+}
+
+// newMastodonNotifier returns a notifier posting to instanceURL with the
+// given access token.
+func newMastodonNotifier(instanceURL, token, visibility string, dailyCap int, templates templateSet) *mastodonNotifier {
+	return &mastodonNotifier{
+		instanceURL: strings.TrimRight(instanceURL, "/"),
+		token:       token,
+		visibility:  visibility,
+		dailyCap:    dailyCap,
+		templates:   templates,
+	}
+}
+
+// Name implements Notifier.
+func (n *mastodonNotifier) Name() string {
+	return "mastodon"
+}
+
+// Notify implements Notifier. Events beyond the daily cap are dropped.
+func (n *mastodonNotifier) Notify(ctx context.Context, e Event) error {
+	if !n.underCap(e.Time) {
+		slog.Warn("Mastodon daily cap reached; not tooting", "component", "mastodon", "cap", n.dailyCap, "state", e.State(), "zone", e.Zone)
+		return nil
+	}
+	message, err := renderMessage(liveSettings().locale, e, n.templates)
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(ctx, n.instanceURL+"/api/v1/statuses", map[string]string{
+		"status":     message,
+		"visibility": n.visibility,
+	})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	// Lets the instance drop duplicates if a retry races a slow success.
+	req.Header.Set("Idempotency-Key", "space-status-"+e.ID)
+	if err := doWebhookRequest(req); err != nil {
+		return err
+	}
+	n.count(e.Time)
+	return nil
+}
+
+// Preview implements Previewer.
+func (n *mastodonNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, mergeTemplates(overrides, n.templates))
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: n.instanceURL, Text: message}}, nil
+}
+
+func (n *mastodonNotifier) underCap(t time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if day := t.In(liveSettings().location).Format("2006-01-02"); day != n.day {
+		n.day, n.posted = day, 0
+	}
+	return n.posted < n.dailyCap
+}
+
+func (n *mastodonNotifier) count(t time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if day := t.In(liveSettings().location).Format("2006-01-02"); day != n.day {
+		n.day, n.posted = day, 0
+	}
+	n.posted++
+}
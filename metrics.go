@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpDurationBuckets are the upper bounds, in seconds, of the HTTP request
+// duration histogram.
+var httpDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric is one Prometheus metric family with a fixed set of label names.
+// Histograms keep cumulative bucket counts per label combination.
+type metric struct {
+	name    string
+	help    string
+	kind    string // "counter", "gauge", or "histogram"
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values []string // label values, in the order of metric.labels
+	value  float64  // counter or gauge value; histogram sum
+	count  uint64   // histogram observations
+	counts []uint64 // histogram observations per bucket
+}
+
+// metrics are the families served at /metrics, in output order.
+var metrics []*metric
+
+func newMetric(kind, name, help string, labels ...string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+	if len(labels) == 0 {
+		m.with(nil) // report unlabelled metrics as 0 until first set
+	}
+	metrics = append(metrics, m)
+	return m
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metric {
+	m := newMetric("histogram", name, help, labels...)
+	m.buckets = buckets
+	return m
+}
+
+// with returns the series for the label values, creating it. The caller
+// must hold m.mu.
+func (m *metric) with(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{values: values, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+// add increases a counter or gauge.
+func (m *metric) add(delta float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(values).value += delta
+}
+
+// inc increases a counter by one.
+func (m *metric) inc(values ...string) {
+	m.add(1, values...)
+}
+
+// set sets a gauge.
+func (m *metric) set(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(values).value = v
+}
+
+// observe records a histogram observation.
+func (m *metric) observe(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.with(values)
+	s.value += v
+	s.count++
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+}
+
+// write renders the family in the Prometheus text exposition format.
+func (m *metric) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.values), formatFloat(s.value))
+			continue
+		}
+		names := append(append([]string(nil), m.labels...), "le")
+		values := append(append([]string(nil), s.values...), "+Inf")
+		for i, bound := range m.buckets {
+			values[len(values)-1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.counts[i])
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.values), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.values), s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// boolGauge returns 1 for true and 0 for false.
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	metricOpen = newMetric("gauge", "space_status_open",
+		"Whether the space is open (1), to everyone or to members only, or closed (0).")
+	metricStatus = newMetric("gauge", "space_status_status",
+		"1 for the current status of the space, 0 for the others.", "status")
+	metricZoneOpen = newMetric("gauge", "space_status_zone_open",
+		"Whether each zone is open (1) or closed (0).", "zone")
+	metricStateChanges = newMetric("counter", "space_status_state_changes_total",
+		"State changes by new status.", "state")
+	metricStateSeconds = newMetric("gauge", "space_status_state_seconds",
+		"Seconds since the last state change.")
+	metricOpenSeconds = newMetric("counter", "space_status_open_seconds_total",
+		"Seconds the space has been open since the process started.")
+	metricDeliveries = newMetric("counter", "space_status_notifications_total",
+		"Notification deliveries by notifier and result (success or failure).", "notifier", "result")
+	metricHTTPDuration = newHistogram("space_status_http_request_duration_seconds",
+		"HTTP request durations by method, route pattern, and status code.", httpDurationBuckets, "method", "route", "code")
+	metricCanaryLatency = newMetric("gauge", "space_status_canary_latency_seconds",
+		"Time the last canary event took to reach each pipeline stage.", "stage")
+	metricCanaryFailures = newMetric("counter", "space_status_canary_failures_total",
+		"Canary runs in which a pipeline stage failed or was too slow.", "stage")
+	metricGPIOReadErrors = newMetric("counter", "space_status_gpio_read_errors_total",
+		"Switch readings discarded because the pin could not be read as an input.")
+	metricSwitchBounces = newMetric("counter", "space_status_switch_bounces_total",
+		"Switch level changes ignored because they did not last GPIO_DEBOUNCE.")
+	metricInfluxLines = newMetric("counter", "space_status_influx_lines_total",
+		"Lines exported to InfluxDB by result (written, rejected, or dropped).", "result")
+	metricRestarts = newMetric("counter", "space_status_task_restarts_total",
+		"Supervised tasks restarted after a panic, by task.", "task")
+	metricRateLimited = newMetric("counter", "space_status_rate_limited_total",
+		"Requests to public endpoints rejected by the rate limit, by route pattern.", "route")
+	metricGoroutines = newMetric("gauge", "go_goroutines",
+		"Number of goroutines that currently exist.")
+	metricOpenFDs = newMetric("gauge", "process_open_fds",
+		"Number of open file descriptors.")
+)
+
+// openTime accumulates the time the space was open, for
+// space_status_open_seconds_total.
+var openTime struct {
+	sync.Mutex
+	total time.Duration
+	since time.Time // when the current open period started; zero if closed
+}
+
+// recordStateMetrics updates the state metrics for a change to s at t.
+func recordStateMetrics(s spaceStatus, t time.Time) {
+	metricStateChanges.inc(s.String())
+	openTime.Lock()
+	defer openTime.Unlock()
+	if !openTime.since.IsZero() {
+		openTime.total += t.Sub(openTime.since)
+		openTime.since = time.Time{}
+	}
+	if s.open() {
+		openTime.since = t
+	}
+}
+
+// recordStartState starts the open-time clock for a state taken over at
+// startup, which is not counted as a change.
+func recordStartState(s spaceStatus, t time.Time) {
+	if !s.open() {
+		return
+	}
+	openTime.Lock()
+	defer openTime.Unlock()
+	openTime.since = t
+}
+
+// recordDelivery counts the final outcome of a notifier delivery.
+func recordDelivery(notifier string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metricDeliveries.inc(notifier, result)
+}
+
+// openFDs returns the number of open file descriptors, or -1 where /proc
+// is unavailable.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// updateScrapeMetrics refreshes the gauges derived from current state.
+func updateScrapeMetrics(now time.Time) {
+	cur := currentSpace()
+	metricOpen.set(boolGauge(cur.Status.open()))
+	for s, name := range spaceStatuses {
+		metricStatus.set(boolGauge(cur.Status == s), name)
+	}
+	if !cur.Since.IsZero() {
+		metricStateSeconds.set(now.Sub(cur.Since).Seconds())
+	}
+
+	openTime.Lock()
+	total := openTime.total
+	if !openTime.since.IsZero() {
+		total += now.Sub(openTime.since)
+	}
+	openTime.Unlock()
+	metricOpenSeconds.set(total.Seconds())
+
+	metricGoroutines.set(float64(runtime.NumGoroutine()))
+	if fds := openFDs(); fds >= 0 {
+		metricOpenFDs.set(float64(fds))
+	}
+}
+
+// handleMetrics serves every metric in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	updateScrapeMetrics(time.Now())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+// instrumentHTTP records the duration of every request handled by mux,
+// labelled with the route pattern it matched so paths with IDs share a
+// series, and traces it as a child of any incoming traceparent. Each
+// request gets an ID, returned in X-Request-ID, and an access log record.
+func instrumentHTTP(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := incomingRequestID(r)
+		w.Header().Set(requestIDHeader, id)
+		ctx, span := startSpan(withRemoteParent(r.Context(), r.Header), r.Method, spanKindServer)
+		r = r.WithContext(context.WithValue(ctx, requestIDKey{}, id))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		if limitBody(sw, r) {
+			if cw := newCompressWriter(sw, r); cw != nil {
+				serveRecovered(mux, cw, r, sw)
+				cw.close()
+			} else {
+				serveRecovered(mux, sw, r, sw)
+			}
+		}
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		elapsed := time.Since(start)
+		metricHTTPDuration.observe(elapsed.Seconds(), r.Method, route, strconv.Itoa(sw.status))
+		logAccess(r, route, sw, elapsed)
+
+		if span != nil {
+			span.rename(route)
+			span.set("http.request.method", r.Method)
+			span.set("http.route", route)
+			span.set("http.response.status_code", sw.status)
+			span.set("http.request.id", id)
+			var err error
+			if sw.status >= 500 {
+				err = fmt.Errorf("%s", http.StatusText(sw.status))
+			}
+			span.finish(err)
+		}
+	})
+}
+
+// serveRecovered serves r, turning a panic in the handler into a 500
+// response and a report rather than a dropped connection. sw is the
+// statusWriter under w. http.ErrAbortHandler, which aborts a response on
+// purpose, is passed on.
+func serveRecovered(mux *http.ServeMux, w http.ResponseWriter, r *http.Request, sw *statusWriter) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if p == http.ErrAbortHandler {
+			panic(p)
+		}
+		reportPanic("http "+r.Pattern, p)
+		if !sw.wroteHeader {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}()
+	mux.ServeHTTP(w, r)
+}
+
+// statusWriter remembers the status code and body size written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers such as the agent feed flush through.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap supports http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
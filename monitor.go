@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/pin"
+)
+
+// edgeFallbackInterval is how long the reader waits for an edge before
+// reading the pin anyway, so a missed interrupt is caught and the monitor
+// heartbeat keeps beating while the switch is idle.
+const edgeFallbackInterval = time.Second
+
+// switchReading is one sample of a zone's switch.
+type switchReading struct {
+	status spaceStatus
+	at     time.Time
+}
+
+// monitorSwitch monitors a zone's GPIO pins and announces state changes
+// through the notifier. With edges, the pins are read on every edge
+// detected and at least every edgeFallbackInterval; without, every
+// POLL_INTERVAL. It returns once ctx is done.
+func monitorSwitch(ctx context.Context, z *zone, pins *zonePins, notifier Notifier, startupPolicy string) {
+	z.pins.Store(pins)
+	read := func() (spaceStatus, bool) { return pins.read(z.openLevel) }
+	readings := make(chan switchReading)
+	// One reader per pin, so an edge on either is seen.
+	for _, p := range pins.all() {
+		go supervise(ctx, "switch reader "+p.Name(), func(ctx context.Context) {
+			readSwitch(ctx, z, read, p, pins.edges, readings)
+		})
+	}
+	if switchDebounce > 0 {
+		in, stable := readings, make(chan switchReading)
+		go supervise(ctx, "debounce "+z.name, func(ctx context.Context) {
+			debounceSwitch(ctx, read, switchDebounce, in, stable)
+		})
+		readings = stable
+	}
+	m := &switchMachine{
+		startup: func(s spaceStatus) { applyStartupState(z, s, notifier, startupPolicy) },
+		change:  func(s spaceStatus) { applySwitchState(z, s, sourceSwitch, notifier) },
+	}
+	if s, _ := z.current(); s != statusUnknown {
+		// Restarted after a crash: carry on from the zone's state rather
+		// than applying the startup policy again.
+		m.started, m.last = true, s
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-readings:
+			m.handle(r)
+		}
+	}
+}
+
+// readSwitch sends readings of the zone's switch to out until ctx is done,
+// waiting for the next on pin p. periph reports a failed read as low,
+// which would look like an open door with the default wiring, so readings
+// are discarded while a pin does not report itself as an input. A reader
+// blocked on a stuck consumer stops the zone's heartbeat.
+func readSwitch(ctx context.Context, z *zone, read func() (spaceStatus, bool), p gpio.PinIO, edges bool, out chan<- switchReading) {
+	for ctx.Err() == nil {
+		z.beat()
+		if s, ok := read(); ok {
+			select {
+			case out <- switchReading{status: s, at: time.Now()}:
+			case <-ctx.Done():
+				return
+			}
+		} else {
+			metricGPIOReadErrors.inc()
+		}
+		waitForSwitch(p, edges)
+	}
+}
+
+// waitForSwitch returns on the next edge or when the next reading is due.
+// Drivers that return from WaitForEdge early without an edge are waited
+// out, so they cannot make the reader spin.
+func waitForSwitch(p gpio.PinIO, edges bool) {
+	if !edges {
+		time.Sleep(liveSettings().pollingInterval)
+		return
+	}
+	start := time.Now()
+	if !p.WaitForEdge(edgeFallbackInterval) {
+		time.Sleep(edgeFallbackInterval - time.Since(start))
+	}
+}
+
+// pinIsInput reports whether p currently works as an input. Drivers that
+// cannot report the pin function are trusted.
+func pinIsInput(p gpio.PinIO) bool {
+	f, ok := p.(pin.PinFunc)
+	return !ok || f.Func().Generalize() == gpio.IN
+}
+
+// debounceSwitch passes readings from in to out once their status has held
+// for d, so a bouncing contact produces one change rather than several.
+// The switch is read again when d has passed, as an edge back to the old
+// level may not have been reported, e.g. between two polls. The first
+// reading has to hold too, so a switch that is bouncing at startup stays
+// unknown until it settles. It returns once ctx is done.
+func debounceSwitch(ctx context.Context, read func() (spaceStatus, bool), d time.Duration, in <-chan switchReading, out chan<- switchReading) {
+	timer := time.NewTimer(d)
+	timer.Stop()
+	var stable, pending *switchReading
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-in:
+			switch {
+			case stable != nil && r.status == stable.status:
+				if pending != nil {
+					metricSwitchBounces.inc()
+					pending = nil
+					timer.Stop()
+				}
+			case pending == nil:
+				pending = &r
+				timer.Reset(d)
+			}
+		case <-timer.C:
+			if pending == nil {
+				continue
+			}
+			if s, ok := read(); ok && s != pending.status {
+				metricSwitchBounces.inc()
+				pending = nil
+				continue
+			}
+			stable, pending = pending, nil
+			select {
+			case out <- *stable:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// switchMachine turns readings into switch states. The first reading goes
+// to startup, which applies the startup policy, and every later change of
+// status to change.
+type switchMachine struct {
+	startup func(s spaceStatus)
+	change  func(s spaceStatus)
+
+	started bool
+	last    spaceStatus
+}
+
+// handle processes one reading.
+func (m *switchMachine) handle(r switchReading) {
+	switch {
+	case !m.started:
+		m.started, m.last = true, r.status
+		m.startup(r.status)
+	case r.status != m.last:
+		m.last = r.status
+		m.change(r.status)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+)
+
+func TestSwitchMachine(t *testing.T) {
+	tests := []struct {
+		name        string
+		restarted   spaceStatus // the zone's state before a crash, if any
+		readings    []spaceStatus
+		wantStartup []spaceStatus
+		wantChanges []spaceStatus
+	}{
+		{
+			name:        "first reading goes to startup",
+			readings:    []spaceStatus{statusClosed},
+			wantStartup: []spaceStatus{statusClosed},
+		},
+		{
+			name:        "changes after startup",
+			readings:    []spaceStatus{statusClosed, statusOpen, statusMembersOnly, statusClosed},
+			wantStartup: []spaceStatus{statusClosed},
+			wantChanges: []spaceStatus{statusOpen, statusMembersOnly, statusClosed},
+		},
+		{
+			name:        "repeated readings are not changes",
+			readings:    []spaceStatus{statusOpen, statusOpen, statusClosed, statusClosed, statusClosed},
+			wantStartup: []spaceStatus{statusOpen},
+			wantChanges: []spaceStatus{statusClosed},
+		},
+		{
+			name:        "restart carries on from the zone's state",
+			restarted:   statusOpen,
+			readings:    []spaceStatus{statusOpen, statusClosed},
+			wantChanges: []spaceStatus{statusClosed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var startup, changes []spaceStatus
+			m := &switchMachine{
+				startup: func(s spaceStatus) { startup = append(startup, s) },
+				change:  func(s spaceStatus) { changes = append(changes, s) },
+			}
+			if tt.restarted != statusUnknown {
+				m.started, m.last = true, tt.restarted
+			}
+			for _, s := range tt.readings {
+				m.handle(switchReading{status: s, at: time.Now()})
+			}
+			if !reflect.DeepEqual(startup, tt.wantStartup) {
+				t.Errorf("startup got %v, want %v", startup, tt.wantStartup)
+			}
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("changes %v, want %v", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestDebounceSwitch(t *testing.T) {
+	const d = 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		readings []spaceStatus // sent in quick succession once closed is stable
+		level    spaceStatus   // what the pins read afterwards
+		want     []spaceStatus // passed on after closed
+	}{
+		{name: "change that holds", readings: []spaceStatus{statusOpen}, level: statusOpen, want: []spaceStatus{statusOpen}},
+		{name: "repeated readings pass once", readings: []spaceStatus{statusOpen, statusOpen, statusOpen}, level: statusOpen, want: []spaceStatus{statusOpen}},
+		{name: "bounce back is dropped", readings: []spaceStatus{statusOpen, statusClosed}, level: statusClosed},
+		{name: "bounce back then change", readings: []spaceStatus{statusOpen, statusClosed, statusOpen}, level: statusOpen, want: []spaceStatus{statusOpen}},
+		{name: "missed edge back is dropped", readings: []spaceStatus{statusOpen}, level: statusClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var level atomic.Int32
+			level.Store(int32(statusClosed))
+			read := func() (spaceStatus, bool) { return spaceStatus(level.Load()), true }
+			in, out := make(chan switchReading), make(chan switchReading)
+			go debounceSwitch(ctx, read, d, in, out)
+
+			in <- switchReading{status: statusClosed, at: time.Now()}
+			select {
+			case r := <-out:
+				if r.status != statusClosed {
+					t.Fatalf("first reading passed as %v, want closed", r.status)
+				}
+			case <-time.After(10 * d):
+				t.Fatal("first reading did not pass")
+			}
+
+			level.Store(int32(tt.level))
+			for _, s := range tt.readings {
+				in <- switchReading{status: s, at: time.Now()}
+			}
+			var got []spaceStatus
+			for {
+				select {
+				case r := <-out:
+					got = append(got, r.status)
+					continue
+				case <-time.After(5 * d):
+				}
+				break
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("passed on %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZonePinsRead(t *testing.T) {
+	pin := func(l gpio.Level) *gpiotest.Pin {
+		return &gpiotest.Pin{N: "GPIO", Fn: string(gpio.IN), L: l}
+	}
+	tests := []struct {
+		name      string
+		pins      zonePins
+		openLevel gpio.Level
+		want      spaceStatus
+		wantOK    bool
+	}{
+		{name: "open at low", pins: zonePins{main: pin(gpio.Low)}, openLevel: gpio.Low, want: statusOpen, wantOK: true},
+		{name: "closed at high", pins: zonePins{main: pin(gpio.High)}, openLevel: gpio.Low, want: statusClosed, wantOK: true},
+		{name: "open at high", pins: zonePins{main: pin(gpio.High)}, openLevel: gpio.High, want: statusOpen, wantOK: true},
+		{name: "members only", pins: zonePins{main: pin(gpio.High), members: pin(gpio.Low)}, openLevel: gpio.Low, want: statusMembersOnly, wantOK: true},
+		{name: "members only wins", pins: zonePins{main: pin(gpio.Low), members: pin(gpio.Low)}, openLevel: gpio.Low, want: statusMembersOnly, wantOK: true},
+		{name: "centre position", pins: zonePins{main: pin(gpio.High), members: pin(gpio.High)}, openLevel: gpio.Low, want: statusClosed, wantOK: true},
+		{name: "pin not an input", pins: zonePins{main: &gpiotest.Pin{N: "GPIO", Fn: string(gpio.OUT), L: gpio.Low}}, openLevel: gpio.Low, want: statusUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.pins.read(tt.openLevel)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("read() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSpaceStatusOf(t *testing.T) {
+	tests := []struct {
+		openWhen string
+		zones    []spaceStatus
+		want     spaceStatus
+	}{
+		{spaceOpenWhenAny, []spaceStatus{statusClosed}, statusClosed},
+		{spaceOpenWhenAny, []spaceStatus{statusClosed, statusOpen}, statusOpen},
+		{spaceOpenWhenAny, []spaceStatus{statusClosed, statusMembersOnly}, statusMembersOnly},
+		{spaceOpenWhenAny, []spaceStatus{statusMembersOnly, statusOpen}, statusOpen},
+		{spaceOpenWhenAny, []spaceStatus{statusOpen, statusUnknown}, statusUnknown},
+		{spaceOpenWhenAll, []spaceStatus{statusClosed, statusOpen}, statusClosed},
+		{spaceOpenWhenAll, []spaceStatus{statusOpen, statusOpen}, statusOpen},
+		{spaceOpenWhenAll, []spaceStatus{statusOpen, statusMembersOnly}, statusMembersOnly},
+		{spaceOpenWhenAll, []spaceStatus{statusUnknown, statusOpen}, statusUnknown},
+	}
+	saved := spaceOpenWhen
+	t.Cleanup(func() { spaceOpenWhen = saved })
+	for _, tt := range tests {
+		spaceOpenWhen = tt.openWhen
+		var states []zoneStatus
+		for _, s := range tt.zones {
+			states = append(states, zoneStatus{Status: s})
+		}
+		if got := spaceStatusOf(states); got != tt.want {
+			t.Errorf("open when %s, zones %v: got %v, want %v", tt.openWhen, tt.zones, got, tt.want)
+		}
+	}
+}
+
+// TestMonitorSwitchTransitions drives the monitor through edges of a fake
+// pin and checks what it announces.
+func TestMonitorSwitchTransitions(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	savedDebounce := switchDebounce
+	switchDebounce = 0
+	t.Cleanup(func() { switchDebounce = savedDebounce })
+	z := &zone{name: defaultZone, label: zoneLabel(defaultZone), pull: gpio.PullUp, openLevel: gpio.Low}
+	withZones(t, z)
+	// Nothing is known from before, so the first reading is a change.
+	lastKnown.mu.Lock()
+	savedKnown := lastKnown.saved
+	lastKnown.saved = savedState{Zones: make(map[string]knownState)}
+	lastKnown.mu.Unlock()
+	events.mu.Lock()
+	savedEvents := events.records
+	events.records = nil
+	events.mu.Unlock()
+	t.Cleanup(func() {
+		lastKnown.mu.Lock()
+		lastKnown.saved = savedKnown
+		lastKnown.mu.Unlock()
+		events.mu.Lock()
+		events.records = savedEvents
+		events.mu.Unlock()
+	})
+
+	n := &fakeNotifier{name: "fake"}
+	notifiers := newNotifierRegistry()
+	notifiers.Register(n)
+	pin := &gpiotest.Pin{N: "GPIO", Fn: string(gpio.IN), L: gpio.High, EdgesChan: make(chan gpio.Level)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitorSwitch(ctx, z, &zonePins{main: pin, edges: true}, notifiers, startupAnnounceChanged)
+
+	// waitFor waits until the notifier has been sent want.
+	waitFor := func(want ...spaceStatus) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			n.mu.Lock()
+			var got []spaceStatus
+			for _, e := range n.events {
+				got = append(got, e.Status)
+			}
+			n.mu.Unlock()
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("announced %v, want %v", got, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(statusClosed)
+	pin.EdgesChan <- gpio.Low
+	waitFor(statusClosed, statusOpen)
+	pin.EdgesChan <- gpio.Low // an edge without a change
+	pin.EdgesChan <- gpio.High
+	waitFor(statusClosed, statusOpen, statusClosed)
+	if s, _ := z.current(); s != statusClosed {
+		t.Errorf("zone is %v, want closed", s)
+	}
+	if got := currentSpace().Status; got != statusClosed {
+		t.Errorf("space is %v, want closed", got)
+	}
+}
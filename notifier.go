@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"text/template"
+)
+
+// Notifier delivers state change announcements to one backend.
+type Notifier interface {
+	// Name identifies the notifier in logs and previews.
+	Name() string
+	// Notify announces the event.
+	Notify(ctx context.Context, e Event) error
+}
+
+// Previewer is implemented by notifiers that can report what they would
+// send for an event without sending it.
+type Previewer interface {
+	Preview(e Event, overrides templateSet) ([]plannedMessage, error)
+}
+
+// templateSet maps template names to parsed templates, overriding the
+// configured ones when rendering previews.
+type templateSet map[string]*template.Template
+
+// plannedMessage is a message a notifier would send for an event.
+type plannedMessage struct {
+	Notifier    string `json:"notifier"`
+	Destination string `json:"destination"`
+	Text        string `json:"text"`
+}
+
+// notifierRegistry fans events out to every registered notifier. It is
+// itself a Notifier.
+type notifierRegistry struct {
+	mu        sync.RWMutex
+	notifiers []Notifier
+}
+
+// newNotifierRegistry returns an empty registry.
+func newNotifierRegistry() *notifierRegistry {
+	return &notifierRegistry{}
+}
+
+// Register adds a notifier to the fan-out.
+func (r *notifierRegistry) Register(n Notifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifiers = append(r.notifiers, n)
+}
+
+// replace swaps in a new set of notifiers, e.g. after a configuration
+// reload.
+func (r *notifierRegistry) replace(notifiers []Notifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifiers = notifiers
+}
+
+// Notifiers returns a snapshot of the registered notifiers.
+func (r *notifierRegistry) Notifiers() []Notifier {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Notifier(nil), r.notifiers...)
+}
+
+// Name implements Notifier.
+func (r *notifierRegistry) Name() string {
+	return "registry"
+}
+
+// Notify sends the event to the notifiers announcing its zone concurrently
+// and returns the combined errors of those that failed. Nothing is sent
+// during maintenance or while notifications are paused. Canary events only
+// go to notifiers that drop them before sending, and are held back by
+// neither.
+func (r *notifierRegistry) Notify(ctx context.Context, e Event) error {
+	canaryEvent := isCanary(e.ID)
+	if m, ok := spaceMaintenance.active(); ok && !canaryEvent {
+		slog.Info("Maintenance in progress; not announcing event", "component", "notify", "started_by", m.By, "state", e.State(), "zone", e.Zone, "event", e.ID)
+		return nil
+	}
+	if pause, ok := notificationsPause.active(); ok && !canaryEvent {
+		slog.Info("Notifications paused; not announcing event", "component", "notify", "paused_by", pause.By, "state", e.State(), "zone", e.Zone, "event", e.ID)
+		return nil
+	}
+	notifiers := r.Notifiers()
+	if canaryEvent {
+		notifiers = canaryTargets(notifiers)
+	} else {
+		notifiers = zoneNotifiers(e.Zone, notifiers)
+	}
+	errs := make([]error, len(notifiers))
+
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			if err := n.Notify(ctx, e); err != nil {
+				slog.Error("Notifier failed", "component", "notify", "notifier", n.Name(), "err", err)
+				errs[i] = fmt.Errorf("%s: %w", n.Name(), err)
+			}
+		}(i, n)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Preview collects the messages every previewable notifier would send.
+func (r *notifierRegistry) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	var plan []plannedMessage
+	for _, n := range zoneNotifiers(e.Zone, r.Notifiers()) {
+		p, ok := n.(Previewer)
+		if !ok {
+			continue
+		}
+		messages, err := p.Preview(e, overrides)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.Name(), err)
+		}
+		plan = append(plan, messages...)
+	}
+	return plan, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+// fakeNotifier records the events it is sent. Each call fails with the
+// next of errs, if any are left, and succeeds otherwise.
+type fakeNotifier struct {
+	name string
+
+	mu     sync.Mutex
+	errs   []error
+	calls  int
+	events []Event
+}
+
+func (n *fakeNotifier) Name() string { return n.name }
+
+func (n *fakeNotifier) Notify(ctx context.Context, e Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls++
+	if len(n.errs) > 0 {
+		err := n.errs[0]
+		n.errs = n.errs[1:]
+		return err
+	}
+	n.events = append(n.events, e)
+	return nil
+}
+
+// received returns the IDs of the events delivered successfully.
+func (n *fakeNotifier) received() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var ids []string
+	for _, e := range n.events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// withZones replaces the configured zones for the rest of the test.
+func withZones(t *testing.T, zs ...*zone) {
+	saved, savedConfigured := zones, zonesConfigured
+	zones, zonesConfigured = zs, len(zs) > 1 || zs[0].name != defaultZone
+	t.Cleanup(func() { zones, zonesConfigured = saved, savedConfigured })
+}
+
+func TestNotifierRegistryFanOut(t *testing.T) {
+	tests := []struct {
+		name    string
+		zones   []*zone
+		zone    string
+		errs    map[string][]error // by notifier
+		paused  bool
+		want    map[string]bool // notifiers that receive the event
+		wantErr []string        // notifiers named in the error
+	}{
+		{
+			name: "every notifier",
+			want: map[string]bool{"slack": true, "discord": true, "mqtt": true},
+		},
+		{
+			name:    "failures are combined",
+			errs:    map[string][]error{"slack": {errBoom}, "mqtt": {errBoom}},
+			want:    map[string]bool{"discord": true},
+			wantErr: []string{"slack", "mqtt"},
+		},
+		{
+			name:  "zone with its own notifiers",
+			zones: []*zone{{name: "woodshop", notify: map[string]bool{"discord": true}}, {name: "lounge"}},
+			zone:  "woodshop",
+			want:  map[string]bool{"discord": true},
+		},
+		{
+			name:  "zone without a notify list",
+			zones: []*zone{{name: "woodshop", notify: map[string]bool{"discord": true}}, {name: "lounge"}},
+			zone:  "lounge",
+			want:  map[string]bool{"slack": true, "discord": true, "mqtt": true},
+		},
+		{
+			name:   "paused",
+			paused: true,
+			want:   map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.zones != nil {
+				withZones(t, tt.zones...)
+			}
+			if tt.paused {
+				notificationsPause.mu.Lock()
+				notificationsPause.current = &notificationPause{Until: time.Now().Add(time.Hour), By: "test"}
+				notificationsPause.mu.Unlock()
+				t.Cleanup(func() {
+					notificationsPause.mu.Lock()
+					notificationsPause.current = nil
+					notificationsPause.mu.Unlock()
+				})
+			}
+			r := newNotifierRegistry()
+			fakes := make(map[string]*fakeNotifier)
+			for _, name := range []string{"slack", "discord", "mqtt"} {
+				fakes[name] = &fakeNotifier{name: name, errs: tt.errs[name]}
+				r.Register(fakes[name])
+			}
+
+			e := Event{ID: newULID(time.Now()), Zone: tt.zone, Status: statusOpen, Open: true}
+			err := r.Notify(context.Background(), e)
+
+			for name, n := range fakes {
+				got := len(n.received()) == 1
+				if got != tt.want[name] {
+					t.Errorf("%s received the event: %v, want %v", name, got, tt.want[name])
+				}
+			}
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Notify() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, errBoom) {
+				t.Fatalf("Notify() = %v, want it to wrap %v", err, errBoom)
+			}
+			for _, name := range tt.wantErr {
+				if !strings.Contains(err.Error(), name+": ") {
+					t.Errorf("Notify() = %q, want it to name %s", err, name)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverWithRetry(t *testing.T) {
+	savedBase, savedMax := notifyBaseBackoff, notifyMaxBackoff
+	notifyBaseBackoff, notifyMaxBackoff = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { notifyBaseBackoff, notifyMaxBackoff = savedBase, savedMax })
+
+	transient := func(n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = errBoom
+		}
+		return errs
+	}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+		permanent bool
+	}{
+		{name: "first attempt", wantCalls: 1},
+		{name: "transient errors are retried", errs: transient(2), wantCalls: 3},
+		{name: "success on the last attempt", errs: transient(notifyMaxAttempts - 1), wantCalls: notifyMaxAttempts},
+		{name: "gives up after the last attempt", errs: transient(notifyMaxAttempts), wantCalls: notifyMaxAttempts, wantErr: true},
+		{name: "permanent errors are not retried", errs: []error{permanent(errBoom)}, wantCalls: 1, wantErr: true, permanent: true},
+		{name: "permanent after transient", errs: []error{errBoom, permanent(errBoom)}, wantCalls: 2, wantErr: true, permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &fakeNotifier{name: "fake", errs: tt.errs}
+			e := Event{ID: newULID(time.Now())}
+			err := deliverWithRetry(context.Background(), n.Name(), func(ctx context.Context) error {
+				return n.Notify(ctx, e)
+			})
+			if n.calls != tt.wantCalls {
+				t.Errorf("%d attempts, want %d", n.calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deliverWithRetry() = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, errBoom) {
+				t.Errorf("deliverWithRetry() = %v, want it to wrap %v", err, errBoom)
+			}
+			if want := fmt.Sprintf("attempt %d of %d: ", tt.wantCalls, notifyMaxAttempts); !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("deliverWithRetry() = %q, want it to start with %q", err, want)
+			}
+			var perm *permanentError
+			if got := errors.As(err, &perm); got != tt.permanent {
+				t.Errorf("deliverWithRetry() = %v, permanent: %v, want %v", err, got, tt.permanent)
+			}
+		})
+	}
+}
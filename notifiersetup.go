@@ -0,0 +1,362 @@
+package main
+
+import "fmt"
+
+// notifierSlot builds one kind of notifier from its settings. A reload
+// rebuilds a slot only when a setting it read last time has changed, so
+// the others keep their in-memory state, such as daily caps and open
+// connections.
+type notifierSlot struct {
+	name string
+	// queued wraps each notifier the slot builds in a delivery queue.
+	queued bool
+	// connects marks slots that hold connections open or serve a protocol;
+	// changes to them take effect after a restart.
+	connects bool
+	build    func(v *settingsView) ([]Notifier, error)
+
+	settings  map[string]settingSnapshot // read by the last build
+	notifiers []Notifier                 // as registered
+}
+
+// reconfigurable is implemented by notifiers that can take over the
+// settings of a freshly built notifier, keeping their queue and history.
+// It reports false when fresh is a different kind of notifier.
+type reconfigurable interface {
+	reconfigure(fresh Notifier) bool
+}
+
+// notifierSlots are the configurable notifiers, in registration order.
+var notifierSlots = []*notifierSlot{
+	{name: "slack", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		values, err := v.requiredSettings("SLACK_TOKEN", "SLACK_CHANNEL")
+		if err != nil {
+			return nil, err
+		}
+		token, channel := values[0], values[1]
+		actions, err := parseActionLinks(v.setting("SLACK_ACTIONS"), v.setting("BASE_URL"))
+		if err != nil {
+			return nil, fmt.Errorf("SLACK_ACTIONS: %w", err)
+		}
+		return []Notifier{newSlackNotifier(token, channel, actions), newSlackDMNotifier(token, actions)}, nil
+	}},
+	{name: "discord", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		if url := v.setting("DISCORD_WEBHOOK_URL"); url != "" {
+			return []Notifier{newDiscordNotifier(url)}, nil
+		}
+		return nil, nil
+	}},
+	{name: "teams", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		if url := v.setting("TEAMS_WEBHOOK_URL"); url != "" {
+			return []Notifier{newTeamsNotifier(url)}, nil
+		}
+		return nil, nil
+	}},
+	{name: "google-chat", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		if url := v.setting("GOOGLE_CHAT_WEBHOOK_URL"); url != "" {
+			return []Notifier{newGoogleChatNotifier(url)}, nil
+		}
+		return nil, nil
+	}},
+	{name: "gotify", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		url := v.setting("GOTIFY_URL")
+		if url == "" {
+			return nil, nil
+		}
+		priority, err := v.intSetting("GOTIFY_PRIORITY", gotifyDefaultPriority)
+		if err != nil {
+			return nil, err
+		}
+		token, err := v.requiredSetting("GOTIFY_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		return []Notifier{newGotifyNotifier(url, token, priority)}, nil
+	}},
+	{name: "push", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		targets, err := parsePushTargets(v.setting("PUSH_TARGETS"))
+		if err != nil {
+			return nil, fmt.Errorf("PUSH_TARGETS: %w", err)
+		}
+		var notifiers []Notifier
+		for _, target := range targets {
+			notifiers = append(notifiers, target)
+		}
+		return notifiers, nil
+	}},
+	{name: "matrix", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		if url := v.setting("MATRIX_WEBHOOK_URL"); url != "" {
+			return []Notifier{newMatrixWebhookNotifier(url)}, nil
+		}
+		return nil, nil
+	}},
+	{name: "signal", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		group := v.setting("SIGNAL_GROUP_ID")
+		if group == "" {
+			return nil, nil
+		}
+		socket := v.setting("SIGNAL_SOCKET")
+		if socket == "" {
+			socket = "/run/signal-cli/socket"
+		}
+		return []Notifier{newSignalNotifier(socket, v.setting("SIGNAL_ACCOUNT"), group)}, nil
+	}},
+	{name: "mastodon", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		url := v.setting("MASTODON_URL")
+		if url == "" {
+			return nil, nil
+		}
+		visibility := v.setting("MASTODON_VISIBILITY")
+		if visibility == "" {
+			visibility = "public"
+		}
+		token, err := v.requiredSetting("MASTODON_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		dailyCap, err := v.intSetting("MASTODON_DAILY_CAP", mastodonDefaultDailyCap)
+		if err != nil {
+			return nil, err
+		}
+		overrides, err := loadTemplateOverrides(v, "MASTODON")
+		if err != nil {
+			return nil, err
+		}
+		return []Notifier{newMastodonNotifier(url, token, visibility, dailyCap, overrides)}, nil
+	}},
+	{name: "bluesky", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		handle := v.setting("BLUESKY_HANDLE")
+		if handle == "" {
+			return nil, nil
+		}
+		pds := v.setting("BLUESKY_PDS")
+		if pds == "" {
+			pds = blueskyDefaultPDS
+		}
+		password, err := v.requiredSetting("BLUESKY_APP_PASSWORD")
+		if err != nil {
+			return nil, err
+		}
+		overrides, err := loadTemplateOverrides(v, "BLUESKY")
+		if err != nil {
+			return nil, err
+		}
+		return []Notifier{newBlueskyNotifier(pds, handle, password, overrides)}, nil
+	}},
+	{name: "irc", queued: true, connects: true, build: func(v *settingsView) ([]Notifier, error) {
+		server := v.setting("IRC_SERVER")
+		if server == "" {
+			return nil, nil
+		}
+		values, err := v.requiredSettings("IRC_CHANNEL", "IRC_NICK")
+		if err != nil {
+			return nil, err
+		}
+		irc := newIRCNotifier(server, v.setting("IRC_TLS") != "false", values[0], values[1],
+			v.setting("IRC_SASL_USER"), v.setting("IRC_SASL_PASSWORD"))
+		if !shadowMode {
+			go irc.run()
+		}
+		return []Notifier{irc}, nil
+	}},
+	{name: "xmpp", queued: true, connects: true, build: func(v *settingsView) ([]Notifier, error) {
+		jid := v.setting("XMPP_JID")
+		if jid == "" {
+			return nil, nil
+		}
+		values, err := v.requiredSettings("XMPP_PASSWORD", "XMPP_ROOM")
+		if err != nil {
+			return nil, err
+		}
+		xmpp := newXMPPNotifier(jid, values[0], values[1], v.setting("XMPP_NICK"),
+			v.setting("XMPP_SERVER"), v.setting("XMPP_TLS") != "false")
+		if !shadowMode {
+			go xmpp.run()
+		}
+		return []Notifier{xmpp}, nil
+	}},
+	{name: "mqtt", queued: true, connects: true, build: func(v *settingsView) ([]Notifier, error) {
+		broker := v.setting("MQTT_BROKER")
+		if broker == "" {
+			return nil, nil
+		}
+		qos, err := v.intSetting("MQTT_QOS", 1)
+		if err != nil {
+			return nil, err
+		}
+		mqtt, err := newMQTTNotifier(broker, v.setting("MQTT_TOPIC_PREFIX"), qos)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_BROKER: %w", err)
+		}
+		if v.setting("MQTT_HA_DISCOVERY") == "true" {
+			prefix := v.setting("MQTT_HA_PREFIX")
+			if prefix == "" {
+				prefix = "homeassistant"
+			}
+			if err := mqtt.enableHADiscovery(prefix); err != nil {
+				return nil, fmt.Errorf("Home Assistant discovery: %w", err)
+			}
+		}
+		if !shadowMode {
+			go mqtt.run()
+		}
+		return []Notifier{mqtt}, nil
+	}},
+	{name: "homekit", connects: true, build: func(v *settingsView) ([]Notifier, error) {
+		pin := v.setting("HOMEKIT_PIN")
+		if pin == "" || shadowMode {
+			return nil, nil
+		}
+		name := v.setting("HOMEKIT_NAME")
+		if name == "" {
+			name = "Space"
+		}
+		addr := v.setting("HOMEKIT_ADDR")
+		if addr == "" {
+			addr = ":51826"
+		}
+		homekit, err := startHomeKit(name, pin, addr)
+		if err != nil {
+			return nil, fmt.Errorf("HomeKit: %w", err)
+		}
+		return []Notifier{homekit}, nil
+	}},
+	{name: "email", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		host := v.setting("SMTP_HOST")
+		if host == "" {
+			return nil, nil
+		}
+		email, err := setupEmailNotifier(v, host)
+		if err != nil {
+			return nil, err
+		}
+		return []Notifier{email}, nil
+	}},
+	{name: "webhooks", build: func(v *settingsView) ([]Notifier, error) {
+		hooks, err := parseWebhookSubscribers(v.setting("OUTGOING_WEBHOOKS"), v.setting("OUTGOING_WEBHOOK_SECRET"))
+		if err != nil {
+			return nil, fmt.Errorf("OUTGOING_WEBHOOKS: %w", err)
+		}
+		var notifiers []Notifier
+		for _, hook := range hooks {
+			notifiers = append(notifiers, hook)
+		}
+		return notifiers, nil
+	}},
+	{name: "sms", queued: true, build: func(v *settingsView) ([]Notifier, error) {
+		sid := v.setting("TWILIO_ACCOUNT_SID")
+		if sid == "" {
+			return nil, nil
+		}
+		values, err := v.requiredSettings("TWILIO_AUTH_TOKEN", "TWILIO_FROM", "SMS_TO")
+		if err != nil {
+			return nil, err
+		}
+		minInterval, err := v.durationSetting("SMS_MIN_INTERVAL", smsDefaultMinInterval)
+		if err != nil {
+			return nil, err
+		}
+		budget, err := v.intSetting("SMS_MONTHLY_BUDGET", smsDefaultMonthlyBudget)
+		if err != nil {
+			return nil, err
+		}
+		sms, err := newSMSNotifier(sid, values[0], values[1], splitCommaList(values[2]), minInterval, budget)
+		if err != nil {
+			return nil, fmt.Errorf("SMS: %w", err)
+		}
+		return []Notifier{sms}, nil
+	}},
+	{name: "telegram", queued: true, connects: true, build: func(v *settingsView) ([]Notifier, error) {
+		token := v.setting("TELEGRAM_BOT_TOKEN")
+		if token == "" {
+			return nil, nil
+		}
+		chat, err := v.requiredSetting("TELEGRAM_CHAT_ID")
+		if err != nil {
+			return nil, err
+		}
+		telegram := newTelegramNotifier(token, chat)
+		if !shadowMode {
+			go telegram.pollCommands()
+		}
+		return []Notifier{telegram}, nil
+	}},
+}
+
+// configureNotifiers builds the notifier slots from v and registers them
+// with r.
+func configureNotifiers(v *settingsView, r *notifierRegistry) error {
+	install, _, err := prepareNotifiers(v, r, false)
+	if err != nil {
+		return err
+	}
+	install()
+	return nil
+}
+
+// prepareNotifiers builds the notifier slots from v and returns a function
+// installing them and registering them with r. On a reload only slots
+// whose settings changed are rebuilt, and changed slots that connect are
+// left alone until a restart; it returns the names of those rebuilt. All
+// slots are built before any is installed, so a failing build, which
+// aborts the reload, leaves the running notifiers untouched.
+func prepareNotifiers(v *settingsView, r *notifierRegistry, reload bool) (install func(), rebuilt []string, err error) {
+	type build struct {
+		slot     *notifierSlot
+		fresh    []Notifier
+		settings map[string]settingSnapshot
+	}
+	var builds []build
+	for _, s := range notifierSlots {
+		if reload && (s.connects || !v.changed(s.settings)) {
+			continue
+		}
+		b := build{slot: s}
+		b.settings = v.capture(func(v *settingsView) { b.fresh, err = s.build(v) })
+		if err != nil {
+			return nil, nil, fmt.Errorf("notifier %s: %w", s.name, err)
+		}
+		builds = append(builds, b)
+		if reload {
+			rebuilt = append(rebuilt, s.name)
+		}
+	}
+
+	return func() {
+		for _, b := range builds {
+			b.slot.install(b.fresh)
+			b.slot.settings = b.settings
+		}
+		var all []Notifier
+		for _, s := range notifierSlots {
+			all = append(all, s.notifiers...)
+		}
+		r.replace(all)
+	}, rebuilt, nil
+}
+
+// install replaces the slot's notifiers with fresh ones. Existing queues
+// take the fresh notifiers over in order, so pending deliveries and the
+// write-ahead log stay intact; queues left over are dropped from the
+// registry and finish what they hold.
+func (s *notifierSlot) install(fresh []Notifier) {
+	installed := make([]Notifier, len(fresh))
+	for i, n := range fresh {
+		if i < len(s.notifiers) {
+			if r, ok := s.notifiers[i].(reconfigurable); ok && r.reconfigure(n) {
+				installed[i] = s.notifiers[i]
+				continue
+			}
+		}
+		switch {
+		case s.queued:
+			n = newQueuedNotifier(n)
+		default:
+			if w, ok := n.(*webhookSubscriber); ok {
+				w.start()
+			}
+		}
+		installed[i] = n
+	}
+	s.notifiers = installed
+}
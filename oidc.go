@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC settings.
+const (
+	oidcClockSkew     = time.Minute
+	oidcRefetchJWKS   = time.Minute // minimum time between key refreshes for unknown key IDs
+	oidcFetchDeadline = 10 * time.Second
+)
+
+// oidcProvider accepts JWTs, typically access tokens, signed by an OpenID
+// Connect issuer such as Keycloak or Authentik. Scopes come from mapping
+// the groups in a claim of the token.
+type oidcProvider struct {
+	issuer   string
+	audience string
+	claim    string
+	groups   map[string]map[string]bool // group -> scopes
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	jwksURL string
+	fetched time.Time
+}
+
+// newOIDCProvider returns a provider for issuer. Discovery and key
+// fetching happen on first use, so the identity provider being down at
+// boot does not stop the service.
+func newOIDCProvider(issuer, audience, claim string, groups map[string]map[string]bool) *oidcProvider {
+	return &oidcProvider{issuer: strings.TrimSuffix(issuer, "/"), audience: audience, claim: claim, groups: groups}
+}
+
+// Name implements authProvider.
+func (p *oidcProvider) Name() string { return "oidc" }
+
+// Authenticate implements authProvider.
+func (p *oidcProvider) Authenticate(ctx context.Context, token string) (principal, bool) {
+	if strings.Count(token, ".") != 2 {
+		return principal{}, false
+	}
+	claims, err := p.verify(ctx, token)
+	if err != nil {
+		slog.Warn("Rejecting OIDC token", "component", "auth", "err", err)
+		return principal{}, false
+	}
+
+	scopes := make(map[string]bool)
+	for _, group := range claimStrings(claims[p.claim]) {
+		for scope := range p.groups[group] {
+			scopes[scope] = true
+		}
+	}
+	// Users can often change preferred_username and it need not be unique,
+	// so the identity is the subject at the issuer; the username is only
+	// shown.
+	sub, _ := claims["sub"].(string)
+	id := "oidc:" + p.issuer + "|" + sub
+	display, _ := claims["preferred_username"].(string)
+	return principal{Name: id, Display: display, Scopes: scopes, Member: id}, true
+}
+
+// verify checks the token's signature, issuer, subject, audience, and
+// validity period and returns its claims.
+func (p *oidcProvider) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("issuer %q is not %q", iss, p.issuer)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("no sub claim")
+	}
+	if !contains(claimStrings(claims["aud"]), p.audience) {
+		return nil, fmt.Errorf("audience %v does not include %q", claims["aud"], p.audience)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	return claims, nil
+}
+
+// key returns the issuer's public key with the given ID, fetching the key
+// set when the ID is unknown, at most once per oidcRefetchJWKS.
+func (p *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.fetched) < oidcRefetchJWKS {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	p.fetched = time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, oidcFetchDeadline)
+	defer cancel()
+	if p.jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := fetchJSON(ctx, p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("discovery: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("discovery document has no jwks_uri")
+		}
+		p.jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := fetchJSON(ctx, p.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("Skipping OIDC key", "component", "auth", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	p.keys = keys
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// jwk is a JSON Web Key; only RSA and EC signing keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWTSignature checks a JWS signature. Only asymmetric algorithms
+// are accepted, so a token cannot be signed with the public key as an
+// HMAC secret.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256", "PS256":
+		hash = crypto.SHA256
+	case "RS384", "ES384", "PS384":
+		hash = crypto.SHA384
+	case "RS512", "PS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+			return errors.New("invalid signature")
+		}
+	}
+	return fmt.Errorf("algorithm %q does not match the key", alg)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings returns a claim that is a string or an array of strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func fetchJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
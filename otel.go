@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTLP export settings. The intervals can be overridden with the standard
+// OTEL_BSP_SCHEDULE_DELAY and OTEL_METRIC_EXPORT_INTERVAL variables.
+const (
+	otlpDefaultSpanDelay      = 5 * time.Second
+	otlpDefaultMetricInterval = time.Minute
+	otlpSpanQueueSize         = 2048
+	otlpSpanBatchSize         = 512
+	otlpExportTimeout         = 10 * time.Second
+	otlpScope                 = "splatspace/space-status"
+)
+
+// Span kinds.
+const (
+	spanKindInternal = trace.SpanKindInternal
+	spanKindServer   = trace.SpanKindServer
+	spanKindClient   = trace.SpanKindClient
+)
+
+// tracer creates spans exported to an OTLP collector; it is nil unless
+// configured by configureOTLP, in which case startSpan returns nil spans.
+var tracer trace.Tracer
+
+// otlpProviders are flushed and stopped by shutdownOTLP.
+var otlpProviders []interface{ Shutdown(context.Context) error }
+
+// traceContext reads and writes W3C traceparent headers.
+var traceContext = propagation.TraceContext{}
+
+// configureOTLP sets up export with the OpenTelemetry SDK from the standard
+// OTEL_* settings. Export is off unless an OTLP endpoint is set.
+func configureOTLP() error {
+	if setting("OTEL_SDK_DISABLED") == "true" {
+		return nil
+	}
+	if protocol := setting("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/protobuf" {
+		return fmt.Errorf("OTEL_EXPORTER_OTLP_PROTOCOL %q is not supported; use http/protobuf", protocol)
+	}
+	base := strings.TrimSuffix(setting("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+	tracesURL := otlpSignalURL(base, "TRACES", "/v1/traces")
+	metricsURL := otlpSignalURL(base, "METRICS", "/v1/metrics")
+	if tracesURL == "" && metricsURL == "" {
+		return nil
+	}
+
+	headers, err := parseOTLPList(setting("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	list, err := parseOTLPList(setting("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	var attrs []attribute.KeyValue
+	for k, v := range list {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	name := "space-status"
+	if n := setting("OTEL_SERVICE_NAME"); n != "" {
+		name = n
+	}
+	attrs = append(attrs, attribute.String("service.name", name))
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("OTLP export failed", "component", "otel", "err", err)
+	}))
+	ctx := context.Background()
+	if tracesURL != "" {
+		exporter, err := otlptracehttp.New(ctx,
+			otlptracehttp.WithEndpointURL(tracesURL),
+			otlptracehttp.WithHeaders(headers),
+			otlptracehttp.WithTimeout(otlpExportTimeout))
+		if err != nil {
+			return fmt.Errorf("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT: %w", err)
+		}
+		provider := sdktrace.NewTracerProvider(
+			sdktrace.WithResource(res),
+			sdktrace.WithBatcher(exporter,
+				sdktrace.WithBatchTimeout(otelMilliseconds("OTEL_BSP_SCHEDULE_DELAY", otlpDefaultSpanDelay)),
+				sdktrace.WithMaxQueueSize(otlpSpanQueueSize),
+				sdktrace.WithMaxExportBatchSize(otlpSpanBatchSize)))
+		otlpProviders = append(otlpProviders, provider)
+		tracer = provider.Tracer(otlpScope)
+	}
+	if metricsURL != "" {
+		exporter, err := otlpmetrichttp.New(ctx,
+			otlpmetrichttp.WithEndpointURL(metricsURL),
+			otlpmetrichttp.WithHeaders(headers),
+			otlpmetrichttp.WithTimeout(otlpExportTimeout))
+		if err != nil {
+			return fmt.Errorf("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT: %w", err)
+		}
+		reader := sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithInterval(otelMilliseconds("OTEL_METRIC_EXPORT_INTERVAL", otlpDefaultMetricInterval)),
+			sdkmetric.WithProducer(metricsProducer{start: time.Now()}))
+		otlpProviders = append(otlpProviders, sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(reader)))
+	}
+	slog.Info("Exporting OpenTelemetry data", "component", "otel", "traces", tracesURL, "metrics", metricsURL)
+	return nil
+}
+
+// shutdownOTLP exports what is still buffered and stops the exporters.
+func shutdownOTLP(ctx context.Context) {
+	for _, p := range otlpProviders {
+		if err := p.Shutdown(ctx); err != nil {
+			slog.Warn("Failed to flush OpenTelemetry data", "component", "otel", "err", err)
+		}
+	}
+}
+
+// otlpSignalURL returns the endpoint for one signal: the signal-specific
+// variable is used as is, the general one gets path appended. It returns
+// "" if the signal's exporter is "none" or no endpoint is set.
+func otlpSignalURL(base, signal, path string) string {
+	if setting("OTEL_"+signal+"_EXPORTER") == "none" {
+		return ""
+	}
+	if u := setting("OTEL_EXPORTER_OTLP_" + signal + "_ENDPOINT"); u != "" {
+		return u
+	}
+	if base == "" {
+		return ""
+	}
+	return base + path
+}
+
+// parseOTLPList parses the W3C baggage-style "key=value,key2=value2" lists
+// used by OTEL_EXPORTER_OTLP_HEADERS and OTEL_RESOURCE_ATTRIBUTES.
+func parseOTLPList(value string) (map[string]string, error) {
+	list := make(map[string]string)
+	for _, pair := range splitCommaList(value) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", pair, err)
+		}
+		list[strings.TrimSpace(k)] = decoded
+	}
+	return list, nil
+}
+
+// otelMilliseconds reads an OTEL_* interval given in milliseconds.
+func otelMilliseconds(key string, fallback time.Duration) time.Duration {
+	ms := getEnvInt(key, int(fallback/time.Millisecond))
+	if ms <= 0 {
+		return fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// span is one traced operation. A nil span is valid and records nothing.
+type span struct {
+	trace.Span
+}
+
+// startSpan starts a span as a child of the span in ctx, or of a remote
+// parent stored there by withRemoteParent.
+func startSpan(ctx context.Context, name string, kind trace.SpanKind) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx, s := tracer.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &span{s}
+}
+
+// rename replaces the span's name, e.g. once the route of a request is
+// known.
+func (s *span) rename(name string) {
+	if s != nil {
+		s.SetName(name)
+	}
+}
+
+// set records an attribute.
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case bool:
+		s.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.SetAttributes(attribute.Int64(key, v))
+	case uint64:
+		s.SetAttributes(attribute.Int64(key, int64(v)))
+	case float64:
+		s.SetAttributes(attribute.Float64(key, v))
+	default:
+		s.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// finish ends the span, marking it failed if err is non-nil. The SDK
+// queues it for export, dropping spans once the queue is full.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.RecordError(err)
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
+
+// withRemoteParent returns ctx carrying the parent from a W3C traceparent
+// header, if valid.
+func withRemoteParent(ctx context.Context, header http.Header) context.Context {
+	if tracer == nil {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// injectTraceparent adds a W3C traceparent header for the span in ctx.
+func injectTraceparent(ctx context.Context, header http.Header) {
+	traceContext.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// metricsProducer hands the metrics served at /metrics to the OTLP
+// exporter at every export interval.
+type metricsProducer struct {
+	start time.Time
+}
+
+// Produce implements sdkmetric.Producer.
+func (p metricsProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	now := time.Now()
+	updateScrapeMetrics(now)
+	out := make([]metricdata.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		out = append(out, m.otlp(p.start, now))
+	}
+	return []metricdata.ScopeMetrics{{Scope: instrumentation.Scope{Name: otlpScope}, Metrics: out}}, nil
+}
+
+// otlp converts a metric family to an OTLP metric with cumulative
+// temporality. Histogram buckets are converted from cumulative to
+// per-bucket counts.
+func (m *metric) otlp(start, now time.Time) metricdata.Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := metricdata.Metrics{Name: m.name, Description: m.help}
+	var points []metricdata.DataPoint[float64]
+	var histogram []metricdata.HistogramDataPoint[float64]
+	for _, s := range m.series {
+		attrs := make([]attribute.KeyValue, len(m.labels))
+		for i, name := range m.labels {
+			attrs[i] = attribute.String(name, s.values[i])
+		}
+		set := attribute.NewSet(attrs...)
+		if m.kind != "histogram" {
+			points = append(points, metricdata.DataPoint[float64]{Attributes: set, StartTime: start, Time: now, Value: s.value})
+			continue
+		}
+		counts := make([]uint64, len(s.counts)+1)
+		var below uint64
+		for i, c := range s.counts {
+			counts[i] = c - below
+			below = c
+		}
+		counts[len(s.counts)] = s.count - below
+		histogram = append(histogram, metricdata.HistogramDataPoint[float64]{
+			Attributes: set, StartTime: start, Time: now,
+			Count: s.count, Sum: s.value, Bounds: m.buckets, BucketCounts: counts,
+		})
+	}
+
+	switch m.kind {
+	case "counter":
+		out.Data = metricdata.Sum[float64]{DataPoints: points, Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+	case "histogram":
+		out.Data = metricdata.Histogram[float64]{DataPoints: histogram, Temporality: metricdata.CumulativeTemporality}
+	default:
+		out.Data = metricdata.Gauge[float64]{DataPoints: points}
+	}
+	return out
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceManual marks transitions made by setting or ending a status
+// override.
+const sourceManual = "manual"
+
+// overrideMaxDuration bounds overrides that expire; longer ones are set
+// without a duration and cleared by hand.
+const overrideMaxDuration = 365 * 24 * time.Hour
+
+// manualStatus is a state of the space set by hand, which it keeps
+// whatever the switch says until the override expires or is cleared.
+type manualStatus struct {
+	Status spaceStatus `json:"status"`
+	Until  *time.Time  `json:"until,omitempty"` // nil until cleared
+	Reason string      `json:"reason,omitempty"`
+	By     string      `json:"by"`
+	SetAt  time.Time   `json:"set_at"`
+}
+
+// expired reports whether the override has run out by now.
+func (m manualStatus) expired(now time.Time) bool {
+	return m.Until != nil && !now.Before(*m.Until)
+}
+
+// overrideSwitch holds the status override, e.g. while the door sensor is
+// broken or the space is open for an event in a room the switch does not
+// cover. Changes of the switch are still recorded; they only do not change
+// the space.
+type overrideSwitch struct {
+	mu       sync.Mutex
+	path     string
+	current  *manualStatus
+	timer    *time.Timer
+	notifier Notifier // announces the space going back to the switch
+}
+
+var statusOverride = &overrideSwitch{path: filepath.Join(dataDir, "override.json")}
+
+// load restores an override saved by a previous run, unless it has
+// expired. notifier announces changes made by the override.
+func (o *overrideSwitch) load(notifier Notifier) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.notifier = notifier
+	data, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m *manualStatus
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("parse %s: %w", o.path, err)
+	}
+	if m == nil || m.expired(time.Now()) {
+		return nil
+	}
+	o.current = m
+	o.schedule(*m)
+	slog.Info("Status overridden", "component", "status", "status", m.Status, "until", m.Until, "overridden_by", m.By)
+	return nil
+}
+
+// active returns the current override, if any.
+func (o *overrideSwitch) active() (manualStatus, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.current == nil || o.current.expired(time.Now()) {
+		return manualStatus{}, false
+	}
+	return *o.current, true
+}
+
+// set overrides the status with s for d, or until cleared if d is 0,
+// replacing any existing override, and takes the space to s.
+func (o *overrideSwitch) set(s spaceStatus, d time.Duration, reason, by string) (manualStatus, error) {
+	now := time.Now()
+	m := manualStatus{Status: s, Reason: reason, By: by, SetAt: now}
+	if d > 0 {
+		until := now.Add(d)
+		m.Until = &until
+	}
+
+	o.mu.Lock()
+	o.current = &m
+	o.schedule(m)
+	err := writeJSONFile(o.path, o.current)
+	o.mu.Unlock()
+
+	applyOverride(o.notifier)
+	return m, err
+}
+
+// clear ends the override early and hands the space back to the switch.
+// It reports whether one was active.
+func (o *overrideSwitch) clear() (bool, error) {
+	o.mu.Lock()
+	active := o.current != nil && !o.current.expired(time.Now())
+	err := o.remove()
+	o.mu.Unlock()
+
+	if active {
+		applyOverride(o.notifier)
+	}
+	return active, err
+}
+
+// schedule arranges for m to be cleared when it expires. The caller must
+// hold o.mu.
+func (o *overrideSwitch) schedule(m manualStatus) {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if m.Until == nil {
+		return
+	}
+	o.timer = time.AfterFunc(time.Until(*m.Until), func() {
+		o.mu.Lock()
+		if o.current == nil || !o.current.SetAt.Equal(m.SetAt) {
+			o.mu.Unlock()
+			return
+		}
+		if err := o.remove(); err != nil {
+			slog.Error("Failed to save status override", "component", "status", "err", err)
+		}
+		o.mu.Unlock()
+		slog.Info("Status override expired", "component", "status", "status", m.Status, "overridden_by", m.By)
+		applyOverride(o.notifier)
+	})
+}
+
+// remove drops the override. The caller must hold o.mu.
+func (o *overrideSwitch) remove() error {
+	o.current = nil
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if err := os.Remove(o.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// applyOverride takes the space to the state of the override or, once
+// there is none, to the one held by maintenance or back to the state of
+// its zones, and announces the change through notifier. Nothing happens
+// while that state is unknown, e.g. before the switch has been read; the
+// first reading applies it then.
+func applyOverride(notifier Notifier) {
+	spaceMu.Lock()
+	now := time.Now()
+	states := zoneStates(nil, statusUnknown)
+	s := spaceStatusOf(states)
+	m, manual := statusOverride.active()
+	if manual {
+		s = m.Status
+	} else if held, ok := spaceMaintenance.held(); ok {
+		s = held
+	}
+	from := currentSpace().Status
+	if last, ok := lastKnown.space(); ok && from == statusUnknown {
+		from = last.Status
+	}
+	if !updateSpace(s, now) {
+		spaceMu.Unlock()
+		return
+	}
+	event := newEvent(spaceEventZone(), s, now)
+	event.SpaceStatus, event.Manual, event.Reason = s, manual, m.Reason
+	if zonesConfigured {
+		event.Zones = states
+	}
+	ctx, span := startSpan(context.Background(), "status.override", spanKindInternal)
+	defer span.finish(nil)
+	span.set("state", event.Status.String())
+	span.set("event.id", event.ID)
+	recordChange(&event, now)
+	span.set("event.seq", event.Seq)
+	event.Duration = event.SpaceDuration
+	spaceMu.Unlock()
+
+	change := transition{EventID: event.ID, Seq: event.Seq, Time: now, Zone: event.Zone,
+		From: from, To: s, SpaceFrom: from, SpaceTo: s, Source: sourceManual}
+	recordTransition(change)
+	influx.transition(change)
+	slog.Info("Space state changed", "component", "status", "state", s, "manual", manual,
+		"duration", event.Duration, "event", event.ID, "seq", event.Seq)
+	notifier.Notify(ctx, event)
+}
+
+// spaceEventZone is the zone of events that change the space rather than
+// one of its zones: the only one without ZONES, and none with.
+func spaceEventZone() string {
+	if zonesConfigured {
+		return ""
+	}
+	return zones[0].name
+}
+
+// manualStatusView is the override as shown by /status, without who set it.
+type manualStatusView struct {
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// overrideView is the API representation of the override state.
+type overrideView struct {
+	Overridden bool `json:"overridden"`
+	*manualStatus
+}
+
+func currentOverrideView() overrideView {
+	m, ok := statusOverride.active()
+	if !ok {
+		return overrideView{}
+	}
+	return overrideView{Overridden: true, manualStatus: &m}
+}
+
+// handleSetStatus overrides the status, for duration_seconds or, without,
+// until cleared.
+func handleSetStatus(w http.ResponseWriter, r *http.Request) {
+	if shadowMode {
+		http.Error(w, "A shadow instance follows its primary; override the status there", http.StatusConflict)
+		return
+	}
+	var req struct {
+		Status          spaceStatus `json:"status"`
+		DurationSeconds int64       `json:"duration_seconds"`
+		Reason          string      `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Status == statusUnknown {
+		http.Error(w, "status must be open, members_only, or closed", http.StatusBadRequest)
+		return
+	}
+	// Checked in seconds, as converting a larger value could overflow.
+	if maxSeconds := int64(overrideMaxDuration / time.Second); req.DurationSeconds < 0 || req.DurationSeconds > maxSeconds {
+		http.Error(w, fmt.Sprintf("duration_seconds must be between 0 and %d", maxSeconds), http.StatusBadRequest)
+		return
+	}
+
+	p, _ := authenticate(r)
+	m, err := statusOverride.set(req.Status, time.Duration(req.DurationSeconds)*time.Second, strings.TrimSpace(req.Reason), p.Name)
+	if err != nil {
+		slog.Error("Failed to save status override", "component", "status", "err", err)
+	}
+	audit("Overrode status", auditStatusOverridden, p.Name, "status", m.Status, "until", m.Until, "reason", m.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentOverrideView())
+}
+
+// handleClearStatus ends an override early.
+func handleClearStatus(w http.ResponseWriter, r *http.Request) {
+	cleared, err := statusOverride.clear()
+	if err != nil {
+		slog.Error("Failed to save status override", "component", "status", "err", err)
+	}
+	if cleared {
+		p, _ := authenticate(r)
+		audit("Cleared status override", auditOverrideCleared, p.Name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
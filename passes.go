@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Guest pass lifetimes.
+const (
+	guestPassDefaultTTL    = 24 * time.Hour
+	guestPassMaxTTL        = 30 * 24 * time.Hour
+	guestPassPruneInterval = time.Hour
+)
+
+// guestPass is a short-lived, scope-limited token for visitors. Only a hash
+// of the token is kept.
+type guestPass struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	Scopes    []string  `json:"scopes"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+}
+
+// principal returns the identity the pass authenticates as.
+func (p guestPass) principal() principal {
+	scopes := make(map[string]bool, len(p.Scopes))
+	for _, s := range p.Scopes {
+		scopes[s] = true
+	}
+	return principal{Name: "pass:" + p.ID, Scopes: scopes}
+}
+
+// guestPassStore holds issued passes. Revoked passes stay on the revocation
+// list until they would have expired anyway.
+type guestPassStore struct {
+	mu     sync.Mutex
+	path   string
+	passes map[string]*guestPass // keyed by ID
+}
+
+var guestPasses = &guestPassStore{
+	path:   filepath.Join(dataDir, "guest_passes.json"),
+	passes: make(map[string]*guestPass),
+}
+
+// load restores passes saved by a previous run.
+func (s *guestPassStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var passes []*guestPass
+	if err := json.Unmarshal(data, &passes); err != nil {
+		return fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range passes {
+		s.passes[p.ID] = p
+	}
+	return nil
+}
+
+// save persists the passes. The caller must hold s.mu.
+func (s *guestPassStore) save() error {
+	passes := make([]*guestPass, 0, len(s.passes))
+	for _, p := range s.passes {
+		passes = append(passes, p)
+	}
+	sort.Slice(passes, func(i, j int) bool { return passes[i].CreatedAt.Before(passes[j].CreatedAt) })
+	return writeJSONFile(s.path, passes)
+}
+
+// mint issues a new pass and returns it with its plaintext token.
+func (s *guestPassStore) mint(label string, scopes []string, ttl time.Duration) (guestPass, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return guestPass{}, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return guestPass{}, "", err
+	}
+	token := "gp_" + secret
+
+	now := time.Now().UTC()
+	pass := &guestPass{
+		ID:        id,
+		Label:     label,
+		Scopes:    scopes,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passes[id] = pass
+	return *pass, token, s.save()
+}
+
+// revoke puts a pass on the revocation list.
+func (s *guestPassStore) revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.passes[id]
+	if !ok {
+		return false, nil
+	}
+	if p.RevokedAt.IsZero() {
+		p.RevokedAt = time.Now().UTC()
+	}
+	return true, s.save()
+}
+
+// lookup returns the live pass matching token.
+func (s *guestPassStore) lookup(token string) (guestPass, bool) {
+	hash := hashToken(token)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.passes {
+		if p.TokenHash == hash && p.RevokedAt.IsZero() && now.Before(p.ExpiresAt) {
+			return *p, true
+		}
+	}
+	return guestPass{}, false
+}
+
+// list returns all passes still on record, newest first.
+func (s *guestPassStore) list() []guestPass {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	passes := make([]guestPass, 0, len(s.passes))
+	for _, p := range s.passes {
+		passes = append(passes, *p)
+	}
+	sort.Slice(passes, func(i, j int) bool { return passes[i].CreatedAt.After(passes[j].CreatedAt) })
+	return passes
+}
+
+// prune forgets passes, revoked or not, once they have expired.
+func (s *guestPassStore) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	removed := false
+	for id, p := range s.passes {
+		if now.After(p.ExpiresAt) {
+			delete(s.passes, id)
+			removed = true
+		}
+	}
+	if removed {
+		if err := s.save(); err != nil {
+			slog.Error("Failed to save guest passes", "component", "auth", "err", err)
+		}
+	}
+}
+
+// pruneGuestPasses periodically drops expired passes.
+func pruneGuestPasses() {
+	for {
+		time.Sleep(guestPassPruneInterval)
+		guestPasses.prune()
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// guestPassView is the API representation of a pass, without its hash.
+type guestPassView struct {
+	ID        string     `json:"id"`
+	Label     string     `json:"label"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Token     string     `json:"token,omitempty"`
+}
+
+func viewGuestPass(p guestPass, token string) guestPassView {
+	v := guestPassView{ID: p.ID, Label: p.Label, Scopes: p.Scopes, CreatedAt: p.CreatedAt, ExpiresAt: p.ExpiresAt, Token: token}
+	if !p.RevokedAt.IsZero() {
+		v.RevokedAt = &p.RevokedAt
+	}
+	return v
+}
+
+// handleMintGuestPass issues a guest pass. The token is only returned here.
+func handleMintGuestPass(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Label      string   `json:"label"`
+		Scopes     []string `json:"scopes"`
+		TTLSeconds int64    `json:"ttl_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{scopeStatusRead, scopeCheckIn}
+	}
+	for _, scope := range req.Scopes {
+		if !guestScopes[scope] {
+			http.Error(w, "Scope not allowed for guest passes: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	// Checked in seconds, as converting a larger value could overflow.
+	if maxSeconds := int64(guestPassMaxTTL / time.Second); req.TTLSeconds < 0 || req.TTLSeconds > maxSeconds {
+		http.Error(w, fmt.Sprintf("ttl_seconds must be between 1 and %d", maxSeconds), http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = guestPassDefaultTTL
+	}
+
+	pass, token, err := guestPasses.mint(req.Label, req.Scopes, ttl)
+	if err != nil {
+		slog.Error("Failed to mint guest pass", "component", "auth", "err", err)
+		http.Error(w, "Failed to mint guest pass", http.StatusInternalServerError)
+		return
+	}
+	p, _ := authenticate(r)
+	audit("Minted guest pass", auditGuestPassMinted, p.Name, "pass", pass.ID, "label", pass.Label, "scopes", pass.Scopes, "expires", pass.ExpiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(viewGuestPass(pass, token))
+}
+
+// handleListGuestPasses lists issued passes, including revoked ones that
+// have not yet expired.
+func handleListGuestPasses(w http.ResponseWriter, r *http.Request) {
+	views := []guestPassView{}
+	for _, p := range guestPasses.list() {
+		views = append(views, viewGuestPass(p, ""))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// handleRevokeGuestPass revokes a pass by ID.
+func handleRevokeGuestPass(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ok, err := guestPasses.revoke(id)
+	if err != nil {
+		slog.Error("Failed to save guest passes", "component", "auth", "err", err)
+	}
+	if !ok {
+		http.Error(w, "Unknown guest pass "+id, http.StatusNotFound)
+		return
+	}
+	p, _ := authenticate(r)
+	audit("Revoked guest pass", auditGuestPassRevoked, p.Name, "pass", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification pause limits.
+const (
+	pauseDefaultDuration = time.Hour
+	pauseMaxDuration     = 7 * 24 * time.Hour
+)
+
+// notificationPause records who silenced outbound notifications and until
+// when.
+type notificationPause struct {
+	Until    time.Time `json:"until"`
+	Reason   string    `json:"reason,omitempty"`
+	By       string    `json:"by"`
+	PausedAt time.Time `json:"paused_at"`
+}
+
+// pauseSwitch silences every notifier and webhook while a pause is active,
+// e.g. while the door sensor is being repaired. Events are still recorded;
+// they are only not announced. A pause always expires.
+type pauseSwitch struct {
+	mu      sync.Mutex
+	path    string
+	current *notificationPause
+	timer   *time.Timer
+}
+
+var notificationsPause = &pauseSwitch{path: filepath.Join(dataDir, "pause.json")}
+
+// load restores a pause saved by a previous run, unless it has expired.
+func (p *pauseSwitch) load() error {
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pause *notificationPause
+	if err := json.Unmarshal(data, &pause); err != nil {
+		return fmt.Errorf("parse %s: %w", p.path, err)
+	}
+	if pause == nil || !time.Now().Before(pause.Until) {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = pause
+	p.schedule(*pause)
+	slog.Info("Notifications paused", "component", "notify", "until", pause.Until, "paused_by", pause.By)
+	return nil
+}
+
+// active returns the current pause, if any.
+func (p *pauseSwitch) active() (notificationPause, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == nil || !time.Now().Before(p.current.Until) {
+		return notificationPause{}, false
+	}
+	return *p.current, true
+}
+
+// pause silences notifications for d, replacing any existing pause.
+func (p *pauseSwitch) pause(d time.Duration, reason, by string) (notificationPause, error) {
+	now := time.Now()
+	pause := notificationPause{Until: now.Add(d), Reason: reason, By: by, PausedAt: now}
+
+	p.mu.Lock()
+	p.current = &pause
+	p.schedule(pause)
+	err := writeJSONFile(p.path, p.current)
+	p.mu.Unlock()
+
+	audit("Paused notifications", auditNotificationsPaused, by, "until", pause.Until, "reason", reason)
+	text := fmt.Sprintf("Notifications paused by %s until %s", by, pause.Until.In(liveSettings().location).Format("Mon 15:04"))
+	if reason != "" {
+		text += ": " + reason
+	}
+	opsAlerts.Alert("pause:"+now.String(), text)
+	return pause, err
+}
+
+// resume ends the current pause early. It reports whether one was active.
+func (p *pauseSwitch) resume(by string) (bool, error) {
+	p.mu.Lock()
+	active := p.current != nil && time.Now().Before(p.current.Until)
+	err := p.clear()
+	p.mu.Unlock()
+
+	if active {
+		audit("Resumed notifications", auditNotificationsResumed, by)
+		opsAlerts.Alert("pause:"+time.Now().String(), "Notifications resumed by "+by)
+	}
+	return active, err
+}
+
+// schedule arranges for pause to be cleared when it expires. The caller
+// must hold p.mu.
+func (p *pauseSwitch) schedule(pause notificationPause) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(time.Until(pause.Until), func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.current == nil || !p.current.Until.Equal(pause.Until) {
+			return
+		}
+		if err := p.clear(); err != nil {
+			slog.Error("Failed to save notification pause", "component", "notify", "err", err)
+		}
+		opsAlerts.Alert("pause:"+pause.Until.String(), "Notification pause expired; announcements have resumed")
+	})
+}
+
+// clear removes the pause. The caller must hold p.mu.
+func (p *pauseSwitch) clear() error {
+	p.current = nil
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if err := os.Remove(p.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// announcementsHeld reports whether announcements are held back, by a
+// pause or maintenance, and by whom. The notifier registry checks it before
+// queueing an event, and the queues again before each delivery, so
+// deliveries queued before it began, replayed from the write-ahead log
+// after a restart, or published straight to a webhook are not sent either.
+func announcementsHeld() (string, bool) {
+	if m, ok := spaceMaintenance.active(); ok {
+		return "maintenance:" + m.By, true
+	}
+	if pause, ok := notificationsPause.active(); ok {
+		return "pause:" + pause.By, true
+	}
+	return "", false
+}
+
+// pauseView is the API representation of the pause state.
+type pauseView struct {
+	Paused bool `json:"paused"`
+	*notificationPause
+}
+
+func currentPauseView() pauseView {
+	pause, ok := notificationsPause.active()
+	if !ok {
+		return pauseView{}
+	}
+	return pauseView{Paused: true, notificationPause: &pause}
+}
+
+// handleGetPause reports whether notifications are paused.
+func handleGetPause(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentPauseView())
+}
+
+// handlePause pauses notifications for the requested duration.
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DurationSeconds int64  `json:"duration_seconds"`
+		Reason          string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	// Checked in seconds, as converting a larger value could overflow.
+	if maxSeconds := int64(pauseMaxDuration / time.Second); req.DurationSeconds < 0 || req.DurationSeconds > maxSeconds {
+		http.Error(w, fmt.Sprintf("duration_seconds must be between 1 and %d", maxSeconds), http.StatusBadRequest)
+		return
+	}
+	d := time.Duration(req.DurationSeconds) * time.Second
+	if d == 0 {
+		d = pauseDefaultDuration
+	}
+
+	p, _ := authenticate(r)
+	if _, err := notificationsPause.pause(d, strings.TrimSpace(req.Reason), p.Name); err != nil {
+		slog.Error("Failed to save notification pause", "component", "notify", "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentPauseView())
+}
+
+// handleResume ends a pause early.
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	p, _ := authenticate(r)
+	if _, err := notificationsPause.resume(p.Name); err != nil {
+		slog.Error("Failed to save notification pause", "component", "notify", "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePauseCommand implements the /pause Slack command:
+// `/pause [DURATION [reason]]`, `/pause off`, or `/pause status`. Only
+// users SLACK_AUTH_USERS makes admins may use it, as only admins may
+// pause through the API.
+func handlePauseCommand(w http.ResponseWriter, r *http.Request) {
+	lang := liveSettings().locale
+	tz := liveSettings().location
+	userID, ok := verifySlackCommand(w, r)
+	if !ok {
+		return
+	}
+	if !slackUserHas(userID, scopeAdmin) {
+		respondEphemeral(w, translate(lang, msgAdminOnly))
+		return
+	}
+	by := "slack:" + userID
+
+	fields := strings.Fields(r.FormValue("text"))
+	switch {
+	case len(fields) == 1 && strings.EqualFold(fields[0], "off"):
+		if _, err := notificationsPause.resume(by); err != nil {
+			slog.Error("Failed to save notification pause", "component", "notify", "err", err)
+		}
+		respondEphemeral(w, translate(lang, msgPauseClear))
+		return
+	case len(fields) == 1 && strings.EqualFold(fields[0], "status"):
+		if pause, ok := notificationsPause.active(); ok {
+			respondEphemeral(w, translate(lang, msgPauseSet, pause.Until.In(tz).Format("Mon 15:04")))
+			return
+		}
+		respondEphemeral(w, translate(lang, msgPauseNone))
+		return
+	}
+
+	d := pauseDefaultDuration
+	if len(fields) > 0 {
+		parsed, err := time.ParseDuration(fields[0])
+		if err != nil || parsed <= 0 || parsed > pauseMaxDuration {
+			respondEphemeral(w, translate(lang, msgPauseUsage))
+			return
+		}
+		d, fields = parsed, fields[1:]
+	}
+	pause, err := notificationsPause.pause(d, strings.Join(fields, " "), by)
+	if err != nil {
+		slog.Error("Failed to save notification pause", "component", "notify", "err", err)
+	}
+	respondEphemeral(w, translate(lang, msgPauseSet, pause.Until.In(tz).Format("Mon 15:04")))
+}
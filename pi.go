@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Raspberry Pi health sources and settings.
+const (
+	piTemperaturePath  = "/sys/class/thermal/thermal_zone0/temp"
+	piThrottledPath    = "/sys/devices/platform/soc/soc:firmware/get_throttled"
+	piHealthInterval   = 30 * time.Second
+	piDefaultTempAlert = 75 // °C; the firmware soft-limits at 80
+)
+
+// piThrottleFlags names the bits of the firmware's get_throttled value.
+// Bits 0-3 describe the current state, bits 16-19 whether the condition
+// occurred since boot.
+var piThrottleFlags = []struct {
+	bit       uint
+	condition string
+	text      string
+}{
+	{0, "under_voltage", "under-voltage"},
+	{1, "freq_capped", "ARM frequency capped"},
+	{2, "throttled", "throttled"},
+	{3, "soft_temp_limit", "soft temperature limit"},
+}
+
+// piHealth is one reading of the SoC temperature and throttle state.
+type piHealth struct {
+	Time         time.Time `json:"time"`
+	TemperatureC float64   `json:"temperature_celsius"`
+	Throttled    string    `json:"throttled"`       // raw get_throttled value, e.g. "0x50005"; empty if unavailable
+	Now          []string  `json:"now"`             // conditions present now
+	SinceBoot    []string  `json:"since_boot"`      // conditions that occurred since boot
+	Error        string    `json:"error,omitempty"` // why the throttle state could not be read
+	AlertAtC     int       `json:"alert_at_celsius"`
+}
+
+var (
+	metricSoCTemperature = newMetric("gauge", "space_status_soc_temperature_celsius",
+		"Raspberry Pi SoC temperature.")
+	metricSoCThrottled = newMetric("gauge", "space_status_soc_throttled",
+		"Raspberry Pi throttle conditions (1 if present) now or since boot.", "condition", "when")
+)
+
+// piMonitor samples the Pi's health and alerts when it runs hot or is
+// throttled.
+type piMonitor struct {
+	alertAt int
+
+	mu     sync.Mutex
+	latest *piHealth
+}
+
+// piHealthMonitor is nil when the host has no thermal sensor.
+var piHealthMonitor *piMonitor
+
+// startPiMonitor begins sampling if the host exposes a SoC temperature.
+func startPiMonitor(alertAt int) {
+	if _, err := os.Stat(piTemperaturePath); err != nil {
+		return
+	}
+	piHealthMonitor = &piMonitor{alertAt: alertAt}
+	go piHealthMonitor.run()
+}
+
+func (m *piMonitor) run() {
+	for {
+		m.sample()
+		time.Sleep(piHealthInterval)
+	}
+}
+
+func (m *piMonitor) sample() {
+	h, err := readPiHealth()
+	if err != nil {
+		slog.Error("Failed to read SoC temperature", "component", "pi", "err", err)
+		return
+	}
+	h.AlertAtC = m.alertAt
+	m.mu.Lock()
+	m.latest = &h
+	m.mu.Unlock()
+
+	metricSoCTemperature.set(h.TemperatureC)
+	if h.Throttled != "" {
+		for _, f := range piThrottleFlags {
+			metricSoCThrottled.set(boolGauge(contains(h.Now, f.condition)), f.condition, "now")
+			metricSoCThrottled.set(boolGauge(contains(h.SinceBoot, f.condition)), f.condition, "since_boot")
+		}
+	}
+
+	if h.TemperatureC >= float64(m.alertAt) {
+		opsAlerts.Alert("pi-temperature", fmt.Sprintf("Pi SoC temperature is %.1f°C (alert at %d°C)", h.TemperatureC, m.alertAt))
+	}
+	if len(h.Now) > 0 {
+		opsAlerts.Alert("pi-throttled:"+h.Throttled, fmt.Sprintf("Pi is currently %s (get_throttled=%s)",
+			strings.Join(piConditionText(h.Now), ", "), h.Throttled))
+	}
+}
+
+// current returns the latest reading, if any.
+func (m *piMonitor) current() (piHealth, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latest == nil {
+		return piHealth{}, false
+	}
+	return *m.latest, true
+}
+
+// readPiHealth reads the SoC temperature and, where available, the
+// firmware throttle flags.
+func readPiHealth() (piHealth, error) {
+	h := piHealth{Time: time.Now(), Now: []string{}, SinceBoot: []string{}}
+	data, err := os.ReadFile(piTemperaturePath)
+	if err != nil {
+		return h, err
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return h, fmt.Errorf("parse %s: %w", piTemperaturePath, err)
+	}
+	h.TemperatureC = float64(milli) / 1000
+
+	raw, err := readThrottled()
+	if err != nil {
+		h.Error = err.Error()
+		return h, nil
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(raw, "0x"), 16, 32)
+	if err != nil {
+		h.Error = fmt.Sprintf("parse throttled value %q: %v", raw, err)
+		return h, nil
+	}
+	h.Throttled = fmt.Sprintf("0x%x", value)
+	for _, f := range piThrottleFlags {
+		if value&(1<<f.bit) != 0 {
+			h.Now = append(h.Now, f.condition)
+		}
+		if value&(1<<(f.bit+16)) != 0 {
+			h.SinceBoot = append(h.SinceBoot, f.condition)
+		}
+	}
+	return h, nil
+}
+
+// readThrottled returns the firmware's get_throttled value, from sysfs on
+// recent kernels or vcgencmd otherwise.
+func readThrottled() (string, error) {
+	if data, err := os.ReadFile(piThrottledPath); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	out, err := exec.Command("vcgencmd", "get_throttled").Output()
+	if err != nil {
+		return "", errors.New("throttle state unavailable: no sysfs entry and vcgencmd failed")
+	}
+	value, ok := strings.CutPrefix(strings.TrimSpace(string(out)), "throttled=")
+	if !ok {
+		return "", fmt.Errorf("unexpected vcgencmd output %q", out)
+	}
+	return value, nil
+}
+
+// piConditionText returns the descriptions of conditions.
+func piConditionText(conditions []string) []string {
+	var text []string
+	for _, f := range piThrottleFlags {
+		if contains(conditions, f.condition) {
+			text = append(text, f.text)
+		}
+	}
+	return text
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSystemHealth serves the latest Pi health reading.
+func handleSystemHealth(w http.ResponseWriter, r *http.Request) {
+	if piHealthMonitor == nil {
+		http.Error(w, "No SoC temperature sensor on this host", http.StatusNotFound)
+		return
+	}
+	h, ok := piHealthMonitor.current()
+	if !ok {
+		http.Error(w, "No reading yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
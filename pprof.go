@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+)
+
+// Profiling limits.
+const (
+	pprofDefaultSeconds = 30
+	pprofMaxSeconds     = 120
+)
+
+// The profiling handlers mirror net/http/pprof, which is not imported
+// because it registers itself on the default mux without authentication.
+// They are mounted under /debug/pprof/ behind the admin scope.
+
+// handlePprofIndex lists the available profiles.
+func handlePprofIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Profiles (append ?debug=1 for text, ?gc=1 to collect garbage before heap):")
+	for _, p := range pprof.Profiles() {
+		fmt.Fprintf(w, "  /debug/pprof/%s (%d)\n", p.Name(), p.Count())
+	}
+	fmt.Fprintf(w, "  /debug/pprof/profile?seconds=%d (CPU)\n", pprofDefaultSeconds)
+	fmt.Fprintln(w, "  /debug/pprof/trace?seconds=5 (execution trace)")
+}
+
+// handlePprofProfile serves a named runtime profile such as heap or
+// goroutine.
+func handlePprofProfile(w http.ResponseWriter, r *http.Request) {
+	p := pprof.Lookup(r.PathValue("name"))
+	if p == nil {
+		http.Error(w, "Unknown profile", http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if p.Name() == "heap" && r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.Name()+".pprof"))
+	}
+	p.WriteTo(w, debug)
+}
+
+// handlePprofCPU records a CPU profile for ?seconds= (default 30).
+func handlePprofCPU(w http.ResponseWriter, r *http.Request) {
+	d, ok := pprofDuration(w, r, pprofDefaultSeconds)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile.pprof"`)
+	keepStreaming(w)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		http.Error(w, "Could not start CPU profile: "+err.Error(), http.StatusConflict)
+		return
+	}
+	sleepOrDone(r, d)
+	pprof.StopCPUProfile()
+}
+
+// handlePprofTrace records an execution trace for ?seconds= (default 1).
+func handlePprofTrace(w http.ResponseWriter, r *http.Request) {
+	d, ok := pprofDuration(w, r, 1)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace.out"`)
+	keepStreaming(w)
+	if err := trace.Start(w); err != nil {
+		http.Error(w, "Could not start trace: "+err.Error(), http.StatusConflict)
+		return
+	}
+	sleepOrDone(r, d)
+	trace.Stop()
+}
+
+// pprofDuration parses ?seconds=, responding with an error if invalid.
+func pprofDuration(w http.ResponseWriter, r *http.Request, fallback int) (time.Duration, bool) {
+	seconds := fallback
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > pprofMaxSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", pprofMaxSeconds), http.StatusBadRequest)
+			return 0, false
+		}
+		seconds = n
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// sleepOrDone waits for d or until the client goes away.
+func sleepOrDone(r *http.Request, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// previewRequest is the body of POST /api/v1/preview.
+type previewRequest struct {
+	State           string            `json:"state"`
+	Time            time.Time         `json:"time"`
+	DurationSeconds int64             `json:"duration_seconds"`
+	Templates       map[string]string `json:"templates"`
+	Zone            string            `json:"zone"` // defaults to the first zone
+}
+
+// handlePreview renders a hypothetical event and returns what each notifier
+// would send, without sending anything. Unsaved templates may be supplied to
+// preview drafts.
+func handlePreview(notifiers *notifierRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req previewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		s, err := parseSpaceStatus(req.State)
+		if err != nil || s == statusUnknown {
+			http.Error(w, `state must be "open", "members_only", or "closed"`, http.StatusBadRequest)
+			return
+		}
+
+		if req.Time.IsZero() {
+			req.Time = time.Now()
+		}
+		if req.Zone == "" {
+			req.Zone = zones[0].name
+		} else if zoneByName(req.Zone) == nil {
+			http.Error(w, "Unknown zone "+req.Zone, http.StatusBadRequest)
+			return
+		}
+		e := newEvent(req.Zone, s, req.Time)
+		states := zoneStates(zoneByName(req.Zone), s)
+		// As in applySwitchState, an override or maintenance decides the
+		// space, and the event only announces the space if that changes it.
+		e.SpaceStatus = spaceStatusOf(states)
+		if override, ok := statusOverride.active(); ok {
+			e.SpaceStatus = override.Status
+		} else if held, ok := spaceMaintenance.held(); ok {
+			e.SpaceStatus = held
+		}
+		e.ZoneOnly = e.SpaceStatus == statusUnknown || e.SpaceStatus == currentSpace().Status
+		if zonesConfigured {
+			e.Zones = states
+		}
+		e.Duration = time.Duration(req.DurationSeconds) * time.Second
+		if !e.Open {
+			e.Session = sessions.snapshot(e.Time)
+		}
+
+		overrides := make(templateSet)
+		for name, source := range req.Templates {
+			if !isTemplateName(name) {
+				http.Error(w, "Unknown template "+name, http.StatusBadRequest)
+				return
+			}
+			tmpl, err := parseMessageTemplate(name, source)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			overrides[name] = tmpl
+		}
+
+		plan, err := notifiers.Preview(e, overrides)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		response := map[string]interface{}{
+			"event":         map[string]interface{}{"id": e.ID, "seq": e.Seq, "state": e.State(), "time": e.Time, "duration_seconds": int64(e.Duration / time.Second)},
+			"notifications": plan,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
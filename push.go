@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pushoverAPI is the Pushover message endpoint.
+const pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// pushTitle is the localized title of push notifications.
+func pushTitle(e Event) string {
+	lang := liveSettings().locale
+	return translate(lang, msgStatus, statusText(lang, e.Status))
+}
+
+// gotifyDefaultPriority is used when GOTIFY_PRIORITY is unset.
+const gotifyDefaultPriority = 5
+
+// gotifyNotifier pushes announcements to a Gotify server.
+type gotifyNotifier struct {
+	serverURL string
+	token     string
+	priority  int
+}
+
+// newGotifyNotifier returns a notifier pushing to serverURL with the given
+// application token.
+func newGotifyNotifier(serverURL, token string, priority int) *gotifyNotifier {
+	return &gotifyNotifier{serverURL: strings.TrimRight(serverURL, "/"), token: token, priority: priority}
+}
+
+// Name implements Notifier.
+func (n *gotifyNotifier) Name() string {
+	return "gotify"
+}
+
+// Notify implements Notifier.
+func (n *gotifyNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	req, err := newJSONRequest(ctx, n.serverURL+"/message", map[string]interface{}{
+		"title":    pushTitle(e),
+		"message":  message,
+		"priority": n.priority,
+	})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gotify-Key", n.token)
+	return doWebhookRequest(req)
+}
+
+// Preview implements Previewer.
+func (n *gotifyNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
+
+// ntfyNotifier publishes announcements to an ntfy topic.
+type ntfyNotifier struct {
+	topicURL string // e.g. https://ntfy.sh/our-space
+	token    string // access token for protected topics, optional
+	priority int
+}
+
+// Name implements Notifier.
+func (n *ntfyNotifier) Name() string {
+	return "ntfy"
+}
+
+// Notify implements Notifier.
+func (n *ntfyNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.topicURL, strings.NewReader(message))
+	if err != nil {
+		return permanent(err)
+	}
+	tag := "red_circle"
+	if e.Open {
+		tag = "green_circle"
+	}
+	// Header values must be ASCII; ntfy decodes RFC 2047 encoded words.
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", pushTitle(e)))
+	req.Header.Set("Tags", tag)
+	req.Header.Set("Priority", strconv.Itoa(n.priority))
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return doWebhookRequest(req)
+}
+
+// Preview implements Previewer.
+func (n *ntfyNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
+
+// pushoverNotifier sends announcements through Pushover.
+type pushoverNotifier struct {
+	userKey  string
+	appToken string
+	device   string
+	priority int
+}
+
+// Name implements Notifier.
+func (n *pushoverNotifier) Name() string {
+	return "pushover"
+}
+
+// Notify implements Notifier.
+func (n *pushoverNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"token":    {n.appToken},
+		"user":     {n.userKey},
+		"title":    {pushTitle(e)},
+		"message":  {message},
+		"priority": {strconv.Itoa(n.priority)},
+	}
+	if n.device != "" {
+		form.Set("device", n.device)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverAPI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doWebhookRequest(req)
+}
+
+// Preview implements Previewer.
+func (n *pushoverNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
+
+// parsePushTargets parses PUSH_TARGETS: semicolon-separated entries of a
+// kind, a URL where the service needs one, and key=value options:
+//
+//	ntfy https://ntfy.sh/our-space [token=...] [priority=3]
+//	gotify https://gotify.example.org token=... [priority=5]
+//	pushover user=... token=... [device=...] [priority=0]
+func parsePushTargets(value string) ([]Notifier, error) {
+	var targets []Notifier
+	for _, entry := range splitList(value) {
+		fields := strings.Fields(entry)
+		kind, rest := strings.ToLower(fields[0]), fields[1:]
+
+		var target string
+		if len(rest) > 0 && !strings.Contains(rest[0], "=") {
+			target, rest = rest[0], rest[1:]
+		}
+		options := make(map[string]string)
+		for _, option := range rest {
+			k, v, ok := strings.Cut(option, "=")
+			if !ok {
+				return nil, fmt.Errorf("%q: option %q must be key=value", entry, option)
+			}
+			options[k] = v
+		}
+		priority := 0
+		if p, ok := options["priority"]; ok {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("%q: invalid priority %q", entry, p)
+			}
+			priority = n
+		}
+
+		switch kind {
+		case "ntfy":
+			if _, err := url.ParseRequestURI(target); err != nil {
+				return nil, fmt.Errorf("%q: ntfy needs a topic URL", entry)
+			}
+			if _, ok := options["priority"]; !ok {
+				priority = 3
+			}
+			targets = append(targets, &ntfyNotifier{topicURL: target, token: options["token"], priority: priority})
+		case "gotify":
+			if _, err := url.ParseRequestURI(target); err != nil || options["token"] == "" {
+				return nil, fmt.Errorf("%q: gotify needs a server URL and token=", entry)
+			}
+			if _, ok := options["priority"]; !ok {
+				priority = gotifyDefaultPriority
+			}
+			targets = append(targets, newGotifyNotifier(target, options["token"], priority))
+		case "pushover":
+			if options["user"] == "" || options["token"] == "" {
+				return nil, fmt.Errorf("%q: pushover needs user= and token=", entry)
+			}
+			targets = append(targets, &pushoverNotifier{userKey: options["user"], appToken: options["token"], device: options["device"], priority: priority})
+		default:
+			return nil, fmt.Errorf("%q: unknown push service %q", entry, kind)
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Delivery settings for queued notifiers.
+const (
+	notifyQueueSize    = 64
+	notifyBacklogLimit = 1024 // deliveries waiting for room in a full queue
+	notifyMaxAttempts  = 5
+	notifyAttemptLimit = 30 * time.Second
+)
+
+// Backoff between delivery attempts; variables so tests need not wait.
+var (
+	notifyBaseBackoff = time.Second
+	notifyMaxBackoff  = time.Minute
+)
+
+// errQueueFull is returned when a notifier's queue and its backlog cannot
+// accept more events.
+var errQueueFull = errors.New("notification queue full")
+
+// permanentError marks a delivery failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err so the queue does not retry it.
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// retryAfterError is implemented by errors carrying a server-requested delay.
+type retryAfterError interface {
+	RetryAfter() time.Duration
+}
+
+// queuedNotifier delivers events to a notifier from a background worker,
+// retrying failures with exponential backoff so a slow or flaky backend
+// does not hold up the monitor or other notifiers. A configuration reload
+// can replace the notifier while the queue carries on.
+type queuedNotifier struct {
+	queue  *deliveryQueue[queuedEvent]
+	seen   *recentIDs
+	walKey string // name of the queue in notifyLog
+
+	mu sync.Mutex
+	n  Notifier
+}
+
+// queuedEvent is an event waiting for delivery. ctx carries the trace of
+// the state change and is never cancelled.
+type queuedEvent struct {
+	ctx context.Context
+	e   Event
+}
+
+// newQueuedNotifier wraps n with a delivery queue and starts its worker.
+func newQueuedNotifier(n Notifier) *queuedNotifier {
+	q := &queuedNotifier{n: n, queue: newDeliveryQueue[queuedEvent](), seen: newRecentIDs()}
+	q.walKey = notifyLog.register(n.Name(), q)
+	go supervise(context.Background(), "notifier "+q.walKey, func(context.Context) { q.run() })
+	return q
+}
+
+// notifier returns the notifier events are delivered to.
+func (q *queuedNotifier) notifier() Notifier {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Name implements Notifier.
+func (q *queuedNotifier) Name() string {
+	return q.notifier().Name()
+}
+
+// reconfigure implements reconfigurable: queued and later events go to
+// fresh, which must be the same kind of notifier.
+func (q *queuedNotifier) reconfigure(fresh Notifier) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if fresh.Name() != q.n.Name() {
+		return false
+	}
+	q.n = fresh
+	return true
+}
+
+// Notify enqueues the event for delivery, recording it in the
+// write-ahead log first. An event whose ID was already accepted is
+// ignored, so redelivery cannot announce it twice.
+func (q *queuedNotifier) Notify(ctx context.Context, e Event) error {
+	if !q.seen.add(e.ID) {
+		return nil
+	}
+	entry := walEntry{Queue: q.walKey, ID: e.ID, Event: &e, Duration: e.Duration, Session: e.Session}
+	if err := notifyLog.accept(entry); err != nil {
+		slog.Error("Failed to log delivery", "component", "notify", "queue", q.walKey, "event", e.ID, "err", err)
+	}
+	if err := q.enqueue(queuedEvent{ctx: context.WithoutCancel(ctx), e: e}); err != nil {
+		// Dropped, so a redelivery of the event must not be ignored.
+		q.seen.forget(e.ID)
+		return err
+	}
+	return nil
+}
+
+func (q *queuedNotifier) enqueue(item queuedEvent) error {
+	return enqueueDelivery(q.queue, q.walKey, item.e.ID, item)
+}
+
+// replay implements walQueue.
+func (q *queuedNotifier) replay(entry walEntry) {
+	if entry.Event == nil {
+		notifyLog.done(q.walKey, entry.ID)
+		return
+	}
+	e := *entry.Event
+	e.Duration, e.Session = entry.Duration, entry.Session
+	q.seen.add(e.ID)
+	if err := q.enqueue(queuedEvent{ctx: context.Background(), e: e}); err != nil {
+		q.seen.forget(e.ID)
+		slog.Error("Dropping replayed delivery", "component", "notify", "queue", q.walKey, "event", e.ID, "err", err)
+	}
+}
+
+// Preview implements Previewer when the wrapped notifier does.
+func (q *queuedNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	if p, ok := q.notifier().(Previewer); ok {
+		return p.Preview(e, overrides)
+	}
+	return nil, nil
+}
+
+// canaryStage implements canaryTarget.
+func (q *queuedNotifier) canaryStage() string {
+	return q.walKey
+}
+
+func (q *queuedNotifier) run() {
+	for item := range q.queue.items {
+		e := item.e
+		if canaryArrived(e.ID, q.walKey) {
+			notifyLog.done(q.walKey, e.ID)
+			continue
+		}
+		if held, ok := announcementsHeld(); ok {
+			slog.Info("Notifications held back; dropping delivery", "component", "notify", "queue", q.walKey, "event", e.ID, "held_by", held)
+			notifyLog.done(q.walKey, e.ID)
+			continue
+		}
+		n := q.notifier()
+		err := deliverWithRetry(item.ctx, n.Name(), func(ctx context.Context) error {
+			return n.Notify(ctx, e)
+		})
+		if err != nil {
+			slog.Error("Giving up on delivery", "component", "notify", "notifier", n.Name(), "state", e.State(), "zone", e.Zone, "event", e.ID, "err", err)
+		}
+		notifyLog.done(q.walKey, e.ID)
+	}
+}
+
+// deliveryQueue feeds a delivery worker in order. While the worker is
+// behind and its channel is full, deliveries wait in a backlog, still
+// pending in the write-ahead log, and are handed over as room frees up.
+type deliveryQueue[T any] struct {
+	items chan T
+
+	mu      sync.Mutex
+	backlog []T
+	feeding bool // a goroutine is moving the backlog to items
+}
+
+func newDeliveryQueue[T any]() *deliveryQueue[T] {
+	return &deliveryQueue[T]{items: make(chan T, notifyQueueSize)}
+}
+
+// push queues item and returns how many deliveries wait in the backlog. It
+// returns errQueueFull once the backlog holds notifyBacklogLimit.
+func (d *deliveryQueue[T]) push(item T) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.backlog) == 0 {
+		select {
+		case d.items <- item:
+			return 0, nil
+		default:
+		}
+	} else if len(d.backlog) >= notifyBacklogLimit {
+		return len(d.backlog), errQueueFull
+	}
+	d.backlog = append(d.backlog, item)
+	if !d.feeding {
+		d.feeding = true
+		go d.feed()
+	}
+	return len(d.backlog), nil
+}
+
+// feed moves the backlog to the worker's channel, oldest first, until it
+// is empty.
+func (d *deliveryQueue[T]) feed() {
+	for {
+		d.mu.Lock()
+		if len(d.backlog) == 0 {
+			d.feeding = false
+			d.mu.Unlock()
+			return
+		}
+		item := d.backlog[0]
+		d.mu.Unlock()
+
+		d.items <- item
+		d.mu.Lock()
+		var zero T
+		d.backlog[0] = zero
+		d.backlog = d.backlog[1:]
+		d.mu.Unlock()
+	}
+}
+
+// enqueueDelivery pushes the delivery with the given ID to the queue
+// logged as walKey. Only a delivery the backlog cannot take either is
+// dropped: it is marked done and raises an ops alert.
+func enqueueDelivery[T any](d *deliveryQueue[T], walKey, id string, item T) error {
+	waiting, err := d.push(item)
+	switch {
+	case err != nil:
+		notifyLog.done(walKey, id)
+		opsAlerts.Alert("queue-full:"+walKey, fmt.Sprintf("Notification queue %s is full with %d deliveries waiting; dropping new ones", walKey, waiting))
+		return err
+	case waiting == 1:
+		slog.Warn("Notification queue full; deliveries wait for the worker", "component", "notify", "queue", walKey, "delivery", id)
+	}
+	return nil
+}
+
+// deliverWithRetry calls send until it succeeds, fails permanently, or runs
+// out of attempts, backing off exponentially between attempts. Each
+// attempt is traced as a child of the span in parent.
+func deliverWithRetry(parent context.Context, name string, send func(ctx context.Context) error) error {
+	backoff := notifyBaseBackoff
+	var err error
+	var attempt int
+	for attempt = 1; attempt <= notifyMaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(parent, notifyAttemptLimit)
+		ctx, span := startSpan(ctx, "notify "+name, spanKindClient)
+		span.set("notifier", name)
+		span.set("attempt", attempt)
+		err = send(ctx)
+		span.finish(err)
+		cancel()
+		if err == nil {
+			recordDelivery(name, nil)
+			return nil
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt == notifyMaxAttempts {
+			break
+		}
+
+		delay := backoff
+		var ra retryAfterError
+		if errors.As(err, &ra) && ra.RetryAfter() > delay {
+			delay = ra.RetryAfter()
+		}
+		slog.Warn("Delivery attempt failed", "component", "notify", "notifier", name, "attempt", attempt, "retry_in", delay, "err", err)
+		time.Sleep(delay)
+		backoff = min(backoff*2, notifyMaxBackoff)
+	}
+	recordDelivery(name, err)
+	return fmt.Errorf("attempt %d of %d: %w", attempt, notifyMaxAttempts, err)
+}
+
+// recentIDsTTL is how long delivered event IDs are remembered.
+const recentIDsTTL = 24 * time.Hour
+
+// recentIDs remembers event IDs seen within recentIDsTTL.
+type recentIDs struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func newRecentIDs() *recentIDs {
+	return &recentIDs{ids: make(map[string]time.Time)}
+}
+
+// add records id and reports whether it was new. Empty IDs are always new.
+func (r *recentIDs) add(id string) bool {
+	if id == "" {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for k, t := range r.ids {
+		if now.Sub(t) > recentIDsTTL {
+			delete(r.ids, k)
+		}
+	}
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	r.ids[id] = now
+	return true
+}
+
+// forget removes id, e.g. when its event was dropped after all.
+func (r *recentIDs) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.ids, id)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default rate limit of the public endpoints per client: a kiosk polling
+// every second stays well within it, a scraper in a tight loop does not.
+const (
+	defaultRateLimitPerMinute = 120
+	defaultRateLimitBurst     = 30
+)
+
+// rateLimitMaxClients is how many clients are tracked at most. Beyond it,
+// the one seen least recently is forgotten and starts over with a full
+// bucket.
+const rateLimitMaxClients = 10000
+
+// rateLimitPolicy is the token bucket each client of the public endpoints
+// gets, read from RATE_LIMIT_PER_MINUTE and RATE_LIMIT_BURST at startup and
+// on reload. A rate of 0 turns rate limiting off.
+type rateLimitPolicy struct {
+	perMinute int // tokens added per minute
+	burst     int // size of the bucket
+}
+
+// loadRateLimitPolicy reads RATE_LIMIT_PER_MINUTE and RATE_LIMIT_BURST.
+func loadRateLimitPolicy(v *settingsView) (rateLimitPolicy, error) {
+	var p rateLimitPolicy
+	var err error
+	if p.perMinute, err = v.intSetting("RATE_LIMIT_PER_MINUTE", defaultRateLimitPerMinute); err != nil {
+		return rateLimitPolicy{}, err
+	}
+	if p.burst, err = v.intSetting("RATE_LIMIT_BURST", defaultRateLimitBurst); err != nil {
+		return rateLimitPolicy{}, err
+	}
+	if p.perMinute < 0 {
+		return rateLimitPolicy{}, fmt.Errorf("RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+	if p.burst < 1 {
+		return rateLimitPolicy{}, fmt.Errorf("RATE_LIMIT_BURST must be at least 1")
+	}
+	return p, nil
+}
+
+// tokenBucket is one client's bucket.
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+// rateLimiter keeps a token bucket per client, in a list ordered by when
+// the client was last seen, most recent first.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element // of *tokenBucket
+	seen    *list.List
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*list.Element), seen: list.New()}
+}
+
+var publicLimiter = newRateLimiter()
+
+// take takes a token from the client's bucket under p. When the bucket is
+// empty, it returns how long until the next token.
+func (l *rateLimiter) take(key string, p rateLimitPolicy, now time.Time) time.Duration {
+	rate := float64(p.perMinute) / 60 // tokens per second
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gc(p, now)
+	var b *tokenBucket
+	if e, ok := l.buckets[key]; ok {
+		b = e.Value.(*tokenBucket)
+		l.seen.MoveToFront(e)
+	} else {
+		if len(l.buckets) >= rateLimitMaxClients {
+			l.forget(l.seen.Back())
+		}
+		b = &tokenBucket{key: key, tokens: float64(p.burst), last: now}
+		l.buckets[key] = l.seen.PushFront(b)
+	}
+	b.tokens = min(float64(p.burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// gc forgets clients not seen for long enough that their bucket is full
+// again, as a new one would be. They are at the back of l.seen, so this
+// stops at the first client seen since. The caller must hold l.mu.
+func (l *rateLimiter) gc(p rateLimitPolicy, now time.Time) {
+	refill := time.Duration(float64(p.burst) / float64(p.perMinute) * float64(time.Minute))
+	for e := l.seen.Back(); e != nil && now.Sub(e.Value.(*tokenBucket).last) >= refill; e = l.seen.Back() {
+		l.forget(e)
+	}
+}
+
+// forget drops a client's bucket. The caller must hold l.mu.
+func (l *rateLimiter) forget(e *list.Element) {
+	delete(l.buckets, e.Value.(*tokenBucket).key)
+	l.seen.Remove(e)
+}
+
+// rateLimitKey identifies the client of a request: its IP address or, for
+// IPv6, its /64, which a single host can pick addresses from at will.
+func rateLimitKey(r *http.Request) string {
+	ip := clientIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Unmap().Is4() {
+		return ip
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
+
+// limitRate answers requests to a public endpoint with 429 and
+// Retry-After once their client has used up its bucket, so a
+// misconfigured kiosk or a scraper cannot overwhelm the Pi. Authenticated
+// endpoints are not limited.
+func limitRate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := liveSettings().rateLimit
+		if p.perMinute > 0 {
+			if wait := publicLimiter.take(rateLimitKey(r), p, time.Now()); wait > 0 {
+				metricRateLimited.inc(r.Pattern)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	p := rateLimitPolicy{perMinute: 60, burst: 2}
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < p.burst; i++ {
+		if wait := l.take("a", p, now); wait != 0 {
+			t.Fatalf("take %d: wait %v, want none", i, wait)
+		}
+	}
+	if wait := l.take("a", p, now); wait != time.Second {
+		t.Errorf("take with an empty bucket: wait %v, want 1s", wait)
+	}
+	if wait := l.take("b", p, now); wait != 0 {
+		t.Errorf("other client: wait %v, want none", wait)
+	}
+	if wait := l.take("a", p, now.Add(time.Second)); wait != 0 {
+		t.Errorf("take after refill: wait %v, want none", wait)
+	}
+}
+
+func TestRateLimiterForgetsIdleClients(t *testing.T) {
+	p := rateLimitPolicy{perMinute: 60, burst: 2} // full again after 2s
+	l := newRateLimiter()
+	now := time.Now()
+	l.take("idle", p, now)
+	l.take("busy", p, now)
+	l.take("busy", p, now.Add(1500*time.Millisecond))
+	l.take("new", p, now.Add(2*time.Second))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("idle client is still tracked")
+	}
+	for _, key := range []string{"busy", "new"} {
+		if _, ok := l.buckets[key]; !ok {
+			t.Errorf("%s is no longer tracked", key)
+		}
+	}
+}
+
+func TestRateLimiterCapsClients(t *testing.T) {
+	p := rateLimitPolicy{perMinute: 1, burst: 1} // nothing refills during the test
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < rateLimitMaxClients; i++ {
+		l.take(strconv.Itoa(i), p, now)
+	}
+	l.take("0", p, now) // seen again, so 1 is now the least recent
+	l.take("new", p, now)
+	if len(l.buckets) != rateLimitMaxClients || l.seen.Len() != rateLimitMaxClients {
+		t.Fatalf("%d clients tracked (%d in the list), want %d", len(l.buckets), l.seen.Len(), rateLimitMaxClients)
+	}
+	if _, ok := l.buckets["1"]; ok {
+		t.Error("least recently seen client is still tracked")
+	}
+	for _, key := range []string{"0", "new"} {
+		if _, ok := l.buckets[key]; !ok {
+			t.Errorf("%s is no longer tracked", key)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// runtimeSettings are the settings read again on reload. They are
+// replaced as a whole, so requests see either the old or the new ones; read
+// them with liveSettings.
+type runtimeSettings struct {
+	// verificationToken authenticates Slack slash commands; they are
+	// rejected while it is unset.
+	verificationToken string
+	// adminToken grants full access to authenticated endpoints, which
+	// reject every request when it is unset.
+	adminToken      string
+	locale          string // for outgoing messages
+	pollingInterval time.Duration
+	location        *time.Location // of the schedule
+	openHours       []openHours
+	specialEvents   []scheduledEvent
+	// trustedProxies are the peers whose X-Forwarded-For and X-Request-ID
+	// headers are believed.
+	trustedProxies []netip.Prefix
+	cors           corsPolicy
+	rateLimit      rateLimitPolicy
+	authProviders  []authProvider // tried in order
+}
+
+// defaultRuntimeSettings are in effect until the settings are read.
+var defaultRuntimeSettings = runtimeSettings{
+	locale:          defaultLocale,
+	pollingInterval: defaultPollingInterval,
+	location:        time.Local,
+	rateLimit:       rateLimitPolicy{perMinute: defaultRateLimitPerMinute, burst: defaultRateLimitBurst},
+	authProviders:   defaultAuthProviders(),
+}
+
+var activeSettings atomic.Pointer[runtimeSettings]
+
+// liveSettings returns the runtime settings in effect.
+func liveSettings() *runtimeSettings {
+	if s := activeSettings.Load(); s != nil {
+		return s
+	}
+	return &defaultRuntimeSettings
+}
+
+// readRuntimeSettings reads and validates the runtime settings without
+// applying them.
+func readRuntimeSettings(v *settingsView) (runtimeSettings, error) {
+	s := runtimeSettings{
+		verificationToken: v.setting("SLACK_VERIFICATION_TOKEN"),
+		adminToken:        v.setting("ADMIN_TOKEN"),
+		locale:            loadLocale(v),
+	}
+	var err error
+	if s.pollingInterval, err = v.durationSetting("POLL_INTERVAL", defaultPollingInterval); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.pollingInterval <= 0 || s.pollingInterval >= monitorStallAfter {
+		return runtimeSettings{}, fmt.Errorf("POLL_INTERVAL must be positive and shorter than the monitor stall timeout of %s", monitorStallAfter)
+	}
+	if s.location, err = loadScheduleLocation(v); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.openHours, err = loadOpenHours(v); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.specialEvents, err = loadSpecialEvents(v, s.location); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.trustedProxies, err = loadTrustedProxies(v); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.cors, err = loadCORSPolicy(v); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.rateLimit, err = loadRateLimitPolicy(v); err != nil {
+		return runtimeSettings{}, err
+	}
+	if s.authProviders, err = buildAuthProviders(v); err != nil {
+		return runtimeSettings{}, fmt.Errorf("authentication: %w", err)
+	}
+	return s, nil
+}
+
+// apply makes the settings live.
+func (s runtimeSettings) apply() {
+	activeSettings.Store(&s)
+}
+
+// reloadMu serializes reloads.
+var reloadMu sync.Mutex
+
+// reloadResult describes a completed reload.
+type reloadResult struct {
+	File string `json:"file,omitempty"`
+	// Changed lists the settings whose value changed in the file.
+	Changed []string `json:"changed"`
+	// Notifiers lists the notifiers rebuilt with new settings.
+	Notifiers []string `json:"notifiers"`
+	// RestartRequired lists changed settings that are only read at startup.
+	RestartRequired []string `json:"restart_required"`
+}
+
+// reloadConfig reads the configuration file again and applies what can
+// change at runtime: the runtime settings, authentication, message
+// templates, ops alerts, and notifiers. Everything is read from the new
+// file and validated before it is loaded and anything is applied, so an
+// invalid file leaves the running configuration untouched. Environment
+// variables and flags cannot change in a running process, so only file
+// settings are compared.
+func reloadConfig(notifiers *notifierRegistry) (reloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	previous := currentConfig()
+	next, err := readConfig()
+	if err != nil {
+		return reloadResult{}, err
+	}
+	result := reloadResult{Changed: changedSettings(previous, next), Notifiers: []string{}, RestartRequired: []string{}}
+
+	// Until it has been validated, the new file is only seen through this
+	// view; requests keep reading the running configuration.
+	next.validating.Store(true)
+	defer next.validating.Store(false)
+	var apply func()
+	read := (&settingsView{file: next}).capture(func(v *settingsView) { apply, err = prepareReload(v, notifiers, &result) })
+	if err == nil {
+		err = next.secretFileErr()
+	}
+	if err != nil {
+		return reloadResult{}, err
+	}
+	loadedConfig.Store(next)
+	apply()
+
+	result.File = next.path
+	for _, key := range result.Changed {
+		if _, ok := read[key]; !ok {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+
+	slog.Info("Configuration reloaded", "component", "config", "file", result.File, "changed", result.Changed,
+		"notifiers", result.Notifiers, "restart_required", result.RestartRequired)
+	return result, nil
+}
+
+// prepareReload reads and validates everything a reload changes from v and
+// builds the notifiers whose settings changed. It returns a function
+// applying all of it.
+func prepareReload(v *settingsView, notifiers *notifierRegistry, result *reloadResult) (func(), error) {
+	runtime, err := readRuntimeSettings(v)
+	if err != nil {
+		return nil, err
+	}
+	activateTemplates, err := prepareMessageTemplates(v)
+	if err != nil {
+		return nil, err
+	}
+	opsChannel := v.setting("OPS_SLACK_CHANNEL")
+	opsToken, err := v.requiredSetting("SLACK_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	installNotifiers, rebuilt, err := prepareNotifiers(v, notifiers, true)
+	if err != nil {
+		return nil, err
+	}
+	if rebuilt != nil {
+		result.Notifiers = rebuilt
+	}
+	return func() {
+		runtime.apply()
+		activateTemplates()
+		if !shadowMode {
+			opsAlerts.configure(opsToken, opsChannel)
+		}
+		installNotifiers()
+	}, nil
+}
+
+// changedSettings returns the settings from the file or Vault whose value
+// differs between two loads, sorted. Secret files are read again, so a
+// rotated secret counts as changed.
+func changedSettings(previous, next *configFile) []string {
+	keys := make(map[string]bool)
+	for _, file := range []*configFile{previous, next} {
+		for key := range file.values {
+			keys[strings.TrimSuffix(key, secretFileSuffix)] = true
+			keys[key] = true
+		}
+		for key := range file.secrets {
+			keys[key] = true
+		}
+	}
+	changed := []string{}
+	for key := range keys {
+		before, hadBefore, _ := resolveSetting(previous, key)
+		after, hasAfter, _ := resolveSetting(next, key)
+		if before != after || hadBefore != hasAfter {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// watchReloadSignal reloads the configuration on SIGHUP.
+func watchReloadSignal(notifiers *notifierRegistry) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		result, err := reloadConfig(notifiers)
+		if err != nil {
+			slog.Error("Failed to reload configuration; keeping the running configuration", "component", "config", "err", err)
+			continue
+		}
+		audit("Reloaded configuration", auditConfigReloaded, "signal:SIGHUP", "changed", result.Changed)
+	}
+}
+
+// handleReload reloads the configuration and describes what changed.
+func handleReload(notifiers *notifierRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := authenticate(r)
+		result, err := reloadConfig(notifiers)
+		if err != nil {
+			slog.Error("Failed to reload configuration; keeping the running configuration", "component", "config", "err", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		audit("Reloaded configuration", auditConfigReloaded, p.Name, "changed", result.Changed)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What happens to transitions older than the retention period, selected
+// with HISTORY_RETENTION_MODE.
+const (
+	// retentionDelete deletes them.
+	retentionDelete = "delete"
+	// retentionDaily replaces them with each day's open time and number
+	// of openings, for the space and each zone.
+	retentionDaily = "daily"
+)
+
+// historyPruneInterval is how often old transitions are pruned.
+const historyPruneInterval = 24 * time.Hour
+
+// historyPruneTimeout bounds one pruning run.
+const historyPruneTimeout = 5 * time.Minute
+
+// retentionPolicy says how long transitions are kept.
+type retentionPolicy struct {
+	months int // 0 keeps them forever
+	mode   string
+}
+
+// loadRetentionPolicy reads HISTORY_RETENTION_MONTHS and
+// HISTORY_RETENTION_MODE.
+func loadRetentionPolicy() (retentionPolicy, error) {
+	p := retentionPolicy{mode: retentionDelete}
+	if value := setting("HISTORY_RETENTION_MONTHS"); value != "" {
+		months, err := strconv.Atoi(value)
+		if err != nil || months < 0 {
+			return p, fmt.Errorf("HISTORY_RETENTION_MONTHS must be a whole number of months, 0 to keep everything")
+		}
+		p.months = months
+	}
+	switch mode := strings.ToLower(setting("HISTORY_RETENTION_MODE")); mode {
+	case "":
+	case retentionDelete, retentionDaily:
+		p.mode = mode
+	default:
+		return p, fmt.Errorf("unknown HISTORY_RETENTION_MODE %q; use delete or daily", mode)
+	}
+	return p, nil
+}
+
+// cutoff returns the start of the oldest day that is kept in full.
+func (p retentionPolicy) cutoff(now time.Time) time.Time {
+	tz := liveSettings().location
+	local := now.In(tz).AddDate(0, -p.months, 0)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz)
+}
+
+// pruneHistoryPeriodically applies the retention policy now and then once
+// per historyPruneInterval.
+func pruneHistoryPeriodically(p retentionPolicy) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), historyPruneTimeout)
+		n, err := pruneHistory(ctx, p, time.Now())
+		cancel()
+		if err != nil {
+			slog.Error("Failed to prune history", "component", "history", "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned history", "component", "history", "transitions", n, "mode", p.mode)
+		}
+		time.Sleep(historyPruneInterval)
+	}
+}
+
+// pruneHistory deletes or, in daily mode, downsamples the transitions
+// before the policy's cutoff and returns how many it deleted.
+func pruneHistory(ctx context.Context, p retentionPolicy, now time.Time) (int, error) {
+	if p.months == 0 {
+		return 0, nil
+	}
+	cutoff := p.cutoff(now)
+	var totals []dailyTotal
+	if p.mode == retentionDaily {
+		var err error
+		if totals, err = downsampleHistory(ctx, cutoff); err != nil {
+			return 0, fmt.Errorf("downsample: %w", err)
+		}
+	}
+	return history.Prune(ctx, cutoff, totals)
+}
+
+// downsampleHistory returns the daily totals of the space and of every
+// zone for the days from the previous cutoff, or the first transition,
+// until cutoff.
+func downsampleHistory(ctx context.Context, cutoff time.Time) ([]dailyTotal, error) {
+	tz := liveSettings().location
+	start, err := history.PrunedBefore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if start.IsZero() {
+		first, err := history.Query(ctx, historyFilter{Limit: 1})
+		if err != nil || len(first) == 0 {
+			return nil, err
+		}
+		local := first[0].Time.In(tz)
+		start = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz)
+	}
+	if !start.Before(cutoff) {
+		return nil, nil
+	}
+
+	names := []string{""} // the space as a whole
+	seen := make(map[string]bool)
+	for _, z := range zones {
+		seen[z.name] = true
+		names = append(names, z.name)
+	}
+	err = history.Each(ctx, historyFilter{Since: start, Until: cutoff}, func(t transition) error {
+		if !seen[t.Zone] {
+			seen[t.Zone] = true
+			names = append(names, t.Zone)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []dailyTotal
+	for _, name := range names {
+		intervals, err := openIntervals(ctx, name, start, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		open, openings := splitByDay(intervals)
+		for day := start; day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+			key := dayPeriod(day)
+			totals = append(totals, dailyTotal{Day: key, Zone: name,
+				OpenSeconds: int64(open[key] / time.Second), Openings: openings[key]})
+		}
+	}
+	return totals, nil
+}
+
+// splitByDay returns the open time of each day in TIMEZONE, splitting
+// intervals at midnight, and the number of openings on each.
+func splitByDay(intervals []openInterval) (map[string]time.Duration, map[string]int) {
+	tz := liveSettings().location
+	open, openings := make(map[string]time.Duration), make(map[string]int)
+	for _, in := range intervals {
+		if !in.StartClipped {
+			openings[dayPeriod(in.Start.In(tz))]++
+		}
+		for start := in.Start; start.Before(in.End); {
+			local := start.In(tz)
+			next := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz).AddDate(0, 0, 1)
+			end := in.End
+			if next.Before(end) {
+				end = next
+			}
+			open[dayPeriod(local)] += end.Sub(start)
+			start = end
+		}
+	}
+	return open, openings
+}
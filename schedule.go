@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// scheduleHorizon is how far ahead the open-hours calendar is expanded.
+const scheduleHorizon = 8 * 7 * 24 * time.Hour
+
+// openHours is a weekly recurring opening window in the schedule timezone.
+type openHours struct {
+	Weekday time.Weekday
+	Start   time.Duration // offset from midnight
+	End     time.Duration // offset from midnight; may exceed 24h for overnight windows
+}
+
+// scheduledEvent is a one-off special event.
+type scheduledEvent struct {
+	Start   time.Time
+	End     time.Time
+	Summary string
+}
+
+// occurrence is a concrete opening produced from the schedule.
+type occurrence struct {
+	UID     string
+	Start   time.Time
+	End     time.Time
+	Summary string
+}
+
+// loadScheduleLocation reads TIMEZONE, defaulting to the system timezone.
+func loadScheduleLocation(v *settingsView) (*time.Location, error) {
+	name := v.setting("TIMEZONE")
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("TIMEZONE: %w", err)
+	}
+	return loc, nil
+}
+
+// loadOpenHours parses OPEN_HOURS, a semicolon-separated list of windows
+// such as "Tue 19:00-22:00; Sat 12:00-18:00".
+func loadOpenHours(v *settingsView) ([]openHours, error) {
+	hours, err := parseOpenHours(v.setting("OPEN_HOURS"))
+	if err != nil {
+		return nil, fmt.Errorf("OPEN_HOURS: %w", err)
+	}
+	return hours, nil
+}
+
+// loadSpecialEvents parses SPECIAL_EVENTS, a semicolon-separated list of
+// entries such as "2026-10-31 18:00-23:00 Halloween build night", in loc.
+func loadSpecialEvents(v *settingsView, loc *time.Location) ([]scheduledEvent, error) {
+	events, err := parseSpecialEvents(v.setting("SPECIAL_EVENTS"), loc)
+	if err != nil {
+		return nil, fmt.Errorf("SPECIAL_EVENTS: %w", err)
+	}
+	return events, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseOpenHours(value string) ([]openHours, error) {
+	var hours []openHours
+	for _, entry := range splitList(value) {
+		fields := strings.Fields(entry)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q: expected \"<weekday> HH:MM-HH:MM\"", entry)
+		}
+		day, ok := weekdays[strings.ToLower(fields[0])[:min(3, len(fields[0]))]]
+		if !ok {
+			return nil, fmt.Errorf("%q: unknown weekday %q", entry, fields[0])
+		}
+		start, end, err := parseTimeRange(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		hours = append(hours, openHours{Weekday: day, Start: start, End: end})
+	}
+	return hours, nil
+}
+
+func parseSpecialEvents(value string, loc *time.Location) ([]scheduledEvent, error) {
+	var events []scheduledEvent
+	for _, entry := range splitList(value) {
+		fields := strings.SplitN(entry, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%q: expected \"YYYY-MM-DD HH:MM-HH:MM [summary]\"", entry)
+		}
+		day, err := time.ParseInLocation("2006-01-02", fields[0], loc)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		start, end, err := parseTimeRange(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		event := scheduledEvent{Start: addClock(day, start), End: addClock(day, end), Summary: "Special event"}
+		if len(fields) == 3 && strings.TrimSpace(fields[2]) != "" {
+			event.Summary = strings.TrimSpace(fields[2])
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// parseTimeRange parses "HH:MM-HH:MM". An end before the start is taken to
+// fall on the following day.
+func parseTimeRange(value string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time range %q must look like HH:MM-HH:MM", value)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		end += 24 * time.Hour
+	}
+	return start, end, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// addClock returns the wall-clock time offset from midnight of day, which
+// stays correct across DST changes.
+func addClock(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, int(offset/time.Minute), 0, 0, day.Location())
+}
+
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// upcomingOpenings expands the weekly schedule and special events into
+// concrete occurrences that end after from and start before from+horizon.
+func upcomingOpenings(from time.Time, horizon time.Duration) []occurrence {
+	until := from.Add(horizon)
+	var result []occurrence
+
+	schedule := liveSettings()
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, schedule.location).AddDate(0, 0, -1)
+	for ; day.Before(until); day = day.AddDate(0, 0, 1) {
+		for _, h := range schedule.openHours {
+			if day.Weekday() != h.Weekday {
+				continue
+			}
+			start, end := addClock(day, h.Start), addClock(day, h.End)
+			if end.After(from) && start.Before(until) {
+				result = append(result, occurrence{
+					UID:     fmt.Sprintf("open-%s", start.UTC().Format("20060102T150405Z")),
+					Start:   start,
+					End:     end,
+					Summary: "Open hours",
+				})
+			}
+		}
+	}
+	for _, e := range schedule.specialEvents {
+		if e.End.After(from) && e.Start.Before(until) {
+			result = append(result, occurrence{
+				UID:     fmt.Sprintf("event-%s", e.Start.UTC().Format("20060102T150405Z")),
+				Start:   e.Start,
+				End:     e.End,
+				Summary: e.Summary,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Start.Before(result[j].Start) })
+	return result
+}
+
+// handleScheduleICS serves upcoming open hours and special events as an
+// iCalendar feed members can subscribe to.
+func handleScheduleICS(w http.ResponseWriter, r *http.Request) {
+	domain := icsDomain()
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\n")
+	b.WriteString("VERSION:2.0\r\n")
+	b.WriteString("PRODID:-//splatspace//space-status//EN\r\n")
+	b.WriteString("CALSCALE:GREGORIAN\r\n")
+	b.WriteString("METHOD:PUBLISH\r\n")
+	b.WriteString("X-WR-CALNAME:Open hours\r\n")
+	for _, o := range upcomingOpenings(time.Now(), scheduleHorizon) {
+		b.WriteString("BEGIN:VEVENT\r\n")
+		writeICSLine(&b, fmt.Sprintf("UID:%s@%s", o.UID, domain))
+		fmt.Fprintf(&b, "DTSTAMP:%s\r\n", stamp)
+		fmt.Fprintf(&b, "DTSTART:%s\r\n", o.Start.UTC().Format("20060102T150405Z"))
+		fmt.Fprintf(&b, "DTEND:%s\r\n", o.End.UTC().Format("20060102T150405Z"))
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(o.Summary))
+		b.WriteString("END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// icsDomain returns the domain that makes event UIDs globally unique: the
+// host of BASE_URL, or a fixed name without it. The request's Host header
+// is not used, so UIDs do not change with the name a client used.
+func icsDomain() string {
+	if u, err := url.Parse(setting("BASE_URL")); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "space-status"
+}
+
+// icsMaxLineOctets is the longest content line RFC 5545 allows, without
+// the line break.
+const icsMaxLineOctets = 75
+
+// writeICSLine writes a content line, folding it into continuation lines
+// that start with a space so none exceeds icsMaxLineOctets. Lines are only
+// broken between UTF-8 sequences.
+func writeICSLine(b *strings.Builder, line string) {
+	limit := icsMaxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = icsMaxLineOctets - 1 // the leading space counts
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// escapeICSText escapes a TEXT property value per RFC 5545.
+func escapeICSText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// apiSchemaVersion is the semantic version of the JSON models below. Bump
+// the major version for breaking changes, the minor version for additions,
+// and record every change in apiChangelog.
+const apiSchemaVersion = "1.11.0"
+
+// schemaField describes one field of a JSON model.
+type schemaField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Since       string `json:"since"`
+	Deprecated  bool   `json:"deprecated"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// schemaModel describes a JSON document and where it appears.
+type schemaModel struct {
+	Description string        `json:"description"`
+	UsedBy      []string      `json:"used_by"`
+	Fields      []schemaField `json:"fields"`
+}
+
+// schemaChange is one entry of the changelog.
+type schemaChange struct {
+	Version  string   `json:"version"`
+	Breaking bool     `json:"breaking"`
+	Changes  []string `json:"changes"`
+}
+
+var apiModels = map[string]schemaModel{
+	"status": {
+		Description: "Current state of the space.",
+		UsedBy:      []string{"GET /status", "GET /api/v1/status"},
+		Fields: []schemaField{
+			{Name: "state", Type: "boolean", Description: "True when the space is open, to everyone or to members only.", Since: "1.0.0"},
+			{Name: "status", Type: "string", Description: `"open", "members_only", "closed", or "unknown" until the switch has been read.`, Since: "1.6.0"},
+			{Name: "text", Type: "string", Description: "Localized name of the state.", Since: "1.0.0"},
+			{Name: "message", Type: "string", Description: "Localized sentence describing the state.", Since: "1.1.0"},
+			{Name: "locale", Type: "string", Description: "Locale used for text and message.", Since: "1.1.0"},
+			{Name: "seq", Type: "integer", Description: "Sequence number of the latest event.", Since: "1.3.0"},
+			{Name: "open_since", Type: "string (RFC 3339)", Description: "When the space opened; only while open.", Since: "1.4.0"},
+			{Name: "closed_since", Type: "string (RFC 3339)", Description: "When the space closed; only while closed.", Since: "1.4.0"},
+			{Name: "next_scheduled_open", Type: "object", Description: "Next opening from the schedule with start, end, and summary; only while closed.", Since: "1.4.0"},
+			{Name: "prediction", Type: "object", Description: "Estimated next opening from recent history with time, confidence (0-1), and weeks considered; only while closed and when history allows.", Since: "1.4.0"},
+			{Name: "open_when", Type: "string", Description: `"any" or "all": how the zones make up state; only with zones.`, Since: "1.5.0"},
+			{Name: "zones", Type: "array", Description: "Each zone's name, label, state, status, text, since, and duration_seconds; only with zones.", Since: "1.5.0"},
+			{Name: "api_version", Type: "string", Description: "Version of these models, as served by /api/v1/schema.", Since: "1.9.0"},
+			{Name: "source", Type: "string", Description: `Where the state comes from: "sensor", the door switch, "agent" on a shadow instance, or "manual" while overridden through PUT /status, or "maintenance" while maintenance mode holds it (since 1.11.0).`, Since: "1.9.0"},
+			{Name: "manual", Type: "object", Description: "The override's until, omitted when it lasts until cleared, and reason; only while source is manual.", Since: "1.10.0"},
+			{Name: "maintenance", Type: "object", Description: "Maintenance mode's since and reason; only while it is on.", Since: "1.11.0"},
+			{Name: "last_changed", Type: "string (RFC 3339)", Description: "When the current state began; omitted while unknown.", Since: "1.9.0"},
+			{Name: "duration_seconds", Type: "integer", Description: "Seconds since last_changed; omitted while unknown.", Since: "1.9.0"},
+		},
+	},
+	"event": {
+		Description: "A change of a zone's state and, unless zone_only, of the space state.",
+		UsedBy:      []string{"GET /api/v1/events", "webhook state.changed data", "MQTT <prefix>/state", "MQTT <prefix>/zones/<zone>/state"},
+		Fields: []schemaField{
+			{Name: "id", Type: "string", Description: "ULID of the event; identical across every channel.", Since: "1.2.0"},
+			{Name: "seq", Type: "integer", Description: "Persisted sequence number; increases by one per event, so gaps mean missed events.", Since: "1.3.0"},
+			{Name: "state", Type: "string", Description: `"open" or "closed"; "open" for members only too.`, Since: "1.0.0"},
+			{Name: "status", Type: "string", Description: `"open", "members_only", or "closed".`, Since: "1.6.0"},
+			{Name: "open", Type: "boolean", Description: "True when the zone, and unless zone_only the space, opened, to everyone or to members only.", Since: "1.0.0"},
+			{Name: "zone", Type: "string", Description: "Area the event applies to; empty with zones when a status override changed the space.", Since: "1.2.0"},
+			{Name: "zone_only", Type: "boolean", Description: "True when the zone changed but the space as a whole did not; omitted otherwise.", Since: "1.5.0"},
+			{Name: "space_status", Type: "string", Description: "Status of the space as a whole after the event; only with zones.", Since: "1.6.0"},
+			{Name: "manual", Type: "boolean", Description: "True when a status override changed the space; omitted otherwise.", Since: "1.10.0"},
+			{Name: "time", Type: "string (RFC 3339)", Description: "When the state changed.", Since: "1.0.0"},
+			{Name: "duration_seconds", Type: "integer", Description: "Time spent in the previous state.", Since: "1.0.0"},
+		},
+	},
+	"stats": {
+		Description: "Statistics computed from the history over a time range.",
+		UsedBy:      []string{"GET /stats", "GET /api/v1/stats"},
+		Fields: []schemaField{
+			{Name: "since", Type: "string (RFC 3339)", Description: "Start of the range.", Since: "1.8.0"},
+			{Name: "until", Type: "string (RFC 3339)", Description: "End of the range, at most now.", Since: "1.8.0"},
+			{Name: "zone", Type: "string", Description: "Zone the statistics are for; omitted for the space as a whole.", Since: "1.8.0"},
+			{Name: "open_hours", Type: "object", Description: "Total open hours and per_day, per_week, and per_month lists of period and hours.", Since: "1.8.0"},
+			{Name: "sessions", Type: "object", Description: "Count, average_seconds, and longest (start, end, seconds) of openings within the range.", Since: "1.8.0"},
+			{Name: "typical_open_times", Type: "array", Description: "Per weekday with openings, the number of openings and the median_open and median_close times.", Since: "1.8.0"},
+		},
+	},
+	"transition": {
+		Description: "A change of a zone's state, as kept in the history.",
+		UsedBy:      []string{"GET /history", "GET /api/v1/history"},
+		Fields: []schemaField{
+			{Name: "event_id", Type: "string", Description: "ID of the event that recorded the change.", Since: "1.7.0"},
+			{Name: "seq", Type: "integer", Description: "Sequence number of the event.", Since: "1.7.0"},
+			{Name: "time", Type: "string (RFC 3339)", Description: "When the state changed.", Since: "1.7.0"},
+			{Name: "zone", Type: "string", Description: "Zone that changed.", Since: "1.7.0"},
+			{Name: "from", Type: "string", Description: `The zone's status before; "unknown" without an earlier one.`, Since: "1.7.0"},
+			{Name: "to", Type: "string", Description: "The zone's status after.", Since: "1.7.0"},
+			{Name: "space_from", Type: "string", Description: "Status of the space before.", Since: "1.7.0"},
+			{Name: "space_to", Type: "string", Description: "Status of the space after; equal to space_from when only the zone changed.", Since: "1.7.0"},
+			{Name: "source", Type: "string", Description: `"switch", "startup", "agent", "import", or "manual" when a status override was set or ended.`, Since: "1.7.0"},
+		},
+	},
+	"webhook": {
+		Description: "Envelope of outgoing webhook deliveries.",
+		UsedBy:      []string{"OUTGOING_WEBHOOKS"},
+		Fields: []schemaField{
+			{Name: "id", Type: "string", Description: "ULID of the delivery; the event ID for state.changed.", Since: "1.2.0"},
+			{Name: "event", Type: "string", Description: "Event type, e.g. state.changed.", Since: "1.0.0"},
+			{Name: "timestamp", Type: "string (RFC 3339)", Description: "When the event happened.", Since: "1.0.0"},
+			{Name: "data", Type: "object", Description: "Event data; an event model for state.changed.", Since: "1.0.0"},
+		},
+	},
+}
+
+var apiChangelog = []schemaChange{
+	{Version: "1.11.0", Changes: []string{
+		`Added maintenance and the "maintenance" source to status, for maintenance mode.`,
+	}},
+	{Version: "1.10.0", Changes: []string{
+		`Added the "manual" source and manual to status, manual to events, and the "manual" source to transitions, for status overrides.`,
+	}},
+	{Version: "1.9.0", Changes: []string{
+		"Added api_version, source, last_changed, and duration_seconds to status, and duration_seconds to its zones.",
+	}},
+	{Version: "1.8.0", Changes: []string{
+		"Added the stats model, served by /stats.",
+	}},
+	{Version: "1.7.0", Changes: []string{
+		"Added the transition model, served by /history.",
+	}},
+	{Version: "1.6.0", Changes: []string{
+		"Added status to status, zones, and events, adding members_only.",
+		"Added space_status to events.",
+	}},
+	{Version: "1.5.0", Changes: []string{
+		"Added open_when and zones to status.",
+		"Added zone_only to events.",
+		"Events of each zone are also published on MQTT <prefix>/zones/<zone>/state.",
+	}},
+	{Version: "1.4.0", Changes: []string{
+		"Added open_since, closed_since, next_scheduled_open, and prediction to status.",
+		"Status is also served at /api/v1/status.",
+	}},
+	{Version: "1.3.0", Changes: []string{
+		"Added seq to events and status.",
+	}},
+	{Version: "1.2.0", Changes: []string{
+		"Added id to events and webhook envelopes.",
+		"Added zone to events.",
+	}},
+	{Version: "1.1.0", Changes: []string{
+		"Added localized message and locale to status.",
+	}},
+	{Version: "1.0.0", Changes: []string{
+		"Initial version.",
+	}},
+}
+
+// handleSchema serves the version, models, and changelog of the JSON API
+// so long-lived integrations can detect breaking changes.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version":   apiSchemaVersion,
+		"models":    apiModels,
+		"changelog": apiChangelog,
+	})
+}
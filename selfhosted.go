@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"html"
+)
+
+// matrixWebhookNotifier posts announcements to a matrix-hookshot style
+// generic webhook, which relays them into a Matrix room.
+type matrixWebhookNotifier struct {
+	webhookURL string
+}
+
+// newMatrixWebhookNotifier returns a notifier posting to the given webhook URL.
+func newMatrixWebhookNotifier(webhookURL string) *matrixWebhookNotifier {
+	return &matrixWebhookNotifier{webhookURL: webhookURL}
+}
+
+// Name implements Notifier.
+func (n *matrixWebhookNotifier) Name() string {
+	return "matrix"
+}
+
+// Notify implements Notifier.
+func (n *matrixWebhookNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.webhookURL, map[string]string{
+		"text":     message,
+		"html":     "<b>" + html.EscapeString(message) + "</b>",
+		"username": "space-status",
+	})
+}
+
+// Preview implements Previewer.
+func (n *matrixWebhookNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	return previewWebhook(n.Name(), e, overrides)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session settings.
+const (
+	checkInPresence     = 3 * time.Hour // how long a check-in counts towards estimated occupancy
+	sessionsDefaultPage = 50
+)
+
+// checkIn records someone arriving during a session.
+type checkIn struct {
+	Name string    `json:"name,omitempty"`
+	By   string    `json:"by"` // principal that made the check-in
+	Time time.Time `json:"time"`
+}
+
+// sessionNote is a free-form note attached to a session.
+type sessionNote struct {
+	Text string    `json:"text"`
+	By   string    `json:"by"`
+	Time time.Time `json:"time"`
+}
+
+// sessionRecord is one period during which the space was open.
+type sessionRecord struct {
+	ID            string        `json:"id"`
+	OpenedAt      time.Time     `json:"opened_at"`
+	ClosedAt      time.Time     `json:"closed_at,omitempty"`
+	OpenEventID   string        `json:"open_event_id,omitempty"`
+	CloseEventID  string        `json:"close_event_id,omitempty"`
+	Keyholder     string        `json:"keyholder,omitempty"`
+	Tags          []string      `json:"tags"`
+	CheckIns      []checkIn     `json:"check_ins"`
+	Notes         []sessionNote `json:"notes"`
+	PeakOccupancy int           `json:"peak_occupancy"`
+}
+
+// occupancyAt estimates how many people are present at t from the
+// check-ins of the last checkInPresence.
+func (s *sessionRecord) occupancyAt(t time.Time) int {
+	n := 0
+	for _, c := range s.CheckIns {
+		if !c.Time.After(t) && t.Sub(c.Time) < checkInPresence {
+			n++
+		}
+	}
+	return n
+}
+
+// Length returns how long the session lasted, or has lasted so far.
+func (s *sessionRecord) Length(now time.Time) time.Duration {
+	if !s.ClosedAt.IsZero() {
+		return s.ClosedAt.Sub(s.OpenedAt)
+	}
+	return now.Sub(s.OpenedAt)
+}
+
+// clone returns a deep copy safe to use outside the store lock.
+func (s *sessionRecord) clone() *sessionRecord {
+	c := *s
+	c.Tags = append([]string{}, s.Tags...)
+	c.CheckIns = append([]checkIn{}, s.CheckIns...)
+	c.Notes = append([]sessionNote{}, s.Notes...)
+	return &c
+}
+
+// sessionStore keeps the current session and past sessions on disk.
+type sessionStore struct {
+	mu      sync.Mutex
+	path    string
+	current *sessionRecord   // nil while the space is closed
+	past    []*sessionRecord // oldest first
+
+	// onChange, if set, is called with a hook event type and a copy of the
+	// session after it starts, changes, or ends.
+	onChange func(kind string, s *sessionRecord)
+}
+
+var sessions = &sessionStore{path: filepath.Join(dataDir, "sessions.json")}
+
+// sessionFile is the on-disk layout of the session store.
+type sessionFile struct {
+	Current *sessionRecord   `json:"current"`
+	Past    []*sessionRecord `json:"past"`
+}
+
+// load restores sessions saved by a previous run.
+func (st *sessionStore) load() error {
+	data, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f sessionFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("parse %s: %w", st.path, err)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.current, st.past = f.Current, f.Past
+	return nil
+}
+
+// save persists the store. The caller must hold st.mu.
+func (st *sessionStore) save() error {
+	return writeJSONFile(st.path, sessionFile{Current: st.current, Past: st.past})
+}
+
+// start begins a session for an open event. A session restored from a
+// previous run continues instead.
+func (st *sessionStore) start(e Event) error {
+	st.mu.Lock()
+	if st.current != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.current = &sessionRecord{ID: e.Time.UTC().Format("20060102T150405Z"), OpenedAt: e.Time, OpenEventID: e.ID, Tags: []string{}}
+	s, err := st.current.clone(), st.save()
+	st.mu.Unlock()
+
+	st.notify(hookSessionStarted, s)
+	return err
+}
+
+// end closes the current session with a close event and returns a copy of
+// it, or nil if no session was being recorded.
+func (st *sessionStore) end(e Event) (*sessionRecord, error) {
+	st.mu.Lock()
+	s := st.current
+	if s == nil {
+		st.mu.Unlock()
+		return nil, nil
+	}
+	s.ClosedAt = e.Time
+	s.CloseEventID = e.ID
+	st.past = append(st.past, s)
+	st.current = nil
+	ended, err := s.clone(), st.save()
+	st.mu.Unlock()
+
+	st.notify(hookSessionEnded, ended)
+	return ended, err
+}
+
+// snapshot returns a copy of the current session as if it ended at t, or
+// nil while the space is closed.
+func (st *sessionStore) snapshot(at time.Time) *sessionRecord {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.current == nil {
+		return nil
+	}
+	s := st.current.clone()
+	s.ClosedAt = at
+	return s
+}
+
+// update runs fn on the session with the given ID ("current" for the open
+// session) and saves the result.
+func (st *sessionStore) update(id string, fn func(s *sessionRecord)) (*sessionRecord, bool, error) {
+	st.mu.Lock()
+	s := st.findLocked(id)
+	if s == nil {
+		st.mu.Unlock()
+		return nil, false, nil
+	}
+	fn(s)
+	updated, err := s.clone(), st.save()
+	st.mu.Unlock()
+
+	st.notify(hookSessionUpdated, updated)
+	return updated, true, err
+}
+
+// notify reports a lifecycle change to the onChange hook.
+func (st *sessionStore) notify(kind string, s *sessionRecord) {
+	if st.onChange != nil {
+		st.onChange(kind, s)
+	}
+}
+
+// get returns a copy of the session with the given ID.
+func (st *sessionStore) get(id string) (*sessionRecord, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.findLocked(id)
+	if s == nil {
+		return nil, false
+	}
+	return s.clone(), true
+}
+
+// findLocked looks up a session. The caller must hold st.mu.
+func (st *sessionStore) findLocked(id string) *sessionRecord {
+	if id == "current" || (st.current != nil && st.current.ID == id) {
+		return st.current
+	}
+	for _, s := range st.past {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// list returns up to limit sessions, newest first, skipping offset.
+func (st *sessionStore) list(offset, limit int) ([]*sessionRecord, int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	all := make([]*sessionRecord, 0, len(st.past)+1)
+	if st.current != nil {
+		all = append(all, st.current)
+	}
+	for i := len(st.past) - 1; i >= 0; i-- {
+		all = append(all, st.past[i])
+	}
+
+	var page []*sessionRecord
+	for i := offset; i < len(all) && len(page) < limit; i++ {
+		page = append(page, all[i].clone())
+	}
+	return page, len(all)
+}
+
+// sessionSummary renders the localized closing summary of a session.
+func sessionSummary(loc string, s *sessionRecord) string {
+	lines := []string{translate(loc, msgSessionSummary, formatDuration(s.Length(s.ClosedAt)), s.PeakOccupancy, len(s.CheckIns))}
+	for _, n := range s.Notes {
+		lines = append(lines, translate(loc, msgSessionNote, n.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sessionView is the API representation of a session.
+type sessionView struct {
+	*sessionRecord
+	ClosedAt *time.Time   `json:"closed_at"`
+	Open     bool         `json:"open"`
+	Stats    sessionStats `json:"stats"`
+}
+
+// sessionStats are figures derived from a session record.
+type sessionStats struct {
+	LengthSeconds      int64 `json:"length_seconds"`
+	CheckIns           int   `json:"check_ins"`
+	PeakOccupancy      int   `json:"peak_occupancy"`
+	EstimatedOccupancy int   `json:"estimated_occupancy"`
+}
+
+func viewSession(s *sessionRecord) sessionView {
+	now := time.Now()
+	v := sessionView{
+		sessionRecord: s,
+		Open:          s.ClosedAt.IsZero(),
+		Stats: sessionStats{
+			LengthSeconds: int64(s.Length(now) / time.Second),
+			CheckIns:      len(s.CheckIns),
+			PeakOccupancy: s.PeakOccupancy,
+		},
+	}
+	if v.Open {
+		v.Stats.EstimatedOccupancy = s.occupancyAt(now)
+	} else {
+		v.ClosedAt = &s.ClosedAt
+	}
+	return v
+}
+
+// handleListSessions lists sessions newest first, paginated with limit and
+// offset.
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", sessionsDefaultPage)
+	if err != nil || limit < 1 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	page, total := sessions.list(offset, limit)
+	views := []sessionView{}
+	for _, s := range page {
+		views = append(views, viewSession(s))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": views, "total": total, "offset": offset, "limit": limit})
+}
+
+// handleGetSession returns one session; "current" names the open session.
+func handleGetSession(w http.ResponseWriter, r *http.Request) {
+	s, ok := sessions.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewSession(s))
+}
+
+// handleUpdateSession sets a session's keyholder and tags.
+func handleUpdateSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Keyholder *string   `json:"keyholder"`
+		Tags      *[]string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	s, ok, err := sessions.update(r.PathValue("id"), func(s *sessionRecord) {
+		if req.Keyholder != nil {
+			s.Keyholder = strings.TrimSpace(*req.Keyholder)
+		}
+		if req.Tags != nil {
+			s.Tags = normalizeTags(*req.Tags)
+		}
+	})
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewSession(s))
+}
+
+// normalizeTags lowercases, trims, and deduplicates tags.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// handleCheckIn records an arrival in the current session.
+func handleCheckIn(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+	}
+	p, _ := authenticate(r)
+
+	var occupancy int
+	s, ok, err := sessions.update("current", func(s *sessionRecord) {
+		now := time.Now()
+		s.CheckIns = append(s.CheckIns, checkIn{Name: strings.TrimSpace(req.Name), By: p.displayName(), Time: now})
+		occupancy = s.occupancyAt(now)
+		s.PeakOccupancy = max(s.PeakOccupancy, occupancy)
+	})
+	if !ok {
+		http.Error(w, "The space is closed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"session": s.ID, "check_ins": len(s.CheckIns), "estimated_occupancy": occupancy})
+}
+
+// handleAddSessionNote attaches a note to the current session; notes are
+// included in the closing summary.
+func handleAddSessionNote(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Text) == "" {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	p, _ := authenticate(r)
+
+	id := r.PathValue("id")
+	if id == "" {
+		id = "current"
+	}
+	s, ok, err := sessions.update(id, func(s *sessionRecord) {
+		s.Notes = append(s.Notes, sessionNote{Text: strings.TrimSpace(req.Text), By: p.displayName(), Time: time.Now()})
+	})
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewSession(s))
+}
+
+// queryInt parses an optional integer query parameter.
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// shieldsCacheSeconds asks shields.io to refresh the badge every five
+// minutes, the shortest it allows.
+const shieldsCacheSeconds = 300
+
+// shieldsColors are the badge colors of each status.
+var shieldsColors = map[spaceStatus]string{
+	statusOpen:        "brightgreen",
+	statusMembersOnly: "yellow",
+	statusClosed:      "red",
+	statusUnknown:     "lightgrey",
+}
+
+// shieldsView is a shields.io endpoint badge,
+// https://shields.io/badges/endpoint-badge.
+type shieldsView struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	CacheSeconds  int    `json:"cacheSeconds"`
+}
+
+// handleShields serves the current state as a shields.io endpoint badge.
+// label overrides SHIELDS_LABEL (default "space"), zone shows one zone,
+// labelled with its name by default, and lang picks the language of the
+// message.
+func handleShields(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	label := setting("SHIELDS_LABEL")
+	if label == "" {
+		label = "space"
+	}
+	s := currentSpace().Status
+	if name := q.Get("zone"); name != "" {
+		z := zoneByName(name)
+		if z == nil {
+			http.Error(w, "Unknown zone", http.StatusNotFound)
+			return
+		}
+		s, _ = z.current()
+		label = z.label
+	}
+	if value := q.Get("label"); value != "" {
+		label = value
+	}
+	loc := negotiateLocale(q.Get("lang"), r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shieldsView{
+		SchemaVersion: 1,
+		Label:         label,
+		Message:       statusText(loc, s),
+		Color:         shieldsColors[s],
+		CacheSeconds:  shieldsCacheSeconds,
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// shutdown stops the service once SIGINT or SIGTERM has cancelled the
+// context the monitors and requests run in: it lets requests in progress
+// finish, waits for the monitors to return and for the notification
+// queues to deliver what they hold, and closes the history. It stops
+// waiting after SHUTDOWN_TIMEOUT in all; deliveries still pending are
+// replayed from the write-ahead log at the next start.
+func shutdown(servers []*http.Server, monitors *supervisor) {
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	if systemd != nil {
+		systemd.notify("STOPPING=1")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Warn("Requests still running; closing their connections", "component", "http", "err", err)
+			s.Close()
+		}
+	}
+	if !monitors.wait(ctx) {
+		slog.Warn("Monitor did not stop in time", "component", "switch")
+	}
+	if n := notifyLog.drain(ctx); n > 0 {
+		slog.Warn("Deliveries still pending; they are replayed at the next start", "component", "notify", "deliveries", n)
+	}
+	shutdownOTLP(ctx)
+
+	if err := history.Close(); err != nil {
+		slog.Error("Failed to close history", "component", "history", "err", err)
+	}
+	notifyLog.close()
+	slog.Info("Stopped")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// signalDialTimeout bounds connecting to the signal-cli socket.
+const signalDialTimeout = 5 * time.Second
+
+// signalNotifier sends announcements to a Signal group through a local
+// signal-cli daemon (`signal-cli daemon --socket`) speaking JSON-RPC.
+type signalNotifier struct {
+	socket  string
+	account string // optional, for daemons serving several accounts
+	groupID string
+}
+
+// newSignalNotifier returns a notifier for the given socket path and group.
+func newSignalNotifier(socket, account, groupID string) *signalNotifier {
+	return &signalNotifier{socket: socket, account: account, groupID: groupID}
+}
+
+// Name implements Notifier.
+func (n *signalNotifier) Name() string {
+	return "signal"
+}
+
+// Notify implements Notifier.
+func (n *signalNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	params := map[string]string{"groupId": n.groupID, "message": message}
+	if n.account != "" {
+		params["account"] = n.account
+	}
+	return n.call(ctx, "send", params, e.ID)
+}
+
+// Preview implements Previewer.
+func (n *signalNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: n.groupID, Text: message}}, nil
+}
+
+// signalRPCError is a JSON-RPC error returned by signal-cli.
+type signalRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *signalRPCError) Error() string {
+	return fmt.Sprintf("signal-cli error %d: %s", e.Code, e.Message)
+}
+
+// call sends one JSON-RPC request over a fresh connection and waits for
+// its response, skipping notifications about incoming messages.
+func (n *signalNotifier) call(ctx context.Context, method string, params interface{}, id string) error {
+	dialer := net.Dialer{Timeout: signalDialTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", n.socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if id == "" {
+		id = newULID(time.Now())
+	}
+	req := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": id}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var resp struct {
+			ID    string          `json:"id"`
+			Error *signalRPCError `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil || resp.ID != id {
+			continue
+		}
+		if resp.Error != nil {
+			// -32700 to -32600 are malformed requests; retrying cannot help.
+			if resp.Error.Code >= -32700 && resp.Error.Code <= -32600 {
+				return permanent(resp.Error)
+			}
+			return resp.Error
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("signal-cli closed the connection without responding")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/slack-go/slack"
+)
+
+// slackNotifier posts announcements to a Slack channel, or to the zone's
+// channel where one is configured.
+type slackNotifier struct {
+	api     *slack.Client
+	channel string
+	actions []actionLink
+}
+
+// newSlackNotifier returns a notifier posting to channel with the given bot
+// token. Announcements carry the actions as link buttons.
+func newSlackNotifier(token, channel string, actions []actionLink) *slackNotifier {
+	return &slackNotifier{api: slack.New(token), channel: channel, actions: actions}
+}
+
+// Name implements Notifier.
+func (n *slackNotifier) Name() string {
+	return "slack"
+}
+
+// Notify implements Notifier.
+func (n *slackNotifier) Notify(ctx context.Context, e Event) error {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, nil)
+	if err != nil {
+		return err
+	}
+	return postSlackAnnouncement(ctx, n.api, zoneSlackChannel(e.Zone, n.channel), message, zoneSummary(lang, e), n.actions)
+}
+
+// Preview implements Previewer.
+func (n *slackNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: zoneSlackChannel(e.Zone, n.channel),
+		Text: withSlackDetail(message, zoneSummary(lang, e))}}, nil
+}
+
+// slackDMNotifier sends announcements as direct messages to opted-in users,
+// honoring their subscription type and quiet hours.
+type slackDMNotifier struct {
+	api     *slack.Client
+	actions []actionLink
+}
+
+// newSlackDMNotifier returns a notifier sending DMs with the given bot
+// token. Announcements carry the actions as link buttons.
+func newSlackDMNotifier(token string, actions []actionLink) *slackDMNotifier {
+	return &slackDMNotifier{api: slack.New(token), actions: actions}
+}
+
+// Name implements Notifier.
+func (n *slackDMNotifier) Name() string {
+	return "slack-dm"
+}
+
+// Notify implements Notifier.
+func (n *slackDMNotifier) Notify(ctx context.Context, e Event) error {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, nil)
+	if err != nil {
+		return err
+	}
+	users := subscribersFor(e)
+	var errs []error
+	for _, userID := range users {
+		if err := postSlackAnnouncement(ctx, n.api, userID, message, zoneSummary(lang, e), n.actions); err != nil {
+			errs = append(errs, fmt.Errorf("DM to %s: %w", userID, err))
+		}
+	}
+	// Retrying after a partial failure would DM the other users twice.
+	if len(errs) > 0 && len(errs) < len(users) {
+		return permanent(errors.Join(errs...))
+	}
+	return errors.Join(errs...)
+}
+
+// Preview implements Previewer.
+func (n *slackDMNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	lang := liveSettings().locale
+	message, err := renderMessage(lang, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	message = withSlackDetail(message, zoneSummary(lang, e))
+	var plan []plannedMessage
+	for _, userID := range subscribersFor(e) {
+		plan = append(plan, plannedMessage{Notifier: n.Name(), Destination: userID, Text: message})
+	}
+	return plan, nil
+}
+
+// postSlackMessage posts a message to a channel or, given a user ID, to that
+// user's direct message conversation with the bot.
+func postSlackMessage(ctx context.Context, api *slack.Client, channel, message string) error {
+	_, _, err := api.PostMessageContext(ctx, channel, slack.MsgOptionText(message, false))
+	return classifySlackError(err)
+}
+
+// postSlackAnnouncement posts an announcement with the detail, such as the
+// state of every zone, as a context line and the actions as Block Kit link
+// buttons below it. The plain text stays as the notification fallback.
+func postSlackAnnouncement(ctx context.Context, api *slack.Client, channel, message, detail string, actions []actionLink) error {
+	if len(actions) == 0 && detail == "" {
+		return postSlackMessage(ctx, api, channel, message)
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, message, false, false), nil, nil),
+	}
+	if detail != "" {
+		blocks = append(blocks, slack.NewContextBlock("detail", slack.NewTextBlockObject(slack.MarkdownType, detail, false, false)))
+	}
+	if len(actions) > 0 {
+		buttons := make([]slack.BlockElement, len(actions))
+		for i, a := range actions {
+			button := slack.NewButtonBlockElement(fmt.Sprintf("link-%d", i), "",
+				slack.NewTextBlockObject(slack.PlainTextType, a.label(liveSettings().locale), false, false))
+			button.URL = a.URL
+			buttons[i] = button
+		}
+		blocks = append(blocks, slack.NewActionBlock("links", buttons...))
+	}
+	_, _, err := api.PostMessageContext(ctx, channel, slack.MsgOptionText(message, false), slack.MsgOptionBlocks(blocks...))
+	return classifySlackError(err)
+}
+
+// withSlackDetail appends the detail to a previewed message, as it appears
+// below the announcement.
+func withSlackDetail(message, detail string) string {
+	if detail == "" {
+		return message
+	}
+	return message + "\n" + detail
+}
+
+// classifySlackError maps Slack rate limiting to a retry delay and API
+// errors such as channel_not_found to permanent failures.
+func classifySlackError(err error) error {
+	var rateLimited *slack.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		return &httpStatusError{StatusCode: http.StatusTooManyRequests, Body: err.Error(), retryAfter: rateLimited.RetryAfter}
+	}
+	var apiErr slack.SlackErrorResponse
+	if errors.As(err, &apiErr) {
+		switch apiErr.Err {
+		case "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+			return err
+		}
+		return permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// slackAuthCacheTTL is how long a checked Slack user token is trusted
+// before asking Slack again.
+const slackAuthCacheTTL = 5 * time.Minute
+
+// slackAuthProvider accepts Slack user tokens (xoxp-...) of members of the
+// bot's workspace, so admins can use their Slack identity. Scopes come
+// from SLACK_AUTH_USERS, where "*" applies to every member.
+type slackAuthProvider struct {
+	users map[string]map[string]bool // user ID -> scopes
+
+	mu    sync.Mutex
+	cache map[[32]byte]slackAuthResult
+}
+
+type slackAuthResult struct {
+	p       principal
+	ok      bool
+	expires time.Time
+}
+
+func newSlackAuthProvider(users map[string]map[string]bool) *slackAuthProvider {
+	return &slackAuthProvider{users: users, cache: make(map[[32]byte]slackAuthResult)}
+}
+
+// Name implements authProvider.
+func (p *slackAuthProvider) Name() string { return "slack" }
+
+// Authenticate implements authProvider.
+func (p *slackAuthProvider) Authenticate(ctx context.Context, token string) (principal, bool) {
+	if !strings.HasPrefix(token, "xoxp-") {
+		return principal{}, false
+	}
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	p.mu.Lock()
+	for k, r := range p.cache {
+		if now.After(r.expires) {
+			delete(p.cache, k)
+		}
+	}
+	cached, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		return cached.p, cached.ok
+	}
+
+	result := slackAuthResult{expires: now.Add(slackAuthCacheTTL)}
+	ctx, cancel := context.WithTimeout(ctx, slackAuthCheckTime)
+	defer cancel()
+	resp, err := slack.New(token).AuthTestContext(ctx)
+	slackAuth.Lock()
+	teamID := slackAuth.teamID
+	slackAuth.Unlock()
+	switch {
+	case err != nil:
+		// Not cached: the error may be Slack being unreachable.
+		slog.Error("Rejecting Slack token", "component", "auth", "err", err)
+		return principal{}, false
+	case teamID == "" || resp.TeamID != teamID:
+		slog.Warn("Rejecting Slack token from another workspace", "component", "auth", "user", resp.UserID, "team", resp.TeamID)
+	default:
+		result.p, result.ok = p.principal(resp.UserID), true
+	}
+	p.mu.Lock()
+	p.cache[key] = result
+	p.mu.Unlock()
+	return result.p, result.ok
+}
+
+// principal returns the principal a member of the workspace acts as, with
+// the scopes SLACK_AUTH_USERS grants them.
+func (p *slackAuthProvider) principal(userID string) principal {
+	scopes := make(map[string]bool)
+	for _, key := range []string{"*", userID} {
+		for scope := range p.users[key] {
+			scopes[scope] = true
+		}
+	}
+	return principal{Name: "slack:" + userID, Scopes: scopes, Member: "slack:" + userID}
+}
+
+// slackUserHas reports whether SLACK_AUTH_USERS grants the Slack user
+// scope, for slash commands that need the same rights as the HTTP API.
+// Without SLACK_AUTH_USERS nobody has any.
+func slackUserHas(userID, scope string) bool {
+	for _, p := range liveSettings().authProviders {
+		if slack, ok := p.(*slackAuthProvider); ok && slack.principal(userID).has(scope) {
+			return true
+		}
+	}
+	return false
+}
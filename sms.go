@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMS defaults.
+const (
+	smsDefaultMinInterval   = 15 * time.Minute
+	smsDefaultMonthlyBudget = 100
+	smsMaxLength            = 320 // two concatenated segments
+)
+
+// twilioAPI is the Twilio REST API base URL.
+const twilioAPI = "https://api.twilio.com/2010-04-01"
+
+// smsNotifier texts announcements to a short list of numbers through
+// Twilio. Each number gets at most one message per minInterval, and no
+// more than monthlyBudget messages are sent per calendar month.
+type smsNotifier struct {
+	accountSID    string
+	authToken     string
+	from          string
+	numbers       []string
+	minInterval   time.Duration
+	monthlyBudget int
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	budget   smsBudget
+	path     string
+}
+
+// smsBudget is the persisted count of messages sent this month.
+type smsBudget struct {
+	Month string `json:"month"` // YYYY-MM
+	Sent  int    `json:"sent"`
+}
+
+// newSMSNotifier returns a notifier sending from the given Twilio number or
+// messaging service SID.
+func newSMSNotifier(accountSID, authToken, from string, numbers []string, minInterval time.Duration, monthlyBudget int) (*smsNotifier, error) {
+	n := &smsNotifier{
+		accountSID:    accountSID,
+		authToken:     authToken,
+		from:          from,
+		numbers:       numbers,
+		minInterval:   minInterval,
+		monthlyBudget: monthlyBudget,
+		lastSent:      make(map[string]time.Time),
+		path:          filepath.Join(dataDir, "sms_budget.json"),
+	}
+	data, err := os.ReadFile(n.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &n.budget); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", n.path, err)
+		}
+	}
+	return n, nil
+}
+
+// Name implements Notifier.
+func (n *smsNotifier) Name() string {
+	return "sms"
+}
+
+// Notify implements Notifier. Numbers texted within minInterval are
+// skipped, which also keeps a retry from texting numbers that already
+// received this event.
+func (n *smsNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	message = truncateRunes(message, smsMaxLength)
+
+	var errs []error
+	for _, number := range n.numbers {
+		ok, reason := n.reserve(number, time.Now())
+		if !ok {
+			slog.Info("Not texting", "component", "sms", "to", maskPhone(number), "reason", reason)
+			continue
+		}
+		if err := n.send(ctx, number, message); err != nil {
+			n.release(number)
+			errs = append(errs, fmt.Errorf("%s: %w", maskPhone(number), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Preview implements Previewer.
+func (n *smsNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	var plan []plannedMessage
+	for _, number := range n.numbers {
+		plan = append(plan, plannedMessage{Notifier: n.Name(), Destination: maskPhone(number), Text: truncateRunes(message, smsMaxLength)})
+	}
+	return plan, nil
+}
+
+// reserve claims one message of budget for number, or reports why it
+// cannot be sent.
+func (n *smsNotifier) reserve(number string, now time.Time) (bool, string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if last, ok := n.lastSent[number]; ok && now.Sub(last) < n.minInterval {
+		return false, fmt.Sprintf("rate limited, last message %s ago", now.Sub(last).Round(time.Second))
+	}
+	if month := now.Format("2006-01"); month != n.budget.Month {
+		n.budget = smsBudget{Month: month}
+	}
+	if n.budget.Sent >= n.monthlyBudget {
+		opsAlerts.Alert("sms-budget-"+n.budget.Month, fmt.Sprintf("SMS budget of %d messages for %s is used up; texts are paused until next month", n.monthlyBudget, n.budget.Month))
+		return false, "monthly budget exhausted"
+	}
+
+	n.lastSent[number] = now
+	n.budget.Sent++
+	if err := writeJSONFile(n.path, n.budget); err != nil {
+		slog.Error("Failed to save SMS budget", "component", "sms", "err", err)
+	}
+	return true, ""
+}
+
+// release returns a reservation after a failed send.
+func (n *smsNotifier) release(number string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.lastSent, number)
+	if n.budget.Sent > 0 {
+		n.budget.Sent--
+	}
+	if err := writeJSONFile(n.path, n.budget); err != nil {
+		slog.Error("Failed to save SMS budget", "component", "sms", "err", err)
+	}
+}
+
+// send creates one message through the Twilio Messages API.
+func (n *smsNotifier) send(ctx context.Context, to, body string) error {
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(n.from, "MG") {
+		form.Set("MessagingServiceSid", n.from)
+	} else {
+		form.Set("From", n.from)
+	}
+
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPI, url.PathEscape(n.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return permanent(err)
+	}
+	req.SetBasicAuth(n.accountSID, n.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doWebhookRequest(req)
+}
+
+// maskPhone hides all but the last four digits of a number for logs.
+func maskPhone(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+)
+
+// Soak test settings.
+const (
+	soakDefaultDuration = time.Hour
+	soakEdgeInterval    = 200 * time.Millisecond // between edges on the fake pin
+	soakRequestInterval = 10 * time.Millisecond
+	soakClients         = 4
+	soakSampleInterval  = 10 * time.Second
+	soakMaxSamples      = 360
+	soakGoroutineSlack  = 50 // growth over the baseline treated as a leak
+	soakFDSlack         = 20
+)
+
+// soakFlag reports whether the hidden --soak[=DURATION] flag was given.
+func soakFlag(args []string) (time.Duration, bool) {
+	for _, arg := range args {
+		if arg == "--soak" {
+			return soakDefaultDuration, true
+		}
+		if v, ok := strings.CutPrefix(arg, "--soak="); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				fatal("Invalid --soak duration", "value", v)
+			}
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// soakSample is one measurement taken during a soak run.
+type soakSample struct {
+	Time       time.Time `json:"time"`
+	Goroutines int       `json:"goroutines"`
+	OpenFDs    int       `json:"open_fds"` // -1 where /proc is unavailable
+	HeapBytes  uint64    `json:"heap_bytes"`
+	Edges      int64     `json:"edges"`
+	Notified   int64     `json:"notified"`
+	Requests   int64     `json:"requests"`
+	Errors     int64     `json:"errors"`
+}
+
+// soakStats collects counters and samples for /debug/soak.
+type soakStats struct {
+	edges, notified, requests, errors atomic.Int64
+
+	mu      sync.Mutex
+	samples []soakSample
+}
+
+func (s *soakStats) sample() soakSample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	sample := soakSample{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		OpenFDs:    openFDs(),
+		HeapBytes:  mem.HeapAlloc,
+		Edges:      s.edges.Load(),
+		Notified:   s.notified.Load(),
+		Requests:   s.requests.Load(),
+		Errors:     s.errors.Load(),
+	}
+	s.mu.Lock()
+	s.samples = append(s.samples, sample)
+	if len(s.samples) > soakMaxSamples {
+		s.samples = s.samples[1:]
+	}
+	s.mu.Unlock()
+	return sample
+}
+
+func (s *soakStats) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.samples)
+}
+
+// soakNotifier renders every announcement and counts it instead of
+// sending it.
+type soakNotifier struct {
+	stats *soakStats
+}
+
+func (n *soakNotifier) Name() string { return "soak" }
+
+func (n *soakNotifier) Notify(ctx context.Context, e Event) error {
+	if _, err := renderMessage(liveSettings().locale, e, nil); err != nil {
+		return err
+	}
+	n.stats.notified.Add(1)
+	return nil
+}
+
+// runSoak exercises the service for d with synthetic switch edges and API
+// traffic, sampling goroutine and file descriptor counts to catch leaks
+// before a release is left running for months. It works in a temporary
+// directory and sends nothing. The exit code is 1 if a leak was detected.
+func runSoak(d time.Duration) int {
+	dir, err := os.MkdirTemp("", "space-status-soak")
+	if err != nil {
+		fatal("Failed to create soak directory", "err", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		fatal("Failed to enter soak directory", "err", err)
+	}
+	settings := defaultRuntimeSettings
+	settings.adminToken, _ = randomHex(16)
+	settings.apply()
+
+	stats := &soakStats{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fatal("Failed to listen", "err", err)
+	}
+	base := "http://" + listener.Addr().String()
+
+	notifiers := newNotifierRegistry()
+	notifiers.Register(newQueuedNotifier(&soakNotifier{stats: stats}))
+	hooks, err := parseWebhookSubscribers(base+"/soak/hook events=state.changed,session.started,session.updated,session.ended", "soak")
+	if err != nil {
+		fatal("Failed to set up soak webhook", "err", err)
+	}
+	for _, hook := range hooks {
+		hook.start()
+		notifiers.Register(hook)
+	}
+	sessions.onChange = func(kind string, s *sessionRecord) {
+		for _, hook := range hooks {
+			hook.PublishSession(kind, s)
+		}
+	}
+
+	registerRoutes(notifiers, nil, nil)
+	http.HandleFunc("GET /debug/soak", stats.serve)
+	http.HandleFunc("POST /soak/hook", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	go http.Serve(listener, instrumentHTTP(http.DefaultServeMux))
+	slog.Info("Soak test running", "component", "soak", "duration", d, "samples", base+"/debug/soak")
+
+	pin := &gpiotest.Pin{N: "SOAK", Fn: string(gpio.IN), L: gpio.High, EdgesChan: make(chan gpio.Level)}
+	go monitorSwitch(context.Background(), zones[0], &zonePins{main: pin, edges: true}, notifiers, startupAnnounceChanged)
+	go func() {
+		level := pin.Read()
+		for range time.Tick(soakEdgeInterval) {
+			level = !level
+			pin.EdgesChan <- level
+			stats.edges.Add(1)
+		}
+	}()
+
+	paths := []string{
+		"GET /status",
+		"GET /schedule.ics",
+		"GET /api/v1/schema",
+		"GET /metrics",
+		"GET /api/v1/events?since_seq=0&limit=10",
+		"GET /api/v1/sessions?limit=5",
+		"GET /api/v1/sessions/current",
+		"POST /api/v1/checkin",
+		"GET /dashboard/templates.html",
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for i := 0; i < soakClients; i++ {
+		go func(i int) {
+			for n := i; ; n++ {
+				method, path, _ := strings.Cut(paths[n%len(paths)], " ")
+				req, _ := http.NewRequest(method, base+path, strings.NewReader("{}"))
+				req.Header.Set("Authorization", "Bearer "+liveSettings().adminToken)
+				resp, err := client.Do(req)
+				stats.requests.Add(1)
+				if err != nil || resp.StatusCode >= 500 {
+					stats.errors.Add(1)
+				}
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				time.Sleep(soakRequestInterval)
+			}
+		}(i)
+	}
+
+	baseline := stats.sample()
+	leaked := false
+	ticker := time.NewTicker(soakSampleInterval)
+	defer ticker.Stop()
+	deadline := time.After(d)
+	for {
+		select {
+		case <-ticker.C:
+			s := stats.sample()
+			slog.Info("Soak sample", "component", "soak", "goroutines", s.Goroutines, "fds", s.OpenFDs, "heap_kib", s.HeapBytes/1024,
+				"edges", s.Edges, "notified", s.Notified, "requests", s.Requests, "errors", s.Errors)
+			if problem := soakLeak(baseline, s); problem != "" {
+				slog.Warn("Possible leak", "component", "soak", "problem", problem)
+				leaked = true
+			}
+		case <-deadline:
+			s := stats.sample()
+			if problem := soakLeak(baseline, s); problem != "" || leaked {
+				slog.Error("Soak test failed", "component", "soak", "problem", problem)
+				return 1
+			}
+			slog.Info("Soak test passed", "component", "soak", "edges", s.Edges, "requests", s.Requests, "errors", s.Errors)
+			return 0
+		}
+	}
+}
+
+// soakLeak compares a sample with the baseline and describes any growth
+// beyond the allowed slack.
+func soakLeak(baseline, s soakSample) string {
+	var problems []string
+	if s.Goroutines > baseline.Goroutines+soakGoroutineSlack {
+		problems = append(problems, fmt.Sprintf("goroutines grew from %d to %d", baseline.Goroutines, s.Goroutines))
+	}
+	if baseline.OpenFDs >= 0 && s.OpenFDs > baseline.OpenFDs+soakFDSlack {
+		problems = append(problems, fmt.Sprintf("open fds grew from %d to %d", baseline.OpenFDs, s.OpenFDs))
+	}
+	return strings.Join(problems, "; ")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// spaceAPIContactKeys are the string-valued contact fields of SpaceAPI v14.
+var spaceAPIContactKeys = map[string]bool{
+	"phone": true, "sip": true, "irc": true, "twitter": true, "mastodon": true,
+	"facebook": true, "identica": true, "foursquare": true, "email": true,
+	"ml": true, "xmpp": true, "issue_mail": true, "gopher": true, "matrix": true,
+	"mumble": true,
+}
+
+// spaceAPIConfig holds the static parts of the SpaceAPI document.
+type spaceAPIConfig struct {
+	Space    string
+	Logo     string
+	URL      string
+	Address  string
+	Lat, Lon float64
+	Contact  map[string]string
+}
+
+// loadSpaceAPIConfig reads SPACEAPI_* settings. It returns nil when
+// SPACEAPI_SPACE is unset, which disables the endpoint.
+func loadSpaceAPIConfig() (*spaceAPIConfig, error) {
+	space := setting("SPACEAPI_SPACE")
+	if space == "" {
+		return nil, nil
+	}
+	c := &spaceAPIConfig{
+		Space:   space,
+		Logo:    getEnv("SPACEAPI_LOGO"),
+		URL:     getEnv("SPACEAPI_URL"),
+		Address: setting("SPACEAPI_ADDRESS"),
+		Contact: make(map[string]string),
+	}
+	var err error
+	if c.Lat, err = strconv.ParseFloat(getEnv("SPACEAPI_LAT"), 64); err != nil {
+		return nil, fmt.Errorf("SPACEAPI_LAT: %w", err)
+	}
+	if c.Lon, err = strconv.ParseFloat(getEnv("SPACEAPI_LON"), 64); err != nil {
+		return nil, fmt.Errorf("SPACEAPI_LON: %w", err)
+	}
+	for _, entry := range splitList(setting("SPACEAPI_CONTACT")) {
+		key, value, ok := strings.Cut(entry, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !spaceAPIContactKeys[key] || value == "" {
+			return nil, fmt.Errorf("SPACEAPI_CONTACT: invalid entry %q", entry)
+		}
+		c.Contact[key] = value
+	}
+	return c, nil
+}
+
+// handleSpaceAPI serves a SpaceAPI v14 document (https://spaceapi.io).
+func handleSpaceAPI(c *spaceAPIConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		location := map[string]interface{}{"lat": c.Lat, "lon": c.Lon}
+		if c.Address != "" {
+			location["address"] = c.Address
+		}
+		cur := currentSpace()
+		spaceState := map[string]interface{}{
+			"message": statusMessage(liveSettings().locale, cur.Status),
+		}
+		// Leaving out open marks the state as undefined.
+		if cur.Status != statusUnknown {
+			spaceState["open"] = cur.Status.open()
+		}
+		if !cur.Since.IsZero() {
+			spaceState["lastchange"] = cur.Since.Unix()
+		}
+
+		// Directories and apps fetch this from other origins.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		data, err := json.Marshal(map[string]interface{}{
+			"api_compatibility": []string{"14"},
+			"space":             c.Space,
+			"logo":              c.Logo,
+			"url":               c.URL,
+			"location":          location,
+			"contact":           c.Contact,
+			"state":             spaceState,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode document", http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, weakETag(data), cur.Since) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(data, '\n'))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "fmt"
+
+// spaceStatus is the state of the space or of a zone. The values are
+// ordered from least to most open, so the space is open to the degree its
+// most open zone is, or with SPACE_OPEN_WHEN=all its least open one.
+type spaceStatus int
+
+const (
+	// statusUnknown is the state of a switch that has not been read.
+	statusUnknown spaceStatus = iota
+	statusClosed
+	// statusMembersOnly is open to members but not to the public, selected
+	// with a members-only pin.
+	statusMembersOnly
+	statusOpen
+)
+
+// spaceStatuses are the names used in the API, events, and the agent feed.
+var spaceStatuses = map[spaceStatus]string{
+	statusUnknown:     "unknown",
+	statusClosed:      "closed",
+	statusMembersOnly: "members_only",
+	statusOpen:        "open",
+}
+
+// statusFromOpen returns the status of a switch without a members-only pin.
+func statusFromOpen(open bool) spaceStatus {
+	if open {
+		return statusOpen
+	}
+	return statusClosed
+}
+
+// parseSpaceStatus parses a status name.
+func parseSpaceStatus(name string) (spaceStatus, error) {
+	for s, n := range spaceStatuses {
+		if n == name {
+			return s, nil
+		}
+	}
+	return statusUnknown, fmt.Errorf("unknown status %q; use open, members_only, or closed", name)
+}
+
+// String returns the status name.
+func (s spaceStatus) String() string {
+	return spaceStatuses[s]
+}
+
+// MarshalText implements encoding.TextMarshaler, so the status appears in
+// JSON by name.
+func (s spaceStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *spaceStatus) UnmarshalText(text []byte) error {
+	parsed, err := parseSpaceStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// open reports whether the space is in use, whether open to everyone or to
+// members only. It is what the open flags of the API, MQTT, HomeKit, and
+// SpaceAPI show, and what sessions follow.
+func (s spaceStatus) open() bool {
+	return s == statusOpen || s == statusMembersOnly
+}
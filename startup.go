@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Startup announcement policies, selected with STARTUP_ANNOUNCE.
+const (
+	// startupAnnounceNone announces nothing for the first reading; a
+	// change while the service was down is still recorded.
+	startupAnnounceNone = "none"
+	// startupAnnounceOps reports the first reading to the ops channel only.
+	startupAnnounceOps = "ops"
+	// startupAnnounceChanged announces the first reading like any other
+	// change if it differs from the last recorded state.
+	startupAnnounceChanged = "changed"
+)
+
+// parseStartupAnnounce validates STARTUP_ANNOUNCE, defaulting to
+// announcing changes.
+func parseStartupAnnounce(value string) (string, error) {
+	switch value {
+	case "":
+		return startupAnnounceChanged, nil
+	case startupAnnounceNone, startupAnnounceOps, startupAnnounceChanged:
+		return value, nil
+	}
+	return "", fmt.Errorf("%q is not none, ops, or changed", value)
+}
+
+// applyStartupState handles the first reading of a zone's switch after the
+// process starts, comparing it with the zone's last known state rather
+// than with the unknown initial state.
+func applyStartupState(z *zone, s spaceStatus, notifier Notifier, policy string) {
+	last, known := lastKnown.zone(z.name)
+	changed := !known || last.Status != s
+	slog.Info("Initial switch reading", "component", "switch", "zone", z.name, "state", s,
+		"changed", changed, "policy", policy)
+
+	if policy == startupAnnounceOps {
+		subject := "the space"
+		if zonesConfigured {
+			subject = z.label
+		}
+		text := fmt.Sprintf("space-status started; %s is %s", subject, statusText(defaultLocale, s))
+		switch {
+		case !known:
+			text += " (no earlier state recorded)"
+		case changed:
+			text += fmt.Sprintf(" (it was %s before the restart)", statusText(defaultLocale, last.Status))
+		}
+		opsAlerts.Alert("startup:"+z.name, text)
+	}
+
+	switch {
+	case !changed:
+		adoptSwitchState(z, s, last.Since)
+	case policy == startupAnnounceChanged:
+		applySwitchState(z, s, sourceStartup, notifier)
+	default:
+		// Keep history and sessions right without announcing.
+		applySwitchState(z, s, sourceStartup, silentNotifier{})
+	}
+}
+
+// adoptSwitchState takes over a zone's unchanged state from before a
+// restart without recording a new event. The space state is taken over
+// too, even if it no longer matches the last recorded change, e.g. when a
+// zone was added since.
+func adoptSwitchState(z *zone, s spaceStatus, since time.Time) {
+	spaceMu.Lock()
+	defer spaceMu.Unlock()
+	now := time.Now()
+	z.setState(s, since)
+	metricZoneOpen.set(boolGauge(s.open()), z.name)
+	if space := spaceStatusOf(zoneStates(nil, statusUnknown)); updateSpace(space, now) {
+		adoptSpaceState(space, now, now)
+	}
+	if err := lastKnown.save(); err != nil {
+		slog.Error("Failed to save state", "component", "switch", "err", err)
+	}
+}
+
+// silentNotifier drops events, for changes that are recorded but not
+// announced.
+type silentNotifier struct{}
+
+// Name implements Notifier.
+func (silentNotifier) Name() string { return "silent" }
+
+// Notify implements Notifier.
+func (silentNotifier) Notify(ctx context.Context, e Event) error { return nil }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// statsDefaultRange is how far back /stats looks without since.
+const statsDefaultRange = 90 * 24 * time.Hour
+
+// openInterval is a stretch of time the space or a zone was open, to
+// everyone or to members only.
+type openInterval struct {
+	Start, End time.Time
+	// StartClipped and EndClipped are set when the interval began before
+	// or ended after the range asked for, or is still going on.
+	StartClipped, EndClipped bool
+}
+
+// periodHours is the open time in one day, week, or month.
+type periodHours struct {
+	Period string  `json:"period"` // e.g. "2026-10-14", "2026-W42", or "2026-10"
+	Hours  float64 `json:"hours"`
+}
+
+// weekdayTimes is when the space typically opens and closes on a weekday.
+type weekdayTimes struct {
+	Weekday  string `json:"weekday"`
+	Openings int    `json:"openings"`
+	// MedianOpen and MedianClose are wall-clock times in TIMEZONE, e.g.
+	// "19:05".
+	MedianOpen  string `json:"median_open"`
+	MedianClose string `json:"median_close,omitempty"`
+}
+
+// openingStats summarizes the open intervals in the range.
+type openingStats struct {
+	Count          int           `json:"count"`
+	AverageSeconds int64         `json:"average_seconds"`
+	Longest        *longestStats `json:"longest,omitempty"`
+}
+
+// longestStats is the longest open interval.
+type longestStats struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Seconds int64     `json:"seconds"`
+}
+
+// statsView is the document served by /stats.
+type statsView struct {
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Zone      string    `json:"zone,omitempty"`
+	OpenHours struct {
+		Total    float64       `json:"total"`
+		PerDay   []periodHours `json:"per_day"`
+		PerWeek  []periodHours `json:"per_week"`
+		PerMonth []periodHours `json:"per_month"`
+	} `json:"open_hours"`
+	Sessions         openingStats   `json:"sessions"`
+	TypicalOpenTimes []weekdayTimes `json:"typical_open_times"`
+}
+
+// handleStats serves statistics computed from the history: open hours per
+// day, week, and month, session lengths, and typical opening and closing
+// times per weekday. since and until bound the range as for /history,
+// defaulting to the last 90 days; zone computes them for one zone rather
+// than the space as a whole.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if _, off := history.(noHistory); off {
+		http.Error(w, "History is turned off", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	since, err := parseHistoryTime(q.Get("since"), false)
+	if err != nil {
+		http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseHistoryTime(q.Get("until"), true)
+	if err != nil {
+		http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if until.IsZero() || until.After(now) {
+		until = now
+	}
+	if since.IsZero() {
+		since = until.Add(-statsDefaultRange)
+	}
+	if !since.Before(until) {
+		http.Error(w, "since must be before until", http.StatusBadRequest)
+		return
+	}
+
+	zone := q.Get("zone")
+	intervals, totals, err := statsInputs(r.Context(), zone, since, until)
+	if err != nil {
+		slog.Error("Failed to query history", "component", "history", "err", err)
+		http.Error(w, "Failed to query history", http.StatusInternalServerError)
+		return
+	}
+	view := computeStats(intervals, totals, since, until)
+	view.Zone = zone
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// statsInputs returns the open intervals between since and until and,
+// for days before the history was downsampled, the daily totals.
+func statsInputs(ctx context.Context, zone string, since, until time.Time) ([]openInterval, []dailyTotal, error) {
+	prunedBefore, err := history.PrunedBefore(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var totals []dailyTotal
+	if prunedBefore.After(since) {
+		if totals, err = history.DailyTotals(ctx, zone, since, prunedBefore); err != nil {
+			return nil, nil, err
+		}
+		since = prunedBefore
+	}
+	if !since.Before(until) {
+		return nil, totals, nil
+	}
+	intervals, err := openIntervals(ctx, zone, since, until)
+	return intervals, totals, err
+}
+
+// openIntervals returns the intervals between since and until in which the
+// zone or, with zone empty, the space was open, clipped to the range.
+func openIntervals(ctx context.Context, zone string, since, until time.Time) ([]openInterval, error) {
+	statusAfter := func(t transition) spaceStatus {
+		if zone == "" {
+			return t.SpaceTo
+		}
+		return t.To
+	}
+	// Every transition carries the space state, so the last one of any
+	// zone before the range tells the state at its start.
+	before, err := history.Query(ctx, historyFilter{Until: since, Zone: zone, NewestFirst: true, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	changes, err := history.Query(ctx, historyFilter{Since: since, Until: until, Zone: zone})
+	if err != nil {
+		return nil, err
+	}
+
+	var intervals []openInterval
+	var current *openInterval
+	if len(before) > 0 && statusAfter(before[0]).open() {
+		current = &openInterval{Start: since, StartClipped: true}
+	}
+	for _, t := range changes {
+		open := statusAfter(t).open()
+		switch {
+		case open && current == nil:
+			current = &openInterval{Start: t.Time}
+		case !open && current != nil:
+			current.End = t.Time
+			intervals = append(intervals, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		current.End, current.EndClipped = until, true
+		intervals = append(intervals, *current)
+	}
+	return intervals, nil
+}
+
+// computeStats summarizes open intervals and daily totals within since and
+// until. Sessions and typical times only come from the intervals, as the
+// totals no longer say when the space opened.
+func computeStats(intervals []openInterval, totals []dailyTotal, since, until time.Time) statsView {
+	tz := liveSettings().location
+	var view statsView
+	view.Since, view.Until = since.UTC(), until.UTC()
+
+	perDay, _ := splitByDay(intervals)
+	for _, t := range totals {
+		perDay[t.Day] += time.Duration(t.OpenSeconds) * time.Second
+	}
+	perWeek, perMonth := make(map[string]time.Duration), make(map[string]time.Duration)
+	var total time.Duration
+	for key, d := range perDay {
+		day, err := time.ParseInLocation("2006-01-02", key, tz)
+		if err != nil {
+			continue
+		}
+		total += d
+		perWeek[weekPeriod(day)] += d
+		perMonth[monthPeriod(day)] += d
+	}
+	view.OpenHours.Total = roundHours(total)
+
+	// List every period in the range, so gaps show as zero.
+	seen := make(map[string]bool)
+	first := since.In(tz)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, tz); day.Before(until); day = day.AddDate(0, 0, 1) {
+		view.OpenHours.PerDay = append(view.OpenHours.PerDay, periodHours{dayPeriod(day), roundHours(perDay[dayPeriod(day)])})
+		if w := weekPeriod(day); !seen[w] {
+			seen[w] = true
+			view.OpenHours.PerWeek = append(view.OpenHours.PerWeek, periodHours{w, roundHours(perWeek[w])})
+		}
+		if m := monthPeriod(day); !seen[m] {
+			seen[m] = true
+			view.OpenHours.PerMonth = append(view.OpenHours.PerMonth, periodHours{m, roundHours(perMonth[m])})
+		}
+	}
+
+	// Intervals cut off by the range have no real length.
+	var complete time.Duration
+	for _, in := range intervals {
+		if in.StartClipped || in.EndClipped {
+			continue
+		}
+		d := in.End.Sub(in.Start)
+		view.Sessions.Count++
+		complete += d
+		if view.Sessions.Longest == nil || int64(d/time.Second) > view.Sessions.Longest.Seconds {
+			view.Sessions.Longest = &longestStats{Start: in.Start.UTC(), End: in.End.UTC(), Seconds: int64(d / time.Second)}
+		}
+	}
+	if view.Sessions.Count > 0 {
+		view.Sessions.AverageSeconds = int64(complete / time.Duration(view.Sessions.Count) / time.Second)
+	}
+
+	view.TypicalOpenTimes = typicalOpenTimes(intervals)
+	return view
+}
+
+// typicalOpenTimes returns the median opening and closing times per
+// weekday, Monday first, for weekdays the space opened on.
+func typicalOpenTimes(intervals []openInterval) []weekdayTimes {
+	tz := liveSettings().location
+	opens, closes := make(map[time.Weekday][]time.Duration), make(map[time.Weekday][]time.Duration)
+	for _, in := range intervals {
+		if in.StartClipped {
+			continue
+		}
+		start := in.Start.In(tz)
+		opens[start.Weekday()] = append(opens[start.Weekday()], clockOf(start))
+		if !in.EndClipped {
+			closes[start.Weekday()] = append(closes[start.Weekday()], clockOf(in.End.In(tz)))
+		}
+	}
+	times := []weekdayTimes{}
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		if len(opens[day]) == 0 {
+			continue
+		}
+		t := weekdayTimes{Weekday: day.String(), Openings: len(opens[day]), MedianOpen: formatClock(median(opens[day]))}
+		if len(closes[day]) > 0 {
+			t.MedianClose = formatClock(median(closes[day]))
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// clockOf returns the wall-clock time of t as an offset from midnight.
+func clockOf(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
+
+// formatClock renders an offset from midnight, e.g. "19:05".
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// median returns the middle value of ds, which it sorts.
+func median(ds []time.Duration) time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2]
+}
+
+// roundHours converts d to hours, to two decimals.
+func roundHours(d time.Duration) float64 {
+	return float64(d.Round(36*time.Second)) / float64(time.Hour)
+}
+
+func dayPeriod(t time.Time) string   { return t.Format("2006-01-02") }
+func monthPeriod(t time.Time) string { return t.Format("2006-01") }
+
+// weekPeriod names the ISO week of t, e.g. "2026-W42".
+func weekPeriod(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Next-open prediction settings.
+const (
+	predictionWeeks    = 8   // weeks of history considered
+	predictionMinWeeks = 2   // fewer weeks of history give no prediction
+	predictionMinShare = 0.5 // share of weeks the space must have opened on a weekday
+)
+
+// Where the state served by /status comes from.
+const (
+	// statusSourceSensor is the door switch read by this instance.
+	statusSourceSensor = "sensor"
+	// statusSourceAgent is the feed of the instance a shadow follows.
+	statusSourceAgent = "agent"
+	// statusSourceManual is a status override set through PUT /status.
+	statusSourceManual = "manual"
+)
+
+// statusView is the JSON form of the current state served by /status and
+// /api/v1/status.
+type statusView struct {
+	State      bool        `json:"state"` // open, to everyone or to members only
+	Status     spaceStatus `json:"status"`
+	Text       string      `json:"text"`
+	Message    string      `json:"message"`
+	Locale     string      `json:"locale"`
+	Seq        uint64      `json:"seq"`
+	APIVersion string      `json:"api_version"` // apiSchemaVersion
+	Source     string      `json:"source"`
+	// Manual describes the status override while Source is manual.
+	Manual *manualStatusView `json:"manual,omitempty"`
+	// Maintenance describes maintenance mode while it is on; Source is
+	// maintenance while it holds the status.
+	Maintenance *maintenanceStatusView `json:"maintenance,omitempty"`
+	// LastChanged is when the current state began, whether open or closed,
+	// and DurationSeconds how long ago that was; both once known.
+	LastChanged       *time.Time      `json:"last_changed,omitempty"`
+	DurationSeconds   *int64          `json:"duration_seconds,omitempty"`
+	OpenSince         *time.Time      `json:"open_since,omitempty"`
+	ClosedSince       *time.Time      `json:"closed_since,omitempty"`
+	NextScheduledOpen *scheduledOpen  `json:"next_scheduled_open,omitempty"`
+	Prediction        *openPrediction `json:"prediction,omitempty"`
+	// With ZONES set, State is the aggregate of the zones, following
+	// OpenWhen, and Zones has each one's state.
+	OpenWhen string       `json:"open_when,omitempty"`
+	Zones    []zoneStatus `json:"zones,omitempty"`
+}
+
+// scheduledOpen is the next opening from OPEN_HOURS or SPECIAL_EVENTS.
+type scheduledOpen struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Summary string    `json:"summary"`
+}
+
+// openPrediction estimates when a closed space opens next from when it
+// opened on the same weekday in recent weeks.
+type openPrediction struct {
+	Time       time.Time `json:"time"`
+	Confidence float64   `json:"confidence"` // share of the weeks considered in which it opened that day
+	Weeks      int       `json:"weeks"`      // weeks of history considered
+}
+
+// getStatus responds with the current switch state in JSON format, or as
+// plain text like /status.txt when the Accept header prefers text/plain.
+// The human-readable fields follow the lang query parameter or
+// Accept-Language header, defaulting to the configured locale.
+func getStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	if prefersPlainText(r.Header.Get("Accept")) {
+		handleStatusText(w, r)
+		return
+	}
+	loc := negotiateLocale(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	now := time.Now()
+	cur := currentSpace()
+	view := statusView{
+		State:   cur.Status.open(),
+		Status:  cur.Status,
+		Text:    statusText(loc, cur.Status),
+		Message: statusMessage(loc, cur.Status),
+		Locale:  loc,
+		Seq:     eventSequence.current(),
+
+		APIVersion: apiSchemaVersion,
+		Source:     statusSourceSensor,
+	}
+	if shadowMode {
+		view.Source = statusSourceAgent
+	}
+	if m, ok := spaceMaintenance.active(); ok {
+		view.Maintenance = &maintenanceStatusView{Since: m.StartedAt, Reason: m.Reason}
+		if _, held := spaceMaintenance.held(); held {
+			view.Source = statusSourceMaintenance
+		}
+	}
+	if m, ok := statusOverride.active(); ok {
+		view.Source, view.Manual = statusSourceManual, &manualStatusView{Until: m.Until, Reason: m.Reason}
+	}
+	if since, ok := stateSince(cur); ok && cur.Status != statusUnknown {
+		view.LastChanged, view.DurationSeconds = &since, secondsSince(since, now)
+		if cur.Status.open() {
+			view.OpenSince = &since
+		} else {
+			view.ClosedSince = &since
+		}
+	}
+	if !cur.Status.open() {
+		view.NextScheduledOpen = nextScheduledOpen(now)
+		view.Prediction = predictNextOpen(now)
+	}
+	if zonesConfigured {
+		view.OpenWhen, view.Zones = spaceOpenWhen, zoneStatusViews(loc)
+	}
+	if notModified(w, r, view.etag(), cur.Since) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// etag derives the entity tag of the document from everything in it but
+// the durations, so it stays the same until the state, the schedule, or
+// the prediction changes.
+func (v statusView) etag() string {
+	v.DurationSeconds = nil
+	v.Zones = append([]zoneStatus(nil), v.Zones...)
+	for i := range v.Zones {
+		v.Zones[i].DurationSeconds = nil
+	}
+	data, _ := json.Marshal(v)
+	return weakETag(data)
+}
+
+// secondsSince returns the whole seconds from since until now.
+func secondsSince(since, now time.Time) *int64 {
+	seconds := int64(now.Sub(since) / time.Second)
+	return &seconds
+}
+
+// handleStatusText serves the state as a line like "OPEN since
+// 2026-10-14T19:05:00+02:00", for curl, shell scripts, and e-ink displays.
+// The first word is the status in capitals: OPEN, MEMBERS_ONLY, CLOSED, or
+// UNKNOWN, which has no since, and ends in "(manual)" while the status is
+// overridden or "(maintenance)" while maintenance holds it. With ZONES
+// set, a line per zone follows, starting with its name.
+func handleStatusText(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	cur := currentSpace()
+	since, ok := stateSince(cur)
+	line := statusLine(cur.Status, since, ok)
+	if _, manual := statusOverride.active(); manual {
+		line = strings.TrimSuffix(line, "\n") + " (manual)\n"
+	} else if _, held := spaceMaintenance.held(); held {
+		line = strings.TrimSuffix(line, "\n") + " (maintenance)\n"
+	}
+	b.WriteString(line)
+	if zonesConfigured {
+		for _, z := range zones {
+			s, since := z.current()
+			b.WriteString(z.name + " " + statusLine(s, since, !since.IsZero()))
+		}
+	}
+	if notModified(w, r, weakETag([]byte(b.String())), cur.Since) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// statusLine formats a status and when it began, in TIMEZONE.
+func statusLine(s spaceStatus, since time.Time, known bool) string {
+	line := strings.ToUpper(s.String())
+	if known && s != statusUnknown {
+		line += " since " + since.In(liveSettings().location).Format(time.RFC3339)
+	}
+	return line + "\n"
+}
+
+// prefersPlainText reports whether an Accept header ranks text/plain above
+// application/json. JSON wins ties, so it is served without a header and
+// when neither is acceptable.
+func prefersPlainText(accept string) bool {
+	return accept != "" && acceptQuality(accept, "text/plain") > acceptQuality(accept, "application/json")
+}
+
+// acceptQuality returns the q value an Accept header gives mediaType, from
+// the most specific media range matching it.
+func acceptQuality(accept, mediaType string) float64 {
+	major, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, 0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		var rank int
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case mediaType:
+			rank = 3
+		case major + "/*":
+			rank = 2
+		case "*/*":
+			rank = 1
+		default:
+			continue
+		}
+		if rank < specificity {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			if k, v, ok := strings.Cut(p, "="); ok && strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		quality, specificity = q, rank
+	}
+	return quality
+}
+
+// stateSince returns when the space entered its current state, from this
+// run or, after a restart, from the event log.
+func stateSince(cur spaceSnapshot) (time.Time, bool) {
+	if !cur.Since.IsZero() {
+		return cur.Since.UTC(), true
+	}
+	if r, ok := events.latest(cur.Status); ok {
+		return r.Time, true
+	}
+	return time.Time{}, false
+}
+
+// nextScheduledOpen returns the first scheduled opening that has not ended
+// yet, or nil if nothing is scheduled.
+func nextScheduledOpen(now time.Time) *scheduledOpen {
+	upcoming := upcomingOpenings(now, scheduleHorizon)
+	if len(upcoming) == 0 {
+		return nil
+	}
+	o := upcoming[0]
+	return &scheduledOpen{Start: o.Start.UTC(), End: o.End.UTC(), Summary: o.Summary}
+}
+
+// predictNextOpen looks at the coming week, day by day, for a weekday on
+// which the space opened in at least predictionMinShare of the recent weeks
+// and predicts the median time of day of those first openings. It returns
+// nil without enough history.
+func predictNextOpen(now time.Time) *openPrediction {
+	tz := liveSettings().location
+	local := now.In(tz)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz)
+	opens, first := events.openings(today.AddDate(0, 0, -7*predictionWeeks))
+	if first.IsZero() {
+		return nil
+	}
+
+	// First opening per calendar day, as a wall-clock offset from midnight.
+	firstOpen := make(map[string]time.Duration)
+	for _, t := range opens {
+		t = t.In(tz)
+		key := t.Format("2006-01-02")
+		if _, ok := firstOpen[key]; !ok {
+			firstOpen[key] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		}
+	}
+
+	firstDay := first.In(tz)
+	firstDay = time.Date(firstDay.Year(), firstDay.Month(), firstDay.Day(), 0, 0, 0, 0, tz)
+	for offset := 0; offset < 7; offset++ {
+		day := today.AddDate(0, 0, offset)
+		var weeks int
+		var times []time.Duration
+		for w := 1; w <= predictionWeeks; w++ {
+			past := day.AddDate(0, 0, -7*w)
+			if past.Before(firstDay) {
+				break
+			}
+			weeks++
+			if d, ok := firstOpen[past.Format("2006-01-02")]; ok {
+				times = append(times, d)
+			}
+		}
+		if weeks < predictionMinWeeks || float64(len(times)) < predictionMinShare*float64(weeks) {
+			continue
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		at := addClock(day, times[len(times)/2])
+		if !at.After(now) {
+			continue
+		}
+		return &openPrediction{Time: at.UTC(), Confidence: float64(len(times)) / float64(weeks), Weeks: weeks}
+	}
+	return nil
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscription records which state changes a user wants to be DMed about.
+type subscription struct {
+	Open  bool        `json:"open"`
+	Close bool        `json:"close"`
+	Quiet *quietHours `json:"quiet_hours,omitempty"` // nil when the user has no do-not-disturb window
+	Email string      `json:"email,omitempty"`       // address for the email notifier, if registered
+}
+
+// quietHours is a daily do-not-disturb window in the schedule timezone.
+type quietHours struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration // offset from midnight; exceeds 24h when the window spans midnight
+}
+
+// contains reports whether t falls inside the window.
+func (q quietHours) contains(t time.Time) bool {
+	t = t.In(liveSettings().location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if offset >= q.Start && offset < q.End {
+		return true
+	}
+	return q.End > 24*time.Hour && offset < q.End-24*time.Hour
+}
+
+// String formats the window as "HH:MM-HH:MM".
+func (q quietHours) String() string {
+	clock := func(d time.Duration) string {
+		d %= 24 * time.Hour
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return clock(q.Start) + "-" + clock(q.End)
+}
+
+// MarshalJSON stores the window as "HH:MM-HH:MM".
+func (q quietHours) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.String())
+}
+
+func (q *quietHours) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	start, end, err := parseTimeRange(value)
+	if err != nil {
+		return err
+	}
+	*q = quietHours{Start: start, End: end}
+	return nil
+}
+
+// wants reports whether the subscription covers an event, taking the
+// user's quiet hours into account.
+func (s subscription) wants(e Event) bool {
+	if s.Quiet != nil && s.Quiet.contains(e.Time) {
+		return false
+	}
+	if e.Open {
+		return s.Open
+	}
+	return s.Close
+}
+
+var (
+	optInUsers     = make(map[string]subscription) // keyed by Slack user ID
+	optInUsersLock sync.RWMutex
+)
+
+var subscriptionsPath = filepath.Join(dataDir, "subscriptions.json")
+
+// loadSubscriptions restores the subscriptions saved by a previous run.
+func loadSubscriptions() error {
+	data, err := os.ReadFile(subscriptionsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	subs := make(map[string]subscription)
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return fmt.Errorf("parse %s: %w", subscriptionsPath, err)
+	}
+	optInUsersLock.Lock()
+	defer optInUsersLock.Unlock()
+	optInUsers = subs
+	return nil
+}
+
+// saveSubscriptions persists the subscriptions. A failure is logged; the
+// change still applies until the next restart. The caller must hold
+// optInUsersLock.
+func saveSubscriptions() {
+	if err := writeJSONFile(subscriptionsPath, optInUsers); err != nil {
+		slog.Error("Failed to save subscriptions", "component", "slack", "err", err)
+	}
+}
+
+// parseSubscription maps the /optin argument to a subscription. An empty
+// argument subscribes to both kinds of event.
+func parseSubscription(arg string) (subscription, bool) {
+	switch strings.ToLower(strings.TrimSpace(arg)) {
+	case "", "both", "all":
+		return subscription{Open: true, Close: true}, true
+	case "open":
+		return subscription{Open: true}, true
+	case "close", "closed":
+		return subscription{Close: true}, true
+	}
+	return subscription{}, false
+}
+
+// handleOptIn handles Slack /optin command and updates user preferences.
+// The command text selects "open", "close", or "both" (the default), or sets
+// quiet hours with "quiet HH:MM-HH:MM" / "quiet off".
+func handleOptIn(w http.ResponseWriter, r *http.Request) {
+	lang := liveSettings().locale
+	userID, ok := verifySlackCommand(w, r)
+	if !ok {
+		return
+	}
+
+	text := strings.TrimSpace(r.FormValue("text"))
+	if fields := strings.Fields(text); len(fields) > 0 && strings.EqualFold(fields[0], "quiet") {
+		handleQuietHours(w, userID, fields[1:])
+		return
+	}
+	if fields := strings.Fields(text); len(fields) > 0 && strings.EqualFold(fields[0], "email") {
+		handleOptInEmail(w, userID, fields[1:])
+		return
+	}
+
+	sub, ok := parseSubscription(text)
+	if !ok {
+		respondEphemeral(w, translate(lang, msgOptInUsage))
+		return
+	}
+
+	optInUsersLock.Lock()
+	sub.Quiet = optInUsers[userID].Quiet
+	sub.Email = optInUsers[userID].Email
+	optInUsers[userID] = sub
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	audit("Updated subscription", auditSubscriberUpdated, "slack:"+userID, "member", "slack:"+userID, "open", sub.Open, "close", sub.Close)
+
+	key := msgOptInDone
+	switch {
+	case sub.Open && !sub.Close:
+		key = msgOptInOpen
+	case sub.Close && !sub.Open:
+		key = msgOptInClose
+	}
+	respondEphemeral(w, translate(lang, key, userID))
+}
+
+// handleQuietHours sets or clears a user's quiet hours. Users who are not
+// yet subscribed are opted in to both kinds of event.
+func handleQuietHours(w http.ResponseWriter, userID string, args []string) {
+	lang := liveSettings().locale
+	if len(args) != 1 {
+		respondEphemeral(w, translate(lang, msgOptInUsage))
+		return
+	}
+
+	var quiet *quietHours
+	if !strings.EqualFold(args[0], "off") {
+		start, end, err := parseTimeRange(args[0])
+		if err != nil {
+			respondEphemeral(w, translate(lang, msgOptInUsage))
+			return
+		}
+		quiet = &quietHours{Start: start, End: end}
+	}
+
+	optInUsersLock.Lock()
+	sub, ok := optInUsers[userID]
+	if !ok {
+		sub = subscription{Open: true, Close: true}
+	}
+	sub.Quiet = quiet
+	optInUsers[userID] = sub
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	audit("Updated subscription", auditSubscriberUpdated, "slack:"+userID, "member", "slack:"+userID, "quiet_hours", quiet)
+
+	if quiet == nil {
+		respondEphemeral(w, translate(lang, msgQuietClear))
+		return
+	}
+	respondEphemeral(w, translate(lang, msgQuietSet, quiet.String()))
+}
+
+// handleOptInEmail registers or removes the address the email notifier
+// sends a user's subscribed events to.
+func handleOptInEmail(w http.ResponseWriter, userID string, args []string) {
+	lang := liveSettings().locale
+	if len(args) != 1 {
+		respondEphemeral(w, translate(lang, msgOptInUsage))
+		return
+	}
+
+	email := ""
+	if !strings.EqualFold(args[0], "off") {
+		// Slack formats addresses as <mailto:a@b|a@b>.
+		raw := strings.Trim(args[0], "<>")
+		if _, label, ok := strings.Cut(raw, "|"); ok {
+			raw = label
+		}
+		addr, err := mail.ParseAddress(strings.TrimPrefix(raw, "mailto:"))
+		if err != nil {
+			respondEphemeral(w, translate(lang, msgOptInUsage))
+			return
+		}
+		email = addr.Address
+	}
+
+	optInUsersLock.Lock()
+	sub, ok := optInUsers[userID]
+	if !ok {
+		sub = subscription{Open: true, Close: true}
+	}
+	sub.Email = email
+	optInUsers[userID] = sub
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	audit("Updated subscription", auditSubscriberUpdated, "slack:"+userID, "member", "slack:"+userID, "email", sub.Email)
+
+	if email == "" {
+		respondEphemeral(w, translate(lang, msgEmailClear))
+		return
+	}
+	respondEphemeral(w, translate(lang, msgEmailSet, email))
+}
+
+// verifySlackCommand parses a slash command request and checks its
+// verification token, writing an error response if it is not genuine.
+func verifySlackCommand(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return "", false
+	}
+
+	userID := r.FormValue("user_id")
+	slackToken := r.FormValue("token")
+	keys := authKeys(r, slackToken)
+	if rejectIfBlocked(w, keys) {
+		return "", false
+	}
+	// Compared by digest, like static tokens, so the time taken does not
+	// tell how much of the token matched.
+	expected := liveSettings().verificationToken
+	want, got := sha256.Sum256([]byte(expected)), sha256.Sum256([]byte(slackToken))
+	if userID == "" || expected == "" || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		authFailures.fail(r.URL.Path, keys...)
+		http.Error(w, "Invalid user or token", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// respondEphemeral replies to a slash command with a message only the
+// invoking user can see.
+func respondEphemeral(w http.ResponseWriter, text string) {
+	response := map[string]string{
+		"response_type": "ephemeral",
+		"text":          text,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// subscriptionView is the API representation of a member's subscription.
+type subscriptionView struct {
+	Subscribed bool   `json:"subscribed"`
+	Open       bool   `json:"open"`
+	Close      bool   `json:"close"`
+	QuietHours string `json:"quiet_hours,omitempty"` // "HH:MM-HH:MM"
+	Email      string `json:"email,omitempty"`
+}
+
+// requestSlackUser returns the Slack user ID of the member the request
+// acts for. Subscriptions belong to Slack users.
+func requestSlackUser(w http.ResponseWriter, r *http.Request) (principal, string, bool) {
+	p, ok := requestMember(w, r)
+	if !ok {
+		return principal{}, "", false
+	}
+	userID, ok := strings.CutPrefix(p.Member, "slack:")
+	if !ok {
+		http.Error(w, "Subscriptions belong to Slack users; sign in with Slack", http.StatusForbidden)
+		return principal{}, "", false
+	}
+	return p, userID, true
+}
+
+// handleGetMySubscription serves the caller's subscription.
+func handleGetMySubscription(w http.ResponseWriter, r *http.Request) {
+	_, userID, ok := requestSlackUser(w, r)
+	if !ok {
+		return
+	}
+	optInUsersLock.RLock()
+	sub, subscribed := optInUsers[userID]
+	optInUsersLock.RUnlock()
+	writeSubscription(w, sub, subscribed)
+}
+
+// handlePutMySubscription replaces the caller's subscription, the API
+// equivalent of /optin.
+func handlePutMySubscription(w http.ResponseWriter, r *http.Request) {
+	p, userID, ok := requestSlackUser(w, r)
+	if !ok {
+		return
+	}
+	var req subscriptionView
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	sub, err := newSubscription(req.Open, req.Close, req.QuietHours, req.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	optInUsersLock.Lock()
+	optInUsers[userID] = sub
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	audit("Updated subscription", auditSubscriberUpdated, p.Name, "member", p.Member, "open", sub.Open, "close", sub.Close)
+	writeSubscription(w, sub, true)
+}
+
+// handleDeleteMySubscription unsubscribes the caller.
+func handleDeleteMySubscription(w http.ResponseWriter, r *http.Request) {
+	p, userID, ok := requestSlackUser(w, r)
+	if !ok {
+		return
+	}
+	optInUsersLock.Lock()
+	delete(optInUsers, userID)
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	audit("Removed subscription", auditSubscriberRemoved, p.Name, "member", p.Member)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newSubscription validates the fields of a subscription sent to the API.
+func newSubscription(open, close bool, quiet, email string) (subscription, error) {
+	if !open && !close {
+		return subscription{}, fmt.Errorf("Subscribe to open, close, or both; DELETE to unsubscribe")
+	}
+	sub := subscription{Open: open, Close: close}
+	if quiet != "" {
+		start, end, err := parseTimeRange(quiet)
+		if err != nil {
+			return subscription{}, fmt.Errorf("Invalid quiet_hours: %w", err)
+		}
+		sub.Quiet = &quietHours{Start: start, End: end}
+	}
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return subscription{}, fmt.Errorf("Invalid email: %w", err)
+		}
+		sub.Email = addr.Address
+	}
+	return sub, nil
+}
+
+func writeSubscription(w http.ResponseWriter, sub subscription, subscribed bool) {
+	view := subscriptionView{Subscribed: subscribed, Open: sub.Open, Close: sub.Close, Email: sub.Email}
+	if sub.Quiet != nil {
+		view.QuietHours = sub.Quiet.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// subscriberView is a user's subscription in the admin API.
+type subscriberView struct {
+	UserID     string `json:"user_id"` // Slack user ID, e.g. "U012ABC"
+	Open       bool   `json:"open"`
+	Close      bool   `json:"close"`
+	QuietHours string `json:"quiet_hours,omitempty"`
+	Email      string `json:"email,omitempty"`
+}
+
+func newSubscriberView(userID string, sub subscription) subscriberView {
+	view := subscriberView{UserID: userID, Open: sub.Open, Close: sub.Close, Email: sub.Email}
+	if sub.Quiet != nil {
+		view.QuietHours = sub.Quiet.String()
+	}
+	return view
+}
+
+// handleListSubscribers lists every subscribed user, by user ID, for board
+// members cleaning up after departed members.
+func handleListSubscribers(w http.ResponseWriter, r *http.Request) {
+	optInUsersLock.RLock()
+	views := make([]subscriberView, 0, len(optInUsers))
+	for userID, sub := range optInUsers {
+		views = append(views, newSubscriberView(userID, sub))
+	}
+	optInUsersLock.RUnlock()
+	sort.Slice(views, func(i, j int) bool { return views[i].UserID < views[j].UserID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// handleAddSubscriber subscribes a user, or replaces their subscription,
+// on their behalf. It responds 201 for a new subscriber.
+func handleAddSubscriber(w http.ResponseWriter, r *http.Request) {
+	var req subscriberView
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if !validSlackUserID(req.UserID) {
+		http.Error(w, "user_id must be a Slack user ID such as U012ABC", http.StatusBadRequest)
+		return
+	}
+	sub, err := newSubscription(req.Open, req.Close, req.QuietHours, req.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	optInUsersLock.Lock()
+	_, existed := optInUsers[req.UserID]
+	optInUsers[req.UserID] = sub
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	p, _ := authenticate(r)
+	audit("Updated subscription", auditSubscriberUpdated, p.Name, "member", "slack:"+req.UserID, "open", sub.Open, "close", sub.Close)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !existed {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(newSubscriberView(req.UserID, sub))
+}
+
+// handleRemoveSubscriber unsubscribes a user.
+func handleRemoveSubscriber(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("user")
+	optInUsersLock.Lock()
+	_, existed := optInUsers[userID]
+	delete(optInUsers, userID)
+	saveSubscriptions()
+	optInUsersLock.Unlock()
+	if !existed {
+		http.Error(w, "Not subscribed", http.StatusNotFound)
+		return
+	}
+	p, _ := authenticate(r)
+	audit("Removed subscription", auditSubscriberRemoved, p.Name, "member", "slack:"+userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validSlackUserID reports whether id looks like a Slack user ID: an
+// upper-case U or W followed by upper-case letters and digits.
+func validSlackUserID(id string) bool {
+	if len(id) < 3 || id[0] != 'U' && id[0] != 'W' {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribersFor returns the users who should be sent a DM for an event.
+func subscribersFor(e Event) []string {
+	optInUsersLock.RLock()
+	defer optInUsersLock.RUnlock()
+
+	var users []string
+	for userID, sub := range optInUsers {
+		if sub.wants(e) {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
+
+// emailSubscribersFor returns the registered addresses of users who want
+// an event.
+func emailSubscribersFor(e Event) []string {
+	optInUsersLock.RLock()
+	defer optInUsersLock.RUnlock()
+
+	var emails []string
+	for _, sub := range optInUsers {
+		if sub.Email != "" && sub.wants(e) {
+			emails = append(emails, sub.Email)
+		}
+	}
+	return emails
+}
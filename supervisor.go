@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Restart backoff of supervised tasks. A task that ran for
+// supervisorStableAfter before crashing starts over at the base delay.
+const (
+	supervisorBaseBackoff = time.Second
+	supervisorMaxBackoff  = time.Minute
+	supervisorStableAfter = 5 * time.Minute
+)
+
+// supervisor runs tasks in a group that can be waited for, each under
+// supervise.
+type supervisor struct {
+	ctx   context.Context
+	group errgroup.Group
+}
+
+func newSupervisor(ctx context.Context) *supervisor {
+	return &supervisor{ctx: ctx}
+}
+
+// start starts a supervised task.
+func (s *supervisor) start(name string, run func(ctx context.Context)) {
+	s.group.Go(func() error {
+		supervise(s.ctx, name, run)
+		return nil
+	})
+}
+
+// wait waits for every task to return, or until ctx is done, and
+// reports whether they did.
+func (s *supervisor) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// supervise runs a task until it returns or ctx is done. When it panics,
+// the panic is reported and the task started again after an exponential
+// backoff, so one bad event cannot end monitoring or delivery for good.
+// Each run gets a context of its own that is cancelled when the run ends,
+// which stops the goroutines it started.
+func supervise(ctx context.Context, name string, run func(ctx context.Context)) {
+	backoff := supervisorBaseBackoff
+	for {
+		start := time.Now()
+		err := runRecovered(ctx, name, run)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > supervisorStableAfter {
+			backoff = supervisorBaseBackoff
+		}
+		metricRestarts.inc(name)
+		slog.Error("Task crashed; restarting", "component", "supervisor", "task", name, "retry_in", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, supervisorMaxBackoff)
+	}
+}
+
+// runRecovered runs a task once and returns the panic it ended with, if
+// any, after reporting it.
+func runRecovered(ctx context.Context, name string, run func(ctx context.Context)) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = reportPanic(name, p)
+		}
+	}()
+	run(ctx)
+	return nil
+}
+
+// reportPanic logs a panic recovered in component with the stack trace of
+// the goroutine that panicked, which it must be called from, and alerts
+// the ops channel: once per component within the alert cooldown, so a
+// crash loop does not flood it.
+func reportPanic(component string, p any) error {
+	err := fmt.Errorf("panic: %v", p)
+	slog.Error("Recovered from panic", "component", "supervisor", "task", component, "err", err, "stack", string(debug.Stack()))
+	opsAlerts.Alert("crash:"+component, fmt.Sprintf("space-status crashed component %s: %v", component, p))
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// monitorStartTimeout bounds how long readiness waits for the first
+// switch reading, so a broken pin fails the health checks rather than
+// the start of the unit.
+const monitorStartTimeout = 30 * time.Second
+
+// systemdNotifier talks to systemd over the socket in NOTIFY_SOCKET, as
+// described in sd_notify(3), when the service runs with Type=notify.
+type systemdNotifier struct {
+	addr     *net.UnixAddr
+	watchdog time.Duration // WATCHDOG_USEC; 0 when the watchdog is off
+}
+
+// newSystemdNotifier reads the variables systemd sets for the service and
+// clears them, so they do not leak into child processes such as hooks. It
+// returns nil when not run by systemd with Type=notify.
+func newSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	usec, pid := os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID")
+	os.Unsetenv("NOTIFY_SOCKET")
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	if socket == "" {
+		return nil
+	}
+	n := &systemdNotifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+	if pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		if us, err := strconv.ParseInt(usec, 10, 64); err == nil && us > 0 {
+			n.watchdog = time.Duration(us) * time.Microsecond
+		}
+	}
+	return n
+}
+
+// notify sends state, e.g. "READY=1".
+func (n *systemdNotifier) notify(state string) error {
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// run reports the service ready once the switch has been read, or at
+// once in shadow mode, and then feeds the watchdog at half its timeout
+// for as long as every zone's monitor keeps its heartbeat. A monitor loop
+// that hangs stops the feeding, and systemd restarts the service.
+func (n *systemdNotifier) run() {
+	if !shadowMode {
+		deadline := time.Now().Add(monitorStartTimeout)
+		for monitorCheck().Status != "ok" && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	if err := n.notify("READY=1"); err != nil {
+		slog.Error("Failed to notify systemd", "component", "systemd", "err", err)
+		return
+	}
+	slog.Info("Notified systemd of readiness", "component", "systemd", "watchdog", n.watchdog)
+	if n.watchdog == 0 {
+		return
+	}
+	stalled := false
+	for range time.Tick(n.watchdog / 2) {
+		// In shadow mode the heartbeat follows the agent feed, which
+		// stalls when the primary is unreachable; restarting would not
+		// help, so the watchdog only watches the process then.
+		if check := monitorCheck(); !shadowMode && check.Status != "ok" {
+			if !stalled {
+				slog.Error("Monitor stalled; no longer feeding the systemd watchdog", "component", "systemd", "detail", check.Detail)
+			}
+			stalled = true
+			continue
+		}
+		stalled = false
+		if err := n.notify("WATCHDOG=1"); err != nil {
+			slog.Warn("Failed to feed the systemd watchdog", "component", "systemd", "err", err)
+		}
+	}
+}
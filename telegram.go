@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramAPI is the Telegram Bot API base URL.
+const telegramAPI = "https://api.telegram.org"
+
+// telegramPollTimeout is the long-poll timeout for getUpdates.
+const telegramPollTimeout = 50 * time.Second
+
+// telegramNotifier posts announcements to a Telegram chat and answers
+// /status commands sent to the bot.
+type telegramNotifier struct {
+	token  string
+	chatID string
+	client *http.Client
+}
+
+// newTelegramNotifier returns a notifier for the given bot token and chat.
+func newTelegramNotifier(token, chatID string) *telegramNotifier {
+	return &telegramNotifier{
+		token:  token,
+		chatID: chatID,
+		client: &http.Client{Timeout: telegramPollTimeout + 10*time.Second},
+	}
+}
+
+// Name implements Notifier.
+func (n *telegramNotifier) Name() string {
+	return "telegram"
+}
+
+// Notify implements Notifier.
+func (n *telegramNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+	return n.call(ctx, "sendMessage", map[string]interface{}{"chat_id": n.chatID, "text": message}, nil)
+}
+
+// Preview implements Previewer.
+func (n *telegramNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: n.chatID, Text: message}}, nil
+}
+
+// telegramResponse is the envelope of every Bot API response.
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// call invokes a Bot API method and decodes its result into out.
+func (n *telegramNotifier) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return permanent(err)
+	}
+	url := fmt.Sprintf("%s/bot%s/%s", telegramAPI, n.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error text includes the URL, and with it the bot token.
+		return fmt.Errorf("telegram %s: %s", method, strings.ReplaceAll(err.Error(), n.token, "<token>"))
+	}
+	defer resp.Body.Close()
+
+	var result telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("telegram %s: decode response: %w", method, err)
+	}
+	if !result.OK {
+		statusErr := &httpStatusError{
+			StatusCode: resp.StatusCode,
+			Body:       result.Description,
+			retryAfter: time.Duration(result.Parameters.RetryAfter) * time.Second,
+		}
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanent(statusErr)
+		}
+		return statusErr
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
+
+// telegramUpdate is the subset of an Update the command handler uses.
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From *struct {
+			LanguageCode string `json:"language_code"`
+		} `json:"from"`
+	} `json:"message"`
+}
+
+// pollCommands long-polls for updates and answers /status in any chat the
+// bot is in.
+func (n *telegramNotifier) pollCommands() {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		params := map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout / time.Second),
+			"allowed_updates": []string{"message"},
+		}
+		if err := n.call(context.Background(), "getUpdates", params, &updates); err != nil {
+			slog.Error("Telegram getUpdates failed", "component", "telegram", "err", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || !isTelegramCommand(u.Message.Text, "status") {
+				continue
+			}
+			loc := liveSettings().locale
+			if u.Message.From != nil {
+				loc = negotiateLocale(u.Message.From.LanguageCode, "")
+			}
+			reply := map[string]interface{}{
+				"chat_id": strconv.FormatInt(u.Message.Chat.ID, 10),
+				"text":    statusMessage(loc, currentSpace().Status),
+			}
+			if err := n.call(context.Background(), "sendMessage", reply, nil); err != nil {
+				slog.Error("Failed to answer Telegram /status", "component", "telegram", "err", err)
+			}
+		}
+	}
+}
+
+// isTelegramCommand reports whether text invokes command, including the
+// "/command@botname" form used in groups.
+func isTelegramCommand(text, command string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	name, _, _ := strings.Cut(fields[0], "@")
+	return name == "/"+command
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Template names, one per kind of state change.
+const (
+	templateOpen   = "open"
+	templateClosed = "closed"
+)
+
+// templateData is the value message templates are executed against.
+type templateData struct {
+	Open     bool   // open to everyone or to members only
+	State    string // localized state words
+	Status   string // open, members_only, or closed
+	Zone     string // label of the event's zone, e.g. "Woodshop"
+	Time     time.Time
+	Duration string // time spent in the previous state, e.g. "2h 15m"
+	Default  string // the built-in localized message
+	Summary  string // localized session summary on close events, otherwise empty
+	Session  *sessionRecord
+	Manual   bool   // set by a status override rather than the switch
+	Reason   string // the reason given for the override, if any
+
+	// SpaceOpen and SpaceState are the state of the space as a whole after
+	// the event, which differs from the zone's when other zones keep it
+	// open or closed.
+	SpaceOpen  bool
+	SpaceState string       // localized state words
+	Zones      []zoneStatus // every zone's state, when ZONES is set
+}
+
+// templateVersion is one saved revision of a message template. An empty
+// source restores the built-in message.
+type templateVersion struct {
+	Version int       `json:"version"`
+	Source  string    `json:"source"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+var (
+	messageTemplates       = make(templateSet)
+	messageTemplateSources = make(map[string]string)
+	templateHistory        = make(map[string][]templateVersion)
+	messageTemplatesLock   sync.RWMutex
+)
+
+// templateHistoryFile stores every saved template version.
+var templateHistoryFile = filepath.Join(dataDir, "templates.json")
+
+// loadTemplateOverrides reads notifier-specific templates from
+// <prefix>_TEMPLATE_OPEN and <prefix>_TEMPLATE_CLOSED.
+func loadTemplateOverrides(v *settingsView, prefix string) (templateSet, error) {
+	templates := make(templateSet)
+	for name, key := range map[string]string{
+		templateOpen:   prefix + "_TEMPLATE_OPEN",
+		templateClosed: prefix + "_TEMPLATE_CLOSED",
+	} {
+		source := v.setting(key)
+		if source == "" {
+			continue
+		}
+		tmpl, err := parseMessageTemplate(name, source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// mergeTemplates returns base with the templates in top taking precedence.
+func mergeTemplates(base, top templateSet) templateSet {
+	merged := make(templateSet, len(base)+len(top))
+	for name, tmpl := range base {
+		merged[name] = tmpl
+	}
+	for name, tmpl := range top {
+		merged[name] = tmpl
+	}
+	return merged
+}
+
+// loadMessageTemplates reads MESSAGE_TEMPLATE_OPEN and MESSAGE_TEMPLATE_CLOSED,
+// then restores saved versions, the latest of which takes precedence. Unset
+// templates fall back to the localized built-in message.
+func loadMessageTemplates() {
+	messageTemplatesLock.Lock()
+	defer messageTemplatesLock.Unlock()
+
+	for name, key := range map[string]string{
+		templateOpen:   "MESSAGE_TEMPLATE_OPEN",
+		templateClosed: "MESSAGE_TEMPLATE_CLOSED",
+	} {
+		if err := activateTemplate(name, setting(key)); err != nil {
+			fatal("Invalid template", "key", key, "err", err)
+		}
+	}
+
+	data, err := os.ReadFile(templateHistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		fatal("Failed to read template history", "err", err)
+	}
+	if err := json.Unmarshal(data, &templateHistory); err != nil {
+		fatal("Failed to parse template history", "file", templateHistoryFile, "err", err)
+	}
+	for name, versions := range templateHistory {
+		if len(versions) == 0 {
+			continue
+		}
+		if err := activateTemplate(name, versions[len(versions)-1].Source); err != nil {
+			slog.Warn("Ignoring saved template", "component", "templates", "template", name, "err", err)
+		}
+	}
+}
+
+// prepareMessageTemplates validates MESSAGE_TEMPLATE_OPEN and
+// MESSAGE_TEMPLATE_CLOSED for a reload and returns a function making them
+// live. Templates saved through the API keep precedence, as at startup.
+func prepareMessageTemplates(v *settingsView) (func(), error) {
+	sources := make(map[string]string)
+	for name, key := range map[string]string{
+		templateOpen:   "MESSAGE_TEMPLATE_OPEN",
+		templateClosed: "MESSAGE_TEMPLATE_CLOSED",
+	} {
+		source := v.setting(key)
+		if source != "" {
+			if _, err := parseMessageTemplate(name, source); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		sources[name] = source
+	}
+	return func() {
+		messageTemplatesLock.Lock()
+		defer messageTemplatesLock.Unlock()
+		for name, source := range sources {
+			if versions := templateHistory[name]; len(versions) > 0 {
+				continue
+			}
+			activateTemplate(name, source)
+		}
+	}, nil
+}
+
+// parseMessageTemplate parses a message template and executes it against
+// sample events, so unknown fields are reported as well as syntax errors.
+func parseMessageTemplate(name, source string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	for _, open := range []bool{true, false} {
+		sample := templateData{Open: open}
+		if !open {
+			sample.Session = &sessionRecord{}
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// activateTemplate makes source the live template for name. The caller must
+// hold messageTemplatesLock.
+func activateTemplate(name, source string) error {
+	if source == "" {
+		delete(messageTemplates, name)
+		delete(messageTemplateSources, name)
+		return nil
+	}
+	tmpl, err := parseMessageTemplate(name, source)
+	if err != nil {
+		return err
+	}
+	messageTemplates[name] = tmpl
+	messageTemplateSources[name] = source
+	return nil
+}
+
+// saveTemplate validates source, makes it live, and records it as a new
+// version.
+func saveTemplate(name, source string) (templateVersion, error) {
+	messageTemplatesLock.Lock()
+	defer messageTemplatesLock.Unlock()
+
+	if err := activateTemplate(name, source); err != nil {
+		return templateVersion{}, err
+	}
+	versions := templateHistory[name]
+	v := templateVersion{Version: len(versions) + 1, Source: source, SavedAt: time.Now().UTC()}
+	templateHistory[name] = append(versions, v)
+
+	return v, writeJSONFile(templateHistoryFile, templateHistory)
+}
+
+// isTemplateName reports whether name is a known template.
+func isTemplateName(name string) bool {
+	return name == templateOpen || name == templateClosed
+}
+
+// templateName returns the template used for an event.
+func templateName(e Event) string {
+	if e.Open {
+		return templateOpen
+	}
+	return templateClosed
+}
+
+// defaultMessage is the built-in announcement of an event: about the space
+// or, with ZONES set, about the event's zone and then the space. Events of
+// a status override say so, with its reason.
+func defaultMessage(loc string, e Event) string {
+	word := statusText(loc, e.Status)
+	if e.Manual {
+		message := translate(loc, msgManualChange, word)
+		if e.Reason != "" {
+			message += " " + translate(loc, msgManualReason, e.Reason)
+		}
+		return message
+	}
+	if zonesConfigured {
+		return translate(loc, msgZoneChange, eventZoneLabel(e), word) + " " +
+			statusMessage(loc, e.SpaceStatus)
+	}
+	return translate(loc, msgStateChange, word)
+}
+
+// eventZoneLabel returns the label of the event's zone.
+func eventZoneLabel(e Event) string {
+	if z := zoneByName(e.Zone); z != nil {
+		return z.label
+	}
+	return zoneLabel(e.Zone)
+}
+
+// renderMessage renders the announcement for an event. Templates in
+// overrides take precedence over the configured ones.
+func renderMessage(loc string, e Event, overrides templateSet) (string, error) {
+	data := templateData{
+		Open:     e.Open,
+		State:    statusText(loc, e.Status),
+		Status:   e.Status.String(),
+		Zone:     eventZoneLabel(e),
+		Time:     e.Time,
+		Duration: formatDuration(e.Duration),
+		Default:  defaultMessage(loc, e),
+		Session:  e.Session,
+		Manual:   e.Manual,
+		Reason:   e.Reason,
+
+		SpaceOpen:  e.SpaceStatus.open(),
+		SpaceState: statusText(loc, e.SpaceStatus),
+		Zones:      e.Zones,
+	}
+	if e.Session != nil {
+		data.Summary = sessionSummary(loc, e.Session)
+	}
+
+	tmpl, ok := overrides[templateName(e)]
+	if !ok {
+		messageTemplatesLock.RLock()
+		tmpl, ok = messageTemplates[templateName(e)]
+		messageTemplatesLock.RUnlock()
+	}
+	if !ok {
+		if data.Summary != "" {
+			return data.Default + "\n" + data.Summary, nil
+		}
+		return data.Default, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render %s template: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// templateState is the API representation of a template and its history.
+type templateState struct {
+	Name    string            `json:"name"`
+	Source  string            `json:"source"`
+	Default string            `json:"default"`
+	History []templateVersion `json:"history"`
+}
+
+// handleListTemplates returns the current source and history of every template.
+func handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	lang := liveSettings().locale
+	messageTemplatesLock.RLock()
+	var states []templateState
+	for _, name := range []string{templateOpen, templateClosed} {
+		states = append(states, templateState{
+			Name:    name,
+			Source:  messageTemplateSources[name],
+			Default: translate(lang, msgStateChange, stateText(lang, name == templateOpen)),
+			History: append([]templateVersion{}, templateHistory[name]...),
+		})
+	}
+	messageTemplatesLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(states)
+}
+
+// handleSaveTemplate validates and saves a new version of a template.
+func handleSaveTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !isTemplateName(name) {
+		http.Error(w, "Unknown template "+name, http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Source  string `json:"source"`
+		Version int    `json:"version"` // restore this version if set
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Version != 0 {
+		source, ok := templateSource(name, req.Version)
+		if !ok {
+			http.Error(w, "Unknown version "+strconv.Itoa(req.Version), http.StatusNotFound)
+			return
+		}
+		req.Source = source
+	}
+
+	v, err := saveTemplate(name, req.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	p, _ := authenticate(r)
+	audit("Saved template", auditTemplateSaved, p.Name, "template", name, "version", v.Version, "restored", req.Version != 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// templateSource returns the source of a saved version.
+func templateSource(name string, version int) (string, bool) {
+	messageTemplatesLock.RLock()
+	defer messageTemplatesLock.RUnlock()
+	versions := templateHistory[name]
+	if version < 1 || version > len(versions) {
+		return "", false
+	}
+	return versions[version-1].Source, true
+}
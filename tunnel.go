@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tunnel settings.
+const (
+	tunnelPingInterval = 30 * time.Second
+	tunnelReadTimeout  = 90 * time.Second
+	tunnelWriteTimeout = 10 * time.Second
+	tunnelMaxBackoff   = 5 * time.Minute
+	tunnelMaxBody      = 1 << 20
+	tunnelHandlerLimit = 30 * time.Second
+	tunnelWorkers      = 8  // requests served at once
+	tunnelQueue        = 32 // requests waiting for a worker before 503s
+)
+
+// tunnelRequest is an HTTP request the relay received for us.
+type tunnelRequest struct {
+	ID         string      `json:"id"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"` // path and query
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`        // base64 in JSON
+	RemoteAddr string      `json:"remote_addr"` // the original client, as seen by the relay
+}
+
+// tunnelResponse answers a tunnelRequest.
+type tunnelResponse struct {
+	ID     string      `json:"id"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// tunnelClient keeps a WebSocket open to a relay that forwards public HTTP
+// requests to us, so callbacks reach the Pi without port forwarding. Each
+// text frame from the relay is a tunnelRequest; we answer with a
+// tunnelResponse carrying the same ID. A fixed pool of workers serves the
+// requests, so a flood through the relay cannot start unbounded handlers.
+// Each response is sent in one frame once its handler returns, so
+// streaming responses are refused, see streamingFlusher.
+type tunnelClient struct {
+	url     string
+	token   string
+	handler http.Handler
+
+	writeMu sync.Mutex
+}
+
+// newTunnelClient returns a client connecting to relayURL (ws:// or wss://)
+// and serving forwarded requests with handler.
+func newTunnelClient(relayURL, token string, handler http.Handler) *tunnelClient {
+	return &tunnelClient{url: relayURL, token: token, handler: handler}
+}
+
+// run keeps the tunnel connected, reconnecting with exponential backoff.
+func (t *tunnelClient) run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := t.session()
+		slog.Warn("Tunnel ended", "component", "tunnel", "url", t.url, "err", err)
+
+		if time.Since(start) > tunnelMaxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, tunnelMaxBackoff)
+	}
+}
+
+// session serves one relay connection until it fails.
+func (t *tunnelClient) session() error {
+	header := http.Header{}
+	if t.token != "" {
+		header.Set("Authorization", "Bearer "+t.token)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(t.url, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%w (HTTP %d)", err, resp.StatusCode)
+		}
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadLimit(2 * tunnelMaxBody)
+	slog.Info("Tunnel connected", "component", "tunnel", "url", t.url)
+
+	conn.SetReadDeadline(time.Now().Add(tunnelReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(tunnelReadTimeout))
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go t.keepalive(conn, stop)
+
+	// Requests still running when the connection fails are cancelled; their
+	// answers could not be sent anyway.
+	ctx, cancel := context.WithCancel(context.Background())
+	requests := make(chan tunnelRequest, tunnelQueue)
+	var workers sync.WaitGroup
+	for i := 0; i < tunnelWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for req := range requests {
+				if ctx.Err() == nil {
+					t.serve(ctx, conn, req)
+				}
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		close(requests)
+		workers.Wait()
+	}()
+
+	for {
+		var req tunnelRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return fmt.Errorf("relay closed the tunnel: %w", err)
+			}
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(tunnelReadTimeout))
+		select {
+		case requests <- req:
+		default:
+			slog.Warn("Tunnel busy; refusing request", "component", "tunnel", "request", req.ID)
+			t.answer(conn, tunnelResponse{ID: req.ID, Status: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"1"}}})
+		}
+	}
+}
+
+// serve handles one forwarded request and sends the response back.
+func (t *tunnelClient) serve(ctx context.Context, conn *websocket.Conn, req tunnelRequest) {
+	resp := tunnelResponse{ID: req.ID, Status: http.StatusBadGateway, Header: http.Header{}}
+	if len(req.Body) > tunnelMaxBody {
+		resp.Status = http.StatusRequestEntityTooLarge
+	} else if r, err := t.request(req); err != nil {
+		slog.Warn("Invalid tunnel request", "component", "tunnel", "request", req.ID, "err", err)
+		resp.Status = http.StatusBadRequest
+	} else {
+		ctx, cancel := context.WithTimeout(context.WithValue(ctx, tunneledKey{}, true), tunnelHandlerLimit)
+		rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		t.handler.ServeHTTP(rec, r.WithContext(ctx))
+		cancel()
+		resp.Status, resp.Header, resp.Body = rec.status, rec.header, rec.body.Bytes()
+	}
+	t.answer(conn, resp)
+}
+
+// answer sends a response to the relay.
+func (t *tunnelClient) answer(conn *websocket.Conn, resp tunnelResponse) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(tunnelWriteTimeout))
+	if err := conn.WriteJSON(resp); err != nil {
+		slog.Error("Failed to answer tunnel request", "component", "tunnel", "request", resp.ID, "err", err)
+	}
+}
+
+// tunneledKey marks the context of a request forwarded by the tunnel.
+type tunneledKey struct{}
+
+func (t *tunnelClient) request(req tunnelRequest) (*http.Request, error) {
+	r, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	if req.Header != nil {
+		r.Header = req.Header
+	}
+	r.Host = r.Header.Get("Host")
+	r.RemoteAddr = req.RemoteAddr
+	if r.RemoteAddr == "" {
+		r.RemoteAddr = "tunnel:0"
+	}
+	r.RequestURI = req.URL
+	return r, nil
+}
+
+// keepalive pings the relay so dead connections are noticed.
+func (t *tunnelClient) keepalive(conn *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(tunnelPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.writeMu.Lock()
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(tunnelWriteTimeout))
+			t.writeMu.Unlock()
+		}
+	}
+}
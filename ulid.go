@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// crockford is the ULID base32 alphabet.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+	ulidMu      sync.Mutex
+	ulidLastMS  uint64
+	ulidLastRnd [10]byte
+)
+
+// newULID returns a lexicographically sortable unique ID: a 48-bit
+// millisecond timestamp followed by 80 random bits. IDs created within the
+// same millisecond increment the random part so they stay ordered.
+func newULID(t time.Time) string {
+	ulidMu.Lock()
+	defer ulidMu.Unlock()
+
+	ms := uint64(t.UnixMilli())
+	if ms <= ulidLastMS {
+		ms = ulidLastMS
+		for i := len(ulidLastRnd) - 1; i >= 0; i-- {
+			ulidLastRnd[i]++
+			if ulidLastRnd[i] != 0 {
+				break
+			}
+		}
+	} else {
+		rand.Read(ulidLastRnd[:])
+		ulidLastMS = ms
+	}
+
+	var raw [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(raw[:6], ts[2:])
+	copy(raw[6:], ulidLastRnd[:])
+
+	// Encode 128 bits as 26 base32 characters, most significant first.
+	var out [26]byte
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
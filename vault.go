@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// vaultTimeout bounds reading secrets from Vault at startup and on reload.
+const vaultTimeout = 10 * time.Second
+
+// loadVaultSecrets reads the secret at VAULT_PATH from the Vault server at
+// VAULT_ADDR into file, when VAULT_ADDR is set. Each key of the secret is a
+// setting, named as in the configuration file, so slack_token or
+// SLACK_TOKEN both set SLACK_TOKEN. Both KV engine versions are supported;
+// for version 2 the path includes data/, as in secret/data/space-status.
+// The Vault settings themselves come from anywhere but Vault.
+func loadVaultSecrets(file *configFile) error {
+	var readErr error
+	get := func(key string) string {
+		value, _, err := resolveSetting(file, key)
+		if err != nil && readErr == nil {
+			readErr = fmt.Errorf("%s%s: %w", key, secretFileSuffix, err)
+		}
+		return value
+	}
+	markSettingsRead("VAULT_ADDR", "VAULT_TOKEN", "VAULT_PATH", "VAULT_NAMESPACE")
+	addr := strings.TrimSuffix(get("VAULT_ADDR"), "/")
+	if addr == "" {
+		return readErr
+	}
+	token, path, namespace := get("VAULT_TOKEN"), strings.Trim(get("VAULT_PATH"), "/"), get("VAULT_NAMESPACE")
+	if readErr != nil {
+		return readErr
+	}
+	if token == "" || path == "" {
+		return fmt.Errorf("VAULT_TOKEN and VAULT_PATH must be set with VAULT_ADDR")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/"+path, nil)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("vault: read %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return fmt.Errorf("vault: read %s: %w", path, err)
+	}
+	data := secret.Data
+	if inner, ok := data["data"]; ok && data["metadata"] != nil {
+		// KV version 2 nests the secret under data.data.
+		data = nil
+		if err := json.Unmarshal(inner, &data); err != nil {
+			return fmt.Errorf("vault: read %s: %w", path, err)
+		}
+	}
+
+	file.secrets = make(map[string]string, len(data))
+	file.secretPath = path
+	for key, raw := range data {
+		value, err := parseVaultValue(raw)
+		if err != nil {
+			return fmt.Errorf("vault: %s: %s: %v", path, key, err)
+		}
+		file.secrets[settingName(strings.Split(key, "."))] = value
+	}
+	return nil
+}
+
+// parseVaultValue returns a secret value as the string the environment
+// variable would hold.
+func parseVaultValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case bool, float64:
+		return strings.TrimSpace(string(raw)), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T; use a string", v)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build information, set with e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Unset values are filled from the module and VCS information Go embeds.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// startTime is when the process started, for uptime.
+var startTime = time.Now()
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit,omitempty"`
+	Modified      bool   `json:"modified,omitempty"` // built from a dirty tree
+	BuildDate     string `json:"build_date,omitempty"`
+	GoVersion     string `json:"go_version"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+// currentBuildInfo combines the ldflags values with debug.ReadBuildInfo.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	info.UptimeSeconds = int64(time.Since(startTime) / time.Second)
+	return info
+}
+
+// String returns the version with the short commit, e.g. "1.4.0 (a1b2c3d)".
+func (b buildInfo) String() string {
+	s := b.Version
+	if b.Commit != "" {
+		short := b.Commit
+		if len(short) > 7 {
+			short = short[:7]
+		}
+		if b.Modified {
+			short += "-dirty"
+		}
+		s += " (" + short + ")"
+	}
+	return s
+}
+
+// handleVersion serves the build information and uptime.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentBuildInfo())
+}
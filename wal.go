@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// walEntry is one line of the notification write-ahead log. An "accept"
+// entry is written before a delivery is queued and a "done" entry once it
+// succeeded or was given up; accepted entries without a done entry are
+// replayed at startup.
+type walEntry struct {
+	Op    string `json:"op"` // "accept" or "done"
+	Queue string `json:"queue"`
+	ID    string `json:"id"`
+
+	// Set on accept entries, depending on the kind of queue.
+	Event    *Event         `json:"event,omitempty"`
+	Duration time.Duration  `json:"duration,omitempty"` // Event.Duration, which Event does not encode
+	Session  *sessionRecord `json:"session,omitempty"`  // Event.Session
+	Payload  *hookPayload   `json:"payload,omitempty"`
+}
+
+// walQueue is a delivery queue that can take back replayed entries.
+type walQueue interface {
+	replay(e walEntry)
+}
+
+// notifyWAL records queued deliveries so they survive the process being
+// killed mid-dispatch. Queues register under a name that is stable as long
+// as the configuration is; entries are only logged once open has run.
+type notifyWAL struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	queues  map[string]walQueue
+	pending map[string]walEntry // by queue and ID
+	order   []string            // pending keys in acceptance order
+}
+
+var notifyLog = &notifyWAL{
+	path:    filepath.Join(dataDir, "notify-wal.jsonl"),
+	queues:  make(map[string]walQueue),
+	pending: make(map[string]walEntry),
+}
+
+// register adds a queue and returns its name in the log: name, or name
+// with a "#2" suffix and so on when several queues share one.
+func (l *notifyWAL) register(name string, q walQueue) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := name
+	for n := 2; l.queues[key] != nil; n++ {
+		key = name + "#" + strconv.Itoa(n)
+	}
+	l.queues[key] = q
+	return key
+}
+
+// open reads entries left by a previous run, rewrites the log with only
+// the unfinished ones, and starts logging. Damaged lines are skipped.
+func (l *notifyWAL) open() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, err := os.Open(l.path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			var e walEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				slog.Warn("Skipping damaged line", "component", "notify", "file", l.path, "line", line, "err", err)
+				continue
+			}
+			l.apply(e)
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", l.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return l.rewrite()
+}
+
+// apply updates the pending set for an entry. The caller must hold l.mu.
+func (l *notifyWAL) apply(e walEntry) {
+	key := e.Queue + " " + e.ID
+	switch e.Op {
+	case "accept":
+		if _, ok := l.pending[key]; !ok {
+			l.order = append(l.order, key)
+		}
+		l.pending[key] = e
+	case "done":
+		delete(l.pending, key)
+	}
+}
+
+// rewrite replaces the log with the pending entries and reopens it for
+// appending. The caller must hold l.mu.
+func (l *notifyWAL) rewrite() error {
+	var keys []string
+	var data []byte
+	for _, key := range l.order {
+		e, ok := l.pending[key]
+		if !ok {
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		data = append(append(data, line...), '\n')
+	}
+	l.order = keys
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		l.f = nil
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+// replay hands unfinished entries back to their queues. Entries for queues
+// that are no longer configured are dropped.
+func (l *notifyWAL) replay() {
+	l.mu.Lock()
+	var entries []walEntry
+	for _, key := range l.order {
+		if e, ok := l.pending[key]; ok {
+			entries = append(entries, e)
+		}
+	}
+	queues := l.queues
+	l.mu.Unlock()
+
+	for _, e := range entries {
+		q, ok := queues[e.Queue]
+		if !ok {
+			slog.Warn("Dropping unfinished delivery; queue no longer configured", "component", "notify", "queue", e.Queue, "delivery", e.ID)
+			l.done(e.Queue, e.ID)
+			continue
+		}
+		slog.Info("Replaying unfinished delivery", "component", "notify", "queue", e.Queue, "delivery", e.ID)
+		q.replay(e)
+	}
+}
+
+// accept durably records a delivery before it is queued.
+func (l *notifyWAL) accept(e walEntry) error {
+	e.Op = "accept"
+	return l.write(e, true)
+}
+
+// done records that a delivery finished, successfully or not. The log is
+// compacted whenever nothing remains pending.
+func (l *notifyWAL) done(queue, id string) {
+	if err := l.write(walEntry{Op: "done", Queue: queue, ID: id}, false); err != nil {
+		slog.Error("Failed to record delivery as done", "component", "notify", "queue", queue, "delivery", id, "err", err)
+	}
+}
+
+func (l *notifyWAL) write(e walEntry, durable bool) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	l.apply(e)
+	if len(l.pending) == 0 {
+		return l.rewrite()
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if durable {
+		return l.f.Sync()
+	}
+	return nil
+}
+
+// drain waits until every accepted delivery is done, or ctx is done, and
+// returns how many are still pending.
+func (l *notifyWAL) drain(ctx context.Context) int {
+	for {
+		l.mu.Lock()
+		n := len(l.pending)
+		l.mu.Unlock()
+		if n == 0 || ctx.Err() != nil {
+			return n
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// close stops logging. Deliveries still pending stay in the log and are
+// replayed at the next start.
+func (l *notifyWAL) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// webhookClient is shared by notifiers that talk to HTTP APIs.
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// httpStatusError reports a non-2xx response from a webhook endpoint.
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+	retryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// RetryAfter implements retryAfterError.
+func (e *httpStatusError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// postJSON POSTs body as JSON to url. Client errors other than 408 and 429
+// are reported as permanent so the queue does not retry them.
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	req, err := newJSONRequest(ctx, url, body)
+	if err != nil {
+		return err
+	}
+	return doWebhookRequest(req)
+}
+
+// newJSONRequest builds a JSON POST request for callers that need to add
+// headers before sending it with doWebhookRequest.
+func newJSONRequest(ctx context.Context, url string, body interface{}) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, permanent(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// doWebhookRequest performs req and converts non-2xx responses into errors.
+func doWebhookRequest(req *http.Request) error {
+	return doJSONRequest(req, nil)
+}
+
+// doJSONRequest performs req, decoding a 2xx JSON response into out when it
+// is non-nil, and converts other responses into errors.
+func doJSONRequest(req *http.Request, out interface{}) error {
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out != nil {
+			return json.NewDecoder(resp.Body).Decode(out)
+		}
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	statusErr := &httpStatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(snippet))}
+	if seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+		statusErr.retryAfter = time.Duration(seconds * float64(time.Second))
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanent(statusErr)
+	}
+	return statusErr
+}
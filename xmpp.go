@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XMPP connection settings.
+const (
+	xmppDialTimeout     = 30 * time.Second
+	xmppReadTimeout     = 5 * time.Minute
+	xmppWriteTimeout    = 10 * time.Second
+	xmppKeepalive       = time.Minute
+	xmppMaxBackoff      = 5 * time.Minute
+	xmppResource        = "space-status"
+	xmppDefaultNick     = "space-status"
+	xmppRegisterTimeout = time.Minute
+)
+
+// XMPP namespaces.
+const (
+	nsXMPPStream = "http://etherx.jabber.org/streams"
+	nsXMPPTLS    = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsXMPPSASL   = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsXMPPBind   = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsMUC        = "http://jabber.org/protocol/muc"
+	nsMUCUser    = "http://jabber.org/protocol/muc#user"
+	nsXMPPPing   = "urn:xmpp:ping"
+)
+
+// errXMPPNotConnected is returned while the client is not in the room.
+var errXMPPNotConnected = errors.New("not connected to XMPP")
+
+// xmppNotifier keeps a connection to an XMPP server and announces state
+// changes in a multi-user chat room.
+type xmppNotifier struct {
+	jid      string // user@domain
+	password string
+	room     string // room@conference.domain
+	nick     string
+	server   string // host:port; looked up via SRV when empty
+	useTLS   bool
+
+	mu     sync.Mutex
+	conn   net.Conn
+	joined bool
+}
+
+// newXMPPNotifier returns a notifier that joins room as nick.
+func newXMPPNotifier(jid, password, room, nick, server string, useTLS bool) *xmppNotifier {
+	if nick == "" {
+		nick = xmppDefaultNick
+	}
+	return &xmppNotifier{jid: jid, password: password, room: room, nick: nick, server: server, useTLS: useTLS}
+}
+
+// Name implements Notifier.
+func (n *xmppNotifier) Name() string {
+	return "xmpp"
+}
+
+// Notify implements Notifier.
+func (n *xmppNotifier) Notify(ctx context.Context, e Event) error {
+	message, err := renderMessage(liveSettings().locale, e, nil)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil || !n.joined {
+		return errXMPPNotConnected
+	}
+	return n.writeLocked("<message to='%s' type='groupchat' id='%s'><body>%s</body></message>",
+		xmlEscape(n.room), xmlEscape(e.ID), xmlEscape(message))
+}
+
+// Preview implements Previewer.
+func (n *xmppNotifier) Preview(e Event, overrides templateSet) ([]plannedMessage, error) {
+	message, err := renderMessage(liveSettings().locale, e, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return []plannedMessage{{Notifier: n.Name(), Destination: n.room, Text: message}}, nil
+}
+
+// run keeps the client connected, reconnecting with exponential backoff.
+func (n *xmppNotifier) run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := n.session()
+		slog.Warn("XMPP connection ended", "component", "xmpp", "jid", n.jid, "err", err)
+
+		if time.Since(start) > xmppMaxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, xmppMaxBackoff)
+	}
+}
+
+// xmppFeatures is the <stream:features> element.
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// xmppPresence is a presence stanza, as far as room joins need it.
+type xmppPresence struct {
+	From     string `xml:"from,attr"`
+	Type     string `xml:"type,attr"`
+	Statuses []struct {
+		Code string `xml:"code,attr"`
+	} `xml:"http://jabber.org/protocol/muc#user x>status"`
+	Error *struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"error"`
+}
+
+// xmppIQ is an info/query stanza.
+type xmppIQ struct {
+	ID   string    `xml:"id,attr"`
+	Type string    `xml:"type,attr"`
+	From string    `xml:"from,attr"`
+	Ping *struct{} `xml:"urn:xmpp:ping ping"`
+}
+
+// session connects, authenticates, joins the room, and serves the
+// connection until it fails.
+func (n *xmppNotifier) session() error {
+	local, domain, ok := strings.Cut(n.jid, "@")
+	if !ok || local == "" || domain == "" {
+		return fmt.Errorf("invalid JID %q", n.jid)
+	}
+	server := n.server
+	if server == "" {
+		server = net.JoinHostPort(domain, "5222")
+		if _, addrs, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(addrs) > 0 {
+			server = net.JoinHostPort(strings.TrimSuffix(addrs[0].Target, "."), strconv.Itoa(int(addrs[0].Port)))
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", server, xmppDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { n.closeConn() }()
+	n.setConn(conn)
+	conn.SetDeadline(time.Now().Add(xmppRegisterTimeout))
+
+	dec, features, err := n.openStream(domain)
+	if err != nil {
+		return err
+	}
+	if features.StartTLS != nil && n.useTLS {
+		if err := n.write("<starttls xmlns='%s'/>", nsXMPPTLS); err != nil {
+			return err
+		}
+		if err := expectElement(dec, "proceed"); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: domain})
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		n.setConn(tlsConn)
+		if dec, features, err = n.openStream(domain); err != nil {
+			return err
+		}
+	} else if n.useTLS {
+		return errors.New("server does not offer STARTTLS")
+	}
+
+	if !containsFold(features.Mechanisms, "PLAIN") {
+		return fmt.Errorf("server does not support SASL PLAIN (offers %v)", features.Mechanisms)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00" + local + "\x00" + n.password))
+	if err := n.write("<auth xmlns='%s' mechanism='PLAIN'>%s</auth>", nsXMPPSASL, auth); err != nil {
+		return err
+	}
+	if err := expectElement(dec, "success"); err != nil {
+		return fmt.Errorf("SASL authentication failed: %w", err)
+	}
+	if dec, features, err = n.openStream(domain); err != nil {
+		return err
+	}
+	if features.Bind == nil {
+		return errors.New("server does not offer resource binding")
+	}
+	if err := n.write("<iq type='set' id='bind'><bind xmlns='%s'><resource>%s</resource></bind></iq>", nsXMPPBind, xmppResource); err != nil {
+		return err
+	}
+	if err := expectElement(dec, "iq"); err != nil {
+		return fmt.Errorf("resource binding: %w", err)
+	}
+
+	nick := n.nick
+	if err := n.joinRoom(nick); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go n.keepalive(stop)
+
+	for {
+		n.deadline(xmppReadTimeout)
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var start xml.StartElement
+		switch t := tok.(type) {
+		case xml.StartElement:
+			start = t
+		case xml.EndElement:
+			if t.Name.Space == nsXMPPStream && t.Name.Local == "stream" {
+				return errors.New("server closed the stream")
+			}
+			continue
+		default:
+			continue
+		}
+
+		switch start.Name.Local {
+		case "presence":
+			var p xmppPresence
+			if err := dec.DecodeElement(&p, &start); err != nil {
+				return err
+			}
+			if p.From != n.room+"/"+nick {
+				continue
+			}
+			switch {
+			case p.Type == "error" && p.Error != nil && strings.Contains(p.Error.Inner, "conflict"):
+				nick += "_"
+				if err := n.joinRoom(nick); err != nil {
+					return err
+				}
+			case p.Type == "error":
+				return fmt.Errorf("could not join %s", n.room)
+			case p.Type == "unavailable":
+				return fmt.Errorf("removed from %s", n.room)
+			case p.isSelf():
+				n.mu.Lock()
+				n.joined = true
+				n.mu.Unlock()
+				slog.Info("Joined XMPP room", "component", "xmpp", "room", n.room, "nick", nick)
+			}
+		case "iq":
+			var iq xmppIQ
+			if err := dec.DecodeElement(&iq, &start); err != nil {
+				return err
+			}
+			if iq.Type == "get" && iq.Ping != nil {
+				n.write("<iq type='result' id='%s' to='%s'/>", xmlEscape(iq.ID), xmlEscape(iq.From))
+			}
+		case "error":
+			var stanza struct {
+				Inner string `xml:",innerxml"`
+			}
+			dec.DecodeElement(&stanza, &start)
+			return fmt.Errorf("stream error: %s", stanza.Inner)
+		default:
+			if err := dec.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isSelf reports whether the presence is the room's reflection of our own
+// join (MUC status code 110).
+func (p xmppPresence) isSelf() bool {
+	for _, s := range p.Statuses {
+		if s.Code == "110" {
+			return true
+		}
+	}
+	return false
+}
+
+// openStream opens a new XML stream on the current connection and returns
+// a decoder positioned after the server's stream features.
+func (n *xmppNotifier) openStream(domain string) (*xml.Decoder, xmppFeatures, error) {
+	var features xmppFeatures
+	if err := n.write("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='%s' version='1.0'>",
+		xmlEscape(domain), nsXMPPStream); err != nil {
+		return nil, features, err
+	}
+	n.mu.Lock()
+	dec := xml.NewDecoder(n.conn)
+	n.mu.Unlock()
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, features, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local == "stream" {
+			continue
+		}
+		if start.Name.Local != "features" {
+			return nil, features, fmt.Errorf("unexpected <%s> before stream features", start.Name.Local)
+		}
+		return dec, features, dec.DecodeElement(&features, &start)
+	}
+}
+
+// joinRoom sends presence to the room without requesting history.
+func (n *xmppNotifier) joinRoom(nick string) error {
+	return n.write("<presence to='%s/%s'><x xmlns='%s'><history maxstanzas='0'/></x></presence>",
+		xmlEscape(n.room), xmlEscape(nick), nsMUC)
+}
+
+// keepalive sends whitespace periodically so idle connections are not
+// dropped by the server or NAT.
+func (n *xmppNotifier) keepalive(stop <-chan struct{}) {
+	ticker := time.NewTicker(xmppKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n.write(" ")
+		}
+	}
+}
+
+func (n *xmppNotifier) setConn(conn net.Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conn = conn
+}
+
+func (n *xmppNotifier) closeConn() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	n.conn, n.joined = nil, false
+}
+
+func (n *xmppNotifier) deadline(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.SetReadDeadline(time.Now().Add(d))
+	}
+}
+
+// write sends raw XML.
+func (n *xmppNotifier) write(format string, args ...interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.writeLocked(format, args...)
+}
+
+// writeLocked sends raw XML. The caller must hold n.mu.
+func (n *xmppNotifier) writeLocked(format string, args ...interface{}) error {
+	if n.conn == nil {
+		return errXMPPNotConnected
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(xmppWriteTimeout))
+	_, err := fmt.Fprintf(n.conn, format, args...)
+	return err
+}
+
+// expectElement reads the next top-level element and fails unless it has
+// the given local name (and, for iq, a result type).
+func expectElement(dec *xml.Decoder, local string) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var el struct {
+			Type  string `xml:"type,attr"`
+			Inner string `xml:",innerxml"`
+		}
+		if err := dec.DecodeElement(&el, &start); err != nil {
+			return err
+		}
+		if start.Name.Local != local || (local == "iq" && el.Type != "result") {
+			return fmt.Errorf("got <%s>%s", start.Name.Local, el.Inner)
+		}
+		return nil
+	}
+}
+
+// xmlEscape escapes text for use in XML content and quoted attributes.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+// zone is an area with its own switch, such as the main space, the
+// woodshop, or the electronics lab. Without ZONES there is one zone, main,
+// set up from the GPIO_* settings.
+type zone struct {
+	name    string
+	label   string // used in messages, e.g. "Woodshop"
+	pinName string
+	// membersPinName is the pin that reads the open level while the zone is
+	// open to members only, empty without one.
+	membersPinName string
+	pull           gpio.Pull
+	openLevel      gpio.Level
+	// notify names the notifiers announcing the zone, e.g. "slack" or
+	// "webhook"; nil means all of them.
+	notify map[string]bool
+	// slackChannel overrides SLACK_CHANNEL for the zone's announcements.
+	slackChannel string
+
+	// heartbeat is the Unix nanosecond time of the last reading or feed
+	// message.
+	heartbeat atomic.Int64
+	// pins are the pins being monitored, nil before monitoring starts and
+	// in shadow mode.
+	pins atomic.Pointer[zonePins]
+
+	mu          sync.Mutex
+	status      spaceStatus // unknown until the first stable reading
+	lastChanged time.Time   // zero until the first change or startup reading
+}
+
+// zonePins are the pins set up for a zone's switch.
+type zonePins struct {
+	main    gpio.PinIO
+	members gpio.PinIO // nil without a members-only pin
+	edges   bool       // whether edges are detected on every pin
+}
+
+// all returns the pins that are set up.
+func (p *zonePins) all() []gpio.PinIO {
+	if p.members == nil {
+		return []gpio.PinIO{p.main}
+	}
+	return []gpio.PinIO{p.main, p.members}
+}
+
+// read returns the status the pins select: members only while the
+// members-only pin reads openLevel, otherwise open or closed following the
+// main pin. With a three-position switch, whose ends connect one pin each,
+// the centre position is closed. ok is false while a pin does not report
+// itself as an input.
+func (p *zonePins) read(openLevel gpio.Level) (s spaceStatus, ok bool) {
+	for _, pin := range p.all() {
+		if !pinIsInput(pin) {
+			return statusUnknown, false
+		}
+	}
+	switch {
+	case p.members != nil && p.members.Read() == openLevel:
+		return statusMembersOnly, true
+	case p.main.Read() == openLevel:
+		return statusOpen, true
+	}
+	return statusClosed, true
+}
+
+// zones are the configured zones. The space as a whole is open when any or
+// all of them are, following SPACE_OPEN_WHEN.
+var zones = []*zone{{name: defaultZone, label: zoneLabel(defaultZone), pinName: defaultSwitchPin,
+	pull: gpio.PullUp, openLevel: gpio.Low}}
+
+// zonesConfigured is set when ZONES is, so messages name the zone.
+var zonesConfigured bool
+
+// How the zones make up the state of the space, selected with
+// SPACE_OPEN_WHEN.
+const (
+	spaceOpenWhenAny = "any" // open while at least one zone is
+	spaceOpenWhenAll = "all" // open only while every zone is
+)
+
+// spaceOpenWhen is SPACE_OPEN_WHEN.
+var spaceOpenWhen = spaceOpenWhenAny
+
+// zoneStatus is a zone's state as served by /status and carried by events
+// for per-zone detail in messages.
+type zoneStatus struct {
+	Name   string      `json:"name"`
+	Label  string      `json:"label"`
+	State  bool        `json:"state"` // open, to everyone or to members
+	Status spaceStatus `json:"status"`
+	Text   string      `json:"text,omitempty"`  // localized status, on /status
+	Since  *time.Time  `json:"since,omitempty"` // on /status, once known
+	// DurationSeconds is how long the zone has been in its state, on
+	// /status once known.
+	DurationSeconds *int64 `json:"duration_seconds,omitempty"`
+}
+
+// loadZones reads ZONES, a comma-separated list of zone names, and each
+// zone's settings, e.g. ZONE_WOODSHOP_PIN for woodshop. Pin resistor and
+// open level default to the given ones, from GPIO_PULL and GPIO_OPEN_LEVEL.
+// Without ZONES the single zone main uses pinName and membersPinName.
+func loadZones(pinName, membersPinName string, pull gpio.Pull, openLevel gpio.Level) []*zone {
+	switch value := strings.ToLower(setting("SPACE_OPEN_WHEN")); value {
+	case "":
+		spaceOpenWhen = spaceOpenWhenAny
+	case spaceOpenWhenAny, spaceOpenWhenAll:
+		spaceOpenWhen = value
+	default:
+		fatal("Invalid SPACE_OPEN_WHEN; use any or all", "value", value)
+	}
+	names := splitCommaList(setting("ZONES"))
+	zonesConfigured = len(names) > 0
+	if !zonesConfigured {
+		if membersPinName != "" && membersPinName == pinName {
+			fatal("Pin used twice", "pin", pinName)
+		}
+		return []*zone{{name: defaultZone, label: zoneLabel(defaultZone), pinName: pinName, membersPinName: membersPinName,
+			pull: pull, openLevel: openLevel}}
+	}
+
+	var loaded []*zone
+	seen, pins := make(map[string]bool), make(map[string]string)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if parts, err := splitConfigKey(name); err != nil || len(parts) != 1 {
+			fatal("Invalid zone name in ZONES; use letters, digits, _ and -", "zone", name)
+		}
+		if seen[name] {
+			fatal("Zone listed twice in ZONES", "zone", name)
+		}
+		seen[name] = true
+
+		prefix := "ZONE_" + settingName([]string{name}) + "_"
+		z := &zone{name: name, label: setting(prefix + "LABEL"), pinName: getEnv(prefix + "PIN"),
+			membersPinName: setting(prefix + "MEMBERS_PIN"), pull: pull, openLevel: openLevel,
+			slackChannel: setting(prefix + "SLACK_CHANNEL")}
+		if z.label == "" {
+			z.label = zoneLabel(name)
+		}
+		for _, pin := range []string{z.pinName, z.membersPinName} {
+			if pin == "" {
+				continue
+			}
+			if other, ok := pins[pin]; ok {
+				fatal("Pin used twice", "pin", pin, "zones", other+", "+name)
+			}
+			pins[pin] = name
+		}
+		if value := setting(prefix + "PULL"); value != "" {
+			p, err := parsePull(value)
+			if err != nil {
+				fatal("Invalid "+prefix+"PULL", "err", err)
+			}
+			z.pull = p
+		}
+		if value := setting(prefix + "OPEN_LEVEL"); value != "" {
+			l, err := parseLevel(value)
+			if err != nil {
+				fatal("Invalid "+prefix+"OPEN_LEVEL", "err", err)
+			}
+			z.openLevel = l
+		}
+		if value := setting(prefix + "NOTIFY"); value != "" {
+			z.notify = make(map[string]bool)
+			for _, n := range splitCommaList(value) {
+				z.notify[strings.ToLower(n)] = true
+			}
+		}
+		loaded = append(loaded, z)
+	}
+	return loaded
+}
+
+// zoneLabel capitalizes a zone name for messages.
+func zoneLabel(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + strings.ReplaceAll(name[1:], "_", " ")
+}
+
+// zoneSummary lists every zone's state after the event, e.g. "Main: open ·
+// Woodshop: closed", or returns "" without ZONES.
+func zoneSummary(loc string, e Event) string {
+	parts := make([]string, len(e.Zones))
+	for i, z := range e.Zones {
+		parts[i] = z.Label + ": " + statusText(loc, z.Status)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// zoneByName returns the zone with the given name, or nil.
+func zoneByName(name string) *zone {
+	for _, z := range zones {
+		if z.name == name {
+			return z
+		}
+	}
+	return nil
+}
+
+// beat records that the zone's monitor is alive.
+func (z *zone) beat() {
+	z.heartbeat.Store(time.Now().UnixNano())
+}
+
+// setState records a change of the zone's switch and returns the previous
+// state and when it began, zero if unknown.
+func (z *zone) setState(s spaceStatus, at time.Time) (spaceStatus, time.Time) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	previous, since := z.status, z.lastChanged
+	z.status, z.lastChanged = s, at
+	return previous, since
+}
+
+// current returns the zone's state and when it began.
+func (z *zone) current() (spaceStatus, time.Time) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.status, z.lastChanged
+}
+
+// zoneStates returns every zone's state, with changed taken to be in s.
+// changed may be nil.
+func zoneStates(changed *zone, s spaceStatus) []zoneStatus {
+	states := make([]zoneStatus, len(zones))
+	for i, z := range zones {
+		states[i] = zoneStatus{Name: z.name, Label: z.label}
+		if z == changed {
+			states[i].Status = s
+		} else {
+			states[i].Status, _ = z.current()
+		}
+		states[i].State = states[i].Status.open()
+	}
+	return states
+}
+
+// zoneStatusViews returns every zone's current state for /status, with
+// the status text in loc.
+func zoneStatusViews(loc string) []zoneStatus {
+	views := make([]zoneStatus, len(zones))
+	for i, z := range zones {
+		s, since := z.current()
+		views[i] = zoneStatus{Name: z.name, Label: z.label, State: s.open(), Status: s, Text: statusText(loc, s)}
+		if !since.IsZero() && s != statusUnknown {
+			views[i].DurationSeconds = secondsSince(since, time.Now())
+			since = since.UTC()
+			views[i].Since = &since
+		}
+	}
+	return views
+}
+
+// spaceStatusOf returns the state of the space given its zones' states:
+// that of the most open zone or, with SPACE_OPEN_WHEN=all, the least open.
+// It is unknown until every zone has been read.
+func spaceStatusOf(states []zoneStatus) spaceStatus {
+	result := states[0].Status
+	for _, s := range states[1:] {
+		switch {
+		case result == statusUnknown || s.Status == statusUnknown:
+			return statusUnknown
+		case spaceOpenWhen == spaceOpenWhenAll:
+			result = min(result, s.Status)
+		default:
+			result = max(result, s.Status)
+		}
+	}
+	return result
+}
+
+// spaceMu serializes changes of the zones, so the space state follows them
+// in order.
+var spaceMu sync.Mutex
+
+// spaceStarted is set once the space state has been taken from its zones.
+var spaceStarted bool
+
+// updateSpace reports whether the space state changes to s. The first
+// time it is known, s is compared with the last known state of the space
+// from before the restart rather than with the unknown initial state, and
+// taken over if they agree. The caller holds spaceMu.
+func updateSpace(s spaceStatus, now time.Time) bool {
+	if s == statusUnknown {
+		return false
+	}
+	if spaceStarted {
+		return s != currentSpace().Status
+	}
+	spaceStarted = true
+	last, known := lastKnown.space()
+	if !known || last.Status != s {
+		if known {
+			// The previous state lasted from its change until now.
+			setSpace(statusUnknown, last.Since)
+		}
+		return true
+	}
+	adoptSpaceState(s, last.Since, now)
+	return false
+}
+
+// adoptSpaceState takes over the space state without recording a change.
+// The caller holds spaceMu.
+func adoptSpaceState(s spaceStatus, since, now time.Time) {
+	setSpace(s, since)
+	sensorReadings.publish(s, now)
+	recordStartState(s, now)
+}
+
+// restoreZoneStates takes when each zone's state began before a restart
+// from its last known state, so the duration is right when the first
+// reading changes it. The state itself stays unknown until then.
+func restoreZoneStates() {
+	for _, z := range zones {
+		if last, ok := lastKnown.zone(z.name); ok {
+			z.setState(statusUnknown, last.Since)
+		}
+	}
+}
+
+// notifies reports whether the notifier named n announces the zone.
+func (z *zone) notifies(n string) bool {
+	return z.notify == nil || z.notify[n]
+}
+
+// zoneNotifiers returns the notifiers announcing events of the named zone.
+// Events of unknown zones, such as canaries, go to every notifier.
+func zoneNotifiers(name string, notifiers []Notifier) []Notifier {
+	z := zoneByName(name)
+	if z == nil || z.notify == nil {
+		return notifiers
+	}
+	var filtered []Notifier
+	for _, n := range notifiers {
+		if z.notifies(n.Name()) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
+
+// warnUnknownZoneNotifiers logs ZONE_*_NOTIFY entries that match no
+// registered notifier, usually typos.
+func warnUnknownZoneNotifiers(notifiers []Notifier) {
+	names := make(map[string]bool)
+	for _, n := range notifiers {
+		names[n.Name()] = true
+	}
+	for _, z := range zones {
+		for n := range z.notify {
+			if !names[n] {
+				slog.Warn("Zone notifies an unknown notifier", "component", "switch", "zone", z.name, "notifier", n)
+			}
+		}
+	}
+}
+
+// zoneSlackChannel returns the channel announcing events of the named zone.
+func zoneSlackChannel(name, fallback string) string {
+	if z := zoneByName(name); z != nil && z.slackChannel != "" {
+		return z.slackChannel
+	}
+	return fallback
+}